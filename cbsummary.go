@@ -13,300 +13,1183 @@ package main
 //
 // cbsummary - a command-line utility for creating a summary report for a set of clusters
 //
+// The collection and reporting are done by the pkg/cbsummary library; this is
+// the command line around it, with its flags, help and subcommands.
+//
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/couchbase/cbsummary/pkg/cbsummary"
+)
+
+// the build, set with -ldflags "-X main.version=<version> -X main.versionHash=<commit>",
+// as CMakeLists.txt does; Go's own record of the commit is used otherwise
+var (
+	version     = ""
+	versionHash = ""
+	installType = "default"
 )
 
-// data type for holding cluster info
+// flags for the command-line
+
+var CONFIG_FILE = flag.String("config", "", "Config file listing clusters and credentials to summarize.")
+var OUTPUT_FILE = flag.String("output", "", "Name for output file (default cbsummary.out.<timestamp>), with optional {date}, {time}, {cluster} and {format} placeholders, '-' for standard output, or an s3://, gs:// or az:// URL to upload to.")
+var CLUSTER = flag.String("cluster", "", "Summarize this one cluster, given by URL or connection string, without a config file.")
+var USERNAME = flag.String("username", "", "Login for --cluster.")
+var PASSWORD = flag.String("password", "", "Password for --cluster (default: ask at the terminal).")
+var KUBE = flag.Bool("kube", false, "Summarize the CouchbaseCluster resources in Kubernetes, without a config file.")
+var KUBECONFIG = flag.String("kubeconfig", "", "Kubeconfig file for --kube (default $KUBECONFIG, the pod's service account, or ~/.kube/config).")
+var KUBE_CONTEXT = flag.String("kube-context", "", "Kubeconfig context for --kube (default the current context).")
+var KUBE_NAMESPACE = flag.String("kube-namespace", "", "Namespace to find clusters in with --kube (default all namespaces).")
+var KUBE_ENDPOINT = flag.String("kube-endpoint", "", "Admin URL of each cluster found with --kube, with {name} and {namespace} placeholders (default its load balancer, or in a pod its service).")
+var HELP = flag.Bool("help", false, "Print a help message.")
+var VERSION = flag.Bool("version", false, "Print the version of cbsummary and exit.")
+var FULL = flag.Bool("full", false, "Produce an extensive report, instead of just core and RAM usage.")
+var CSV = flag.Bool("csv", false, "Produce a report in CSV format, short for --format=csv.")
+var FORMAT = flag.String("format", cbsummary.FORMAT_JSON, "Report format: json, csv, html, xlsx, yaml, jsonl or prom.")
+var JSONL_NODES = flag.Bool("jsonl-nodes", false, "With --format=jsonl, write a line for each node rather than each cluster.")
+var TEMPLATE = flag.String("template", "", "Go text/template file to render the report through, instead of a --format.")
+var SPLIT_PER_CLUSTER = flag.Bool("split-per-cluster", false, "Also write a report for each cluster, named by its label or UUID.")
+var STREAM = flag.Bool("stream", false, "Write each cluster to the JSON report as soon as it's collected, to save memory on large fleets.")
+var SPLIT_ONLY = flag.Bool("split-only", false, "With --split-per-cluster, write only the per-cluster reports, not the combined one.")
+var COMPRESS = flag.Bool("compress", false, "Gzip the report written or uploaded, adding .gz to its name.")
+var ENCRYPT_KEY = flag.String("encrypt-key", "", "File of age recipients or a PGP public key to encrypt the report written or uploaded to.")
+var LICENSE = flag.Bool("license", false, "Add a license summary, counting cores unless --license-model says otherwise.")
+var LICENSE_MODEL = flag.String("license-model", "", "Add a license summary counting 'nodes' or 'cores'.")
+var ENTITLEMENT = flag.String("entitlement", "", "JSON or YAML file of license limits, e.g. {\"max_cores\": 512}, to check the license summary against.")
+var CAPELLA_SIZING = flag.Bool("capella-sizing", false, "Add a Capella migration sizing appendix to the report.")
+var DRIFT_LABEL = flag.String("drift-label", "", "Compare settings across clusters sharing a value of this label, e.g. 'env'.")
+var CONSUMPTION_UNITS = flag.Bool("consumption-units", false, "Include Capella-style consumption-unit figures in the report.")
+var DIAL_TIMEOUT = flag.Duration("dial-timeout", 0, "Timeout for establishing connections to cluster nodes.")
+var TLS_HANDSHAKE_TIMEOUT = flag.Duration("tls-handshake-timeout", 0, "Timeout for TLS handshakes with cluster nodes.")
+var RESPONSE_HEADER_TIMEOUT = flag.Duration("response-header-timeout", 0, "Timeout waiting for a node to start responding to a request.")
+var MAX_CONNS_PER_HOST = flag.Int("max-conns-per-host", 0, "Maximum number of connections to each cluster node.")
+var DISABLE_HTTP2 = flag.Bool("disable-http2", false, "Use HTTP/1.1 even when the server supports HTTP/2.")
+var REPORT_DIR = flag.String("report-dir", "", "Directory to write timestamped reports to when no --output is given.")
+var KEEP_REPORTS = flag.Int("keep-reports", 0, "Number of most recent timestamped reports to keep, removing older ones.")
+var REPORT_MAX_AGE = flag.String("report-max-age", "", "Remove timestamped reports older than this, e.g. '30d'.")
+var SINK_REGION = flag.String("sink-region", "", "Region of the S3 bucket given with --output (default $AWS_REGION, or us-east-1).")
+var SINK_ENDPOINT = flag.String("sink-endpoint", "", "Endpoint for S3-compatible storage given with --output, e.g. 'https://minio.local:9000'.")
+var SINK_SSE = flag.String("sink-sse", "", "Server-side encryption for reports uploaded to S3: 'AES256' or 'aws:kms'.")
+var SINK_KMS_KEY = flag.String("sink-kms-key", "", "KMS key (S3 and GCS) or encryption scope (Azure) for uploaded reports.")
+var HISTORY = flag.String("history", "", "History store to record the key figures of each run in (a SQLite database if named *.sqlite).")
+var HISTORY_KEEP = flag.Int("history-keep", 0, "Number of most recent runs to keep in the history store.")
+var HISTORY_MAX_AGE = flag.String("history-max-age", "", "Discard runs older than this from the history store, e.g. '90d'.")
+var PUSH_URL = flag.String("push-url", "", "Push each summary to a central 'cbsummary receive' at this https URL.")
+var PUSH_KEY = flag.String("push-key", "", "File holding the key shared with the receiver for signing pushes.")
+var PUSH_CACERT = flag.String("push-cacert", "", "CA certificate for verifying the receiver's TLS certificate.")
+var AGENT_NAME = flag.String("agent-name", "", "Name identifying this agent to the receiver (default the hostname).")
+var EMAIL_TO = flag.String("email-to", "", "Comma-separated addresses to email each report to.")
+var EMAIL_FROM = flag.String("email-from", "", "Sender address for emailed reports (default cbsummary@<hostname>).")
+var SMTP_SERVER = flag.String("smtp-server", "", "SMTP server to send emailed reports through, as host:port.")
+var SMTP_AUTH = flag.String("smtp-auth", "", "File holding 'user:password' for authenticating to the SMTP server.")
+var POST_URL = flag.String("post-url", "", "URL to POST each JSON report to.")
+var POST_HEADERS_FILE = flag.String("post-headers", "", "File of 'Name: value' headers to send with --post-url, one per line.")
+var POST_CACERT = flag.String("post-cacert", "", "CA certificate for verifying the --post-url server's TLS certificate.")
+var POST_HEADERS stringList
+var NOTIFY_URL = flag.String("notify-url", "", "Slack or Microsoft Teams incoming webhook to post a digest of each report to.")
+var NOTIFY_TYPE = flag.String("notify-type", "", "Kind of --notify-url webhook, 'slack' or 'teams' (default guessed from the URL).")
+var QUERY_STATS = flag.Bool("query-stats", false, "Collect request and error counts from each cluster's query nodes.")
+var SERVICE_USAGE = flag.Bool("service-usage", false, "Collect memory and disk used by the index, search, analytics and eventing services.")
+var EVENTING_STATS = flag.Bool("eventing-stats", false, "Collect DCP backlog, timer and failure stats for eventing functions.")
+var BUCKET_STATS = flag.Bool("bucket-stats", false, "Collect the latest value of selected stats for each bucket.")
+var BUCKET_STAT_NAMES = flag.String("bucket-stat-names", strings.Join(cbsummary.DEFAULT_BUCKET_STATS, ","), "Comma-separated list of bucket stats collected by --bucket-stats.")
+var XDCR_STATS = flag.Bool("xdcr-stats", false, "Collect backlog, bandwidth and errors for XDCR replications.")
+var XDCR_LAG_THRESHOLD = flag.Int("xdcr-lag-threshold", cbsummary.DEFAULT_XDCR_LAG_THRESHOLD, "Changes left above which a replication is reported as lagging.")
+var TASKS = flag.Bool("tasks", false, "Collect the rebalances, compactions and XDCR tasks running on each cluster.")
+var HARDWARE = flag.Bool("hardware", false, "Collect a hardware inventory of CPUs and memory for each node.")
+var SECURITY = flag.Bool("security", false, "Collect auditing, LDAP, encryption and password policy settings, and the RBAC users.")
+var CERT_WARN_DAYS = flag.Int("cert-warn-days", cbsummary.DEFAULT_CERT_WARN_DAYS, "Days before expiry at which certificates are marked as expiring.")
+var EVENTS = flag.Int("events", 0, "Look through this many of each cluster's most recent log entries for failovers and node changes in full reports.")
+var MAX_COLLECTION_NAMES = flag.Int("max-collection-names", 0, "Most collection names to list for each bucket in full reports (default all).")
+var NODE_RTT = flag.Bool("node-rtt", false, "Measure the round trip to each node's management endpoint.")
+var SKIP_BUSY = flag.Bool("skip-busy", false, "Skip optional collection on clusters that are rebalancing or failing over.")
+var WAIT_BUSY = flag.Duration("wait-busy", 0, "Wait up to this long for a busy cluster before skipping optional collection.")
+var PROFILE = flag.String("profile", "", "Collect what a common use needs: license, health, capacity or security.")
+var CHECKPOINT = flag.String("checkpoint", "", "File to record each collected cluster in, so an interrupted run can be resumed.")
+var RESUME = flag.Bool("resume", false, "Resume from the --checkpoint file without asking.")
+var CONNECT_TIMEOUT = flag.Duration("connect-timeout", 0, "Longest any one request to a node may take, e.g. '30s'.")
+var CLUSTER_TIMEOUT = flag.Duration("cluster-timeout", 0, "Longest to spend collecting any one cluster, e.g. '5m'.")
+var DEADLINE = flag.Duration("deadline", 0, "Longest to spend on the whole run, e.g. '10m'; clusters not collected by then are reported as timed out.")
+var RETRIES = flag.Int("retries", -1, "Times to retry a request that fails transiently (default 2).")
+var RETRY_BACKOFF = flag.Duration("retry-backoff", 0, "Delay before the first retry, doubled for each one after (default 500ms).")
+var MAX_REQUESTS_PER_SECOND = flag.Float64("max-requests-per-second", 0, "Most REST requests a second to make of any one cluster (default no limit).")
+var RETRY_JITTER = flag.Float64("retry-jitter", 0, "Fraction of each retry delay to randomize (default 0.5).")
+var CACERT = flag.String("cacert", "", "PEM file of CA certificates to verify https:// cluster endpoints with.")
+var NO_SSL_VERIFY = flag.Bool("no-ssl-verify", false, "Don't verify the certificates of https:// cluster endpoints.")
+var CLIENT_CERT = flag.String("client-cert", "", "PEM file of an X.509 client certificate to authenticate to clusters with.")
+var CLIENT_KEY = flag.String("client-key", "", "PEM file of the private key for --client-cert.")
+var CONFIG_FORMAT = flag.String("config-format", "", "Format of the config file, json or yaml (default by file name).")
+var CONFIG_KEY_FILE = flag.String("config-key-file", "", "Key file for decrypting an encrypted config file.")
+var PASSWORD_FROM_STDIN = flag.Bool("password-from-stdin", false, "Read the password for clusters that don't give one from standard input.")
+var NODE_CACHE = flag.String("node-cache", "", "File remembering each cluster's nodes, for reaching it when the configured nodes are down.")
+var MAX_CONCURRENCY = flag.Int("max-concurrency", 4, "Number of clusters to collect at the same time.")
+var DAEMON = flag.Bool("daemon", false, "Keep running, writing a new report every --interval.")
+var INTERVAL = flag.Duration("interval", 24*time.Hour, "Time between reports in daemon mode.")
+var LISTEN = flag.String("listen", "", "Address to serve the /health endpoint and collection API on in daemon mode, e.g. ':9911'.")
+var API_TOKEN = flag.String("api-token", "", "File holding a bearer token required by the collection API.")
+var REPORT_AUTH = flag.String("report-auth", "", "File holding 'user:password' required to fetch /report and /metrics.")
+var PID_FILE = flag.String("pid-file", "", "File to record the process ID in while running as a daemon.")
+var HEALTH_THRESHOLDS = flag.String("health-thresholds", "", "JSON or YAML file overriding the thresholds of 'cbsummary check', and turning rules off.")
+var QUIET = flag.Bool("quiet", false, "Only log warnings and errors.")
+var VERBOSE = flag.Bool("verbose", false, "Log each cluster as it is collected, and other detail.")
+var DEBUG = flag.Bool("debug", false, "Log every REST call, writing the log lines as JSON.")
+var LOG_FILE = flag.String("log-file", "", "File to append the log lines to, instead of the console.")
+var REDACT = flag.Bool("redact", false, "Replace hostnames, cluster names and UUIDs in the report with tokens, for sharing it.")
+var REDACT_MAP = flag.String("redact-map", cbsummary.DEFAULT_REDACT_MAP, "File keeping the tokens --redact gives, and what they stand for.")
+var RAW_DIR = flag.String("raw-dir", "", "With 'cbsummary collect', a directory to save every REST response in, for 'cbsummary report'.")
+var FROM_RAW = flag.String("from-raw", "", "With 'cbsummary report', a directory of responses saved by 'cbsummary collect' to report on.")
+var CHECK_REPORT = flag.String("report", "", "With 'cbsummary check', a JSON report to check instead of collecting the clusters.")
 
-// count of buckets of different types
-type BucketSummary struct {
-	Emphemeral int `json:"ephemeral"`
-	Membase    int `json:"membase"`
-	Memcached  int `json:"memcached"`
-	Total      int `json:"total"`
+// a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
 }
 
-// cluster settings
-type ClusterSettings struct {
-	//Compaction CompactionSettings `json:"compaction"`
-	EnableAutoFailover bool   `json:"enable_auto_failover"`
-	FailoverTimeout    int    `json:"failover_timeout"`
-	IndexStorageMode   string `json:"index_storage_mode"`
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-// types for ODP reports
-type BriefCluster struct {
-	Nodes []BriefNode `json:"nodes"`
-	Size  int         `json:"cluster_size"`
-	UUID  string      `json:"cluster_uuid"`
+func main() {
+	cbsummary.SetToolVersion(buildVersion())
+	if status, ok := RunSubcommand(os.Args[1:]); ok {
+		os.Exit(status)
+	}
+	os.Exit(run())
 }
 
-type BriefNode struct {
-	Cores   float64 `json:"cpu_cores_available"`
-	RAM     float64 `json:"mem_total"`
-	Name    string  `json:"hostname"`
-	Version string  `json:"version"`
+// the version and commit built, falling back on what the Go toolchain recorded
+func buildVersion() (string, string) {
+	v, hash := version, versionHash
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if len(v) == 0 && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		settings := make(map[string]string)
+		for _, setting := range info.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		if revision := settings["vcs.revision"]; len(hash) == 0 && len(revision) > 0 {
+			if len(revision) > 7 {
+				revision = revision[:7]
+			}
+			if settings["vcs.modified"] == "true" {
+				revision += "-dirty"
+			}
+			hash = revision
+		}
+	}
+	return v, hash
 }
 
-type ClusterInfo struct {
-	AdminAuditEnabled bool            `json:"adminAuditEnabled"`
-	AdminLDAPEnabled  bool            `json:"adminLDAPEnabled"`
-	Buckets           BucketSummary   `json:"buckets"`
-	Cluster_Settings  ClusterSettings `json:"cluester_settings"`
+// a context cancelled by the first SIGINT or SIGTERM until stop is called, so
+// the clusters not yet collected can be reported as interrupted. A second
+// signal, or one after stop, exits at once as usual.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			cbsummary.LogWarn("Received %v, stopping; the report will be written with what has been collected.", sig)
+			cancel()
+		case <-done:
+			signal.Stop(signals)
+		}
+	}()
+	return ctx, func() { close(done) }
 }
 
-type SummaryInfo struct {
-	NumClusters   int            `json:"#clusters"`
-	TotalNumNodes int            `json:"#nodes"`
-	NodeVersions  map[string]int `json:"#nodeVersions"`
-	Clusters      []interface{}  `json:"clusters"`
+// the first of the options given that needs the whole report at once, which
+// --stream doesn't keep
+func streamConflict(check bool) string {
+	conflicts := []struct {
+		set  bool
+		name string
+	}{
+		{check, "'cbsummary check'"},
+		{*DAEMON, "--daemon"},
+		{*FORMAT != cbsummary.FORMAT_JSON, "--format=" + *FORMAT},
+		{len(*TEMPLATE) > 0, "--template"},
+		{*SPLIT_PER_CLUSTER, "--split-per-cluster"},
+		{*COMPRESS, "--compress"},
+		{len(*ENCRYPT_KEY) > 0, "--encrypt-key"},
+		{cbsummary.IsSinkURL(*OUTPUT_FILE), "an --output URL"},
+		{*KEEP_REPORTS > 0 || len(*REPORT_MAX_AGE) > 0, "--keep-reports or --report-max-age"},
+		{len(*HISTORY) > 0, "--history"},
+		{len(*PUSH_URL) > 0, "--push-url"},
+		{len(*POST_URL) > 0, "--post-url"},
+		{len(*NOTIFY_URL) > 0, "--notify-url"},
+		{len(*EMAIL_TO) > 0, "--email-to"},
+		{*REDACT, "--redact"},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return conflict.name
+		}
+	}
+	return ""
 }
 
-type ClusterError struct {
-	TheCluster Cluster `json:"error_with_cluster"`
-	ErrMsg     string  `json:"error_message"`
+// the exit codes, for automation to react to
+const (
+	EXIT_OK           = 0 // every cluster was collected and the report delivered
+	EXIT_PARTIAL      = 1 // some clusters couldn't be collected, or the report couldn't be delivered
+	EXIT_USAGE        = 2 // the command line or configuration is wrong, so nothing was collected
+	EXIT_CHECK_FAILED = 3 // the health check found critical problems, or the entitlement is exceeded
+)
+
+// the exit code for the findings, failing the check for any critical ones
+func FindingsExitStatus(findings []cbsummary.Finding) int {
+	if cbsummary.HasCriticalFindings(findings) {
+		return EXIT_CHECK_FAILED
+	}
+	return EXIT_OK
 }
 
-// flags for the command-line
+// generate a report, or check the clusters' health, returning the exit code
+func run() int {
+	flag.StringVar(USERNAME, "u", "", "Short for --username.")
+	flag.StringVar(PASSWORD, "p", "", "Short for --password.")
+	flag.Var(&POST_HEADERS, "post-header", "Header to send with --post-url, as 'Name: value'; can be repeated.")
 
-var CONFIG_FILE = flag.String("config", "", "Config file listing clusters and credentials to summarize.")
-var OUTPUT_FILE = flag.String("output", "", "Name for output file (default cbsummary.out.<timestamp>).")
-var HELP = flag.Bool("help", false, "Print a help message.")
-var FULL = flag.Bool("full", false, "Produce an extensive report, instead of just core and RAM usage.")
-var CSV = flag.Bool("csv", false, "Produce a report in CSV format. Not compatible with full reports.")
+	// 'cbsummary check' takes the same flags, collecting the clusters to check their health, as
+	// do 'cbsummary collect', saving the REST responses, and 'cbsummary report', reporting on them
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && (args[0] == "check" || args[0] == "collect" || args[0] == "report") {
+		command = args[0]
+		args = args[1:]
+	}
+	check := command == "check"
+	flag.CommandLine.Parse(args)
 
-func main() {
-	flag.Parse()
+	if *VERSION {
+		fmt.Printf("cbsummary %s, %s install, %s %s/%s\n", cbsummary.ToolVersion(), installType,
+			runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return EXIT_OK
+	}
+
+	// keep standard output for the report when it's written there
+	if *OUTPUT_FILE == cbsummary.OUTPUT_STDOUT {
+		cbsummary.SetConsole(os.Stderr)
+	}
+	if err := cbsummary.SetupLogging(*QUIET, *VERBOSE, *DEBUG, *LOG_FILE); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
+	}
+
+	thresholds := cbsummary.DEFAULT_HEALTH_THRESHOLDS
+	if check && len(*HEALTH_THRESHOLDS) > 0 {
+		var err error
+		thresholds, err = cbsummary.LoadHealthThresholds(*HEALTH_THRESHOLDS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
+	if check && len(*CHECK_REPORT) > 0 {
+		findings, err := cbsummary.CheckReportHealth(*CHECK_REPORT, thresholds)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		return FindingsExitStatus(findings)
+	}
 
 	// help message
-	if *HELP || len(*CONFIG_FILE) == 0 {
-		fmt.Printf("usage: cbsummary --config=<config file> [--output=<output file>] [--full]\n\n")
-		fmt.Printf("  cbsummary connects to a set of Couchbase clusters and generates a summary report.\n\n")
-		fmt.Printf("  The config file contains JSON specifying an array of information on each cluster,\n")
-		fmt.Printf("  giving the Couchbase login/password and one or more IP addresses for cluster nodes.\n")
-		fmt.Printf("  An example config file giving information about 2 clusters is:\n\n")
-		fmt.Printf("  { \"clusters\": [\n")
-		fmt.Printf("    {\"login\": \"Administrator\", \"pass\": \"password1\", \"nodes\": [\"http://192.168.1.1:8091\"]},\n")
-		fmt.Printf("    {\"login\": \"Administrator\", \"pass\": \"password2\", \"nodes\": [\"http://192.166.1.1:8091\",\"http://192.16.1.2:8091\"]}\n")
-		fmt.Printf("  ]}\n\n")
-		fmt.Printf("  The default report format includes RAM and Core utilization across each specified cluster,\n")
-		fmt.Printf("  since that information is useful in determining compliance with Couchbase licenses. If you\n")
-		fmt.Printf("  specify --csv, then the report is generated in CSV instead of JSON. If, instead, you\n")
-		fmt.Printf("  specify --full, then a much more detailed report is generated.\n\n")
-		fmt.Printf("  The summary report is sent to the file 'cbsummary.out.<timestamp>', unless a different\n")
-		fmt.Printf("  file name is specified with the --output option.\n\n")
-		return
-	}
-
-	// can't have both FULL and CSV
-	if *FULL && *CSV {
-		fmt.Printf("CSV format is not available for full reports.\n\n")
-		return
+	if *HELP || (len(*CONFIG_FILE) == 0 && len(*CLUSTER) == 0 && len(*FROM_RAW) == 0 && !*KUBE) {
+		// to standard output when asked for, or standard error when the flags are wrong
+		out := os.Stdout
+		if !*HELP {
+			out = os.Stderr
+		}
+		fmt.Fprintf(out, "usage: cbsummary --config=<config file> [--output=<output file>] [--full]\n")
+		fmt.Fprintf(out, "       cbsummary --cluster=<URL or connection string> -u <login> [-p <password>] [...]\n")
+		fmt.Fprintf(out, "       cbsummary --kube [--kubeconfig=<file>] [--kube-namespace=<namespace>] [...]\n")
+		fmt.Fprintf(out, "       cbsummary check --config=<config file> | --report=<JSON report> [...]\n")
+		fmt.Fprintf(out, "       cbsummary collect --config=<config file> --raw-dir=<dir> [...]\n")
+		fmt.Fprintf(out, "       cbsummary report --from-raw=<dir> [--output=<output file>] [...]\n\n")
+		fmt.Fprintf(out, "  cbsummary connects to a set of Couchbase clusters and generates a summary report.\n\n")
+		fmt.Fprintf(out, "  The config file contains JSON specifying an array of information on each cluster,\n")
+		fmt.Fprintf(out, "  giving the Couchbase login/password and one or more IP addresses for cluster nodes.\n")
+		fmt.Fprintf(out, "  An example config file giving information about 2 clusters is:\n\n")
+		fmt.Fprintf(out, "  { \"clusters\": [\n")
+		fmt.Fprintf(out, "    {\"login\": \"Administrator\", \"pass\": \"password1\", \"nodes\": [\"http://192.168.1.1:8091\"]},\n")
+		fmt.Fprintf(out, "    {\"login\": \"Administrator\", \"pass\": \"password2\", \"nodes\": [\"http://192.166.1.1:8091\",\"http://192.16.1.2:8091\"]}\n")
+		fmt.Fprintf(out, "  ]}\n\n")
+		fmt.Fprintf(out, "  Nodes may also be SDK connection strings, e.g. \"couchbases://cluster.example.com\",\n")
+		fmt.Fprintf(out, "  which are translated to the admin REST endpoints. A single host name without a port is\n")
+		fmt.Fprintf(out, "  looked up as a DNS SRV record first, as the SDKs do.\n\n")
+		fmt.Fprintf(out, "  Clusters in Capella are added to the report from a \"capella\" section listing\n")
+		fmt.Fprintf(out, "  organizations and management API keys, e.g.\n\n")
+		fmt.Fprintf(out, "  \"capella\": [{\"organization_id\": \"...\", \"api_key\": \"...\", \"api_secret\": \"$CAPELLA_SECRET\"}]\n\n")
+		fmt.Fprintf(out, "  The config file may be YAML instead, with the same keys, if it's named *.yaml or\n")
+		fmt.Fprintf(out, "  *.yml or --config-format=yaml is given.\n\n")
+		fmt.Fprintf(out, "  For a quick look at one cluster, --cluster=<URL or connection string> with\n")
+		fmt.Fprintf(out, "  --username/-u and --password/-p stands in for a config file. Without a password\n")
+		fmt.Fprintf(out, "  cbsummary asks for one at the terminal.\n\n")
+		fmt.Fprintf(out, "  With --kube, the clusters are the CouchbaseCluster resources of the Couchbase Operator,\n")
+		fmt.Fprintf(out, "  found through --kubeconfig, $KUBECONFIG, the pod's service account or ~/.kube/config,\n")
+		fmt.Fprintf(out, "  using --kube-context or the current context, in --kube-namespace or all namespaces.\n")
+		fmt.Fprintf(out, "  Any of kubectl's ways of authenticating work, including exec plugins. Each cluster is\n")
+		fmt.Fprintf(out, "  reached through its admin console's load balancer, if it has one, or, when cbsummary\n")
+		fmt.Fprintf(out, "  runs in a pod, its service's in-cluster DNS name, with the login and password from its\n")
+		fmt.Fprintf(out, "  admin secret, and is labeled with its name and kube_namespace. Elsewhere, give the\n")
+		fmt.Fprintf(out, "  clusters' address with --kube-endpoint, e.g. 'https://{name}.{namespace}.example.com:18091'\n")
+		fmt.Fprintf(out, "  for an ingress. Listing the resources and reading the services and secrets is all the\n")
+		fmt.Fprintf(out, "  access needed. Clusters with TLS enabled are reached on port 18091; give their CA\n")
+		fmt.Fprintf(out, "  certificate with --cacert.\n\n")
+		fmt.Fprintf(out, "  'cbsummary import' can build this file from existing SDK connection strings or\n")
+		fmt.Fprintf(out, "  connection profiles; run 'cbsummary import --help' for details.\n\n")
+		fmt.Fprintf(out, "  'cbsummary validate --config=<file>' checks a config file without generating a report,\n")
+		fmt.Fprintf(out, "  and with --probe makes sure each node can be reached and accepts the credentials.\n\n")
+		fmt.Fprintf(out, "  To keep passwords out of the config file, \"pass\" (or \"login\") may be \"$NAME\" to read\n")
+		fmt.Fprintf(out, "  it from the environment variable NAME, and a cluster with \"prompt\": true and no\n")
+		fmt.Fprintf(out, "  password asks for one at the terminal. --password-from-stdin reads one password from\n")
+		fmt.Fprintf(out, "  standard input for all the clusters without one, e.g. from a secrets manager.\n\n")
+		fmt.Fprintf(out, "  Credentials may also be read from HashiCorp Vault, given as \"vault:<path>#<field>\",\n")
+		fmt.Fprintf(out, "  e.g. \"pass\": \"vault:secret/couchbase/prod#password\". Vault is reached with a token\n")
+		fmt.Fprintf(out, "  or an AppRole role_id and secret_id, from a \"vault\" section of the config, e.g.\n\n")
+		fmt.Fprintf(out, "  \"vault\": {\"address\": \"https://vault:8200\", \"role_id\": \"...\", \"secret_id\": \"$VAULT_SECRET_ID\"}\n\n")
+		fmt.Fprintf(out, "  or from VAULT_ADDR, VAULT_TOKEN, VAULT_ROLE_ID, VAULT_SECRET_ID, VAULT_NAMESPACE and\n")
+		fmt.Fprintf(out, "  VAULT_CACERT in the environment.\n\n")
+		fmt.Fprintf(out, "  Likewise \"awssm:<secret>[#<field>]\" reads a credential from AWS Secrets Manager, by\n")
+		fmt.Fprintf(out, "  name or ARN, and \"ssm:<parameter>\" from SSM Parameter Store. A secret holding JSON,\n")
+		fmt.Fprintf(out, "  as rotated credentials do, gives the field named, or else its \"username\" for a login\n")
+		fmt.Fprintf(out, "  and \"password\" for a password. The usual AWS credentials are used - the environment,\n")
+		fmt.Fprintf(out, "  AWS_PROFILE and the shared config files, or an instance or task role - in the ARN's\n")
+		fmt.Fprintf(out, "  region or the configured one.\n\n")
+		fmt.Fprintf(out, "  For clusters run by the Couchbase Operator, \"k8s:<namespace>/<secret>/<key>\" reads a\n")
+		fmt.Fprintf(out, "  credential from a Kubernetes secret, through the API found as for --kube or as given\n")
+		fmt.Fprintf(out, "  in a \"kubernetes\" section of the config, e.g. {\"kubeconfig\": \"...\", \"context\": \"...\"}.\n\n")
+		fmt.Fprintf(out, "  'cbsummary encrypt-config' encrypts the config file with a passphrase or key file.\n")
+		fmt.Fprintf(out, "  An encrypted config is decrypted when loaded, with --config-key-file=<file>, the\n")
+		fmt.Fprintf(out, "  passphrase in %s, or the passphrase asked for at the terminal.\n\n", cbsummary.CONFIG_PASSPHRASE_ENV)
+		fmt.Fprintf(out, "  A cluster may also give a \"label\" and \"tags\", e.g. \"label\": \"prod-eu\" and\n")
+		fmt.Fprintf(out, "  \"tags\": [\"finance\", \"tier1\"], which are carried into the report.\n\n")
+		fmt.Fprintf(out, "  A cluster may be assigned an \"environment\", or other group, e.g. \"environment\": \"prod\".\n")
+		fmt.Fprintf(out, "  The report then has environment_rollups, giving the clusters, nodes, cores and RAM of\n")
+		fmt.Fprintf(out, "  each environment, in total and by edition, with clusters without one as 'unassigned'.\n\n")
+		fmt.Fprintf(out, "  A cluster may also give \"headers\", an object of extra HTTP headers to send with every\n")
+		fmt.Fprintf(out, "  request to that cluster, e.g. {\"X-Api-Key\": \"...\"} for clusters behind an auth proxy.\n\n")
+		fmt.Fprintf(out, "  The config file may also have a \"transport\" section tuning the connections to the\n")
+		fmt.Fprintf(out, "  clusters, useful over slow WAN links, e.g.\n\n")
+		fmt.Fprintf(out, "  \"transport\": {\"dial_timeout\": \"30s\", \"tls_handshake_timeout\": \"20s\",\n")
+		fmt.Fprintf(out, "                \"response_header_timeout\": \"2m\", \"max_conns_per_host\": 4}\n\n")
+		fmt.Fprintf(out, "  The flags --dial-timeout, --tls-handshake-timeout, --response-header-timeout and\n")
+		fmt.Fprintf(out, "  --max-conns-per-host override these settings. HTTP/2 is used where the server supports\n")
+		fmt.Fprintf(out, "  it, unless --disable-http2 is given or \"disable_http2\" is true.\n\n")
+		fmt.Fprintf(out, "  The certificates of https:// nodes are checked against the system's trusted CAs, or\n")
+		fmt.Fprintf(out, "  against the CA certificates in --cacert=<PEM file> for clusters using a private CA.\n")
+		fmt.Fprintf(out, "  --no-ssl-verify turns checking off, leaving the connections open to man-in-the-middle\n")
+		fmt.Fprintf(out, "  attacks.\n\n")
+		fmt.Fprintf(out, "  Clusters requiring X.509 client certificate authentication take a certificate and key\n")
+		fmt.Fprintf(out, "  from --client-cert=<PEM file> and --client-key=<PEM file>, or per cluster from\n")
+		fmt.Fprintf(out, "  \"client_cert\" and \"client_key\" in the config file. The login and password aren't\n")
+		fmt.Fprintf(out, "  sent to clusters that are given a client certificate.\n\n")
+		fmt.Fprintf(out, "  All of a cluster's nodes are tried at once, and the first to answer is used. The\n")
+		fmt.Fprintf(out, "  nodes each cluster reports are remembered and tried too, so a cluster whose configured\n")
+		fmt.Fprintf(out, "  node is down can still be reached through the others; --node-cache=<file> keeps them\n")
+		fmt.Fprintf(out, "  from one run to the next.\n\n")
+		fmt.Fprintf(out, "  By default cbsummary waits as long as a node takes to answer. --connect-timeout=<duration>\n")
+		fmt.Fprintf(out, "  (or \"connect_timeout\" in the transport section) limits each request, from connecting\n")
+		fmt.Fprintf(out, "  to reading the response, and --cluster-timeout=<duration> limits the time spent on any\n")
+		fmt.Fprintf(out, "  one cluster; when it runs out, collection moves on and the cluster is reported as an\n")
+		fmt.Fprintf(out, "  error, or its optional sections as failed if its basic details are already in.\n")
+		fmt.Fprintf(out, "  --deadline=<duration> bounds the whole run, e.g. to a maintenance window: clusters not\n")
+		fmt.Fprintf(out, "  collected when it passes are reported with a \"timed out\" error, and the report is\n")
+		fmt.Fprintf(out, "  written with the rest. Any --checkpoint is kept, for resuming the run.\n\n")
+		fmt.Fprintf(out, "  Requests that fail transiently (503s, connection resets) are retried --retries times\n")
+		fmt.Fprintf(out, "  (default 2), waiting --retry-backoff (default 500ms) before the first retry and twice\n")
+		fmt.Fprintf(out, "  as long before each one after, less a random --retry-jitter fraction (default 0.5).\n")
+		fmt.Fprintf(out, "  The transport section takes \"retries\", \"retry_backoff\" and \"retry_jitter\" too.\n")
+		fmt.Fprintf(out, "  Authentication and permission errors are never retried.\n\n")
+		fmt.Fprintf(out, "  To go easy on production clusters, --max-requests-per-second=<n> (or\n")
+		fmt.Fprintf(out, "  \"max_requests_per_second\" in the transport section) spaces out the requests made of\n")
+		fmt.Fprintf(out, "  each cluster, e.g. 5 for one every 200ms. Fractions such as 0.5 are allowed.\n\n")
+		fmt.Fprintf(out, "  Up to --max-concurrency clusters (default 4) are collected at the same time, so a few\n")
+		fmt.Fprintf(out, "  slow or unreachable clusters don't hold up the rest.\n\n")
+		fmt.Fprintf(out, "  For very large fleets, --stream writes each cluster to the JSON report as soon as it\n")
+		fmt.Fprintf(out, "  and the clusters before it are collected, and then lets it go, so memory doesn't grow\n")
+		fmt.Fprintf(out, "  with the number of clusters. The totals follow the clusters at the end of the report.\n")
+		fmt.Fprintf(out, "  It can't be combined with other formats, --split, --compress, --encrypt-key, uploads,\n")
+		fmt.Fprintf(out, "  --history, --push, --post, --notify, --email or --redact; {cluster} in --output is\n")
+		fmt.Fprintf(out, "  always \"clusters\".\n\n")
+		fmt.Fprintf(out, "  The default report format includes RAM and Core utilization across each specified cluster,\n")
+		fmt.Fprintf(out, "  since that information is useful in determining compliance with Couchbase licenses. If you\n")
+		fmt.Fprintf(out, "  specify --csv, then the report is generated in CSV instead of JSON. If you specify\n")
+		fmt.Fprintf(out, "  --full, then a much more detailed report is generated, with sections for:\n")
+		fmt.Fprintf(out, "    - each bucket's type, quota, replicas and item count, and its last minute of stats\n")
+		fmt.Fprintf(out, "    - the scopes and collections of each bucket on 7.0 and later; --max-collection-names\n")
+		fmt.Fprintf(out, "      limits how many names are listed for each bucket\n")
+		fmt.Fprintf(out, "    - the GSI and Full-Text Search indexes, and the memory used by the search service\n")
+		fmt.Fprintf(out, "    - the analytics datasets and ingestion, and the memory used by the analytics service\n")
+		fmt.Fprintf(out, "    - the eventing functions, and the query service statistics\n")
+		fmt.Fprintf(out, "    - the service layout: the nodes running each service, and each service's memory\n")
+		fmt.Fprintf(out, "      quota against what it uses, in total and on each index, search, analytics and\n")
+		fmt.Fprintf(out, "      eventing node; services using %.0f%% or more of their quota are marked near_quota\n", cbsummary.SERVICE_NEAR_QUOTA_PERCENT)
+		fmt.Fprintf(out, "    - the XDCR remote clusters and replications\n")
+		fmt.Fprintf(out, "    - the rebalances and compactions running, with their progress, as with --tasks\n")
+		fmt.Fprintf(out, "    - the security settings and RBAC users\n")
+		fmt.Fprintf(out, "    - the auto-failover, auto-reprovision, auto-compaction and index storage settings,\n")
+		fmt.Fprintf(out, "      and the buckets overriding the auto-compaction settings\n")
+		fmt.Fprintf(out, "    - the server groups, flagging rack awareness with unequal groups of data nodes\n")
+		fmt.Fprintf(out, "    - the email alert recipients and alert types; clusters with no email alerting are\n")
+		fmt.Fprintf(out, "      listed in clusters_without_alerting\n")
+		fmt.Fprintf(out, "    - the subject, issuer and days until expiry of the cluster and node certificates;\n")
+		fmt.Fprintf(out, "      those expiring within --cert-warn-days (default 30) are marked as expiring\n")
+		fmt.Fprintf(out, "    - with --events=<n>, the failovers, auto-failovers and nodes joining or leaving the\n")
+		fmt.Fprintf(out, "      cluster among the n most recent entries of its event log, newest first\n")
+		fmt.Fprintf(out, "  With --csv, a full report has a row for each node, with the cluster's fields repeated on\n")
+		fmt.Fprintf(out, "  each row and nested fields named with dot notation, e.g. buckets.counts.total.\n")
+		fmt.Fprintf(out, "  --format=html produces a self-contained web page instead; when a --history store is\n")
+		fmt.Fprintf(out, "  also given, it includes charts of each cluster's nodes, cores and RAM over the recorded\n")
+		fmt.Fprintf(out, "  runs. --format=xlsx produces a spreadsheet with a fleet summary sheet, giving the nodes,\n")
+		fmt.Fprintf(out, "  cores and RAM of each cluster, and a sheet for each cluster listing its nodes.\n")
+		fmt.Fprintf(out, "  --format=yaml gives the same report as the JSON, as YAML with its keys sorted.\n")
+		fmt.Fprintf(out, "  --format=jsonl writes a JSON object for each cluster on a line of its own, for streaming\n")
+		fmt.Fprintf(out, "  into jq or a log pipeline; with --jsonl-nodes there's a line for each node instead.\n")
+		fmt.Fprintf(out, "  --format=prom gives the clusters, nodes, cores and RAM as Prometheus gauges, e.g.\n")
+		fmt.Fprintf(out, "  cbsummary_cluster_nodes{cluster_uuid=...}, for a node_exporter textfile collector.\n\n")
+		fmt.Fprintf(out, "  For any other layout, --template=<file> renders the report through a Go text/template,\n")
+		fmt.Fprintf(out, "  with the summary as its data, e.g. {{range .Clusters}}{{if eq (kind .) \"brief\"}}...\n")
+		fmt.Fprintf(out, "  Each cluster is \"brief\", \"full\" or \"error\" by the kind function; join and json\n")
+		fmt.Fprintf(out, "  are also available.\n\n")
+		fmt.Fprintf(out, "  For nodes running in containers (e.g. Kubernetes), servers that report both the host CPU\n")
+		fmt.Fprintf(out, "  count and the effective CPU limit (cgroup quota) have the limit reported as the node's\n")
+		fmt.Fprintf(out, "  cores, with 'host_cpu_count' and 'cpu_limited' added when the two differ. Servers before\n")
+		fmt.Fprintf(out, "  6.5 don't report the limit, so their nodes' cores fall back to the host CPU count. The\n")
+		fmt.Fprintf(out, "  brief CSV report has 'cpu_limited' and 'host_cpu_count' columns.\n\n")
+		fmt.Fprintf(out, "  Nodes that are failed over, added but not yet rebalanced in, or marked for recovery and\n")
+		fmt.Fprintf(out, "  so pending a rebalance are listed in each cluster's membership_anomalies, and counted\n")
+		fmt.Fprintf(out, "  across the fleet in node_membership. With --full --events=<n>, failed-over nodes also\n")
+		fmt.Fprintf(out, "  give the time of their last failover from the event log.\n\n")
+		fmt.Fprintf(out, "  Optional sections can be added to both brief and full reports:\n")
+		fmt.Fprintf(out, "    --query-stats    request, error, active, queued and prepared counts, and memory, from the\n")
+		fmt.Fprintf(out, "                     query service, showing which clusters actually serve N1QL traffic\n")
+		fmt.Fprintf(out, "    --service-usage  memory and disk used by the index, search (FTS), analytics and eventing\n")
+		fmt.Fprintf(out, "                     services on each node, against their memory quotas\n")
+		fmt.Fprintf(out, "    --eventing-stats DCP backlog, timer and failure counts for each eventing function\n")
+		fmt.Fprintf(out, "    --bucket-stats   the latest value of selected stats for each bucket, by default\n")
+		fmt.Fprintf(out, "                     %s; choose others with --bucket-stat-names\n", strings.Join(cbsummary.DEFAULT_BUCKET_STATS, ", "))
+		fmt.Fprintf(out, "    --xdcr-stats     changes left, bandwidth and errors for each XDCR replication;\n")
+		fmt.Fprintf(out, "                     replications with more than --xdcr-lag-threshold changes left\n")
+		fmt.Fprintf(out, "                     are flagged as lagging, those with errors as broken\n")
+		fmt.Fprintf(out, "    --tasks          the rebalances and failovers running, with their progress, the bucket\n")
+		fmt.Fprintf(out, "                     compactions running and the XDCR tasks, marking clusters that are\n")
+		fmt.Fprintf(out, "                     mid-operation; always in full reports\n")
+		fmt.Fprintf(out, "    --hardware       CPU threads, available cores, memory, platform and architecture\n")
+		fmt.Fprintf(out, "                     of each node\n")
+		fmt.Fprintf(out, "    --security       whether auditing and LDAP are enabled, the cluster and node-to-node\n")
+		fmt.Fprintf(out, "                     encryption, minimum TLS version and password policy, and the\n")
+		fmt.Fprintf(out, "                     internal and external users, their roles and the full admins\n")
+		fmt.Fprintf(out, "    --node-rtt       round-trip times to each node's management endpoint, and the time\n")
+		fmt.Fprintf(out, "                     taken to collect the whole cluster\n\n")
+		fmt.Fprintf(out, "  To avoid adding load at the worst time, --skip-busy skips these sections, and the rest of a\n")
+		fmt.Fprintf(out, "  --full report, on clusters that are rebalancing or failing over, and --wait-busy=<duration>\n")
+		fmt.Fprintf(out, "  (e.g. '10m') first waits up to that long for the cluster to finish. The basic cluster and\n")
+		fmt.Fprintf(out, "  node details and the tasks are always collected.\n\n")
+		fmt.Fprintf(out, "  Rather than choosing sections one by one, --profile=<name> selects a bundle of them:\n")
+		fmt.Fprintf(out, "    license   --license-model=cores --consumption-units --hardware\n")
+		fmt.Fprintf(out, "    health    --query-stats --eventing-stats --bucket-stats --xdcr-stats --tasks --node-rtt\n")
+		fmt.Fprintf(out, "              --skip-busy\n")
+		fmt.Fprintf(out, "    capacity  --service-usage --bucket-stats --hardware --consumption-units --capella-sizing\n")
+		fmt.Fprintf(out, "    security  --full --security\n")
+		fmt.Fprintf(out, "  Options given on the command line override those of the profile.\n\n")
+		fmt.Fprintf(out, "  If you specify --license, a license summary is added giving per-cluster and total figures,\n")
+		fmt.Fprintf(out, "  with the licensed total counted in cores, or in nodes or cores as --license-model=nodes or\n")
+		fmt.Fprintf(out, "  --license-model=cores says, to match the contract (--license-model alone adds the summary\n")
+		fmt.Fprintf(out, "  too). In CSV reports the summary follows the node rows as a separate table.\n")
+		fmt.Fprintf(out, "  The totals are also broken down by edition (Enterprise or Community).\n\n")
+		fmt.Fprintf(out, "  With --entitlement=<file>, a JSON or YAML file such as {\"max_cores\": 512} giving any of\n")
+		fmt.Fprintf(out, "  max_nodes, max_cores and max_ram_gb, the license summary (counting cores unless\n")
+		fmt.Fprintf(out, "  --license-model says otherwise) is checked against those limits. Community Edition\n")
+		fmt.Fprintf(out, "  nodes don't count against them. Any limit exceeded is reported as an overage, in the\n")
+		fmt.Fprintf(out, "  report and on the console, and cbsummary exits with status 3.\n\n")
+		fmt.Fprintf(out, "  If you specify --consumption-units, the report also converts the cores, RAM and services\n")
+		fmt.Fprintf(out, "  of every node into Capella-style consumption units (CUs), using:\n\n")
+		fmt.Fprintf(out, "    node CUs = (cores + RAM_GB / 4) * max(service weight)\n\n")
+		fmt.Fprintf(out, "  with service weights kv=1.0, index=1.0, n1ql=1.0, fts=1.0, eventing=1.0, cbas=1.25\n")
+		fmt.Fprintf(out, "  and backup=0.5. Nodes older than 6.5 count their host CPUs as cores (see above); only\n")
+		fmt.Fprintf(out, "  nodes with no core count from either are estimated from RAM.\n")
+		fmt.Fprintf(out, "  These figures are an approximation for comparison with Capella pricing, not a quote.\n\n")
+		fmt.Fprintf(out, "  If you specify --capella-sizing, the report gets a migration-planning appendix that\n")
+		fmt.Fprintf(out, "  suggests a Capella cluster for each cluster: nodes are grouped by the services they run,\n")
+		fmt.Fprintf(out, "  and each group is fitted to a Capella node size from its cores (scaled by observed CPU\n")
+		fmt.Fprintf(out, "  utilization against a 70%% target) and RAM, with at least 3 nodes for data and 2 for\n")
+		fmt.Fprintf(out, "  other services.\n\n")
+		fmt.Fprintf(out, "  Clusters can be given \"labels\" in the config file, e.g. \"labels\": {\"env\": \"prod\"}.\n")
+		fmt.Fprintf(out, "  With --drift-label=<label>, the quotas and the auto-failover, compaction and security\n")
+		fmt.Fprintf(out, "  settings of the clusters sharing each value of that label are compared, and the\n")
+		fmt.Fprintf(out, "  settings where a cluster differs from the most common value are reported.\n\n")
+		fmt.Fprintf(out, "  The summary report is sent to the file 'cbsummary.out.<timestamp>', unless a different\n")
+		fmt.Fprintf(out, "  file name is specified with the --output option. The name may have the placeholders\n")
+		fmt.Fprintf(out, "  {date}, {time}, {cluster} (the label or name of the cluster, if there is just one) and\n")
+		fmt.Fprintf(out, "  {format}, e.g. --output=reports/cbsummary-{date}.{format}; missing directories are created.\n\n")
+		fmt.Fprintf(out, "  With --split-per-cluster, a report is also written for each cluster, for distributing to\n")
+		fmt.Fprintf(out, "  the teams that own them. Each is named for the cluster's label, or its UUID, filling in\n")
+		fmt.Fprintf(out, "  {cluster} or otherwise added before the extension (fleet.json gives fleet.<label>.json).\n")
+		fmt.Fprintf(out, "  --split-only leaves out the combined report.\n\n")
+		fmt.Fprintf(out, "  The report can be uploaded to cloud storage instead, by giving --output as a URL:\n")
+		fmt.Fprintf(out, "    s3://<bucket>/<prefix>/             Amazon S3, with the AWS SDK's default credentials\n")
+		fmt.Fprintf(out, "                                        (environment, AWS_PROFILE, or an instance role)\n")
+		fmt.Fprintf(out, "    gs://<bucket>/<prefix>/             Google Cloud Storage, with the application default\n")
+		fmt.Fprintf(out, "                                        credentials\n")
+		fmt.Fprintf(out, "    az://<account>/<container>/<prefix>/ Azure Blob Storage, with AZURE_STORAGE_SAS_TOKEN or\n")
+		fmt.Fprintf(out, "                                        the Azure SDK's default credential\n")
+		fmt.Fprintf(out, "  A URL ending in '/' is a prefix, under which each report gets its timestamped name;\n")
+		fmt.Fprintf(out, "  otherwise the URL names the object. --sink-region sets the S3 region, --sink-endpoint\n")
+		fmt.Fprintf(out, "  points at S3-compatible storage, --sink-sse=AES256 or aws:kms requests server-side\n")
+		fmt.Fprintf(out, "  encryption, and --sink-kms-key chooses the KMS key (or the Azure encryption scope).\n\n")
+		fmt.Fprintf(out, "  With --history=<file>, the key figures of each run (clusters, nodes, cores and RAM) are\n")
+		fmt.Fprintf(out, "  appended to a history store so growth can be tracked. --history-keep=<runs> and\n")
+		fmt.Fprintf(out, "  --history-max-age=<age> (e.g. '90d') prune old runs after each append, and\n")
+		fmt.Fprintf(out, "  'cbsummary prune --history=<file> --keep=<runs> --max-age=<age>' prunes by hand.\n")
+		fmt.Fprintf(out, "  A history named *.sqlite (or *.sqlite3 or *.db) is kept as a SQLite database, with\n")
+		fmt.Fprintf(out, "  tables of runs, clusters and nodes for querying with other tools, and 'cbsummary\n")
+		fmt.Fprintf(out, "  trend --history=<file>' prints the growth in nodes, cores and RAM over time.\n\n")
+		fmt.Fprintf(out, "  'cbsummary diff <old report> <new report>' compares two JSON reports and prints what\n")
+		fmt.Fprintf(out, "  changed: clusters and nodes added or removed, upgrades, core and RAM changes, and new\n")
+		fmt.Fprintf(out, "  or removed buckets, e.g. for license true-ups.\n\n")
+		fmt.Fprintf(out, "  'cbsummary check' collects the full details of the clusters, taking the same flags, and\n")
+		fmt.Fprintf(out, "  lists health findings: unreachable clusters, unhealthy nodes and nodes more than one\n")
+		fmt.Fprintf(out, "  release apart (critical), and mixed node versions, rebalances under way or needed, swap\n")
+		fmt.Fprintf(out, "  in use, CPU over 90%%, disk under 10%% free, buckets using over 90%% of their RAM quota\n")
+		fmt.Fprintf(out, "  and services using over 90%% of their memory quota on a node (warnings). It exits with\n")
+		fmt.Fprintf(out, "  status 3 if there are critical findings; warnings alone pass.\n")
+		fmt.Fprintf(out, "  --report=<file> checks a JSON report instead of the clusters; a report is only written,\n")
+		fmt.Fprintf(out, "  with the findings in it, if --output is given. --health-thresholds=<file> overrides the\n")
+		fmt.Fprintf(out, "  thresholds from JSON or YAML, e.g. {\"max_cpu_percent\": 80, \"min_free_disk_percent\": 20,\n")
+		fmt.Fprintf(out, "  \"max_version_skew\": 0, \"max_bucket_quota_percent\": 95, \"disabled_rules\": [\"swap-in-use\"]}.\n\n")
+		fmt.Fprintf(out, "  For long runs, --checkpoint=<file> records each cluster as soon as it is collected.\n")
+		fmt.Fprintf(out, "  If the run dies, running the same command again offers to resume from the clusters\n")
+		fmt.Fprintf(out, "  already collected; --resume does so without asking. The file is removed once the\n")
+		fmt.Fprintf(out, "  report has been written.\n\n")
+		fmt.Fprintf(out, "  Interrupting a run with Ctrl-C (SIGINT) or SIGTERM abandons the clusters still being\n")
+		fmt.Fprintf(out, "  collected and writes a partial report, with those clusters marked \"interrupted\"; a\n")
+		fmt.Fprintf(out, "  second interrupt exits at once. Any --checkpoint is kept, for resuming the run.\n\n")
+		fmt.Fprintf(out, "  For clusters in isolated network segments, run an agent in each segment with\n")
+		fmt.Fprintf(out, "  --push-url=https://<receiver>:9443 and --push-key=<key file>, and a central\n")
+		fmt.Fprintf(out, "  'cbsummary receive' holding the same key. Each agent signs its summary and pushes it\n")
+		fmt.Fprintf(out, "  over TLS, and the receiver merges the latest summary from every agent into one report.\n")
+		fmt.Fprintf(out, "  Run 'cbsummary receive --help' for the receiver's options.\n\n")
+		fmt.Fprintf(out, "  With --email-to=<addresses> and --smtp-server=<host:port>, each report is also emailed,\n")
+		fmt.Fprintf(out, "  as HTML with the JSON report attached. --email-from sets the sender, and --smtp-auth=<file>,\n")
+		fmt.Fprintf(out, "  where the file holds 'user:password', authenticates to the server over STARTTLS.\n\n")
+		fmt.Fprintf(out, "  With --post-url=<url>, each JSON report is also POSTed to that URL, e.g. for an inventory\n")
+		fmt.Fprintf(out, "  or CMDB service. Send headers such as auth tokens with --post-header='Name: value', which\n")
+		fmt.Fprintf(out, "  can be repeated, or keep them in a --post-headers=<file> with one 'Name: value' per line.\n")
+		fmt.Fprintf(out, "  --post-cacert verifies the server's certificate against a private CA.\n\n")
+		fmt.Fprintf(out, "  With --notify-url=<webhook>, a short digest of each report is posted to a Slack or\n")
+		fmt.Fprintf(out, "  Microsoft Teams incoming webhook: the clusters collected with their total nodes, cores\n")
+		fmt.Fprintf(out, "  and RAM, the clusters in error, and warnings such as unhealthy nodes and expiring\n")
+		fmt.Fprintf(out, "  certificates. --notify-type=slack|teams says which, if it can't be told from the URL.\n\n")
+		fmt.Fprintf(out, "  With --daemon, cbsummary keeps running and writes a new report every --interval (default\n")
+		fmt.Fprintf(out, "  24h). It is designed to run under a service manager such as systemd or as a Windows\n")
+		fmt.Fprintf(out, "  service: SIGHUP reloads the config file, SIGTERM shuts down once any collection in\n")
+		fmt.Fprintf(out, "  progress has finished (waiting at most 30s, or until a second SIGTERM, before writing\n")
+		fmt.Fprintf(out, "  the report with the clusters not yet collected marked \"interrupted\"), --pid-file\n")
+		fmt.Fprintf(out, "  records the process ID, and --listen=<addr> serves a /health endpoint reporting the\n")
+		fmt.Fprintf(out, "  daemon's status. Without --output, each report is written under its own timestamped\n")
+		fmt.Fprintf(out, "  name, in --report-dir if given; --keep-reports=<n> and --report-max-age=<age> (e.g.\n")
+		fmt.Fprintf(out, "  '30d') remove older reports after each run.\n\n")
+		fmt.Fprintf(out, "  The --listen server also serves the latest report at /report, as JSON or in another\n")
+		fmt.Fprintf(out, "  format with ?format=<format>, and /metrics, the latest summary as Prometheus gauges (as\n")
+		fmt.Fprintf(out, "  for --format=prom), so the daemon can run as a fleet exporter, e.g.\n")
+		fmt.Fprintf(out, "    cbsummary --config=<config file> --daemon --interval=5m --listen=:9911\n")
+		fmt.Fprintf(out, "  With --report-auth=<file>, where the file holds 'user:password', both need HTTP basic\n")
+		fmt.Fprintf(out, "  authentication.\n\n")
+		fmt.Fprintf(out, "  The --listen server also lets other systems collect on demand: POST /api/v1/collect with\n")
+		fmt.Fprintf(out, "  a filter such as {\"clusters\": [0, 3]}, {\"labels\": {\"env\": \"prod\"}} or {\"nodes\":\n")
+		fmt.Fprintf(out, "  [\"10.1.\"]} (or no body for all clusters) starts a job and returns its ID, GET\n")
+		fmt.Fprintf(out, "  /api/v1/jobs/<id> gives its progress, and GET /api/v1/jobs/<id>/report?format=<format>\n")
+		fmt.Fprintf(out, "  its report. The report numbers the job's clusters from 0, in the order of the job's\n")
+		fmt.Fprintf(out, "  \"clusters\", which give their positions in the config file. With --api-token=<file>,\n")
+		fmt.Fprintf(out, "  requests must send 'Authorization: Bearer <token>'; with --report-auth, they must send\n")
+		fmt.Fprintf(out, "  its basic authentication, or the token if there is one.\n\n")
+		fmt.Fprintf(out, "  To share a report with Couchbase support or others, --redact replaces the hostnames and IP\n")
+		fmt.Fprintf(out, "  addresses, cluster names and UUIDs in it with tokens such as host-3, cluster-1 and uuid-7,\n")
+		fmt.Fprintf(out, "  and removes the logins, passwords and headers of clusters that couldn't be collected.\n")
+		fmt.Fprintf(out, "  The tokens and what they stand for are kept in --redact-map=<file> (default %s),\n", cbsummary.DEFAULT_REDACT_MAP)
+		fmt.Fprintf(out, "  which should be kept apart from the report; reusing it keeps the same tokens from run to run.\n\n")
+		fmt.Fprintf(out, "  Reports hold topology details worth protecting at rest. --compress gzips the report\n")
+		fmt.Fprintf(out, "  written or uploaded (adding .gz to its name), and --encrypt-key=<file> encrypts it to the\n")
+		fmt.Fprintf(out, "  public key in the file: age recipients (age1...) give an age file (.age) to decrypt with\n")
+		fmt.Fprintf(out, "  'age --decrypt', and a PGP public key from 'gpg --export' gives a .gpg file for 'gpg\n")
+		fmt.Fprintf(out, "  --decrypt'.\n\n")
+		fmt.Fprintf(out, "  'cbsummary collect --raw-dir=<dir>' collects the clusters as usual, also saving every REST\n")
+		fmt.Fprintf(out, "  response in the directory, along with the clusters without their credentials. 'cbsummary\n")
+		fmt.Fprintf(out, "  report --from-raw=<dir>' then generates reports from those responses without contacting\n")
+		fmt.Fprintf(out, "  the clusters, for reproducible reports or testing; collect with the options the reports\n")
+		fmt.Fprintf(out, "  will need, e.g. --full, as only the requests made then can be answered.\n\n")
+		fmt.Fprintf(out, "  --output=- writes the report to standard output, for piping into jq or other tools,\n")
+		fmt.Fprintf(out, "  with all other messages going to standard error.\n\n")
+		fmt.Fprintf(out, "  Progress, warnings and errors are logged to the console. --quiet leaves only warnings\n")
+		fmt.Fprintf(out, "  and errors, --verbose adds each cluster as it is collected, and --debug adds every REST\n")
+		fmt.Fprintf(out, "  call and writes each log line as a JSON object. --log-file=<file> appends the log lines\n")
+		fmt.Fprintf(out, "  to a file instead. At a terminal, a status line shows how many clusters have been\n")
+		fmt.Fprintf(out, "  collected, have failed and are in progress, unless --quiet is given.\n\n")
+		fmt.Fprintf(out, "  cbsummary exits with status 0 when every cluster was collected and the report delivered,\n")
+		fmt.Fprintf(out, "  1 when some clusters couldn't be collected or the report couldn't be delivered, 2 for\n")
+		fmt.Fprintf(out, "  errors in the command line or configuration, and 3 when a health check or license\n")
+		fmt.Fprintf(out, "  entitlement check fails. The subcommands exit with 2 for errors in their arguments, and\n")
+		fmt.Fprintf(out, "  1 when they fail.\n\n")
+		fmt.Fprintf(out, "  Every report has a \"metadata\" section giving the cbsummary version and commit that\n")
+		fmt.Fprintf(out, "  produced it, the report's schema version, when it was generated (UTC), how long the\n")
+		fmt.Fprintf(out, "  collection took and the SHA-256 of the config file. --version prints the version.\n")
+		fmt.Fprintf(out, "  'cbsummary schema' prints the JSON Schema the JSON report follows.\n\n")
+		if *HELP {
+			return EXIT_OK
+		}
+		return EXIT_USAGE
 	}
 
-	// need some configuration
-	if CONFIG_FILE == nil || len(*CONFIG_FILE) == 0 {
-		fmt.Printf("You must specify a configuration file.\n\n")
-		return
+	if len(*PROFILE) > 0 {
+		err := ApplyProfile(*PROFILE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v, must be one of %s.\n\n", err, strings.Join(ProfileNames(), ", "))
+			return EXIT_USAGE
+		}
 	}
 
-	var output_file string
-	if OUTPUT_FILE == nil || len(*OUTPUT_FILE) == 0 {
-		now := time.Now()
-		output_file = fmt.Sprintf("cbsummary.out.%04d-%02d-%02d-%02d:%02d:%02d", now.Year(), now.Month(), now.Day(),
-			now.Hour(), now.Minute(), now.Second())
-	} else {
-		output_file = *OUTPUT_FILE
+	if *CSV {
+		*FORMAT = cbsummary.FORMAT_CSV
+	}
+	if !cbsummary.ValidFormat(*FORMAT) {
+		fmt.Fprintf(os.Stderr, "Unknown report format '%s', must be one of %s.\n\n", *FORMAT,
+			strings.Join(cbsummary.REPORT_FORMATS, ", "))
+		return EXIT_USAGE
 	}
 
-	// load the configuration
+	var redactor *cbsummary.Redactor
+	if *REDACT {
+		var err error
+		redactor, err = cbsummary.LoadRedactor(*REDACT_MAP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
+
+	if *LICENSE && len(*LICENSE_MODEL) == 0 {
+		*LICENSE_MODEL = cbsummary.LICENSE_MODEL_CORES
+	}
+
+	var entitlement *cbsummary.Entitlement
+	if len(*ENTITLEMENT) > 0 {
+		var err error
+		entitlement, err = cbsummary.LoadEntitlement(*ENTITLEMENT)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		if len(*LICENSE_MODEL) == 0 {
+			*LICENSE_MODEL = cbsummary.LICENSE_MODEL_CORES
+		}
+	}
+
+	if len(*LICENSE_MODEL) > 0 && !cbsummary.ValidLicenseModel(*LICENSE_MODEL) {
+		fmt.Fprintf(os.Stderr, "Unknown license model '%s', must be '%s' or '%s'.\n\n", *LICENSE_MODEL, cbsummary.LICENSE_MODEL_NODES,
+			cbsummary.LICENSE_MODEL_CORES)
+		return EXIT_USAGE
+	}
+
+	if check {
+		if *DAEMON {
+			fmt.Fprintf(os.Stderr, "'cbsummary check' doesn't run as a daemon.\n\n")
+			return EXIT_USAGE
+		}
+		*FULL = true
+	}
+
+	if command == "collect" && len(*RAW_DIR) == 0 {
+		fmt.Fprintf(os.Stderr, "'cbsummary collect' needs --raw-dir to save the responses in.\n\n")
+		return EXIT_USAGE
+	}
+	if command == "report" && len(*FROM_RAW) == 0 {
+		fmt.Fprintf(os.Stderr, "'cbsummary report' needs --from-raw to report on saved responses.\n\n")
+		return EXIT_USAGE
+	}
+	if len(*FROM_RAW) > 0 {
+		if len(*CONFIG_FILE) > 0 || len(*CLUSTER) > 0 || *KUBE || len(*RAW_DIR) > 0 || *DAEMON {
+			fmt.Fprintf(os.Stderr, "--from-raw reports on the clusters saved with their responses; it can't be used with\n")
+			fmt.Fprintf(os.Stderr, "--config, --cluster, --kube, --raw-dir or --daemon.\n\n")
+			return EXIT_USAGE
+		}
+	}
+
+	if *DAEMON && *INTERVAL <= 0 {
+		fmt.Fprintf(os.Stderr, "The daemon interval must be positive.\n\n")
+		return EXIT_USAGE
+	}
+
+	if *RETRY_JITTER < 0 || *RETRY_JITTER > 1 {
+		fmt.Fprintf(os.Stderr, "--retry-jitter must be between 0 and 1.\n\n")
+		return EXIT_USAGE
+	}
+
+	if *MAX_CONCURRENCY < 1 {
+		fmt.Fprintf(os.Stderr, "--max-concurrency must be at least 1.\n\n")
+		return EXIT_USAGE
+	}
+
+	retention := cbsummary.RetentionPolicy{KeepRuns: *HISTORY_KEEP}
+	if len(*HISTORY_MAX_AGE) > 0 {
+		age, err := cbsummary.ParseAge(*HISTORY_MAX_AGE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --history-max-age: %v\n\n", err)
+			return EXIT_USAGE
+		}
+		retention.MaxAge = age
+	}
+
+	reportRetention := cbsummary.RetentionPolicy{KeepRuns: *KEEP_REPORTS}
+	if len(*REPORT_MAX_AGE) > 0 {
+		age, err := cbsummary.ParseAge(*REPORT_MAX_AGE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --report-max-age: %v\n\n", err)
+			return EXIT_USAGE
+		}
+		reportRetention.MaxAge = age
+	}
+
+	var push *cbsummary.PushOptions
+	if len(*PUSH_URL) > 0 {
+		if len(*PUSH_KEY) == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify --push-key to push summaries.\n\n")
+			return EXIT_USAGE
+		}
+		push = &cbsummary.PushOptions{URL: *PUSH_URL, KeyFile: *PUSH_KEY, Agent: *AGENT_NAME, CACert: *PUSH_CACERT}
+		if len(push.Agent) == 0 {
+			push.Agent = cbsummary.DefaultAgentName()
+		}
+	}
+
+	var email *cbsummary.EmailOptions
+	if len(*EMAIL_TO) > 0 {
+		if len(*SMTP_SERVER) == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify --smtp-server to email reports.\n\n")
+			return EXIT_USAGE
+		}
+		email = &cbsummary.EmailOptions{Server: cbsummary.SMTPAddress(*SMTP_SERVER), From: *EMAIL_FROM,
+			To: cbsummary.ParseRecipients(*EMAIL_TO)}
+		if len(email.From) == 0 {
+			email.From = cbsummary.DefaultEmailSender()
+		}
+		if len(*SMTP_AUTH) > 0 {
+			auth, err := cbsummary.LoadBasicAuth(*SMTP_AUTH)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n\n", err)
+				return EXIT_USAGE
+			}
+			email.Auth = auth
+		}
+	}
+
+	var post *cbsummary.PostOptions
+	if len(*POST_URL) > 0 {
+		headers, err := cbsummary.ParsePostHeaders(POST_HEADERS, *POST_HEADERS_FILE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		post = &cbsummary.PostOptions{URL: *POST_URL, Headers: headers, CACert: *POST_CACERT}
+	}
+
+	var notify *cbsummary.NotifyOptions
+	if len(*NOTIFY_URL) > 0 {
+		notify = &cbsummary.NotifyOptions{URL: *NOTIFY_URL, Kind: *NOTIFY_TYPE}
+		if len(notify.Kind) == 0 {
+			notify.Kind = cbsummary.NotifyKind(notify.URL)
+		}
+		if notify.Kind != cbsummary.NOTIFY_SLACK && notify.Kind != cbsummary.NOTIFY_TEAMS {
+			fmt.Fprintf(os.Stderr, "Invalid --notify-type %s, it should be '%s' or '%s'.\n\n", notify.Kind, cbsummary.NOTIFY_SLACK,
+				cbsummary.NOTIFY_TEAMS)
+			return EXIT_USAGE
+		}
+	}
 
-	config, err := ioutil.ReadFile(*CONFIG_FILE)
+	// need some configuration
+	if len(*CONFIG_FILE) == 0 && len(*CLUSTER) == 0 && len(*FROM_RAW) == 0 && !*KUBE {
+		fmt.Fprintf(os.Stderr, "You must specify a configuration file.\n\n")
+		return EXIT_USAGE
+	}
+	if len(*CONFIG_FILE) > 0 && len(*CLUSTER) > 0 {
+		fmt.Fprintf(os.Stderr, "Give either --config or --cluster, not both.\n\n")
+		return EXIT_USAGE
+	}
+	if *KUBE && (len(*CONFIG_FILE) > 0 || len(*CLUSTER) > 0) {
+		fmt.Fprintf(os.Stderr, "--kube finds the clusters itself; it can't be used with --config or --cluster.\n\n")
+		return EXIT_USAGE
+	}
+	if *DAEMON && len(*CONFIG_FILE) == 0 {
+		fmt.Fprintf(os.Stderr, "Daemon mode needs a config file to reload.\n\n")
+		return EXIT_USAGE
+	}
+
+	var bucketStats []string
+	if *BUCKET_STATS {
+		bucketStats = cbsummary.ParseStatNames(*BUCKET_STAT_NAMES)
+	}
+
+	transport := cbsummary.TransportOptions{
+		ConnectTimeout:        cbsummary.Duration(*CONNECT_TIMEOUT),
+		DialTimeout:           cbsummary.Duration(*DIAL_TIMEOUT),
+		TLSHandshakeTimeout:   cbsummary.Duration(*TLS_HANDSHAKE_TIMEOUT),
+		ResponseHeaderTimeout: cbsummary.Duration(*RESPONSE_HEADER_TIMEOUT),
+		MaxConnsPerHost:       *MAX_CONNS_PER_HOST,
+		DisableHTTP2:          *DISABLE_HTTP2,
+		RetryBackoff:          cbsummary.Duration(*RETRY_BACKOFF),
+		RetryJitter:           *RETRY_JITTER,
+		MaxRequestsPerSecond:  *MAX_REQUESTS_PER_SECOND,
+	}
+	if *RETRIES >= 0 {
+		transport.Retries = RETRIES
+	}
+	var err error
+	if len(*RAW_DIR) > 0 {
+		transport.Raw, err = cbsummary.NewRawRecorder(*RAW_DIR)
+	} else if len(*FROM_RAW) > 0 {
+		transport.Raw, err = cbsummary.OpenRawSnapshots(*FROM_RAW)
+	}
 	if err != nil {
-		fmt.Printf("Error reading configuration file %s: %s\n\n", *CONFIG_FILE, err)
-		return
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
 	}
 
-	// parse the configuration as JSON
-	var clusters ClusterList
-	err = json.Unmarshal(config, &clusters)
+	tlsConfig, err := cbsummary.NewTLSConfig(cbsummary.TLSOptions{
+		CACert:     *CACERT,
+		NoVerify:   *NO_SSL_VERIFY,
+		ClientCert: *CLIENT_CERT,
+		ClientKey:  *CLIENT_KEY,
+	})
 	if err != nil {
-		fmt.Printf("Error parsing configuration file %s: %s\n\n", *CONFIG_FILE, err)
-		return
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
 	}
 
-	fmt.Printf("Working from config file: %s\n", *CONFIG_FILE)
+	collector := cbsummary.NewCollector(cbsummary.CollectOptions{
+		Full:               *FULL,
+		LicenseModel:       *LICENSE_MODEL,
+		Entitlement:        entitlement,
+		Redactor:           redactor,
+		ConsumptionUnits:   *CONSUMPTION_UNITS,
+		CapellaSizing:      *CAPELLA_SIZING,
+		DriftLabel:         *DRIFT_LABEL,
+		QueryStats:         *QUERY_STATS,
+		ServiceUsage:       *SERVICE_USAGE,
+		EventingStats:      *EVENTING_STATS,
+		BucketStats:        bucketStats,
+		XDCRStats:          *XDCR_STATS,
+		XDCRLagThreshold:   float64(*XDCR_LAG_THRESHOLD),
+		Tasks:              *TASKS,
+		Hardware:           *HARDWARE,
+		Security:           *SECURITY,
+		CertWarnDays:       *CERT_WARN_DAYS,
+		MaxCollectionNames: *MAX_COLLECTION_NAMES,
+		Events:             *EVENTS,
+		NodeRTT:            *NODE_RTT,
+		SkipBusy:           *SKIP_BUSY,
+		WaitBusy:           *WAIT_BUSY,
+		MaxConcurrency:     *MAX_CONCURRENCY,
+		ClusterTimeout:     *CLUSTER_TIMEOUT,
+		Deadline:           *DEADLINE,
+		Transport:          transport,
+		TLSConfig:          tlsConfig,
+	})
+	configOptions := cbsummary.ConfigOptions{Format: *CONFIG_FORMAT, Key: &cbsummary.ConfigKey{File: *CONFIG_KEY_FILE}}
+	passwords := cbsummary.NewPasswordSource()
+	if *PASSWORD_FROM_STDIN {
+		err = passwords.ReadStdin(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
 
-	clusterSummary := new(SummaryInfo)
-	clusterSummary.NumClusters = len(clusters.Clusters)
-	clusterSummary.TotalNumNodes = 0
-	clusterSummary.NodeVersions = make(map[string]int)
-	clusterSummary.Clusters = make([]interface{}, len(clusters.Clusters))
+	if len(*NODE_CACHE) > 0 {
+		cache, err := cbsummary.LoadNodeCache(*NODE_CACHE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		collector = collector.WithNodeCache(cache)
+	}
 
-	// loop through the clusters
-	for cnum, cluster := range clusters.Clusters {
-		//fmt.Printf("\n\nCluster login: %s pass %s nodes: %v\n", cluster.Login, cluster.Pass, cluster.Nodes)
-		var thisCluster *ClusterSummary
-		var briefCluster *BriefCluster
-		var cerr error
+	report := cbsummary.ReportOptions{Format: *FORMAT, JSONLNodes: *JSONL_NODES, Compress: *COMPRESS}
+	if len(*ENCRYPT_KEY) > 0 {
+		report.Encrypt, err = cbsummary.LoadEncryptKey(*ENCRYPT_KEY)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
+	if len(*TEMPLATE) > 0 {
+		report.Template, err = cbsummary.LoadReportTemplate(*TEMPLATE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
+
+	if *SPLIT_ONLY && !*SPLIT_PER_CLUSTER {
+		fmt.Fprintf(os.Stderr, "--split-only needs --split-per-cluster\n\n")
+		return EXIT_USAGE
+	}
+	if *SPLIT_PER_CLUSTER && *OUTPUT_FILE == cbsummary.OUTPUT_STDOUT {
+		fmt.Fprintf(os.Stderr, "--split-per-cluster can't be used with --output=-\n\n")
+		return EXIT_USAGE
+	}
+	if *SPLIT_PER_CLUSTER && cbsummary.IsSinkURL(*OUTPUT_FILE) && !strings.HasSuffix(*OUTPUT_FILE, "/") {
+		fmt.Fprintf(os.Stderr, "--split-per-cluster needs --output to be a prefix, ending in '/', to upload each report under\n")
+		fmt.Fprintf(os.Stderr, "its own name.\n\n")
+		return EXIT_USAGE
+	}
+
+	if *STREAM {
+		if conflict := streamConflict(check); len(conflict) > 0 {
+			fmt.Fprintf(os.Stderr, "--stream writes a JSON report to a file or standard output as it goes, so it can't be\n")
+			fmt.Fprintf(os.Stderr, "used with %s.\n\n", conflict)
+			return EXIT_USAGE
+		}
+	}
+
+	var sink cbsummary.ReportSink
+	if cbsummary.IsSinkURL(*OUTPUT_FILE) {
+		sink, err = cbsummary.NewReportSink(*OUTPUT_FILE, cbsummary.SinkOptions{
+			Region:   *SINK_REGION,
+			Endpoint: *SINK_ENDPOINT,
+			SSE:      *SINK_SSE,
+			KMSKey:   *SINK_KMS_KEY,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
 
-		for _, node := range cluster.Nodes {
-			client := CreateRestClient(node, cluster.Login, cluster.Pass, nil)
+	publisher := &cbsummary.Publisher{
+		OutputFile:      *OUTPUT_FILE,
+		ReportDir:       *REPORT_DIR,
+		Report:          report,
+		ReportRetention: reportRetention,
+		History:         *HISTORY,
+		Retention:       retention,
+		Push:            push,
+		Sink:            sink,
+		SplitPerCluster: *SPLIT_PER_CLUSTER,
+		SplitOnly:       *SPLIT_ONLY,
+		Email:           email,
+		Post:            post,
+		Notify:          notify,
+	}
 
-			// get /pools and /pools/defaults
-			pools, err := client.GetPoolsData()
+	if *DAEMON {
+		var apiToken string
+		if len(*API_TOKEN) > 0 {
+			token, err := ioutil.ReadFile(*API_TOKEN)
 			if err != nil {
-				cerr = err
-				fmt.Printf("Error getting bucket settings from node %s: %v\n", node, err)
-				continue // try the next node
+				fmt.Fprintf(os.Stderr, "Error reading API token %s: %v\n\n", *API_TOKEN, err)
+				return EXIT_USAGE
 			}
+			apiToken = strings.TrimSpace(string(token))
+		}
 
-			poolsDefaults, err := client.GetPoolsDefaultData()
-
+		var reportAuth *cbsummary.BasicAuth
+		if len(*REPORT_AUTH) > 0 {
+			reportAuth, err = cbsummary.LoadBasicAuth(*REPORT_AUTH)
 			if err != nil {
-				cerr = err
-				fmt.Printf("Error getting pools/default from node %s: %v\n", node, err)
-				continue // try the next node
+				fmt.Fprintf(os.Stderr, "%v\n\n", err)
+				return EXIT_USAGE
 			}
+		}
 
-			// if we make it this far, we have both /pools and /pools/defaults
-
-			// full report? get all details
-
-			if *FULL {
-				thisCluster = new(ClusterSummary)
-				thisCluster.ImplementationVersion = pools.ImplementationVersion
-				thisCluster.IsEnterprise = pools.IsEnterprise
-				thisCluster.Uuid = pools.Uuid
-
-				thisCluster.Balanced = poolsDefaults.Balanced
-				thisCluster.ClusterName = poolsDefaults.ClusterName
-				thisCluster.FtsMemoryQuota = poolsDefaults.FtsMemoryQuota
-				thisCluster.IndexMemoryQuota = poolsDefaults.IndexMemoryQuota
-				thisCluster.MemoryQuota = poolsDefaults.MemoryQuota
-				thisCluster.Name = poolsDefaults.Name
-				thisCluster.NodeCount = len(poolsDefaults.Nodes)
-				thisCluster.Nodes = poolsDefaults.Nodes
-				thisCluster.RebalanceStatus = poolsDefaults.RebalanceStatus
-				thisCluster.StorageTotals = poolsDefaults.StorageTotals
-
-				// for each of the nodes in this cluster, show the distribution of versions
-				nodeVersions := make(map[string]int)
-				for _, nodeInfo := range poolsDefaults.Nodes {
-					nodeVersions[nodeInfo.Version] = nodeVersions[nodeInfo.Version] + 1
-					clusterSummary.NodeVersions[nodeInfo.Version] = clusterSummary.NodeVersions[nodeInfo.Version] + 1
-				}
-				thisCluster.NodeVersions = nodeVersions
-
-				clusterSummary.Clusters[cnum] = thisCluster
-				clusterSummary.TotalNumNodes = clusterSummary.TotalNumNodes + len(poolsDefaults.Nodes)
+		daemon := &cbsummary.Daemon{
+			ConfigFile: *CONFIG_FILE,
+			Config:     configOptions,
+			Interval:   *INTERVAL,
+			Listen:     *LISTEN,
+			PidFile:    *PID_FILE,
+			APIToken:   apiToken,
+			ReportAuth: reportAuth,
+			Passwords:  passwords,
+			Collector:  collector,
+			Publisher:  publisher,
+		}
 
-			} else {
-				// for a partial report, get the cluster_size, uuid, and an array of nodes with:
-				// - cpu cores
-				// - hostname
-				// - memory limit
-
-				briefCluster = new(BriefCluster)
-
-				nodes := make([]BriefNode, len(poolsDefaults.Nodes))
-				curNode := 0
-				for _, nodeInfo := range poolsDefaults.Nodes {
-					node := new(BriefNode)
-					node.Cores = nodeInfo.SystemStats.CPU_cores_available
-					node.RAM = nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0
-					node.Name = nodeInfo.Hostname
-					node.Version = nodeInfo.Version
-					nodes[curNode] = *node
-					curNode = curNode + 1
-				}
-
-				briefCluster.Nodes = nodes
-				briefCluster.Size = len(nodes)
-				briefCluster.UUID = pools.Uuid
-
-				clusterSummary.Clusters[cnum] = briefCluster
-
-				clusterSummary.TotalNumNodes = clusterSummary.TotalNumNodes + len(poolsDefaults.Nodes)
-
-				// for each of the nodes in this cluster, show the distribution of versions
-				for _, nodeInfo := range poolsDefaults.Nodes {
-					clusterSummary.NodeVersions[nodeInfo.Version] = clusterSummary.NodeVersions[nodeInfo.Version] + 1
-				}
-			}
+		handled, err := cbsummary.RunService(daemon)
+		if !handled && err == nil {
+			err = daemon.Run()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		return EXIT_OK
+	}
 
-			//  debugging output
-			//body, err := json.Marshal(clusterSummary.Clusters[cnum])
-			//if (err == nil) {
-			//    fmt.Printf("%s\n\n",string(body))
-			//}
+	// load the configuration
 
-			// when we've gotten all the info, break from this look to look at the next cluster
+	var clusters *cbsummary.ClusterList
+	if len(*FROM_RAW) > 0 {
+		clusters, err = transport.Raw.LoadClusters()
+	} else if len(*CLUSTER) > 0 {
+		clusters, err = cbsummary.SingleClusterConfig(*CLUSTER, *USERNAME, *PASSWORD)
+	} else if *KUBE {
+		kube := cbsummary.KubeOptions{Kubeconfig: *KUBECONFIG, Context: *KUBE_CONTEXT, Namespace: *KUBE_NAMESPACE,
+			Endpoint: *KUBE_ENDPOINT}
+		clusters, err = cbsummary.DiscoverKubeClusters(kube, transport)
+	} else {
+		clusters, err = cbsummary.LoadConfig(*CONFIG_FILE, configOptions)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
+	}
+	err = passwords.Fill(clusters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
+	}
 
-			break
+	if len(*FROM_RAW) > 0 {
+		cbsummary.LogInfo("Working from saved responses: %s", *FROM_RAW)
+	} else if len(*CLUSTER) > 0 {
+		cbsummary.LogInfo("Working from cluster: %s", *CLUSTER)
+	} else if *KUBE {
+		cbsummary.LogInfo("Working from Kubernetes: %d CouchbaseCluster resources", len(clusters.Clusters))
+	} else {
+		cbsummary.LogInfo("Working from config file: %s", *CONFIG_FILE)
+	}
+	if len(*RAW_DIR) > 0 {
+		err = transport.Raw.SaveClusters(clusters)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
 		}
+	}
 
-		// if we get this far with thisCluster unset, we need to replace it with a
-		// different item indicating the error.
-
-		if thisCluster == nil && briefCluster == nil {
-			//fmt.Printf("Failed to contact cluster, error: %v\n",cerr)
-			errorStatus := new(ClusterError)
-			errorStatus.TheCluster = cluster
-			if cerr != nil {
-				errorStatus.ErrMsg = cerr.Error()
+	var checkpoint *cbsummary.Checkpoint
+	if len(*CHECKPOINT) > 0 {
+		checkpoint, err = cbsummary.OpenCheckpoint(*CHECKPOINT, collector.CheckpointKey(clusters))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		if done := checkpoint.Completed(); done > 0 {
+			question := fmt.Sprintf("Checkpoint %s from %s has %d of %d clusters collected. Resume?", *CHECKPOINT,
+				checkpoint.Started().Format(time.RFC1123), done, len(clusters.Clusters))
+			if *RESUME || cbsummary.AskYesNo(question, false) {
+				cbsummary.LogInfo("Resuming, %d clusters already collected.", done)
 			} else {
-				errorStatus.ErrMsg = "Unknown Error"
+				cbsummary.LogInfo("Starting over; use --resume to resume from the checkpoint.")
+				checkpoint.Discard()
 			}
-			clusterSummary.Clusters[cnum] = errorStatus
 		}
+		collector = collector.WithCheckpoint(checkpoint)
 	}
 
-	// create the output, either JSON or CSV
+	// with --stream, each cluster is written as it's collected, and the rest
+	// of the report at the end
+	var stream *cbsummary.ReportStream
+	if *STREAM {
+		outputFile := *OUTPUT_FILE
+		if len(outputFile) == 0 {
+			outputFile = filepath.Join(*REPORT_DIR, cbsummary.DefaultOutputFile())
+		} else if outputFile != cbsummary.OUTPUT_STDOUT {
+			outputFile = cbsummary.ExpandOutputFile(outputFile, "clusters", cbsummary.FORMAT_JSON, time.Now())
+		}
+		stream, err = cbsummary.CreateReportStream(outputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+		collector = collector.WithStream(stream)
+	}
 
-	var body []byte
+	// show the progress at the terminal, unless the console is quiet or taken by JSON log lines
+	var progress *cbsummary.StatusLine
+	if !*QUIET && (!*DEBUG || len(*LOG_FILE) > 0) {
+		progress = cbsummary.StartStatusLine()
+		collector = collector.WithProgress(progress.Update)
+	}
+	ctx, stopInterrupts := interruptContext()
+	clusterSummary := collector.CollectContext(ctx, clusters)
+	stopInterrupts()
+	progress.Stop()
+	uncollected := clusterSummary.Metadata != nil && (clusterSummary.Metadata.Interrupted || clusterSummary.Metadata.TimedOut)
+	if uncollected && checkpoint != nil {
+		// keep the checkpoint, rather than removing it once the report is written
+		cbsummary.LogInfo("Use --resume with --checkpoint=%s to collect the rest.", *CHECKPOINT)
+		checkpoint = nil
+	}
+	status := EXIT_OK
+	for _, cluster := range clusterSummary.Clusters {
+		if _, ok := cluster.(*cbsummary.ClusterError); ok {
+			status = EXIT_PARTIAL
+		}
+	}
 
-	if *CSV {
-		var buffer strings.Builder
-		buffer.WriteString("cluster_num\tcluster_uuid\tcluster_size\thostname\tcpu_cores\tRAM\n")
-
-		for cnum, icluster := range clusterSummary.Clusters {
-			cluster, ok := icluster.(*BriefCluster)
-			if ok {
-				for _, node := range cluster.Nodes {
-					// no cores info for earlier than 6.5
-					if node.Version < "6.5" {
-						buffer.WriteString(fmt.Sprintf("%d\t%s\t%d\t%s\tN/A\t%.1f\n", cnum, cluster.UUID, cluster.Size,
-							node.Name, node.RAM))
-					} else {
-						buffer.WriteString(fmt.Sprintf("%d\t%s\t%d\t%s\t%.1f\t%.1f\n", cnum, cluster.UUID, cluster.Size,
-							node.Name, node.Cores, node.RAM))
-					}
-				}
+	// when checking health, the report is only written if --output is given
+	if check {
+		clusterSummary.Findings = cbsummary.CheckHealth(clusterSummary, thresholds)
+		if len(*OUTPUT_FILE) > 0 {
+			err = publisher.Publish(clusterSummary)
+			if err != nil {
+				cbsummary.LogError("%v", err)
+				status = EXIT_PARTIAL
 			}
 		}
-		body = []byte(buffer.String())
-
-	} else { // JSON output
-		body, err = json.MarshalIndent(clusterSummary, "", "  ")
-		if err != nil {
-			fmt.Printf("Error marshalling summary: %v\n", err)
-			return
+		if checkpoint != nil {
+			checkpoint.Remove()
+		}
+		cbsummary.PrintFindings(clusterSummary.Findings, len(clusterSummary.Clusters))
+		if FindingsExitStatus(clusterSummary.Findings) != EXIT_OK {
+			return EXIT_CHECK_FAILED
 		}
+		return status
 	}
 
-	err = ioutil.WriteFile(output_file, body, 0644)
+	// write the report, and pass it on to anywhere else it should go
+
+	if stream != nil {
+		err = stream.Close(clusterSummary)
+	} else {
+		err = publisher.Publish(clusterSummary)
+	}
 	if err != nil {
-		fmt.Printf("Error writing output file %s: %v\n", output_file, err)
-		return
+		cbsummary.LogError("%v", err)
+		return EXIT_PARTIAL
 	}
 
-	fmt.Printf("Wrote information on %d clusters to file %s.\n", clusterSummary.NumClusters, output_file)
+	if checkpoint != nil {
+		err = checkpoint.Remove()
+		if err != nil {
+			cbsummary.LogError("%v", err)
+		}
+	}
+
+	if clusterSummary.License != nil && len(clusterSummary.License.Overages) > 0 {
+		return EXIT_CHECK_FAILED
+	}
+	return status
 }
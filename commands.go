@@ -0,0 +1,573 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package main
+
+//
+// subcommands - operations other than generating a report, selected by the
+// first command-line argument, e.g. 'cbsummary prune --history=...'
+//
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/couchbase/cbsummary/pkg/cbsummary"
+)
+
+// run the subcommand named by the first argument, returning its exit status,
+// or false if there isn't one
+func RunSubcommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return EXIT_OK, false
+	}
+
+	switch args[0] {
+	case "validate":
+		return runValidate(args[1:]), true
+	case "schema":
+		return runSchema(args[1:]), true
+	case "prune":
+		return runPrune(args[1:]), true
+	case "receive":
+		return runReceive(args[1:]), true
+	case "import":
+		return runImport(args[1:]), true
+	case "encrypt-config":
+		return runEncryptConfig(args[1:]), true
+	case "diff":
+		return runDiff(args[1:]), true
+	case "trend":
+		return runTrend(args[1:]), true
+	}
+	return EXIT_OK, false
+}
+
+// where a subcommand's usage goes: to standard output when asked for with
+// -h or --help, or standard error when the arguments are wrong
+func usageOutput(args []string) io.Writer {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "-h" || arg == "--h" || arg == "-help" || arg == "--help" {
+			return os.Stdout
+		}
+	}
+	return os.Stderr
+}
+
+// cbsummary validate - check a config file, and optionally that its nodes
+// accept the credentials, without collecting anything
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	config := flags.String("config", "", "Config file to check.")
+	configFormat := flags.String("config-format", "", "Format of the config file, json or yaml (default: from the file name).")
+	configKeyFile := flags.String("config-key-file", "", "File holding the secret an encrypted config was encrypted with.")
+	probe := flags.Bool("probe", false, "Make one authenticated request of every node, without collecting anything.")
+	passwordFromStdin := flags.Bool("password-from-stdin", false, "Read the password for clusters that don't give one from standard input.")
+	cacert := flags.String("cacert", "", "PEM file of CA certificates to verify https:// cluster endpoints with.")
+	noSSLVerify := flags.Bool("no-ssl-verify", false, "Don't verify the certificates of https:// cluster endpoints.")
+	timeout := flags.Duration("timeout", 10*time.Second, "How long to wait for each node to answer the probe.")
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary validate --config=<config file> [--config-format=json|yaml]\n")
+		fmt.Fprintf(out, "                          [--config-key-file=<file>] [--probe] [--timeout=<duration>]\n\n")
+		fmt.Fprintf(out, "  Checks a config file without generating a report: its syntax, that every cluster has\n")
+		fmt.Fprintf(out, "  nodes and credentials, that the nodes are URLs or connection strings, and that no node\n")
+		fmt.Fprintf(out, "  or label is given twice. With --probe, each node is also asked for /pools/default with\n")
+		fmt.Fprintf(out, "  the cluster's credentials, to show it can be reached and accepts them.\n\n")
+		fmt.Fprintf(out, "  Exits with %d if the config has errors, or %d if any node failed the probe.\n\n", EXIT_USAGE, EXIT_PARTIAL)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(*config) == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	options := cbsummary.ConfigOptions{Format: *configFormat, Key: &cbsummary.ConfigKey{File: *configKeyFile}}
+	clusters, problems := cbsummary.ValidateConfig(*config, options)
+	for _, problem := range problems {
+		fmt.Printf("%s\n", problem)
+	}
+	if clusters == nil {
+		fmt.Fprintf(os.Stderr, "\n%s can't be used.\n", *config)
+		return EXIT_USAGE
+	}
+	fmt.Printf("%s: %d clusters, %d Capella organizations, %d warnings.\n",
+		*config, len(clusters.Clusters), len(clusters.Capella), len(problems))
+	if !*probe {
+		return EXIT_OK
+	}
+
+	passwords := cbsummary.NewPasswordSource()
+	if *passwordFromStdin {
+		err := passwords.ReadStdin(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_USAGE
+		}
+	}
+	err := passwords.Fill(clusters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
+	}
+	tlsConfig, err := cbsummary.NewTLSConfig(cbsummary.TLSOptions{CACert: *cacert, NoVerify: *noSSLVerify})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
+	}
+	var transport cbsummary.TransportOptions
+	if clusters.Transport != nil {
+		transport = *clusters.Transport
+	}
+	transport = transport.Merge(cbsummary.TransportOptions{ConnectTimeout: cbsummary.Duration(*timeout)})
+
+	fmt.Printf("\n")
+	status := EXIT_OK
+	for _, probe := range cbsummary.ProbeClusters(context.Background(), clusters, tlsConfig, transport) {
+		if probe.Err != nil {
+			fmt.Printf("cluster %d: %s: FAILED: %v\n", probe.Cluster, probe.Node, probe.Err)
+			status = EXIT_PARTIAL
+			continue
+		}
+		fmt.Printf("cluster %d: %s: ok (%v)\n", probe.Cluster, probe.Node, probe.Elapsed.Round(time.Millisecond))
+	}
+	return status
+}
+
+// cbsummary schema - print the JSON Schema of the JSON report
+func runSchema(args []string) int {
+	flags := flag.NewFlagSet("schema", flag.ExitOnError)
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary schema\n\n")
+		fmt.Fprintf(out, "  Prints the JSON Schema of the JSON report, version %d, for validating reports against.\n",
+			cbsummary.REPORT_SCHEMA_VERSION)
+		fmt.Fprintf(out, "  Each report gives the version it follows as metadata.schema_version.\n\n")
+	}
+	flags.Parse(args)
+
+	os.Stdout.Write(cbsummary.ReportSchema())
+	return EXIT_OK
+}
+
+// cbsummary prune - apply a retention policy to a history store
+func runPrune(args []string) int {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	history := flags.String("history", "", "History store to prune.")
+	keep := flags.Int("keep", 0, "Number of most recent runs to keep.")
+	maxAge := flags.String("max-age", "", "Discard runs older than this, e.g. '90d' or '36h'.")
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary prune --history=<history> [--keep=<runs>] [--max-age=<age>]\n\n")
+		fmt.Fprintf(out, "  Removes old runs from a history store, keeping the most recent --keep runs and\n")
+		fmt.Fprintf(out, "  discarding any runs older than --max-age.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(*history) == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	policy := cbsummary.RetentionPolicy{KeepRuns: *keep}
+	if len(*maxAge) > 0 {
+		age, err := cbsummary.ParseAge(*maxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --max-age: %v\n\n", err)
+			return EXIT_USAGE
+		}
+		policy.MaxAge = age
+	}
+	if !policy.IsSet() {
+		fmt.Fprintf(os.Stderr, "Specify --keep and/or --max-age to say which runs to keep.\n\n")
+		return EXIT_USAGE
+	}
+
+	store, err := cbsummary.OpenHistoryStore(*history)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_PARTIAL
+	}
+	defer store.Close()
+
+	pruned, err := store.Prune(policy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_PARTIAL
+	}
+	fmt.Printf("Pruned %d runs from history %s.\n", pruned, *history)
+	return EXIT_OK
+}
+
+// cbsummary trend - print the growth recorded in a history store
+func runTrend(args []string) int {
+	flags := flag.NewFlagSet("trend", flag.ExitOnError)
+	history := flags.String("history", "", "History store to read.")
+	cluster := flags.String("cluster", "", "Only count the cluster with this UUID or name.")
+	by := flags.String("by", "run", "Show every 'run', or the last run of each 'day', 'week' or 'month'.")
+	last := flags.Int("last", 0, "Only show this many of the most recent rows.")
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary trend --history=<history> [--cluster=<uuid or name>] [--by=run|day|week|month]\n")
+		fmt.Fprintf(out, "                       [--last=<rows>]\n\n")
+		fmt.Fprintf(out, "  Prints the clusters, nodes, cores and RAM recorded for each run in a history store,\n")
+		fmt.Fprintf(out, "  and their growth over the runs shown.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(*history) == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	period, ok := map[string]string{"run": "", "day": "2006-01-02", "week": "week", "month": "2006-01"}[*by]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Invalid --by %s, it should be run, day, week or month.\n\n", *by)
+		return EXIT_USAGE
+	}
+
+	records, err := cbsummary.LoadHistory(*history)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_PARTIAL
+	}
+
+	type row struct {
+		time     time.Time
+		clusters int
+		nodes    int
+		cores    float64
+		ram      float64
+	}
+	rows := make([]row, 0)
+	for _, record := range records {
+		r := row{time: record.Time}
+		for _, c := range record.Clusters {
+			if len(*cluster) > 0 && c.UUID != *cluster && c.Name != *cluster {
+				continue
+			}
+			r.clusters++
+			r.nodes = r.nodes + len(c.Nodes)
+			r.cores = r.cores + c.Cores
+			r.ram = r.ram + c.RAM
+		}
+
+		// keep only the last run of each period
+		if len(rows) > 0 && len(period) > 0 && samePeriod(rows[len(rows)-1].time, r.time, period) {
+			rows[len(rows)-1] = r
+		} else {
+			rows = append(rows, r)
+		}
+	}
+	if *last > 0 && len(rows) > *last {
+		rows = rows[len(rows)-*last:]
+	}
+	if len(rows) == 0 {
+		fmt.Printf("No runs recorded in history %s.\n", *history)
+		return EXIT_OK
+	}
+
+	fmt.Printf("%-20s %8s %8s %8s %10s\n", "Run", "Clusters", "Nodes", "Cores", "RAM (GB)")
+	for _, r := range rows {
+		fmt.Printf("%-20s %8d %8d %8.0f %10.0f\n", r.time.UTC().Format("2006-01-02 15:04 UTC"), r.clusters, r.nodes,
+			r.cores, r.ram)
+	}
+
+	first, latest := rows[0], rows[len(rows)-1]
+	fmt.Printf("\nGrowth since %s: clusters %s, nodes %s, cores %s, RAM %s\n",
+		first.time.UTC().Format("2006-01-02"),
+		formatGrowth(float64(first.clusters), float64(latest.clusters), ""),
+		formatGrowth(float64(first.nodes), float64(latest.nodes), ""),
+		formatGrowth(first.cores, latest.cores, ""), formatGrowth(first.ram, latest.ram, " GB"))
+	return EXIT_OK
+}
+
+// whether two times fall in the same day, ISO week or month
+func samePeriod(a, b time.Time, period string) bool {
+	a, b = a.UTC(), b.UTC()
+	if period == "week" {
+		aYear, aWeek := a.ISOWeek()
+		bYear, bWeek := b.ISOWeek()
+		return aYear == bYear && aWeek == bWeek
+	}
+	return a.Format(period) == b.Format(period)
+}
+
+// a change in a figure, e.g. "+8 GB (+8.3%)"
+func formatGrowth(from, to float64, unit string) string {
+	growth := fmt.Sprintf("%+.0f%s", to-from, unit)
+	if from > 0 {
+		growth = growth + fmt.Sprintf(" (%+.1f%%)", 100*(to-from)/from)
+	}
+	return growth
+}
+
+// cbsummary receive - accept pushes from agents and write the merged report
+func runReceive(args []string) int {
+	flags := flag.NewFlagSet("receive", flag.ExitOnError)
+	listen := flags.String("listen", ":9443", "Address to accept pushes on.")
+	cert := flags.String("cert", "", "TLS certificate for the receiver.")
+	key := flags.String("key", "", "Private key for the TLS certificate.")
+	pushKey := flags.String("push-key", "", "File holding the key shared with the agents for signing pushes.")
+	stateDir := flags.String("state-dir", "cbsummary-agents", "Directory to keep the latest summary from each agent in.")
+	output := flags.String("output", "cbsummary.merged.json", "File to write the merged report to.")
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary receive --cert=<cert> --key=<key> --push-key=<key file> [--listen=<addr>]\n")
+		fmt.Fprintf(out, "                         [--state-dir=<dir>] [--output=<file>]\n\n")
+		fmt.Fprintf(out, "  Accepts signed summaries pushed over TLS by cbsummary agents run with --push-url,\n")
+		fmt.Fprintf(out, "  and writes a report merging the latest summary from each agent.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(*cert) == 0 || len(*key) == 0 || len(*pushKey) == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	signingKey, err := cbsummary.LoadPushKey(*pushKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_USAGE
+	}
+
+	receiver := &cbsummary.Receiver{Key: signingKey, StateDir: *stateDir, OutputFile: *output}
+	err = receiver.LoadState()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_PARTIAL
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+cbsummary.PUSH_PATH, receiver.HandlePush)
+
+	cbsummary.LogInfo("Receiving agent pushes on %s, writing merged report to %s.", *listen, *output)
+	// the read timeout leaves room for the largest push over a slow link
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       5 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	err = server.ListenAndServeTLS(*cert, *key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving on %s: %v\n\n", *listen, err)
+		return EXIT_PARTIAL
+	}
+	return EXIT_OK
+}
+
+// cbsummary import - build a config file from SDK connection strings or
+// connection profiles
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	connstrs := flags.String("connection-strings", "", "File listing one SDK connection string per line.")
+	profiles := flags.String("profiles", "", "TOML file of [[cluster]] connection profiles.")
+	username := flags.String("username", "", "Login to use where the source gives none.")
+	password := flags.String("password", "", "Password to use where the source gives none.")
+	output := flags.String("output", "", "File to write the config to (default standard output).")
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary import (--connection-strings=<file> | --profiles=<file>) [--username=<login>]\n")
+		fmt.Fprintf(out, "                        [--password=<pass>] [--output=<config file>]\n\n")
+		fmt.Fprintf(out, "  Builds a cbsummary config file from a list of SDK connection strings or from\n")
+		fmt.Fprintf(out, "  Couchbase shell style TOML connection profiles, whose identifiers become the\n")
+		fmt.Fprintf(out, "  clusters' labels.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(*connstrs) == 0 && len(*profiles) == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	clusters := cbsummary.ClusterList{Clusters: make([]cbsummary.Cluster, 0)}
+	if len(*connstrs) > 0 {
+		imported, err := cbsummary.ImportConnectionStrings(*connstrs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_PARTIAL
+		}
+		clusters.Clusters = append(clusters.Clusters, imported...)
+	}
+	if len(*profiles) > 0 {
+		imported, err := cbsummary.ImportProfiles(*profiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n\n", err)
+			return EXIT_PARTIAL
+		}
+		clusters.Clusters = append(clusters.Clusters, imported...)
+	}
+
+	for i := range clusters.Clusters {
+		if len(clusters.Clusters[i].Login) == 0 {
+			clusters.Clusters[i].Login = *username
+		}
+		if len(clusters.Clusters[i].Pass) == 0 {
+			clusters.Clusters[i].Pass = *password
+		}
+	}
+
+	body, err := json.MarshalIndent(clusters, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshalling config: %v\n\n", err)
+		return EXIT_PARTIAL
+	}
+	body = append(body, '\n')
+
+	if len(*output) == 0 {
+		os.Stdout.Write(body)
+		return EXIT_OK
+	}
+	err = ioutil.WriteFile(*output, body, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing config file %s: %v\n\n", *output, err)
+		return EXIT_PARTIAL
+	}
+	fmt.Printf("Wrote config for %d clusters to %s.\n", len(clusters.Clusters), *output)
+	return EXIT_OK
+}
+
+// cbsummary encrypt-config - encrypt (or decrypt) a config file
+func runEncryptConfig(args []string) int {
+	flags := flag.NewFlagSet("encrypt-config", flag.ExitOnError)
+	config := flags.String("config", "", "Config file to encrypt.")
+	keyFile := flags.String("key-file", "", "File whose contents are the secret (default: ask for a passphrase).")
+	output := flags.String("output", "", "File to write to (default: replace --config).")
+	decrypt := flags.Bool("decrypt", false, "Decrypt an encrypted config file instead, e.g. to edit it.")
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary encrypt-config --config=<config file> [--key-file=<file>] [--output=<file>]\n")
+		fmt.Fprintf(out, "                                [--decrypt]\n\n")
+		fmt.Fprintf(out, "  Encrypts a config file with a passphrase, or with the contents of a key file, so the\n")
+		fmt.Fprintf(out, "  cluster credentials aren't stored in cleartext. cbsummary decrypts it when loading it,\n")
+		fmt.Fprintf(out, "  given the same --config-key-file, the passphrase in %s, or the\n", cbsummary.CONFIG_PASSPHRASE_ENV)
+		fmt.Fprintf(out, "  passphrase at the terminal.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(*config) == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	if len(*output) == 0 {
+		*output = *config
+	}
+
+	body, err := ioutil.ReadFile(*config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration file %s: %v\n\n", *config, err)
+		return EXIT_PARTIAL
+	}
+	key := &cbsummary.ConfigKey{File: *keyFile}
+
+	if *decrypt {
+		if !cbsummary.IsEncryptedConfig(body) {
+			fmt.Fprintf(os.Stderr, "Configuration file %s isn't encrypted.\n\n", *config)
+			return EXIT_USAGE
+		}
+		body, err = cbsummary.DecryptConfig(body, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting configuration file %s: %v\n\n", *config, err)
+			return EXIT_PARTIAL
+		}
+	} else {
+		if cbsummary.IsEncryptedConfig(body) {
+			fmt.Fprintf(os.Stderr, "Configuration file %s is already encrypted.\n\n", *config)
+			return EXIT_USAGE
+		}
+		// make sure it's a config we can load before locking it away
+		format, err := cbsummary.ConfigFormat(*config, "")
+		if err == nil {
+			_, err = cbsummary.ParseConfig(body, format)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing configuration file %s: %v\n\n", *config, err)
+			return EXIT_USAGE
+		}
+		body, err = cbsummary.EncryptConfig(body, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encrypting configuration file %s: %v\n\n", *config, err)
+			return EXIT_PARTIAL
+		}
+	}
+
+	err = ioutil.WriteFile(*output, body, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n\n", *output, err)
+		return EXIT_PARTIAL
+	}
+	if *decrypt {
+		fmt.Printf("Wrote decrypted config to %s.\n", *output)
+	} else {
+		fmt.Printf("Wrote encrypted config to %s.\n", *output)
+	}
+	return EXIT_OK
+}
+
+// cbsummary diff - compare two reports
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		out := usageOutput(args)
+		flags.SetOutput(out)
+		fmt.Fprintf(out, "usage: cbsummary diff <old report> <new report>\n\n")
+		fmt.Fprintf(out, "  Compares two JSON reports and prints what changed: clusters that appeared or\n")
+		fmt.Fprintf(out, "  disappeared, nodes added and removed, version upgrades, core and RAM changes, and\n")
+		fmt.Fprintf(out, "  new or removed buckets (for full reports). Clusters are matched by their UUID.\n\n")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	old, err := cbsummary.LoadReport(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_PARTIAL
+	}
+	new, err := cbsummary.LoadReport(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		return EXIT_PARTIAL
+	}
+
+	fmt.Printf("Comparing %s with %s.\n\n", flags.Arg(0), flags.Arg(1))
+	for _, line := range cbsummary.DiffReports(old, new) {
+		fmt.Println(line)
+	}
+	return EXIT_OK
+}
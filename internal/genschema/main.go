@@ -0,0 +1,180 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package main
+
+//
+// genschema - writes the JSON Schema of the JSON report, from the Go types the
+// report is marshalled from. Run by 'go generate ./pkg/cbsummary' whenever
+// the report's types change; see pkg/cbsummary/schema.go.
+//
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/cbsummary/pkg/cbsummary"
+)
+
+type schema map[string]interface{}
+
+// the types of report fields that marshal themselves
+var marshalled = map[reflect.Type]schema{
+	reflect.TypeOf(time.Time{}):           {"type": "string", "format": "date-time"},
+	reflect.TypeOf(cbsummary.Duration(0)): {"type": "string", "description": "a Go duration, e.g. \"1m30s\""},
+}
+
+type generator struct {
+	defs map[string]schema
+}
+
+// the schema of a value of type t, adding any structs to the definitions
+func (g *generator) typeSchema(t reflect.Type) schema {
+	if s, ok := marshalled[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.typeSchema(t.Elem())
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return schema{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if len(name) == 0 {
+			return g.structSchema(t)
+		}
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = schema{} // for recursive types
+			g.defs[name] = g.structSchema(t)
+		}
+		return schema{"$ref": "#/$defs/" + name}
+	}
+	// interface{} and anything else may hold any value
+	return schema{}
+}
+
+// the schema of a struct: its fields, with those always written required
+func (g *generator) structSchema(t reflect.Type) schema {
+	properties := make(schema)
+	required := make([]string, 0)
+	g.addFields(t, properties, &required)
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+func (g *generator) addFields(t reflect.Type, properties schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		ft := field.Type
+		if field.Anonymous && len(name) == 0 {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		s := g.typeSchema(ft)
+		omitEmpty := strings.Contains(options, "omitempty")
+		if !omitEmpty {
+			*required = append(*required, name)
+			// nil pointers, slices and maps are written as null
+			switch ft.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+				if len(s) > 0 {
+					s = schema{"anyOf": []schema{s, {"type": "null"}}}
+				}
+			}
+		}
+		properties[name] = s
+	}
+}
+
+func main() {
+	output := flag.String("o", "report.schema.json", "File to write the schema to.")
+	flag.Parse()
+
+	g := &generator{defs: make(map[string]schema)}
+	root := g.structSchema(reflect.TypeOf(cbsummary.SummaryInfo{}))
+
+	// the clusters are brief or full, or the error collecting them
+	clusters := make([]schema, 0, 3)
+	for _, t := range []reflect.Type{
+		reflect.TypeOf(cbsummary.BriefCluster{}),
+		reflect.TypeOf(cbsummary.ClusterSummary{}),
+		reflect.TypeOf(cbsummary.ClusterError{}),
+	} {
+		clusters = append(clusters, g.typeSchema(t))
+	}
+	properties := root["properties"].(schema)
+	properties["clusters"] = schema{"anyOf": []schema{
+		{"type": "array", "items": schema{"anyOf": clusters}},
+		{"type": "null"},
+	}}
+
+	doc := schema{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         fmt.Sprintf("urn:couchbase:cbsummary:report:v%d", cbsummary.REPORT_SCHEMA_VERSION),
+		"title":       "cbsummary report",
+		"description": fmt.Sprintf("The JSON report written by cbsummary, schema version %d.", cbsummary.REPORT_SCHEMA_VERSION),
+		"$defs":       g.defs,
+	}
+	for k, v := range root {
+		doc[k] = v
+	}
+
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshalling schema: %v\n", err)
+		os.Exit(1)
+	}
+	err = os.WriteFile(*output, append(body, '\n'), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+}
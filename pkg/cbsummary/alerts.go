@@ -0,0 +1,56 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// email alerting, from /settings/alerts
+//
+// A cluster only tells anyone about a problem when email alerts are enabled,
+// with at least one recipient and at least one alert type; the pop-up alerts
+// are only seen by someone already looking at the UI. Clusters without
+// alerting in that sense are listed across the whole report.
+//
+
+type AlertSettings struct {
+	Enabled    bool     `json:"email_enabled"`
+	Recipients []string `json:"recipients"`
+	Alerts     []string `json:"alerts"`
+	Configured bool     `json:"alerting_configured"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func CollectAlertSettings(conn *ClusterConn) *AlertSettings {
+	settings := &AlertSettings{
+		Recipients: make([]string, 0),
+		Alerts:     make([]string, 0),
+	}
+
+	var data struct {
+		Enabled    bool     `json:"enabled"`
+		Recipients []string `json:"recipients"`
+		Alerts     []string `json:"alerts"`
+	}
+	err := conn.Client.getJSON("/settings/alerts", &data)
+	if err != nil {
+		settings.Error = err.Error()
+		return settings
+	}
+
+	settings.Enabled = data.Enabled
+	if data.Recipients != nil {
+		settings.Recipients = data.Recipients
+	}
+	if data.Alerts != nil {
+		settings.Alerts = data.Alerts
+	}
+	settings.Configured = settings.Enabled && len(settings.Recipients) > 0 && len(settings.Alerts) > 0
+	return settings
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// Analytics service summary for the full report, for clusters running the
+// cbas service
+//
+// - the dataverse and dataset counts come from querying the Metadata
+//   dataverse through /analytics/service
+// - the state of each link's ingestion comes from /analytics/status/ingestion
+// - the memory used is the total of each analytics node's heap_used (see
+//   service_usage.go), compared with the cbasMemoryQuota, which applies to
+//   each node
+//
+
+import (
+	"fmt"
+)
+
+type AnalyticsSummary struct {
+	Dataverses    int                  `json:"dataverses"`
+	Datasets      int                  `json:"datasets"`
+	Ingestion     []AnalyticsLinkState `json:"ingestion"`
+	MemoryUsedMB  float64              `json:"memory_used_mb"`
+	MemoryQuotaMB float64              `json:"memory_quota_mb"`
+	MemoryUsedPct float64              `json:"memory_used_pct"`
+	Errors        []string             `json:"errors,omitempty"`
+
+	// each node's usage, for the service layout
+	usage *ServiceUsage
+}
+
+type AnalyticsLinkState struct {
+	Name   string `json:"name"`
+	Scope  string `json:"scope,omitempty"`
+	Status string `json:"status"`
+}
+
+// the response from /analytics/status/ingestion
+type analyticsIngestion struct {
+	Links []AnalyticsLinkState `json:"links"`
+}
+
+// the response from /analytics/service to a COUNT query
+type analyticsCount struct {
+	Results []int `json:"results"`
+}
+
+func CollectAnalyticsSummary(conn *ClusterConn) *AnalyticsSummary {
+	summary := &AnalyticsSummary{Ingestion: make([]AnalyticsLinkState, 0)}
+
+	clients, err := conn.ServiceClients("cbas")
+	if err != nil {
+		summary.Errors = append(summary.Errors, err.Error())
+		return summary
+	}
+	if len(clients) == 0 {
+		return summary
+	}
+	client := clients[0]
+
+	summary.Dataverses, err = analyticsCountOf(client, "Dataverse")
+	if err != nil {
+		summary.Errors = append(summary.Errors, fmt.Sprintf("counting dataverses: %v", err))
+	}
+	summary.Datasets, err = analyticsCountOf(client, "Dataset")
+	if err != nil {
+		summary.Errors = append(summary.Errors, fmt.Sprintf("counting datasets: %v", err))
+	}
+
+	var ingestion analyticsIngestion
+	err = client.getJSON("/analytics/status/ingestion", &ingestion)
+	if err != nil {
+		summary.Errors = append(summary.Errors, fmt.Sprintf("ingestion status: %v", err))
+	} else if ingestion.Links != nil {
+		summary.Ingestion = ingestion.Links
+	}
+
+	usage := CollectAnalyticsUsage(conn)
+	summary.usage = usage
+	summary.MemoryUsedMB = usage.MemoryUsedMB
+	summary.MemoryQuotaMB = float64(conn.PoolsDefault.CbasMemoryQuota * len(clients))
+	if summary.MemoryQuotaMB > 0 {
+		summary.MemoryUsedPct = 100 * summary.MemoryUsedMB / summary.MemoryQuotaMB
+	}
+	if len(usage.Error) > 0 {
+		summary.Errors = append(summary.Errors, usage.Error)
+	}
+	return summary
+}
+
+// the number of entries in one of the Metadata dataverse's datasets
+func analyticsCountOf(client *RestClient, dataset string) (int, error) {
+	var count analyticsCount
+	err := client.postJSON("/analytics/service", map[string]string{
+		"statement": "SELECT VALUE COUNT(*) FROM Metadata.`" + dataset + "`",
+	}, &count)
+	if err != nil {
+		return 0, err
+	}
+	if len(count.Results) == 0 {
+		return 0, fmt.Errorf("no count in the response")
+	}
+	return count.Results[0], nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// AWS Secrets Manager and SSM Parameter Store - credentials read from AWS
+// when the config is loaded, for fleets whose admin credentials are rotated
+// there
+//
+//   "awssm:<secret>[#<field>]"   the secret's value from Secrets Manager, by
+//                                name or ARN
+//   "ssm:<parameter>"            the parameter's value from Parameter Store,
+//                                decrypted if it's a SecureString
+//
+// A Secrets Manager secret holding JSON, as rotated credentials do, gives the
+// field named after '#', or else its "username" for a login and "password"
+// for a password. Any other secret is used whole.
+//
+// Requests are made with the AWS SDK's default credential chain - the
+// environment, the shared config and credentials files (AWS_PROFILE), SSO,
+// web identity and EC2 or ECS roles - in the region of the secret's ARN, or
+// the configured region, or us-east-1. AWS_ENDPOINT_URL sends them somewhere
+// else, e.g. to a VPC endpoint. Each secret is read once per config load.
+//
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	AWS_SECRETS_MANAGER_PREFIX = "awssm:"
+	AWS_SSM_PREFIX             = "ssm:"
+
+	AWS_DEFAULT_REGION  = "us-east-1"
+	AWS_REQUEST_TIMEOUT = time.Minute
+)
+
+// reads secrets and parameters from AWS
+type AWSSecretReader struct {
+	// loaded on first use, so that configs without AWS references never look
+	// for credentials
+	config *aws.Config
+
+	// the values already read, by reference
+	values map[string]string
+}
+
+func NewAWSSecretReader() *AWSSecretReader {
+	return &AWSSecretReader{values: make(map[string]string)}
+}
+
+func isAWSReference(value string) bool {
+	return strings.HasPrefix(value, AWS_SECRETS_MANAGER_PREFIX) || strings.HasPrefix(value, AWS_SSM_PREFIX)
+}
+
+// the value of an "awssm:" or "ssm:" reference, taking defaultField from a
+// JSON secret that doesn't name a field
+func (a *AWSSecretReader) Read(ref, defaultField string) (string, error) {
+	if strings.HasPrefix(ref, AWS_SSM_PREFIX) {
+		name := strings.TrimPrefix(ref, AWS_SSM_PREFIX)
+		if len(name) == 0 {
+			return "", fmt.Errorf("Parameter Store reference '%s' should be ssm:<parameter>", ref)
+		}
+		return a.readParameter(name)
+	}
+
+	id, field, named := strings.Cut(strings.TrimPrefix(ref, AWS_SECRETS_MANAGER_PREFIX), "#")
+	if len(id) == 0 {
+		return "", fmt.Errorf("Secrets Manager reference '%s' should be awssm:<secret>[#<field>]", ref)
+	}
+	secret, err := a.readSecret(id)
+	if err != nil {
+		return "", err
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(secret), &fields) != nil {
+		if named {
+			return "", fmt.Errorf("Secrets Manager secret %s isn't JSON, so has no field %s", id, field)
+		}
+		return secret, nil
+	}
+	if !named {
+		field = defaultField
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("Secrets Manager secret %s has no string field %s", id, field)
+	}
+	return value, nil
+}
+
+func (a *AWSSecretReader) readSecret(id string) (string, error) {
+	if value, ok := a.values[AWS_SECRETS_MANAGER_PREFIX+id]; ok {
+		return value, nil
+	}
+
+	cfg, err := a.awsConfig(id)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), AWS_REQUEST_TIMEOUT)
+	defer cancel()
+	resp, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading secret %s from Secrets Manager: %v", id, awsErrorMessage(err))
+	}
+	if resp.SecretString == nil || len(*resp.SecretString) == 0 {
+		return "", fmt.Errorf("Secrets Manager secret %s has no string value", id)
+	}
+	a.values[AWS_SECRETS_MANAGER_PREFIX+id] = *resp.SecretString
+	return *resp.SecretString, nil
+}
+
+func (a *AWSSecretReader) readParameter(name string) (string, error) {
+	if value, ok := a.values[AWS_SSM_PREFIX+name]; ok {
+		return value, nil
+	}
+
+	cfg, err := a.awsConfig(name)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), AWS_REQUEST_TIMEOUT)
+	defer cancel()
+	resp, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading parameter %s from Parameter Store: %v", name, awsErrorMessage(err))
+	}
+	value := aws.ToString(resp.Parameter.Value)
+	a.values[AWS_SSM_PREFIX+name] = value
+	return value, nil
+}
+
+// the SDK's configuration, in the region of id if it's an ARN,
+// arn:aws:<service>:<region>:...
+func (a *AWSSecretReader) awsConfig(id string) (aws.Config, error) {
+	if a.config == nil {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("Error loading the AWS configuration: %v", err)
+		}
+		if len(cfg.Region) == 0 {
+			cfg.Region = AWS_DEFAULT_REGION
+		}
+		a.config = &cfg
+	}
+
+	cfg := a.config.Copy()
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" && len(parts[3]) > 0 {
+		cfg.Region = parts[3]
+	}
+	return cfg, nil
+}
+
+// the code and message of an error from an AWS API, e.g.
+// "ResourceNotFoundException: Secrets Manager can't find the specified secret."
+func awsErrorMessage(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if msg := apiErr.ErrorMessage(); len(msg) > 0 {
+			return apiErr.ErrorCode() + ": " + msg
+		}
+		return apiErr.ErrorCode()
+	}
+	return err.Error()
+}
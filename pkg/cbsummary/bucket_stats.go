@@ -0,0 +1,77 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// detailed KV bucket stats - the last sample of a configurable set of stats
+// from /pools/default/buckets/<bucket>/stats for each bucket
+//
+
+import (
+	"strings"
+)
+
+// the stats collected when no others are asked for: the queues that show
+// whether writes are keeping up, and the cache miss rate
+var DEFAULT_BUCKET_STATS = []string{"ep_queue_size", "disk_write_queue", "ep_cache_miss_rate"}
+
+type BucketStats struct {
+	Buckets []BucketStatValues `json:"buckets"`
+	Error   string             `json:"error,omitempty"`
+}
+
+type BucketStatValues struct {
+	Name  string             `json:"name"`
+	Stats map[string]float64 `json:"stats"`
+	Error string             `json:"error,omitempty"`
+}
+
+// parse a comma-separated list of stat names
+func ParseStatNames(names string) []string {
+	stats := make([]string, 0)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 {
+			stats = append(stats, name)
+		}
+	}
+	return stats
+}
+
+func CollectBucketStats(conn *ClusterConn, statNames []string) *BucketStats {
+	stats := &BucketStats{Buckets: make([]BucketStatValues, 0)}
+
+	buckets, err := conn.Buckets()
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+
+	for _, bucket := range buckets {
+		values := BucketStatValues{Name: bucket.Name, Stats: make(map[string]float64)}
+
+		samples, err := conn.Client.GetBucketStats(bucket.Name)
+		if err != nil {
+			LogError("Error getting stats for bucket %s: %v", bucket.Name, err)
+			values.Error = err.Error()
+		} else {
+			for _, name := range statNames {
+				if sample := samples.Op.Samples[name]; len(sample) > 0 {
+					values.Stats[name] = sample[len(sample)-1]
+				}
+			}
+		}
+
+		stats.Buckets = append(stats.Buckets, values)
+	}
+
+	return stats
+}
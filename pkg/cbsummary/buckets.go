@@ -0,0 +1,136 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// bucket inventory for the full report - the number of buckets of each type,
+// and each bucket's quota, replicas and item count, from
+// /pools/default/buckets
+//
+// Each Couchbase and ephemeral bucket also gets a summary of its last minute
+// of stats from /pools/default/buckets/<bucket>/stats: the average resident
+// ratio and ops/sec, the peak ops/sec, and the latest disk use, data size and
+// fragmentation.
+//
+
+type BucketInventory struct {
+	Counts  BucketSummary  `json:"counts"`
+	Buckets []BucketDetail `json:"buckets"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type BucketDetail struct {
+	Name       string  `json:"name"`
+	BucketType string  `json:"bucket_type"`
+	QuotaMB    float64 `json:"ram_quota_mb"`
+	Replicas   int     `json:"replicas"`
+	Items      float64 `json:"item_count"`
+	QuotaUsed  float64 `json:"ram_quota_used_pct"`
+
+	Stats       *BucketStatSummary  `json:"stats,omitempty"`
+	Compaction  *CompactionSettings `json:"compaction,omitempty"`
+	Collections *BucketCollections  `json:"collections,omitempty"`
+}
+
+type BucketStatSummary struct {
+	ResidentRatio    float64 `json:"resident_ratio_pct"`
+	OpsPerSec        float64 `json:"ops_per_sec"`
+	PeakOpsPerSec    float64 `json:"peak_ops_per_sec"`
+	DiskUsedMB       float64 `json:"disk_used_mb"`
+	DataSizeMB       float64 `json:"data_size_mb"`
+	FragmentationPct float64 `json:"fragmentation_pct"`
+	Samples          int     `json:"samples"`
+	Error            string  `json:"error,omitempty"`
+}
+
+func CollectBucketInventory(conn *ClusterConn, maxCollectionNames int) *BucketInventory {
+	inventory := &BucketInventory{Buckets: make([]BucketDetail, 0)}
+
+	buckets, err := conn.Buckets()
+	if err != nil {
+		inventory.Error = err.Error()
+		return inventory
+	}
+
+	collections := supportsCollections(conn.PoolsDefault.Nodes)
+	for _, bucket := range buckets {
+		switch bucket.BucketType {
+		case "membase", "couchbase":
+			inventory.Counts.Membase++
+		case "ephemeral":
+			inventory.Counts.Emphemeral++
+		case "memcached":
+			inventory.Counts.Memcached++
+		}
+		inventory.Counts.Total++
+
+		detail := BucketDetail{
+			Name:       bucket.Name,
+			BucketType: bucket.BucketType,
+			QuotaMB:    bucket.Quota.RAM / 1024 / 1024,
+			Replicas:   bucket.ReplicaNumber,
+			Items:      bucket.BasicStats.ItemCount,
+			QuotaUsed:  bucket.BasicStats.QuotaPercentUsed,
+			Compaction: bucketCompactionSettings(bucket),
+		}
+		if bucket.BucketType != "memcached" {
+			detail.Stats = summarizeBucketStats(conn.Client, bucket.Name)
+		}
+		if collections && bucket.BucketType != "memcached" {
+			detail.Collections = collectBucketCollections(conn.Client, bucket.Name, maxCollectionNames)
+		}
+		inventory.Buckets = append(inventory.Buckets, detail)
+	}
+
+	return inventory
+}
+
+func summarizeBucketStats(client *RestClient, bucket string) *BucketStatSummary {
+	summary := new(BucketStatSummary)
+
+	samples, err := client.GetBucketStats(bucket)
+	if err != nil {
+		LogError("Error getting stats for bucket %s: %v", bucket, err)
+		summary.Error = err.Error()
+		return summary
+	}
+
+	stats := samples.Op.Samples
+	summary.ResidentRatio, _ = averageSample(stats["vb_active_resident_items_ratio"])
+	summary.OpsPerSec, summary.PeakOpsPerSec = averageSample(stats["ops"])
+	summary.DiskUsedMB = lastSample(stats["couch_docs_actual_disk_size"]) / 1024 / 1024
+	summary.DataSizeMB = lastSample(stats["couch_docs_data_size"]) / 1024 / 1024
+	summary.FragmentationPct = lastSample(stats["couch_docs_fragmentation"])
+	summary.Samples = len(stats["ops"])
+	return summary
+}
+
+// the average and the largest of the samples
+func averageSample(samples []float64) (float64, float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	total, peak := 0.0, samples[0]
+	for _, sample := range samples {
+		total += sample
+		if sample > peak {
+			peak = sample
+		}
+	}
+	return total / float64(len(samples)), peak
+}
+
+func lastSample(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	return samples[len(samples)-1]
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// busy cluster guardrails - a cluster that is rebalancing or failing over is
+// already under strain, so the optional (heavier) collectors can be held off
+//
+// The basic cluster and node details and the tasks are always collected. With
+// --skip-busy the optional sections, and the rest of a full report, are
+// skipped for a busy cluster; with --wait-busy the collector first waits up to
+// the given time for the cluster to finish, checking every BUSY_POLL_INTERVAL,
+// and skips them if it is still busy. The check is made once per cluster,
+// before any of the heavier requests.
+//
+
+import (
+	"time"
+)
+
+const BUSY_POLL_INTERVAL = 30 * time.Second
+
+// the task types that mean a cluster is busy when running
+var BUSY_TASK_TYPES = map[string]bool{
+	"rebalance":        true,
+	"failover":         true,
+	"gracefulFailover": true,
+}
+
+// what the cluster is busy doing, or "" if it isn't
+func ClusterBusy(client *RestClient) (string, error) {
+	tasks, err := client.GetTasks()
+	if err != nil {
+		return "", err
+	}
+
+	for _, task := range tasks {
+		if task.Status != "running" || !BUSY_TASK_TYPES[task.Type] {
+			continue
+		}
+		if len(task.Subtype) > 0 {
+			return task.Subtype, nil
+		}
+		return task.Type, nil
+	}
+	return "", nil
+}
+
+// whether to hold off the optional collectors for a cluster, and why
+func (c *Collector) holdOffBusy(client *RestClient) (bool, string) {
+	if !c.options.SkipBusy && c.options.WaitBusy <= 0 {
+		return false, ""
+	}
+
+	deadline := time.Now().Add(c.options.WaitBusy)
+	for {
+		busy, err := ClusterBusy(client)
+		if err != nil {
+			// can't tell, so carry on as usual
+			LogError("Error checking tasks on %s: %v", client.host, err)
+			return false, ""
+		}
+		if len(busy) == 0 {
+			return false, ""
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			LogWarn("Cluster at %s is busy (%s), skipping optional collection.", client.host, busy)
+			return true, busy
+		}
+
+		wait := BUSY_POLL_INTERVAL
+		if remaining < wait {
+			wait = remaining
+		}
+		LogWarn("Cluster at %s is busy (%s), waiting %v.", client.host, busy, wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-client.context().Done():
+			return true, busy
+		}
+	}
+}
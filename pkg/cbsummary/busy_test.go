@@ -0,0 +1,127 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a copy of testdata/raw with the cluster mid-rebalance, keeping only the
+// responses needed for the basic details so that any heavier request fails
+func busyRawDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"clusters.json", "127.0.0.1_18091/GET_pools_2ff3dfc0.json",
+		"127.0.0.1_18091/GET_pools_default_c89fd6ea.json", "127.0.0.1_18091/GET_pools_default_tasks_513da785.json"} {
+		body, err := os.ReadFile(filepath.Join(filepath.FromSlash(TEST_RAW_DIR), filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(name, "tasks") {
+			body = []byte(strings.Replace(string(body), `"status": "notRunning"`, `"status": "running"`, 1))
+		}
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, body, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func collectRawDir(t *testing.T, dir string, options CollectOptions) interface{} {
+	t.Helper()
+	store, err := OpenRawSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := store.LoadClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Transport.Raw = store
+	return NewCollector(options).Collect(clusters).Clusters[0]
+}
+
+func TestBusyClusterHeldOff(t *testing.T) {
+	dir := busyRawDir(t)
+
+	tests := []struct {
+		name    string
+		options CollectOptions
+	}{
+		{"full", CollectOptions{Full: true, SkipBusy: true}},
+		{"full with extras", CollectOptions{Full: true, SkipBusy: true, Tasks: true, QueryStats: true, Security: true}},
+		{"brief with extras", CollectOptions{SkipBusy: true, Tasks: true, QueryStats: true, Security: true,
+			NodeRTT: true}},
+	}
+	for _, test := range tests {
+		var extras ClusterExtras
+		switch c := collectRawDir(t, dir, test.options).(type) {
+		case *ClusterSummary:
+			if c.Buckets != nil || c.Indexes != nil || c.XDCRTopology != nil || c.Certificates != nil ||
+				c.Info != nil {
+				t.Errorf("%s: collected the full sections of a busy cluster", test.name)
+			}
+			extras = c.ClusterExtras
+		case *BriefCluster:
+			extras = c.ClusterExtras
+		default:
+			t.Fatalf("%s: cluster is %T", test.name, c)
+		}
+
+		if extras.SkippedBusy != "rebalance" {
+			t.Errorf("%s: skipped for %q, want rebalance", test.name, extras.SkippedBusy)
+		}
+		if extras.QueryStats != nil || extras.Security != nil || extras.RBACUsers != nil ||
+			extras.ManagementLatency != nil {
+			t.Errorf("%s: collected the optional sections of a busy cluster", test.name)
+		}
+		if extras.Tasks == nil || len(extras.Tasks.Error) > 0 {
+			t.Errorf("%s: tasks %+v, want them collected", test.name, extras.Tasks)
+		}
+	}
+}
+
+func TestIdleClusterNotHeldOff(t *testing.T) {
+	options := CollectOptions{Full: true, SkipBusy: true}
+	c, ok := collectRawDir(t, filepath.FromSlash(TEST_RAW_DIR), options).(*ClusterSummary)
+	if !ok {
+		t.Fatal("cluster isn't a *ClusterSummary")
+	}
+	if len(c.SkippedBusy) > 0 || c.Buckets == nil || c.Security == nil || c.Info == nil {
+		t.Errorf("held off an idle cluster: skipped for %q", c.SkippedBusy)
+	}
+}
+
+func TestBusyClusterDriftSkipped(t *testing.T) {
+	store, err := OpenRawSnapshots(busyRawDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := store.LoadClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters.Clusters[0].Labels = map[string]string{"env": "prod"}
+
+	summary := NewCollector(CollectOptions{SkipBusy: true, DriftLabel: "env",
+		Transport: TransportOptions{Raw: store}}).Collect(clusters)
+	if summary.Drift == nil || len(summary.Drift.Groups) > 0 || len(summary.Drift.SkippedBusy) != 1 ||
+		summary.Drift.SkippedBusy[0] != 0 {
+		t.Errorf("drift %+v, want the busy cluster skipped and not compared", summary.Drift)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// Capella - summarizing the clusters in Capella organizations alongside the
+// self-managed ones, so a hybrid fleet appears in one report
+//
+// Organizations are listed in the "capella" section of the config file, e.g.
+//
+//   "capella": [{"organization_id": "6af08c0a-...", "api_key": "...",
+//                "api_secret": "$CAPELLA_SECRET"}]
+//
+// Every cluster in every project of the organization is added to the report
+// after the configured clusters, built from the management API's description
+// of it. The management API reports each service group's node size rather
+// than each node, so the nodes are listed per service group, named after the
+// cluster, and carry no host names. The "api_secret" is the API key's token,
+// sent as a bearer token; like passwords it may be "$NAME" to read it from the
+// environment. The "api_key" ID is optional, and only used in messages.
+//
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const CAPELLA_API_URL = "https://cloudapi.cloud.couchbase.com"
+
+// the page size for listing projects and clusters
+const CAPELLA_PAGE_SIZE = 100
+
+type CapellaOrg struct {
+	OrganizationID string `json:"organization_id"`
+	APIKey         string `json:"api_key,omitempty"`
+	APISecret      string `json:"api_secret"`
+
+	// for testing against somewhere other than the public API
+	URL string `json:"url,omitempty"`
+
+	// the secret as written in the config, before it was resolved
+	configuredSecret string
+}
+
+// the organization as given in the config, without its secret resolved
+func (o CapellaOrg) asConfigured() CapellaOrg {
+	o.APISecret = o.configuredSecret
+	return o
+}
+
+type capellaPage struct {
+	Data   json.RawMessage `json:"data"`
+	Cursor struct {
+		Pages struct {
+			Page int `json:"page"`
+			Last int `json:"last"`
+		} `json:"pages"`
+	} `json:"cursor"`
+}
+
+type CapellaProject struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CapellaCluster struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	CurrentState    string `json:"currentState"`
+	CouchbaseServer struct {
+		Version string `json:"version"`
+	} `json:"couchbaseServer"`
+	CloudProvider struct {
+		Type   string `json:"type"`
+		Region string `json:"region"`
+	} `json:"cloudProvider"`
+	ServiceGroups []CapellaServiceGroup `json:"serviceGroups"`
+}
+
+type CapellaServiceGroup struct {
+	Node struct {
+		Compute struct {
+			CPU float64 `json:"cpu"`
+			RAM float64 `json:"ram"` // GB
+		} `json:"compute"`
+	} `json:"node"`
+	NumOfNodes int      `json:"numOfNodes"`
+	Services   []string `json:"services"`
+}
+
+func (org CapellaOrg) client(transport TransportOptions) *RestClient {
+	base := org.URL
+	if len(base) == 0 {
+		base = CAPELLA_API_URL
+	}
+	client := CreateRestClient(strings.TrimSuffix(base, "/"), "", "", nil, transport)
+	client.SetHeaders(map[string]string{"Authorization": "Bearer " + org.APISecret})
+	return client
+}
+
+func (org CapellaOrg) keyInfo() string {
+	if len(org.APIKey) == 0 {
+		return ""
+	}
+	return " with API key " + org.APIKey
+}
+
+// the org as given in the config, for error reports
+func (org CapellaOrg) asCluster() Cluster {
+	base := org.URL
+	if len(base) == 0 {
+		base = CAPELLA_API_URL
+	}
+	return Cluster{Nodes: []string{base}, Label: "capella:" + org.OrganizationID, Tags: []string{"capella"}}
+}
+
+// GET every page of a list from the management API, passing the items on
+// each to the function
+func capellaList(client *RestClient, path string, each func(items json.RawMessage) error) error {
+	for page := 1; ; page++ {
+		var data capellaPage
+		err := client.getJSON(fmt.Sprintf("%s?page=%d&perPage=%d", path, page, CAPELLA_PAGE_SIZE), &data)
+		if err != nil {
+			return err
+		}
+		err = each(data.Data)
+		if err != nil {
+			return err
+		}
+		if data.Cursor.Pages.Last <= page {
+			return nil
+		}
+	}
+}
+
+// the clusters in all the projects of an organization, and the project each
+// belongs to
+func ListCapellaClusters(client *RestClient, org string) ([]CapellaCluster, []CapellaProject, error) {
+	orgPath := "/v4/organizations/" + url.PathEscape(org)
+
+	projects := make([]CapellaProject, 0)
+	err := capellaList(client, orgPath+"/projects", func(items json.RawMessage) error {
+		var page []CapellaProject
+		err := json.Unmarshal(items, &page)
+		projects = append(projects, page...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clusters := make([]CapellaCluster, 0)
+	owners := make([]CapellaProject, 0)
+	for _, project := range projects {
+		err := capellaList(client, orgPath+"/projects/"+url.PathEscape(project.ID)+"/clusters", func(items json.RawMessage) error {
+			var page []CapellaCluster
+			err := json.Unmarshal(items, &page)
+			for _, cluster := range page {
+				clusters = append(clusters, cluster)
+				owners = append(owners, project)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return clusters, owners, nil
+}
+
+// the ns_server names of Capella's services
+func serverServices(services []string) []string {
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		name := service
+		for server, capella := range CAPELLA_SERVICE_NAMES {
+			if capella == service {
+				name = server
+				break
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// the cluster's nodes, as ns_server would describe them
+func (cluster CapellaCluster) nodeInfo() []NodeInfo {
+	nodes := make([]NodeInfo, 0)
+	for g, group := range cluster.ServiceGroups {
+		services := serverServices(group.Services)
+		for n := 0; n < group.NumOfNodes; n++ {
+			var node NodeInfo
+			node.Hostname = fmt.Sprintf("%s-sg%d-%d", cluster.Name, g+1, n+1)
+			node.Version = cluster.CouchbaseServer.Version
+			node.Services = services
+			node.Status = cluster.CurrentState
+			node.ClusterMembership = "active"
+			node.CpuCount = group.Node.Compute.CPU
+			node.SystemStats.CPU_cores_available = group.Node.Compute.CPU
+			node.MemoryTotal = group.Node.Compute.RAM * 1024 * 1024 * 1024
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (cluster CapellaCluster) identity(org string, project CapellaProject) ClusterIdentity {
+	return ClusterIdentity{
+		Label: cluster.Name,
+		Tags:  []string{"capella"},
+		Labels: map[string]string{
+			"capella_organization": org,
+			"capella_project":      project.Name,
+			"cloud_provider":       cluster.CloudProvider.Type,
+			"region":               cluster.CloudProvider.Region,
+		},
+	}
+}
+
+// results for the clusters in the Capella organizations, numbered from first
+func (c *Collector) collectCapella(ctx context.Context, orgs []CapellaOrg, first int, transport TransportOptions) []*clusterResult {
+	results := make([]*clusterResult, 0)
+	for _, org := range orgs {
+		client := org.client(transport).WithContext(ctx)
+		clusters, projects, err := ListCapellaClusters(client, org.OrganizationID)
+		if err != nil {
+			LogError("Error listing Capella clusters in organization %s%s: %v", org.OrganizationID, org.keyInfo(), err)
+			results = append(results, &clusterResult{
+				Num:   first + len(results),
+				Error: &ClusterError{TheCluster: org.asCluster(), ErrMsg: err.Error()},
+			})
+			continue
+		}
+
+		for i, cluster := range clusters {
+			result := &clusterResult{Num: first + len(results), UUID: cluster.ID, Nodes: cluster.nodeInfo()}
+			identity := cluster.identity(org.OrganizationID, projects[i])
+			result.Labels = identity.Labels
+
+			if c.options.Full {
+				full := &ClusterSummary{
+					ImplementationVersion: cluster.CouchbaseServer.Version,
+					IsEnterprise:          true,
+					Uuid:                  cluster.ID,
+					ClusterName:           cluster.Name,
+					NodeCount:             len(result.Nodes),
+					Nodes:                 result.Nodes,
+					NodeVersions:          map[string]int{cluster.CouchbaseServer.Version: len(result.Nodes)},
+					ClusterIdentity:       identity,
+				}
+				result.Full = full
+			} else {
+				brief := &BriefCluster{Size: len(result.Nodes), UUID: cluster.ID, ClusterIdentity: identity}
+				for _, nodeInfo := range result.Nodes {
+					brief.Nodes = append(brief.Nodes, BriefNode{
+						Cores:   nodeInfo.SystemStats.CPU_cores_available,
+						RAM:     nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0,
+						Name:    nodeInfo.Hostname,
+						Version: nodeInfo.Version,
+					})
+				}
+				result.Brief = brief
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// certificate expiry - the cluster certificate from /pools/default/certificate
+// and each node's certificate from /pools/default/certificates
+//
+// The subject, issuer and expiry are read from the PEM where the server gives
+// it, falling back to the subject and expiry fields it reports alongside.
+// Certificates expiring within --cert-warn-days are marked as expiring, as are
+// those which have already expired.
+//
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"math"
+	"time"
+)
+
+const DEFAULT_CERT_WARN_DAYS = 30
+
+type CertificateReport struct {
+	Certificates []CertificateInfo `json:"certificates"`
+	Expiring     int               `json:"expiring"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type CertificateInfo struct {
+	Kind     string    `json:"kind"`
+	Node     string    `json:"node,omitempty"`
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer,omitempty"`
+	Expires  time.Time `json:"expires"`
+	DaysLeft int       `json:"days_until_expiry"`
+	Expiring bool      `json:"expiring"`
+}
+
+// types for parsing JSON from the certificate endpoints
+
+type certificateEntry struct {
+	Node    string `json:"node"`
+	Subject string `json:"subject"`
+	Expires string `json:"expires"`
+	PEM     string `json:"pem"`
+}
+
+func CollectCertificates(conn *ClusterConn, warnDays int) *CertificateReport {
+	report := &CertificateReport{Certificates: make([]CertificateInfo, 0)}
+	now := time.Now()
+
+	var cluster struct {
+		Cert certificateEntry `json:"cert"`
+	}
+	err := conn.Client.getJSON("/pools/default/certificate", &cluster)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.add(certificateInfo("cluster", cluster.Cert, now, warnDays))
+
+	var nodes []certificateEntry
+	err = conn.Client.getJSON("/pools/default/certificates", &nodes)
+	if err != nil {
+		// servers before 5.0 only have the cluster certificate
+		LogError("Error getting node certificates from %s: %v", conn.Client.host, err)
+		report.Error = err.Error()
+		return report
+	}
+	for _, node := range nodes {
+		report.add(certificateInfo("node", node, now, warnDays))
+	}
+
+	return report
+}
+
+func (r *CertificateReport) add(cert CertificateInfo) {
+	r.Certificates = append(r.Certificates, cert)
+	if cert.Expiring {
+		r.Expiring++
+	}
+}
+
+func certificateInfo(kind string, entry certificateEntry, now time.Time, warnDays int) CertificateInfo {
+	info := CertificateInfo{Kind: kind, Node: entry.Node, Subject: entry.Subject}
+
+	if block, _ := pem.Decode([]byte(entry.PEM)); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			info.Subject = cert.Subject.String()
+			info.Issuer = cert.Issuer.String()
+			info.Expires = cert.NotAfter
+		}
+	}
+	if info.Expires.IsZero() {
+		info.Expires, _ = time.Parse(time.RFC3339, entry.Expires)
+	}
+
+	if !info.Expires.IsZero() {
+		info.DaysLeft = int(math.Floor(info.Expires.Sub(now).Hours() / 24))
+		info.Expiring = info.DaysLeft < warnDays
+	}
+	return info
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// checkpoints - so that a long run which dies part way through can pick up
+// where it left off instead of starting again
+//
+// With --checkpoint=<file>, each cluster's result is appended to the file as
+// soon as it has been collected, and the file is removed once the report has
+// been written. If the file is there at the start of a run, the clusters
+// already in it can be reused rather than collected again; clusters that
+// couldn't be reached are tried again. The file starts
+// with a key made from the config and the collection options, and is only
+// reused for a run with the same key. It holds the cluster details collected,
+// so it is written readable only by its owner.
+//
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type Checkpoint struct {
+	path    string
+	key     string
+	started time.Time
+	done    map[int]*clusterResult
+	file    *os.File
+
+	// clusters are saved from several goroutines at once
+	mu sync.Mutex
+}
+
+// the first line of a checkpoint file
+type checkpointHeader struct {
+	Key     string    `json:"key"`
+	Started time.Time `json:"started"`
+}
+
+// the key identifying runs that can share a checkpoint; the clusters and
+// Capella organizations are taken as configured, so a credential asked for or
+// read from the environment or a secret store can differ between the runs
+func (c *Collector) CheckpointKey(clusters *ClusterList) string {
+	configured := *clusters
+	configured.Clusters = make([]Cluster, len(clusters.Clusters))
+	for i, cluster := range clusters.Clusters {
+		configured.Clusters[i] = cluster.asConfigured()
+	}
+	configured.Capella = make([]CapellaOrg, len(clusters.Capella))
+	for i, org := range clusters.Capella {
+		configured.Capella[i] = org.asConfigured()
+	}
+	body, _ := json.Marshal(struct {
+		Clusters *ClusterList
+		Options  CollectOptions
+	}{&configured, c.options})
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// open a checkpoint, reading the results from an earlier run with the same key if there are any
+func OpenCheckpoint(path, key string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, key: key, started: time.Now(), done: make(map[int]*clusterResult)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error opening checkpoint %s: %v", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	if !scanner.Scan() {
+		return cp, nil
+	}
+	var header checkpointHeader
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Key != key {
+		LogWarn("Checkpoint %s is from a different config or options, starting over.", path)
+		return cp, nil
+	}
+	cp.started = header.Started
+
+	for scanner.Scan() {
+		var result clusterResult
+		if err = json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			break // the last line may have been cut short by the crash
+		}
+		cp.done[result.Num] = &result
+	}
+	return cp, nil
+}
+
+// the number of clusters already collected successfully
+func (cp *Checkpoint) Completed() int {
+	completed := 0
+	for _, result := range cp.done {
+		if result.Error == nil {
+			completed++
+		}
+	}
+	return completed
+}
+
+func (cp *Checkpoint) Started() time.Time {
+	return cp.started
+}
+
+// forget the earlier results and start over
+func (cp *Checkpoint) Discard() {
+	cp.done = make(map[int]*clusterResult)
+	cp.started = time.Now()
+}
+
+// rewrite the file with the results being kept, and leave it open for appending
+func (cp *Checkpoint) begin() error {
+	f, err := os.OpenFile(cp.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Error writing checkpoint %s: %v", cp.path, err)
+	}
+	cp.file = f
+
+	err = cp.append(checkpointHeader{cp.key, cp.started})
+	for _, result := range cp.done {
+		if err == nil {
+			err = cp.append(result)
+		}
+	}
+	return err
+}
+
+// the result of an earlier run for the cluster, if it was collected successfully
+func (cp *Checkpoint) result(cnum int) (*clusterResult, bool) {
+	if cp == nil {
+		return nil, false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	result, ok := cp.done[cnum]
+	if !ok || result.Error != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// record a cluster's result
+func (cp *Checkpoint) save(result *clusterResult) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.file == nil {
+		return nil
+	}
+	cp.done[result.Num] = result
+	return cp.append(result)
+}
+
+func (cp *Checkpoint) append(record interface{}) error {
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = cp.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = cp.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing checkpoint %s: %v", cp.path, err)
+	}
+	return nil
+}
+
+func (cp *Checkpoint) close() {
+	if cp != nil && cp.file != nil {
+		cp.file.Close()
+		cp.file = nil
+	}
+}
+
+// remove the checkpoint once the run's report is safely written
+func (cp *Checkpoint) Remove() error {
+	cp.close()
+	err := os.Remove(cp.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing checkpoint %s: %v", cp.path, err)
+	}
+	return nil
+}
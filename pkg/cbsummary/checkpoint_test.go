@@ -0,0 +1,59 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"testing"
+)
+
+// the clusters of a config taking its credentials from the environment
+func testCredentialClusters(t *testing.T) *ClusterList {
+	t.Helper()
+	clusters := &ClusterList{
+		Clusters: []Cluster{{Login: "$CBS_TEST_LOGIN", Pass: "${CBS_TEST_PASS}",
+			Nodes: []string{"http://10.0.0.1:8091"}}},
+		Capella: []CapellaOrg{{OrganizationID: "org-1", APISecret: "$CBS_TEST_SECRET"}},
+	}
+	if err := expandCredentials(clusters); err != nil {
+		t.Fatal(err)
+	}
+	return clusters
+}
+
+func TestCheckpointKeyIgnoresResolvedCredentials(t *testing.T) {
+	collector := NewCollector(CollectOptions{})
+
+	t.Setenv("CBS_TEST_LOGIN", "admin")
+	t.Setenv("CBS_TEST_PASS", "password-1")
+	t.Setenv("CBS_TEST_SECRET", "secret-1")
+	first := testCredentialClusters(t)
+	if first.Clusters[0].Login != "admin" || first.Clusters[0].Pass != "password-1" ||
+		first.Capella[0].APISecret != "secret-1" {
+		t.Fatalf("resolved %+v and %+v", first.Clusters[0], first.Capella[0])
+	}
+
+	t.Setenv("CBS_TEST_LOGIN", "operator")
+	t.Setenv("CBS_TEST_PASS", "password-2")
+	t.Setenv("CBS_TEST_SECRET", "secret-2")
+	second := testCredentialClusters(t)
+
+	if collector.CheckpointKey(first) != collector.CheckpointKey(second) {
+		t.Errorf("the checkpoint key changed with the resolved credentials")
+	}
+
+	configured := first.Clusters[0].asConfigured()
+	if configured.Login != "$CBS_TEST_LOGIN" || configured.Pass != "${CBS_TEST_PASS}" {
+		t.Errorf("configured login %q and password %q, want them as written", configured.Login, configured.Pass)
+	}
+	if secret := first.Capella[0].asConfigured().APISecret; secret != "$CBS_TEST_SECRET" {
+		t.Errorf("configured secret %q, want it as written", secret)
+	}
+}
@@ -0,0 +1,753 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// collection of the summary information from each of the configured clusters
+//
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// settings controlling what gets collected
+type CollectOptions struct {
+	Full             bool
+	LicenseModel     string
+	Entitlement      *Entitlement
+	ConsumptionUnits bool
+	CapellaSizing    bool
+
+	// compare settings across the clusters sharing a value of this label
+	DriftLabel string
+
+	QueryStats    bool
+	ServiceUsage  bool
+	EventingStats bool
+
+	// the stats to collect for each bucket; none means no bucket stats
+	BucketStats []string
+
+	XDCRStats bool
+	// the changes left above which a replication is reported as lagging
+	XDCRLagThreshold float64
+
+	Hardware bool
+
+	// auditing, LDAP, encryption and password policy, and the RBAC users
+	Security bool
+
+	// certificates expiring within this many days are marked as expiring
+	CertWarnDays int
+
+	// the most collection names to list for each bucket; 0 lists them all
+	MaxCollectionNames int
+
+	// how many of the most recent event log entries to look through for
+	// failovers and nodes joining or leaving; 0 leaves the events out
+	Events int
+
+	// measure the round trip to each node's management endpoint
+	NodeRTT bool
+
+	// the rebalances, compactions and XDCR tasks running on each cluster
+	Tasks bool
+
+	// hold off the optional collectors on clusters that are rebalancing or
+	// failing over: skip them at once, or after waiting up to WaitBusy
+	SkipBusy bool
+	WaitBusy time.Duration
+
+	// overrides for the transport settings in the config file
+	Transport TransportOptions
+
+	// for https:// endpoints; nil for the defaults
+	TLSConfig *tls.Config `json:"-"`
+
+	// how many clusters to collect at once; it doesn't change what is
+	// collected, so it's left out of checkpoint keys
+	MaxConcurrency int `json:"-"`
+
+	// the longest to spend on any one cluster before moving on
+	ClusterTimeout time.Duration `json:"-"`
+
+	// the longest to spend on the whole run; clusters not collected by then
+	// are reported as timed out
+	Deadline time.Duration `json:"-"`
+
+	// replaces the hostnames, cluster names and UUIDs in the summary, if set
+	Redactor *Redactor `json:"-"`
+}
+
+// a cluster we have connected to, with the information the collectors share
+type ClusterConn struct {
+	Client       *RestClient
+	Pools        *Pools
+	PoolsDefault *PoolsDefault
+
+	nodeServices    *NodeServices
+	nodeServicesErr error
+	buckets         []BucketInfo
+	bucketsErr      error
+}
+
+// the cluster's buckets, fetched the first time they're needed
+func (conn *ClusterConn) Buckets() ([]BucketInfo, error) {
+	if conn.buckets == nil && conn.bucketsErr == nil {
+		conn.buckets, conn.bucketsErr = conn.Client.GetBuckets()
+	}
+	return conn.buckets, conn.bucketsErr
+}
+
+// the ports each node's services listen on, fetched the first time it's needed
+func (conn *ClusterConn) NodeServices() (*NodeServices, error) {
+	if conn.nodeServices == nil && conn.nodeServicesErr == nil {
+		conn.nodeServices, conn.nodeServicesErr = conn.Client.GetNodeServices()
+	}
+	return conn.nodeServices, conn.nodeServicesErr
+}
+
+// a client for each node running the given service
+func (conn *ClusterConn) ServiceClients(service string) ([]*RestClient, error) {
+	nodeServices, err := conn.NodeServices()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Client.serviceClients(nodeServices, service), nil
+}
+
+// whether any of the optional sections were asked for
+func (o CollectOptions) anyExtras() bool {
+	return o.QueryStats || o.ServiceUsage || o.EventingStats || len(o.BucketStats) > 0 ||
+		o.XDCRStats || o.Hardware || o.Security || o.NodeRTT || o.Tasks
+}
+
+type Collector struct {
+	options CollectOptions
+
+	// called as each cluster is started and finished, if set
+	progress func(progress CollectProgress)
+
+	// where finished clusters are recorded, if set
+	checkpoint *Checkpoint
+
+	// the nodes each cluster was last seen with
+	nodeCache *NodeCache
+
+	// where each cluster is written as soon as it's in, if set
+	stream *ReportStream
+}
+
+func NewCollector(options CollectOptions) *Collector {
+	return &Collector{options: options, nodeCache: NewNodeCache()}
+}
+
+// how far a collection has got
+type CollectProgress struct {
+	Total     int
+	InFlight  int
+	Collected int
+	Failed    int
+}
+
+func (p CollectProgress) Done() int {
+	return p.Collected + p.Failed
+}
+
+// a copy of the collector that reports its progress to the given function
+func (c *Collector) WithProgress(progress func(progress CollectProgress)) *Collector {
+	copy := *c
+	copy.progress = progress
+	return &copy
+}
+
+// a copy of the collector that remembers the clusters' nodes in the given cache
+func (c *Collector) WithNodeCache(cache *NodeCache) *Collector {
+	copy := *c
+	copy.nodeCache = cache
+	return &copy
+}
+
+// a copy of the collector that records each cluster in the checkpoint, and
+// reuses the results already there
+func (c *Collector) WithCheckpoint(checkpoint *Checkpoint) *Collector {
+	copy := *c
+	copy.checkpoint = checkpoint
+	return &copy
+}
+
+// a copy of the collector that writes each cluster to the stream, in config
+// order, as soon as it and those before it are collected, and then drops it from
+// the summary; the stream is closed with the summary that's returned. It can't
+// be used with a Redactor, which needs the whole summary.
+func (c *Collector) WithStream(stream *ReportStream) *Collector {
+	copy := *c
+	copy.stream = stream
+	return &copy
+}
+
+// connect to each of the clusters and build the summary report
+func (c *Collector) Collect(clusters *ClusterList) *SummaryInfo {
+	return c.CollectContext(context.Background(), clusters)
+}
+
+// as Collect, abandoning any clusters not yet collected when ctx is done, which
+// are reported as interrupted
+func (c *Collector) CollectContext(ctx context.Context, clusters *ClusterList) *SummaryInfo {
+	start := time.Now()
+	if c.options.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.Deadline)
+		defer cancel()
+	}
+
+	clusterSummary := new(SummaryInfo)
+	clusterSummary.NumClusters = len(clusters.Clusters)
+	clusterSummary.TotalNumNodes = 0
+	clusterSummary.NodeVersions = make(map[string]int)
+	clusterSummary.Clusters = make([]interface{}, len(clusters.Clusters))
+	clusterSummary.NodeMembership = NewMembershipRollup()
+	if hasEnvironments(clusters.Clusters) {
+		clusterSummary.Environments = NewEnvironmentRollup()
+	}
+	if len(c.options.LicenseModel) > 0 {
+		clusterSummary.License = NewLicenseSummary(c.options.LicenseModel)
+	}
+	if c.options.ConsumptionUnits {
+		clusterSummary.ConsumptionUnits = NewConsumptionUnitReport()
+	}
+	if c.options.CapellaSizing {
+		clusterSummary.CapellaSizing = NewCapellaSizingReport()
+	}
+	if len(c.options.DriftLabel) > 0 {
+		clusterSummary.Drift = NewDriftReport(c.options.DriftLabel)
+	}
+
+	// the config's transport settings with the options' overrides, for this
+	// collection only, as a Collector may be collecting several configs at once
+	transport := c.options.Transport
+	if clusters.Transport != nil {
+		transport = clusters.Transport.Merge(c.options.Transport)
+	}
+
+	if c.checkpoint != nil {
+		if err := c.checkpoint.begin(); err != nil {
+			LogWarn("%v, continuing without it", err)
+		}
+		defer c.checkpoint.close()
+	}
+
+	// collect up to MaxConcurrency clusters at a time, then add the results
+	// to the summary in config order once they're all in
+	concurrency := c.options.MaxConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]*clusterResult, len(clusters.Clusters))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	progress := CollectProgress{Total: len(clusters.Clusters)}
+	update := func(change func()) {
+		mu.Lock()
+		change()
+		if c.progress != nil {
+			c.progress(progress)
+		}
+		mu.Unlock()
+	}
+
+	// add the results to the summary in config order, as far as they're in;
+	// with a stream, that's as they come in, otherwise once they all have
+	added := 0
+	addReady := func() {
+		for added < len(results) && results[added] != nil {
+			c.addResult(clusterSummary, results[added])
+			c.streamResult(clusterSummary, results[added])
+			added++
+		}
+	}
+
+	for cnum, cluster := range clusters.Clusters {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(cnum int, cluster Cluster) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			result, checkpointed := c.checkpoint.result(cnum)
+			if !checkpointed {
+				update(func() { progress.InFlight++ })
+				result = c.collectCluster(ctx, cnum, cluster, transport)
+				// anything cut short by an interrupt is left for a resumed run to collect
+				if ctx.Err() == nil {
+					if err := c.checkpoint.save(result); err != nil {
+						LogError("%v", err)
+					}
+				}
+			}
+			update(func() {
+				results[cnum] = result
+				if c.stream != nil {
+					addReady()
+				}
+				if !checkpointed {
+					progress.InFlight--
+				}
+				if result.Error != nil {
+					progress.Failed++
+				} else {
+					progress.Collected++
+				}
+			})
+		}(cnum, cluster)
+	}
+	wg.Wait()
+
+	// Capella clusters follow the configured ones
+	if capella := c.collectCapella(ctx, clusters.Capella, len(results), transport); len(capella) > 0 {
+		results = append(results, capella...)
+		clusterSummary.NumClusters = len(results)
+		clusterSummary.Clusters = append(clusterSummary.Clusters, make([]interface{}, len(capella))...)
+	}
+
+	addReady()
+
+	if clusterSummary.Drift != nil {
+		clusterSummary.Drift.Analyze()
+	}
+	if clusterSummary.License != nil && c.options.Entitlement != nil {
+		clusterSummary.License.CheckEntitlement(c.options.Entitlement)
+	}
+
+	if err := c.nodeCache.Save(); err != nil {
+		LogError("%v", err)
+	}
+
+	// warn if any cluster is newer than this tool knows about
+	clusterSummary.Metadata = newReportMetadata()
+	clusterSummary.Metadata.CollectionDuration = Duration(time.Since(start).Round(time.Millisecond))
+	clusterSummary.Metadata.ConfigHash = clusters.configHash
+	clusterSummary.Metadata.HighestServerVersion = HighestVersion(clusterSummary.NodeVersions)
+	if advisory := VersionAdvisory(clusterSummary.Metadata.HighestServerVersion); len(advisory) > 0 {
+		LogWarn("Warning: %s", advisory)
+		clusterSummary.Metadata.Advisories = append(clusterSummary.Metadata.Advisories, advisory)
+	}
+	if interrupted, timedOut := countUncollected(results); interrupted > 0 {
+		advisory := fmt.Sprintf("The run was interrupted, so this report is partial: %d of %d clusters were not collected.",
+			interrupted, len(results))
+		LogWarn("%s", advisory)
+		clusterSummary.Metadata.Interrupted = true
+		clusterSummary.Metadata.Advisories = append(clusterSummary.Metadata.Advisories, advisory)
+	} else if timedOut > 0 {
+		advisory := fmt.Sprintf("The run's deadline of %v passed, so this report is partial: %d of %d clusters timed out.",
+			c.options.Deadline, timedOut, len(results))
+		LogWarn("%s", advisory)
+		clusterSummary.Metadata.TimedOut = true
+		clusterSummary.Metadata.Advisories = append(clusterSummary.Metadata.Advisories, advisory)
+	}
+
+	if c.options.Redactor != nil {
+		redacted, err := c.options.Redactor.Redact(clusterSummary)
+		if err != nil {
+			LogError("%v", err)
+		}
+		if redacted == nil {
+			// never pass on what couldn't be redacted
+			metadata := newReportMetadata()
+			metadata.Advisories = []string{fmt.Sprintf("The report could not be redacted: %v", err)}
+			return &SummaryInfo{Clusters: make([]interface{}, 0), Metadata: metadata}
+		}
+		clusterSummary = redacted
+	}
+
+	return clusterSummary
+}
+
+// gather the optional sections that were asked for, unless the cluster is
+// busy. Failures are recorded in the sections themselves rather than failing
+// the whole cluster.
+func (c *Collector) collectExtras(conn *ClusterConn, busy string) ClusterExtras {
+	var extras ClusterExtras
+
+	if !c.options.anyExtras() {
+		return extras
+	}
+	// a single request, and what shows the cluster is busy, so never held off
+	if c.options.Tasks {
+		extras.Tasks = CollectClusterTasks(conn)
+	}
+	if len(busy) > 0 {
+		extras.SkippedBusy = busy
+		return extras
+	}
+
+	// measure the round trips first, before the other requests add load
+	if c.options.NodeRTT {
+		extras.ManagementLatency = MeasureManagementLatency(conn)
+	}
+	if c.options.QueryStats {
+		extras.QueryStats = CollectQueryStats(conn)
+	}
+	if c.options.ServiceUsage {
+		extras.IndexUsage = CollectIndexUsage(conn)
+		extras.SearchUsage = CollectSearchUsage(conn)
+		extras.AnalyticsUsage = CollectAnalyticsUsage(conn)
+		extras.EventingUsage = CollectEventingUsage(conn)
+	}
+	if c.options.EventingStats {
+		extras.EventingStats = CollectEventingStats(conn)
+	}
+	if len(c.options.BucketStats) > 0 {
+		extras.BucketStats = CollectBucketStats(conn, c.options.BucketStats)
+	}
+	if c.options.Hardware {
+		extras.Hardware = CollectHardwareInventory(conn)
+	}
+	if c.options.XDCRStats {
+		extras.XDCRStats = CollectXDCRStats(conn, c.options.XDCRLagThreshold)
+	}
+	if c.options.Security {
+		extras.Security = CollectSecurityPosture(conn)
+		extras.RBACUsers = CollectRBACSummary(conn)
+	}
+
+	return extras
+}
+
+// record how long the cluster took to collect, if round trips are being measured
+func (extras *ClusterExtras) setCollectTime(elapsed time.Duration) {
+	if extras.ManagementLatency != nil {
+		extras.ManagementLatency.CollectSeconds = elapsed.Seconds()
+	}
+}
+
+// record the host cores alongside the effective (cgroup) limit, when the
+// server reports both and they differ
+func setContainerLimits(node *BriefNode, nodeInfo NodeInfo) {
+	if nodeInfo.CpuCount > 0 && node.Cores > 0 && node.Cores < nodeInfo.CpuCount {
+		node.HostCores = nodeInfo.CpuCount
+		node.CPULimited = true
+	}
+
+	memLimit := nodeInfo.SystemStats.Mem_limit
+	if memLimit > 0 && memLimit < nodeInfo.MemoryTotal {
+		node.RAMLimit = memLimit / 1024.0 / 1024.0 / 1024.0
+	}
+}
+
+// what was collected from one cluster: exactly one of Brief, Full and Error
+// is set. The node details are kept for the analyses done across clusters.
+type clusterResult struct {
+	Num   int             `json:"cluster_num"`
+	Brief *BriefCluster   `json:"brief,omitempty"`
+	Full  *ClusterSummary `json:"full,omitempty"`
+	Error *ClusterError   `json:"error,omitempty"`
+
+	UUID          string            `json:"uuid,omitempty"`
+	Nodes         []NodeInfo        `json:"nodes,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	DriftSettings map[string]string `json:"drift_settings,omitempty"`
+	DriftBusy     bool              `json:"drift_busy,omitempty"`
+}
+
+// the number of clusters whose collection was interrupted, and that timed out
+// at the run's deadline
+func countUncollected(results []*clusterResult) (int, int) {
+	interrupted, timedOut := 0, 0
+	for _, result := range results {
+		if result.Error != nil && result.Error.Interrupted {
+			interrupted++
+		}
+		if result.Error != nil && result.Error.TimedOut {
+			timedOut++
+		}
+	}
+	return interrupted, timedOut
+}
+
+// record a cluster's result in the summary
+func (c *Collector) addResult(clusterSummary *SummaryInfo, result *clusterResult) {
+	cnum := result.Num
+	switch {
+	case result.Full != nil:
+		clusterSummary.Clusters[cnum] = result.Full
+	case result.Brief != nil:
+		clusterSummary.Clusters[cnum] = result.Brief
+	default:
+		clusterSummary.Clusters[cnum] = result.Error
+		if clusterSummary.Environments != nil {
+			clusterSummary.Environments.AddFailed(cnum, result.Error.TheCluster.Environment)
+		}
+		return
+	}
+
+	// for each of the nodes in this cluster, show the distribution of versions
+	for _, nodeInfo := range result.Nodes {
+		clusterSummary.NodeVersions[nodeInfo.Version] = clusterSummary.NodeVersions[nodeInfo.Version] + 1
+	}
+	clusterSummary.TotalNumNodes = clusterSummary.TotalNumNodes + len(result.Nodes)
+
+	if clusterSummary.NodeMembership != nil {
+		clusterSummary.NodeMembership.AddCluster(cnum, clusterMembershipAnomalies(clusterSummary.Clusters[cnum]))
+	}
+	if clusterSummary.Environments != nil {
+		clusterSummary.Environments.AddCluster(cnum, clusterEnvironment(clusterSummary.Clusters[cnum]), result.Nodes)
+	}
+	if clusterSummary.License != nil {
+		clusterSummary.License.AddCluster(cnum, result.UUID, result.Nodes)
+	}
+	if clusterSummary.ConsumptionUnits != nil {
+		clusterSummary.ConsumptionUnits.AddCluster(cnum, result.UUID, result.Nodes)
+	}
+	if clusterSummary.CapellaSizing != nil {
+		clusterSummary.CapellaSizing.AddCluster(cnum, result.UUID, result.Nodes)
+	}
+	if result.Full != nil && result.Full.Alerts != nil && len(result.Full.Alerts.Error) == 0 &&
+		!result.Full.Alerts.Configured {
+		clusterSummary.NoAlerting = append(clusterSummary.NoAlerting, cnum)
+	}
+	if clusterSummary.Drift != nil && result.DriftSettings != nil {
+		clusterSummary.Drift.AddCluster(cnum, result.UUID, result.Labels, result.DriftSettings)
+	}
+	if clusterSummary.Drift != nil && result.DriftBusy {
+		clusterSummary.Drift.AddSkippedBusy(cnum)
+	}
+}
+
+// write a result added to the summary to the stream, if there is one, and
+// let go of it
+func (c *Collector) streamResult(clusterSummary *SummaryInfo, result *clusterResult) {
+	if c.stream == nil {
+		return
+	}
+	err := c.stream.WriteCluster(clusterSummary.Clusters[result.Num])
+	if err != nil && c.stream.count == result.Num {
+		LogError("%v", err)
+	}
+	// errors are kept, being small and needed for the exit status
+	if _, ok := clusterSummary.Clusters[result.Num].(*ClusterError); !ok {
+		clusterSummary.Clusters[result.Num] = nil
+	}
+	result.Full = nil
+	result.Brief = nil
+}
+
+// try the cluster's nodes until one of them gives us the cluster information
+func (c *Collector) collectCluster(ctx context.Context, cnum int, cluster Cluster, transport TransportOptions) *clusterResult {
+	result := &clusterResult{Num: cnum, Labels: cluster.Labels}
+	var cerr error
+	start := time.Now()
+	LogVerbose("Collecting cluster %d from %s", cnum, strings.Join(cluster.Nodes, ", "))
+
+	runCtx := ctx
+	if c.options.ClusterTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.ClusterTimeout)
+		defer cancel()
+	}
+
+	tlsConfig, err := ClusterTLSConfig(c.options.TLSConfig, cluster)
+	if err != nil {
+		LogError("%v", err)
+		cerr = err
+	} else {
+		// try all the nodes we know of at once, and carry on with the first to answer
+		conn, err := connectFirst(ctx, c.nodeCache.Candidates(cluster), func(node string) *RestClient {
+			client := CreateRestClient(node, cluster.Login, cluster.Pass, tlsConfig, transport)
+			client.SetHeaders(cluster.Headers)
+			return client
+		})
+		if err != nil {
+			cerr = err
+		} else {
+			c.collectFrom(cluster, conn, result, start)
+		}
+	}
+
+	// if we get this far with no result, we need to replace it with a
+	// different item indicating the error.
+
+	if result.Full == nil && result.Brief == nil {
+		errorStatus := new(ClusterError)
+		if runCtx.Err() == context.DeadlineExceeded {
+			cerr = fmt.Errorf("timed out: the run's deadline passed before the cluster was collected")
+			errorStatus.TimedOut = true
+		} else if c.options.ClusterTimeout > 0 && ctx.Err() == context.DeadlineExceeded {
+			cerr = fmt.Errorf("cluster not collected within --cluster-timeout of %v", c.options.ClusterTimeout)
+		} else if ctx.Err() == context.Canceled {
+			cerr = fmt.Errorf("interrupted before the cluster was collected")
+			errorStatus.Interrupted = true
+		}
+		errorStatus.TheCluster = cluster.asConfigured()
+		if cerr != nil {
+			errorStatus.ErrMsg = cerr.Error()
+		} else {
+			errorStatus.ErrMsg = "Unknown Error"
+		}
+		result.Error = errorStatus
+		LogVerbose("Cluster %d could not be collected after %v: %s", cnum, time.Since(start).Round(time.Millisecond),
+			errorStatus.ErrMsg)
+	} else {
+		LogVerbose("Collected cluster %d in %v", cnum, time.Since(start).Round(time.Millisecond))
+	}
+	return result
+}
+
+// fill in the result from the node that answered
+func (c *Collector) collectFrom(cluster Cluster, node *nodeConn, result *clusterResult, start time.Time) {
+	client, pools, poolsDefaults := node.client, node.pools, node.poolsDefault
+	c.nodeCache.Update(cluster, node.node, poolsDefaults.Nodes)
+	fillNodeCores(client, poolsDefaults.Nodes)
+	conn := &ClusterConn{Client: client, Pools: pools, PoolsDefault: poolsDefaults}
+
+	// checked once, before any of the heavier requests, so that --wait-busy
+	// waits before the cluster is asked for anything more
+	_, busy := c.holdOffBusy(client)
+
+	// full report? get all details
+
+	if c.options.Full {
+		thisCluster := new(ClusterSummary)
+		thisCluster.ImplementationVersion = pools.ImplementationVersion
+		thisCluster.IsEnterprise = pools.IsEnterprise
+		thisCluster.Uuid = pools.Uuid
+
+		thisCluster.Balanced = poolsDefaults.Balanced
+		thisCluster.ClusterName = poolsDefaults.ClusterName
+		thisCluster.FtsMemoryQuota = poolsDefaults.FtsMemoryQuota
+		thisCluster.IndexMemoryQuota = poolsDefaults.IndexMemoryQuota
+		thisCluster.CbasMemoryQuota = poolsDefaults.CbasMemoryQuota
+		thisCluster.EventingMemoryQuota = poolsDefaults.EventingMemoryQuota
+		thisCluster.MemoryQuota = poolsDefaults.MemoryQuota
+		thisCluster.Name = poolsDefaults.Name
+		thisCluster.NodeCount = len(poolsDefaults.Nodes)
+		thisCluster.Nodes = poolsDefaults.Nodes
+		thisCluster.RebalanceStatus = poolsDefaults.RebalanceStatus
+		thisCluster.StorageTotals = poolsDefaults.StorageTotals
+		thisCluster.ClusterExtras = c.collectExtras(conn, busy)
+		if thisCluster.Tasks == nil {
+			thisCluster.Tasks = CollectClusterTasks(conn)
+		}
+		if len(busy) > 0 {
+			thisCluster.SkippedBusy = busy
+		} else {
+			c.collectFullSections(conn, thisCluster)
+		}
+		thisCluster.Membership = FindMembershipAnomalies(poolsDefaults.Nodes, thisCluster.Events)
+		thisCluster.ClusterExtras.setCollectTime(time.Since(start))
+
+		// for each of the nodes in this cluster, show the distribution of versions
+		nodeVersions := make(map[string]int)
+		for _, nodeInfo := range poolsDefaults.Nodes {
+			nodeVersions[nodeInfo.Version] = nodeVersions[nodeInfo.Version] + 1
+		}
+		thisCluster.NodeVersions = nodeVersions
+
+		thisCluster.ClusterIdentity = cluster.identity()
+		result.Full = thisCluster
+
+	} else {
+		// for a partial report, get the cluster_size, uuid, and an array of nodes with:
+		// - cpu cores
+		// - hostname
+		// - memory limit
+
+		briefCluster := new(BriefCluster)
+
+		nodes := make([]BriefNode, len(poolsDefaults.Nodes))
+		curNode := 0
+		for _, nodeInfo := range poolsDefaults.Nodes {
+			node := new(BriefNode)
+			node.Cores = nodeInfo.SystemStats.CPU_cores_available
+			node.RAM = nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+			node.Name = nodeInfo.Hostname
+			node.Version = nodeInfo.Version
+			setContainerLimits(node, nodeInfo)
+			nodes[curNode] = *node
+			curNode = curNode + 1
+		}
+
+		briefCluster.Nodes = nodes
+		briefCluster.Size = len(nodes)
+		briefCluster.UUID = pools.Uuid
+		briefCluster.Membership = FindMembershipAnomalies(poolsDefaults.Nodes, nil)
+		briefCluster.ClusterExtras = c.collectExtras(conn, busy)
+		briefCluster.ClusterExtras.setCollectTime(time.Since(start))
+
+		briefCluster.ClusterIdentity = cluster.identity()
+		result.Brief = briefCluster
+	}
+
+	result.UUID = pools.Uuid
+	result.Nodes = poolsDefaults.Nodes
+	if len(c.options.DriftLabel) > 0 && len(cluster.Labels[c.options.DriftLabel]) > 0 {
+		// the settings are several more requests, held off like the rest
+		if len(busy) == 0 {
+			result.DriftSettings = CollectDriftSettings(client, poolsDefaults)
+		} else {
+			result.DriftBusy = true
+		}
+	}
+}
+
+// the sections of a full report beyond the basic cluster and node details,
+// leaving out any already collected as extras
+func (c *Collector) collectFullSections(conn *ClusterConn, thisCluster *ClusterSummary) {
+	nodes := conn.PoolsDefault.Nodes
+
+	thisCluster.Buckets = CollectBucketInventory(conn, c.options.MaxCollectionNames)
+	usage := make(map[string]*ServiceUsage)
+	if runsService(nodes, "index") {
+		thisCluster.Indexes = CollectIndexSummary(conn)
+		usage["index"] = CollectIndexUsage(conn)
+	}
+	if runsService(nodes, "fts") {
+		thisCluster.SearchIndexes = CollectSearchSummary(conn)
+		usage["fts"] = thisCluster.SearchIndexes.usage
+	}
+	if runsService(nodes, "cbas") {
+		thisCluster.Analytics = CollectAnalyticsSummary(conn)
+		usage["cbas"] = thisCluster.Analytics.usage
+	}
+	if runsService(nodes, "eventing") {
+		thisCluster.EventingFunctions = CollectEventingFunctions(conn)
+		usage["eventing"] = CollectEventingUsage(conn)
+	}
+	thisCluster.ServiceLayout = SummarizeServiceLayout(conn.PoolsDefault, usage)
+	thisCluster.XDCRTopology = CollectXDCRTopology(conn)
+	thisCluster.ServerGroups = CollectServerGroups(conn)
+	thisCluster.Alerts = CollectAlertSettings(conn)
+	thisCluster.Certificates = CollectCertificates(conn, c.options.CertWarnDays)
+	if c.options.Events > 0 {
+		thisCluster.Events = CollectClusterEvents(conn, c.options.Events)
+	}
+	if thisCluster.QueryStats == nil && runsService(nodes, "n1ql") {
+		thisCluster.QueryStats = CollectQueryStats(conn)
+	}
+	if thisCluster.Security == nil {
+		thisCluster.Security = CollectSecurityPosture(conn)
+		thisCluster.RBACUsers = CollectRBACSummary(conn)
+	}
+	thisCluster.Info = &ClusterInfo{
+		AdminAuditEnabled: thisCluster.Security.AuditEnabled,
+		AdminLDAPEnabled:  thisCluster.Security.LDAPEnabled(),
+		Buckets:           thisCluster.Buckets.Counts,
+		Cluster_Settings:  CollectClusterSettings(conn),
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// collector tests, replaying the responses of a two-node 7.2.4 cluster saved
+// in testdata/raw with 'cbsummary collect --raw-dir', as 'cbsummary report
+// --from-raw' does
+//
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+const TEST_RAW_DIR = "testdata/raw"
+
+// collect the clusters saved in testdata/raw
+func collectRaw(t *testing.T, options CollectOptions) *SummaryInfo {
+	t.Helper()
+	store, err := OpenRawSnapshots(filepath.FromSlash(TEST_RAW_DIR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := store.LoadClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Transport.Raw = store
+	return NewCollector(options).Collect(clusters)
+}
+
+func TestCollectBriefFromRaw(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+
+	if summary.NumClusters != 1 || summary.TotalNumNodes != 2 {
+		t.Fatalf("got %d clusters and %d nodes, want 1 and 2", summary.NumClusters, summary.TotalNumNodes)
+	}
+	if summary.NodeVersions["7.2.4-7070-enterprise"] != 2 {
+		t.Errorf("node versions %v, want 2 nodes of 7.2.4-7070-enterprise", summary.NodeVersions)
+	}
+	cluster, ok := summary.Clusters[0].(*BriefCluster)
+	if !ok {
+		t.Fatalf("cluster 0 is %T, want *BriefCluster", summary.Clusters[0])
+	}
+	if cluster.UUID != "uuid-18091" || cluster.Size != 2 || cluster.Label != "prod-eu" {
+		t.Errorf("cluster %s of size %d labeled %q, want uuid-18091 of size 2 labeled prod-eu",
+			cluster.UUID, cluster.Size, cluster.Label)
+	}
+
+	want := []BriefNode{
+		{Cores: 4, RAM: 16, Name: "127.0.0.1:18091"},
+		{Cores: 8, RAM: 32, Name: "node2:18091"},
+	}
+	for i, node := range cluster.Nodes {
+		w := want[i]
+		if node.Name != w.Name || node.Cores != w.Cores || node.RAM != w.RAM {
+			t.Errorf("node %d is %+v, want %+v", i, node, w)
+		}
+	}
+}
+
+// both nodes run in containers limited to half their hosts' CPUs
+func TestCollectCoresFromRaw(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	cluster := summary.Clusters[0].(*BriefCluster)
+
+	want := []BriefNode{
+		{Cores: 4, HostCores: 8, CPULimited: true},
+		{Cores: 8, HostCores: 16, CPULimited: true},
+	}
+	for i, node := range cluster.Nodes {
+		w := want[i]
+		if node.Cores != w.Cores || node.HostCores != w.HostCores || node.CPULimited != w.CPULimited {
+			t.Errorf("node %d has %.0f of %.0f host cores, limited %v, want %.0f of %.0f, limited %v", i, node.Cores,
+				node.HostCores, node.CPULimited, w.Cores, w.HostCores, w.CPULimited)
+		}
+	}
+}
+
+func TestCollectFullFromRaw(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{Full: true})
+
+	cluster, ok := summary.Clusters[0].(*ClusterSummary)
+	if !ok {
+		t.Fatalf("cluster 0 is %T, want *ClusterSummary", summary.Clusters[0])
+	}
+	if cluster.Uuid != "uuid-18091" || cluster.ClusterName != "c18091" || len(cluster.Nodes) != 2 {
+		t.Errorf("cluster %s named %s with %d nodes, want uuid-18091 named c18091 with 2 nodes",
+			cluster.Uuid, cluster.ClusterName, len(cluster.Nodes))
+	}
+	if cluster.Buckets == nil || len(cluster.Buckets.Buckets) != 2 {
+		t.Errorf("buckets %+v, want 2", cluster.Buckets)
+	}
+	if cluster.CbasMemoryQuota == 0 || cluster.EventingMemoryQuota == 0 {
+		t.Errorf("analytics quota %d and eventing quota %d, want both set", cluster.CbasMemoryQuota,
+			cluster.EventingMemoryQuota)
+	}
+}
+
+func TestCollectEnvironmentsFromRaw(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+
+	if summary.Environments == nil {
+		t.Fatal("no environment rollups")
+	}
+	prod, ok := summary.Environments.Environments["prod"]
+	if !ok {
+		t.Fatalf("environments %v, want prod", summary.Environments.Names())
+	}
+	if len(prod.Clusters) != 1 || prod.Nodes != 2 || prod.Cores != 12 || prod.RAM != 48 {
+		t.Errorf("prod has %d clusters, %d nodes, %.0f cores and %.0f GB, want 1, 2, 12 and 48",
+			len(prod.Clusters), prod.Nodes, prod.Cores, prod.RAM)
+	}
+	if summary.Environments.Total.Nodes != 2 {
+		t.Errorf("total of %d nodes, want 2", summary.Environments.Total.Nodes)
+	}
+}
+
+func TestCollectMissingResponseFromRaw(t *testing.T) {
+	store, err := OpenRawSnapshots(filepath.FromSlash(TEST_RAW_DIR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := &ClusterList{Clusters: []Cluster{{Login: "a", Nodes: []string{"http://10.0.0.1:8091"},
+		Headers: map[string]string{"X-Proxy-Token": "secret"}}}}
+	summary := NewCollector(CollectOptions{Transport: TransportOptions{Raw: store}}).Collect(clusters)
+
+	errorStatus, ok := summary.Clusters[0].(*ClusterError)
+	if !ok {
+		t.Fatalf("cluster 0 is %T, want *ClusterError for a node with no saved responses", summary.Clusters[0])
+	}
+	if errorStatus.TheCluster.Headers != nil {
+		t.Errorf("error entry has the cluster's headers %v", errorStatus.TheCluster.Headers)
+	}
+}
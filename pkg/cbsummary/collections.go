@@ -0,0 +1,95 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// scopes and collections of each bucket, from the collections manifest at
+// /pools/default/buckets/<bucket>/scopes
+//
+// Collections only exist once every node is on server 7.0 or later, so the
+// manifest isn't asked for on earlier or mixed-version clusters. Buckets can
+// have a great many collections; with --max-collection-names only that many
+// names are listed for each bucket, though all of them are counted.
+//
+
+import (
+	"net/url"
+)
+
+const COLLECTIONS_MIN_VERSION = "7.0"
+
+type BucketCollections struct {
+	Scopes      int      `json:"scopes"`
+	Collections int      `json:"collections"`
+	Names       []string `json:"names"`
+	Truncated   bool     `json:"truncated,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// types for parsing JSON from /pools/default/buckets/<bucket>/scopes
+
+type CollectionsManifest struct {
+	UID    string `json:"uid"`
+	Scopes []struct {
+		Name        string `json:"name"`
+		Collections []struct {
+			Name string `json:"name"`
+		} `json:"collections"`
+	} `json:"scopes"`
+}
+
+// the bucket's collections manifest
+func (r *RestClient) GetCollectionsManifest(bucket string) (*CollectionsManifest, error) {
+	var data CollectionsManifest
+	err := r.getJSON("/pools/default/buckets/"+url.PathEscape(bucket)+"/scopes", &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// whether every node of the cluster supports collections
+func supportsCollections(nodes []NodeInfo) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	for _, nodeInfo := range nodes {
+		if CompareVersions(nodeInfo.Version, COLLECTIONS_MIN_VERSION) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// count the scopes and collections of a bucket, listing up to maxNames of
+// them as scope.collection, or all of them if maxNames is 0
+func collectBucketCollections(client *RestClient, bucket string, maxNames int) *BucketCollections {
+	collections := &BucketCollections{Names: make([]string, 0)}
+
+	manifest, err := client.GetCollectionsManifest(bucket)
+	if err != nil {
+		collections.Error = err.Error()
+		return collections
+	}
+
+	for _, scope := range manifest.Scopes {
+		collections.Scopes++
+		for _, collection := range scope.Collections {
+			collections.Collections++
+			if maxNames > 0 && len(collections.Names) >= maxNames {
+				collections.Truncated = true
+				continue
+			}
+			collections.Names = append(collections.Names, scope.Name+"."+collection.Name)
+		}
+	}
+	return collections
+}
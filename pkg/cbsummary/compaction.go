@@ -0,0 +1,70 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// auto-compaction thresholds, cluster-wide from /settings/autoCompaction and
+// for the buckets which override them, from the bucket API
+//
+// Buckets using the cluster-wide settings report "autoCompactionSettings":
+// false. Thresholds which aren't set are reported by the server as
+// "undefined", and are left out here.
+//
+
+type CompactionSettings struct {
+	DatabaseFragmentation   float64 `json:"database_fragmentation_pct,omitempty"`
+	DatabaseFragmentationMB float64 `json:"database_fragmentation_mb,omitempty"`
+	ViewFragmentation       float64 `json:"view_fragmentation_pct,omitempty"`
+	ViewFragmentationMB     float64 `json:"view_fragmentation_mb,omitempty"`
+	MagmaFragmentation      float64 `json:"magma_fragmentation_pct,omitempty"`
+	ParallelDBAndView       bool    `json:"parallel_db_and_view"`
+	PurgeIntervalDays       float64 `json:"purge_interval_days,omitempty"`
+}
+
+// the cluster-wide auto-compaction settings
+func (r *RestClient) GetCompactionSettings() (*CompactionSettings, error) {
+	var data struct {
+		Settings      map[string]interface{} `json:"autoCompactionSettings"`
+		PurgeInterval interface{}            `json:"purgeInterval"`
+	}
+	err := r.getJSON("/settings/autoCompaction", &data)
+	if err != nil {
+		return nil, err
+	}
+	return parseCompactionSettings(data.Settings, data.PurgeInterval), nil
+}
+
+// the settings a bucket overrides the cluster-wide ones with, or nil if it
+// doesn't
+func bucketCompactionSettings(bucket BucketInfo) *CompactionSettings {
+	settings, ok := bucket.AutoCompactionSettings.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return parseCompactionSettings(settings, bucket.PurgeInterval)
+}
+
+func parseCompactionSettings(settings map[string]interface{}, purgeInterval interface{}) *CompactionSettings {
+	compaction := &CompactionSettings{PurgeIntervalDays: toFloat(purgeInterval)}
+
+	if threshold, ok := settings["databaseFragmentationThreshold"].(map[string]interface{}); ok {
+		compaction.DatabaseFragmentation = toFloat(threshold["percentage"])
+		compaction.DatabaseFragmentationMB = toFloat(threshold["size"]) / 1024 / 1024
+	}
+	if threshold, ok := settings["viewFragmentationThreshold"].(map[string]interface{}); ok {
+		compaction.ViewFragmentation = toFloat(threshold["percentage"])
+		compaction.ViewFragmentationMB = toFloat(threshold["size"]) / 1024 / 1024
+	}
+	compaction.MagmaFragmentation = toFloat(settings["magmaFragmentationPercentage"])
+	compaction.ParallelDBAndView, _ = settings["parallelDBAndViewCompaction"].(bool)
+
+	return compaction
+}
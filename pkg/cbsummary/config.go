@@ -0,0 +1,216 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// loading the config file listing the clusters to summarize
+//
+// The config is JSON, or YAML if the file is named *.yaml or *.yml or
+// --config-format=yaml is given. YAML configs use the same keys as JSON ones,
+// e.g.
+//
+//   clusters:
+//     - login: Administrator
+//       pass: $PROD_PASSWORD   # from the environment
+//       nodes: [http://192.168.1.1:8091]
+//       labels: {env: prod}
+//
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_FORMAT_JSON = "json"
+	CONFIG_FORMAT_YAML = "yaml"
+)
+
+type ConfigOptions struct {
+	// json or yaml; empty to go by the file name
+	Format string
+
+	// for decrypting an encrypted config
+	Key *ConfigKey
+}
+
+// the format of a config file, from the name unless one is given
+func ConfigFormat(configFile, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case CONFIG_FORMAT_JSON:
+		return CONFIG_FORMAT_JSON, nil
+	case CONFIG_FORMAT_YAML, "yml":
+		return CONFIG_FORMAT_YAML, nil
+	case "", "auto":
+		switch strings.ToLower(filepath.Ext(configFile)) {
+		case ".yaml", ".yml":
+			return CONFIG_FORMAT_YAML, nil
+		}
+		return CONFIG_FORMAT_JSON, nil
+	}
+	return "", fmt.Errorf("unknown config format '%s', expected json or yaml", format)
+}
+
+// load the config file, decrypting it if it's encrypted
+func LoadConfig(configFile string, options ConfigOptions) (*ClusterList, error) {
+	format, err := ConfigFormat(configFile, options.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading configuration file %s: %s", configFile, err)
+	}
+	hash := sha256.Sum256(config)
+
+	if IsEncryptedConfig(config) {
+		config, err = DecryptConfig(config, options.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error decrypting configuration file %s: %s", configFile, err)
+		}
+	}
+
+	clusters, err := ParseConfig(config, format)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing configuration file %s: %s", configFile, err)
+	}
+	clusters.configHash = fmt.Sprintf("sha256:%x", hash)
+
+	if clusters.Transport != nil {
+		_, err = NewRetryPolicy(*clusters.Transport)
+		if err != nil {
+			return nil, fmt.Errorf("Error in configuration file %s: transport: %s", configFile, err)
+		}
+	}
+
+	err = resolveConnectionStrings(clusters)
+	if err != nil {
+		return nil, fmt.Errorf("Error in configuration file %s: %s", configFile, err)
+	}
+
+	err = expandCredentials(clusters)
+	if err != nil {
+		return nil, fmt.Errorf("Error in configuration file %s: %s", configFile, err)
+	}
+
+	return clusters, nil
+}
+
+// replace any connection strings in the clusters' nodes with the REST
+// endpoints they stand for
+func resolveConnectionStrings(clusters *ClusterList) error {
+	for i := range clusters.Clusters {
+		cluster := &clusters.Clusters[i]
+		nodes := make([]string, 0, len(cluster.Nodes))
+		for _, node := range cluster.Nodes {
+			if !isConnectionString(node) {
+				nodes = append(nodes, node)
+				continue
+			}
+			resolved, err := ConnectionStringNodes(node)
+			if err != nil {
+				return fmt.Errorf("cluster %d: %v", i+1, err)
+			}
+			for _, r := range resolved {
+				if !containsString(nodes, r) {
+					nodes = append(nodes, r)
+				}
+			}
+		}
+		cluster.Nodes = nodes
+	}
+	return nil
+}
+
+// a config for the one cluster given on the command line, asking for the
+// password if there isn't one
+func SingleClusterConfig(connstr, login, pass string) (*ClusterList, error) {
+	nodes, err := ConnectionStringNodes(connstr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --cluster: %v", err)
+	}
+	cluster := Cluster{Login: login, Pass: pass, Nodes: nodes, Prompt: len(pass) == 0}
+	return &ClusterList{Clusters: []Cluster{cluster}}, nil
+}
+
+func ParseConfig(config []byte, format string) (*ClusterList, error) {
+	if format == CONFIG_FORMAT_YAML {
+		var err error
+		config, err = yamlToJSON(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var clusters ClusterList
+	err := json.Unmarshal(config, &clusters)
+	if err != nil {
+		return nil, err
+	}
+	return &clusters, nil
+}
+
+// translate YAML into the equivalent JSON, so a YAML config is read with
+// exactly the same keys and types as a JSON one
+func yamlToJSON(config []byte) ([]byte, error) {
+	var doc interface{}
+	err := yaml.Unmarshal(config, &doc)
+	if err != nil {
+		return nil, err
+	}
+	doc, err = jsonCompatible(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// YAML allows mapping keys that aren't strings, which JSON doesn't
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, item := range v {
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// encrypted config files, so the cluster credentials aren't stored in
+// cleartext on the collection host
+//
+// 'cbsummary encrypt-config' encrypts a config file with AES-256-GCM, using a
+// key derived with scrypt from a passphrase or the contents of a key file.
+// The result is a small JSON envelope, which LoadConfig recognizes and
+// decrypts with the secret from --config-key-file, the environment variable
+// CBSUMMARY_CONFIG_PASSPHRASE, or a passphrase asked for at the terminal.
+//
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	CONFIG_ENCRYPTION_VERSION = 1
+	CONFIG_PASSPHRASE_ENV     = "CBSUMMARY_CONFIG_PASSPHRASE"
+
+	// scrypt parameters for deriving the key
+	SCRYPT_N = 32768
+	SCRYPT_R = 8
+	SCRYPT_P = 1
+)
+
+type EncryptedConfig struct {
+	Version    int    `json:"cbsummary_encrypted_config"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// where the secret for an encrypted config comes from
+type ConfigKey struct {
+	File string
+
+	// a passphrase already asked for, so a daemon only asks once
+	passphrase []byte
+}
+
+func (k *ConfigKey) secret(confirm bool) ([]byte, error) {
+	if k == nil {
+		k = &ConfigKey{}
+	}
+	if len(k.File) > 0 {
+		secret, err := ioutil.ReadFile(k.File)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading config key file %s: %v", k.File, err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("Config key file %s is empty", k.File)
+		}
+		return secret, nil
+	}
+	if passphrase := os.Getenv(CONFIG_PASSPHRASE_ENV); len(passphrase) > 0 {
+		return []byte(passphrase), nil
+	}
+	if k.passphrase != nil {
+		return k.passphrase, nil
+	}
+
+	if !isTerminal(os.Stdin) {
+		return nil, fmt.Errorf("no passphrase; give a key file or set %s", CONFIG_PASSPHRASE_ENV)
+	}
+	passphrase, err := askPassword("Config passphrase")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("the config passphrase can't be empty")
+	}
+	if confirm {
+		again, err := askPassword("Config passphrase again")
+		if err != nil {
+			return nil, err
+		}
+		if again != passphrase {
+			return nil, fmt.Errorf("the passphrases don't match")
+		}
+	}
+	k.passphrase = []byte(passphrase)
+	return k.passphrase, nil
+}
+
+// whether the contents of a config file are an encrypted config
+func IsEncryptedConfig(body []byte) bool {
+	var probe struct {
+		Version int `json:"cbsummary_encrypted_config"`
+	}
+	return json.Unmarshal(body, &probe) == nil && probe.Version > 0
+}
+
+func configAEAD(secret, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func EncryptConfig(plaintext []byte, key *ConfigKey) ([]byte, error) {
+	secret, err := key.secret(true)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := EncryptedConfig{
+		Version: CONFIG_ENCRYPTION_VERSION,
+		KDF:     "scrypt",
+		N:       SCRYPT_N,
+		R:       SCRYPT_R,
+		P:       SCRYPT_P,
+		Salt:    make([]byte, 16),
+	}
+	if _, err = rand.Read(enc.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := configAEAD(secret, enc.Salt, enc.N, enc.R, enc.P)
+	if err != nil {
+		return nil, err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(enc.Nonce); err != nil {
+		return nil, err
+	}
+	enc.Ciphertext = aead.Seal(nil, enc.Nonce, plaintext, nil)
+
+	body, err := json.MarshalIndent(enc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+func DecryptConfig(body []byte, key *ConfigKey) ([]byte, error) {
+	var enc EncryptedConfig
+	err := json.Unmarshal(body, &enc)
+	if err != nil {
+		return nil, err
+	}
+	if enc.Version != CONFIG_ENCRYPTION_VERSION || enc.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported encryption (version %d, kdf %s)", enc.Version, enc.KDF)
+	}
+
+	secret, err := key.secret(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := configAEAD(secret, enc.Salt, enc.N, enc.R, enc.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("bad nonce")
+	}
+	plaintext, err := aead.Open(nil, enc.Nonce, enc.Ciphertext, nil)
+	if err != nil {
+		if key != nil {
+			key.passphrase = nil // ask again next time
+		}
+		return nil, fmt.Errorf("wrong passphrase or key file, or the file is corrupt")
+	}
+	return plaintext, nil
+}
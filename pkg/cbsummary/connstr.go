@@ -0,0 +1,116 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// SDK-style connection strings, e.g. couchbase://host1,host2?network=external
+//
+// The hosts in a connection string are translated into the admin REST
+// endpoints cbsummary talks to: http://host:8091 for couchbase:// and
+// https://host:18091 for couchbases://. Ports given in the connection string
+// are for the data service, so they are replaced. http:// and https:// URLs
+// are passed through unchanged.
+//
+// As with the SDKs, a connection string naming a single host without a port,
+// e.g. couchbase://cluster.example.com, is first looked up as a DNS SRV
+// record (_couchbase._tcp.cluster.example.com, or _couchbases._tcp for
+// couchbases://), and the nodes it lists are used if there is one.
+//
+// Connection strings can be used in the "nodes" of the config file as well as
+// with 'cbsummary import' and --cluster.
+//
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	MGMT_PORT     = "8091"
+	MGMT_SSL_PORT = "18091"
+)
+
+// the admin REST endpoints for the hosts in a connection string
+func ConnectionStringNodes(connstr string) ([]string, error) {
+	connstr = strings.TrimSpace(connstr)
+	if strings.HasPrefix(connstr, "http://") || strings.HasPrefix(connstr, "https://") {
+		return []string{connstr}, nil
+	}
+
+	scheme := "http"
+	port := MGMT_PORT
+	service := "couchbase"
+	hosts := connstr
+	if strings.HasPrefix(connstr, "couchbases://") {
+		service = "couchbases"
+		scheme = "https"
+		port = MGMT_SSL_PORT
+		hosts = strings.TrimPrefix(connstr, "couchbases://")
+	} else if strings.HasPrefix(connstr, "couchbase://") {
+		hosts = strings.TrimPrefix(connstr, "couchbase://")
+	} else if strings.Contains(connstr, "://") {
+		return nil, fmt.Errorf("unsupported connection string scheme in '%s'", connstr)
+	}
+
+	// drop any options or bucket name
+	if idx := strings.IndexAny(hosts, "?/"); idx >= 0 {
+		hosts = hosts[:idx]
+	}
+
+	hostList := strings.FieldsFunc(hosts, func(r rune) bool { return r == ',' || r == ';' })
+	if len(hostList) == 1 {
+		if targets := srvHosts(service, strings.TrimSpace(hostList[0])); len(targets) > 0 {
+			hostList = targets
+		}
+	}
+
+	nodes := make([]string, 0)
+	for _, host := range hostList {
+		host = strings.TrimSpace(host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if len(host) == 0 {
+			continue
+		}
+		nodes = append(nodes, scheme+"://"+net.JoinHostPort(host, port))
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no hosts in connection string '%s'", connstr)
+	}
+	return nodes, nil
+}
+
+// whether a config node is a connection string rather than a URL
+func isConnectionString(node string) bool {
+	return strings.HasPrefix(node, "couchbase://") || strings.HasPrefix(node, "couchbases://")
+}
+
+// the hosts listed in the DNS SRV record for a host, if it could have one:
+// a name rather than an address, without a port
+func srvHosts(service, host string) []string {
+	if len(host) == 0 || strings.Contains(host, ":") || strings.Contains(host, "[") || net.ParseIP(host) != nil {
+		return nil
+	}
+	_, records, err := net.LookupSRV(service, "tcp", host)
+	if err != nil {
+		return nil // not an SRV name; use the host itself
+	}
+
+	targets := make([]string, 0, len(records))
+	for _, record := range records {
+		targets = append(targets, strings.TrimSuffix(record.Target, "."))
+	}
+	return targets
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// consumption units - converts observed cores, RAM and services into
+// Capella-style consumption-unit figures
+//
+// The formula used for each node is:
+//
+//   node CUs = (cores + RAM_GB / RAM_GB_PER_CU) * service weight
+//
+// where the service weight is the highest weight of any service running on
+// the node (see SERVICE_CU_WEIGHTS). A node's cores are its available cores,
+// or its host CPU count on servers earlier than 6.5 (see cores.go); only a
+// node with no core count from either is estimated from RAM alone, assuming
+// the same ratio of RAM to cores, and flagged as estimated. Cluster and report
+// totals are simple sums of the node figures.
+//
+// These figures are an approximation meant for comparing self-managed usage
+// against Capella pricing; they are not a quote.
+//
+
+import (
+	"fmt"
+	"sort"
+)
+
+// number of GB of RAM that count as a single consumption unit
+const RAM_GB_PER_CU = 4.0
+
+// weighting applied to a node's compute and memory, keyed by the service names
+// reported by ns_server
+var SERVICE_CU_WEIGHTS = map[string]float64{
+	"kv":       1.0,
+	"index":    1.0,
+	"n1ql":     1.0,
+	"fts":      1.0,
+	"eventing": 1.0,
+	"cbas":     1.25,
+	"backup":   0.5,
+}
+
+// weight used for a node with no (or only unknown) services
+const DEFAULT_CU_WEIGHT = 1.0
+
+const CU_FORMULA = "node CUs = (cores + RAM_GB / 4) * max(service weight); " +
+	"weights: kv=1.0 index=1.0 n1ql=1.0 fts=1.0 eventing=1.0 cbas=1.25 backup=0.5"
+
+type ConsumptionUnitReport struct {
+	Formula  string               `json:"formula"`
+	Clusters []ClusterConsumption `json:"clusters"`
+	Total    float64              `json:"total_cus"`
+}
+
+type ClusterConsumption struct {
+	ClusterNum int               `json:"cluster_num"`
+	UUID       string            `json:"cluster_uuid"`
+	Nodes      []NodeConsumption `json:"nodes"`
+	Total      float64           `json:"total_cus"`
+}
+
+type NodeConsumption struct {
+	Name      string   `json:"hostname"`
+	Services  []string `json:"services"`
+	Cores     float64  `json:"cores"`
+	RAM       float64  `json:"ram_gb"`
+	Weight    float64  `json:"service_weight"`
+	CUs       float64  `json:"cus"`
+	Estimated bool     `json:"estimated,omitempty"`
+}
+
+func NewConsumptionUnitReport() *ConsumptionUnitReport {
+	return &ConsumptionUnitReport{
+		Formula:  CU_FORMULA,
+		Clusters: make([]ClusterConsumption, 0),
+	}
+}
+
+// compute the consumption units for one cluster and add them to the report
+func (r *ConsumptionUnitReport) AddCluster(clusterNum int, uuid string, nodes []NodeInfo) {
+	cluster := ClusterConsumption{
+		ClusterNum: clusterNum,
+		UUID:       uuid,
+		Nodes:      make([]NodeConsumption, 0, len(nodes)),
+	}
+
+	for _, nodeInfo := range nodes {
+		node := nodeConsumption(nodeInfo)
+		cluster.Nodes = append(cluster.Nodes, node)
+		cluster.Total = cluster.Total + node.CUs
+	}
+
+	r.Clusters = append(r.Clusters, cluster)
+	r.Total = r.Total + cluster.Total
+
+	// keep the clusters in config order regardless of the order they were added
+	sort.Slice(r.Clusters, func(i, j int) bool {
+		return r.Clusters[i].ClusterNum < r.Clusters[j].ClusterNum
+	})
+}
+
+// add the clusters from another report, renumbering them from offset
+func (r *ConsumptionUnitReport) Merge(other *ConsumptionUnitReport, offset int) {
+	for _, cluster := range other.Clusters {
+		cluster.ClusterNum = cluster.ClusterNum + offset
+		r.Clusters = append(r.Clusters, cluster)
+	}
+	r.Total = r.Total + other.Total
+}
+
+func nodeConsumption(nodeInfo NodeInfo) NodeConsumption {
+	node := NodeConsumption{
+		Name:     nodeInfo.Hostname,
+		Services: nodeInfo.Services,
+		Cores:    nodeInfo.SystemStats.CPU_cores_available,
+		RAM:      nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0,
+		Weight:   serviceWeight(nodeInfo.Services),
+	}
+
+	// no cores info for earlier than 6.5, so estimate from RAM alone
+	cores := node.Cores
+	if cores <= 0 {
+		cores = node.RAM / RAM_GB_PER_CU
+		node.Estimated = true
+	}
+
+	node.CUs = (cores + node.RAM/RAM_GB_PER_CU) * node.Weight
+	return node
+}
+
+func serviceWeight(services []string) float64 {
+	weight := 0.0
+	for _, service := range services {
+		if w, ok := SERVICE_CU_WEIGHTS[service]; ok && w > weight {
+			weight = w
+		}
+	}
+	if weight == 0 {
+		weight = DEFAULT_CU_WEIGHT
+	}
+	return weight
+}
+
+func (r *ConsumptionUnitReport) String() string {
+	return fmt.Sprintf("%.1f consumption units across %d clusters", r.Total, len(r.Clusters))
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// node cores - a node's cores are its systemStats.cpu_cores_available, which
+// inside a cgroup-limited container is the container's limit rather than the
+// host's CPU count, and which servers before 6.5 don't report at all.
+//
+// Where cpu_cores_available is missing, the node's cpuCount (the host's CPU
+// count) is used instead, from /pools/default or, if that doesn't give it
+// either, from the per-node listing at /pools/nodes. Nodes whose cores are a
+// container's limit, below the host's CPU count, are marked cpu_limited with
+// the host_cpu_count alongside (see setContainerLimits); the cores of all
+// other nodes are the host's.
+//
+// Nodes with no cores from anywhere are left at 0.
+//
+
+// fill in the cores of any nodes that don't report cpu_cores_available
+func fillNodeCores(client *RestClient, nodes []NodeInfo) {
+	missing := false
+	for _, nodeInfo := range nodes {
+		if nodeInfo.SystemStats.CPU_cores_available <= 0 && nodeInfo.CpuCount <= 0 {
+			missing = true
+		}
+	}
+	if missing {
+		listed, err := client.GetNodesList()
+		if err != nil {
+			LogVerbose("Error getting the CPU counts of the nodes from %s: %v", client.host, err)
+		} else {
+			cpuCounts := make(map[string]float64)
+			for _, nodeInfo := range listed {
+				cpuCounts[nodeInfo.Hostname] = nodeInfo.CpuCount
+			}
+			for i := range nodes {
+				if nodes[i].CpuCount <= 0 {
+					nodes[i].CpuCount = cpuCounts[nodes[i].Hostname]
+				}
+			}
+		}
+	}
+
+	for i := range nodes {
+		stats := &nodes[i].SystemStats
+		if stats.CPU_cores_available <= 0 && nodes[i].CpuCount > 0 {
+			stats.CPU_cores_available = nodes[i].CpuCount
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// credentials kept out of the config file
+//
+// - "login" or "pass" given as "$NAME" or "${NAME}" is read from the
+//   environment variable NAME when the config is loaded. A value that really
+//   starts with '$' is written with "$$". The same goes for the "api_secret"
+//   of Capella organizations.
+// - any of them given as "vault:<path>#<field>" is read from HashiCorp Vault
+//   (see vault.go), and as "awssm:<secret>" or "ssm:<parameter>" from AWS
+//   Secrets Manager or Parameter Store (see aws_secrets.go), and as
+//   "k8s:<namespace>/<secret>/<key>" from a Kubernetes secret (see kube.go).
+// - a cluster with "prompt": true and no password is asked for one at the
+//   terminal, without echo. In daemon mode the answer is remembered across
+//   config reloads.
+// - --password-from-stdin reads a single password from the first line of
+//   standard input and uses it for every cluster without one.
+//
+// Resolved credentials are only held in memory; the config as written is what
+// goes into error reports and checkpoints.
+//
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// where credentials given as references are read from
+type secretSources struct {
+	vault *VaultReader
+	aws   *AWSSecretReader
+	kube  *KubeSecretReader
+}
+
+// resolve any references to the environment, Vault, AWS or Kubernetes in the
+// clusters' credentials
+func expandCredentials(clusters *ClusterList) error {
+	// the Vault settings may refer to the environment themselves
+	var vault *VaultReader
+	if clusters.Vault != nil {
+		config := *clusters.Vault
+		for _, value := range []*string{&config.Token, &config.RoleID, &config.SecretID} {
+			var err error
+			*value, err = expandCredential(*value)
+			if err != nil {
+				return fmt.Errorf("vault: %v", err)
+			}
+		}
+		vault = NewVaultReader(&config)
+	} else {
+		vault = NewVaultReader(nil)
+	}
+	transport := TransportOptions{}
+	if clusters.Transport != nil {
+		transport = *clusters.Transport
+	}
+	sources := &secretSources{
+		vault: vault,
+		aws:   NewAWSSecretReader(),
+		kube:  NewKubeSecretReader(clusters.Kubernetes, transport),
+	}
+
+	for i := range clusters.Clusters {
+		cluster := &clusters.Clusters[i]
+		cluster.configuredLogin = cluster.Login
+		cluster.configuredPass = cluster.Pass
+
+		var err error
+		cluster.Login, err = resolveCredential(cluster.Login, "username", sources)
+		if err != nil {
+			return fmt.Errorf("cluster %d login: %v", i+1, err)
+		}
+		cluster.Pass, err = resolveCredential(cluster.Pass, "password", sources)
+		if err != nil {
+			return fmt.Errorf("cluster %d password: %v", i+1, err)
+		}
+	}
+	for i := range clusters.Capella {
+		org := &clusters.Capella[i]
+		org.configuredSecret = org.APISecret
+
+		var err error
+		org.APISecret, err = resolveCredential(org.APISecret, "api_secret", sources)
+		if err != nil {
+			return fmt.Errorf("Capella organization %d secret: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// a credential from Vault, AWS, Kubernetes or the environment, or as given;
+// field is the field of a JSON secret in AWS to use when the reference
+// doesn't name one
+func resolveCredential(value, field string, sources *secretSources) (string, error) {
+	if isVaultReference(value) {
+		return sources.vault.Read(value)
+	}
+	if isAWSReference(value) {
+		return sources.aws.Read(value, field)
+	}
+	if isKubeSecretReference(value) {
+		return sources.kube.Read(value)
+	}
+	return expandCredential(value)
+}
+
+func expandCredential(value string) (string, error) {
+	if !strings.HasPrefix(value, "$") {
+		return value, nil
+	}
+	if strings.HasPrefix(value, "$$") {
+		return value[1:], nil
+	}
+
+	name := value[1:]
+	if strings.HasPrefix(name, "{") && strings.HasSuffix(name, "}") {
+		name = name[1 : len(name)-1]
+	}
+	expanded, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return expanded, nil
+}
+
+// where passwords missing from the config come from
+type PasswordSource struct {
+	// from --password-from-stdin, if given
+	stdin    string
+	hasStdin bool
+
+	// answers already given at the terminal, by cluster
+	prompted map[string]string
+}
+
+func NewPasswordSource() *PasswordSource {
+	return &PasswordSource{prompted: make(map[string]string)}
+}
+
+// read the password from the first line of r
+func (p *PasswordSource) ReadStdin(r io.Reader) error {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(err == io.EOF && len(line) > 0) {
+		return fmt.Errorf("Error reading password from standard input: %v", err)
+	}
+	p.stdin = strings.TrimRight(line, "\r\n")
+	p.hasStdin = true
+	return nil
+}
+
+// fill in the passwords of the clusters that don't have one
+func (p *PasswordSource) Fill(clusters *ClusterList) error {
+	for i := range clusters.Clusters {
+		cluster := &clusters.Clusters[i]
+		if len(cluster.Pass) > 0 {
+			continue
+		}
+		if p.hasStdin {
+			cluster.Pass = p.stdin
+			continue
+		}
+		if !cluster.Prompt {
+			continue
+		}
+
+		key := cluster.Login + "@" + strings.Join(cluster.Nodes, ",")
+		pass, ok := p.prompted[key]
+		if !ok {
+			var err error
+			pass, err = askPassword(fmt.Sprintf("Password for %s", key))
+			if err != nil {
+				return fmt.Errorf("cluster %d: %v", i+1, err)
+			}
+			p.prompted[key] = pass
+		}
+		cluster.Pass = pass
+	}
+	return nil
+}
+
+// ask for a password at the terminal without echoing it
+func askPassword(question string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("a password prompt needs a terminal; use --password-from-stdin or \"$ENV_VAR\" instead")
+	}
+	fmt.Fprintf(console, "%s: ", question)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintf(console, "\n")
+	if err != nil {
+		return "", fmt.Errorf("Error reading password: %v", err)
+	}
+	return string(pass), nil
+}
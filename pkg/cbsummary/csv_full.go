@@ -0,0 +1,137 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// CSV output for full reports - one row per node, for loading into a
+// spreadsheet
+//
+// Each cluster's fields are flattened into columns named with dot notation,
+// e.g. "buckets.counts.total" or "buckets.buckets.0.name", and repeated on
+// every row for the cluster; the node's own fields follow as "node.<field>".
+// Clusters have different numbers of buckets, indexes and so on, so the
+// columns are the union of those of all the rows, and a row leaves the
+// columns it doesn't have empty. Like the brief CSV report, the columns are
+// separated by tabs, and any tabs or newlines in the values become spaces.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// write the full clusters of the report as CSV rows, one per node
+func writeFullCSV(buffer *strings.Builder, clusters []interface{}) error {
+	rows := make([]map[string]string, 0)
+	clusterColumns := make(map[string]bool)
+	nodeColumns := make(map[string]bool)
+
+	for cnum, icluster := range clusters {
+		cluster, ok := icluster.(*ClusterSummary)
+		if !ok {
+			continue
+		}
+
+		var fields map[string]interface{}
+		err := roundTrip(cluster, &fields)
+		if err != nil {
+			return err
+		}
+		nodes, _ := fields["nodes"].([]interface{})
+		delete(fields, "nodes")
+
+		clusterRow := map[string]string{"cluster_num": fmt.Sprint(cnum)}
+		flattenJSON("", fields, clusterRow)
+		for column := range clusterRow {
+			clusterColumns[column] = true
+		}
+
+		if len(nodes) == 0 {
+			rows = append(rows, clusterRow)
+			continue
+		}
+		for _, node := range nodes {
+			row := make(map[string]string, len(clusterRow))
+			for column, value := range clusterRow {
+				row[column] = value
+			}
+			nodeRow := make(map[string]string)
+			flattenJSON("node", node, nodeRow)
+			for column, value := range nodeRow {
+				row[column] = value
+				nodeColumns[column] = true
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	delete(clusterColumns, "cluster_num")
+	columns := append([]string{"cluster_num"}, sortedKeys(clusterColumns)...)
+	columns = append(columns, sortedKeys(nodeColumns)...)
+
+	buffer.WriteString(strings.Join(columns, "\t") + "\n")
+	values := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		buffer.WriteString(strings.Join(values, "\t") + "\n")
+	}
+	return nil
+}
+
+// convert a value to its generic JSON form, keeping numbers as written
+func roundTrip(value interface{}, generic interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Error marshalling summary: %v", err)
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber()
+	return decoder.Decode(generic)
+}
+
+// add the leaves of a decoded JSON value to the row, named by their dotted
+// paths under prefix
+func flattenJSON(prefix string, value interface{}, row map[string]string) {
+	join := func(key string) string {
+		if len(prefix) == 0 {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			flattenJSON(join(key), item, row)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenJSON(join(fmt.Sprint(i)), item, row)
+		}
+	case nil:
+		row[prefix] = ""
+	default:
+		row[prefix] = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(fmt.Sprint(v))
+	}
+}
+
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// daemon mode - keep running and collect a new report on a schedule
+//
+// The daemon is meant to be run under a service manager:
+// - SIGHUP reloads the config file, keeping the old config if the new one is bad
+// - SIGTERM/SIGINT shut down cleanly, letting an in-flight collection finish
+//   for up to DAEMON_SHUTDOWN_GRACE; a second signal, or the grace period
+//   running out, abandons the clusters not yet collected, and the report is
+//   written with them marked interrupted
+// - --pid-file records the process ID for the lifetime of the daemon
+// - --listen serves a /health liveness endpoint, the on-demand collection
+//   API (see jobs.go), /report with the latest report in any of the formats,
+//   and /metrics with the latest summary in the Prometheus exposition format,
+//   making the daemon a fleet exporter. With --report-auth, /report,
+//   /metrics and the collection API need HTTP basic authentication (or, for
+//   the API, the --api-token).
+// Under Windows the same behavior is available when run as a service (see
+// service_windows.go).
+//
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how long a shutdown waits for the collection in progress
+const DAEMON_SHUTDOWN_GRACE = 30 * time.Second
+
+type Daemon struct {
+	ConfigFile string
+	Config     ConfigOptions
+	Interval   time.Duration
+	Listen     string
+	PidFile    string
+	APIToken   string
+	ReportAuth *BasicAuth
+	Passwords  *PasswordSource
+	Collector  *Collector
+	Publisher  *Publisher
+
+	jobs *JobQueue
+	// held while collecting, by the scheduled runs and the jobs alike
+	collecting sync.Mutex
+	mu         sync.Mutex
+	clusters   *ClusterList
+	status     DaemonStatus
+	latest     *SummaryInfo
+}
+
+// state reported by the /health endpoint
+type DaemonStatus struct {
+	Status    string     `json:"status"`
+	Started   time.Time  `json:"started"`
+	Running   bool       `json:"collection_running"`
+	Runs      int        `json:"runs"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+}
+
+// run the daemon until told to stop by a signal
+func (d *Daemon) Run() error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	return d.RunUntil(stop)
+}
+
+// run the daemon until something arrives on the stop channel
+func (d *Daemon) RunUntil(stop <-chan os.Signal) error {
+	clusters, err := d.loadConfig()
+	if err != nil {
+		return err
+	}
+	d.clusters = clusters
+	d.status = DaemonStatus{Status: "ok", Started: time.Now()}
+
+	// cancelled to abandon the collection in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if len(d.PidFile) > 0 {
+		err = ioutil.WriteFile(d.PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+		if err != nil {
+			return fmt.Errorf("Error writing pid file %s: %v", d.PidFile, err)
+		}
+		defer os.Remove(d.PidFile)
+	}
+
+	if len(d.Listen) > 0 {
+		// listen now, so an address in use stops the daemon before it starts
+		listener, err := net.Listen("tcp", d.Listen)
+		if err != nil {
+			return fmt.Errorf("Error listening on %s: %v", d.Listen, err)
+		}
+
+		d.jobs = NewJobQueue(d.Collector, d.APIToken, d.ReportAuth, &d.collecting)
+		go d.jobs.Run(ctx)
+
+		server := &http.Server{Addr: d.Listen, Handler: d.handler()}
+		go func() {
+			err := server.Serve(listener)
+			if err != nil && err != http.ErrServerClosed {
+				LogError("Error serving on %s: %v", d.Listen, err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	LogInfo("Running as a daemon from config file %s, collecting every %s.", d.ConfigFile, d.Interval)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var done chan struct{}
+
+	for {
+		select {
+		case <-timer.C:
+			done = make(chan struct{})
+			go func(done chan struct{}) {
+				d.runOnce(ctx)
+				close(done)
+			}(done)
+
+		case <-done:
+			done = nil
+			next := time.Now().Add(d.Interval)
+			d.mu.Lock()
+			d.status.NextRun = &next
+			d.mu.Unlock()
+			timer.Reset(d.Interval)
+
+		case <-hup:
+			d.reload()
+
+		case sig := <-stop:
+			if done != nil {
+				LogInfo("Received %v, waiting up to %s for the current collection to finish.", sig, DAEMON_SHUTDOWN_GRACE)
+				grace := time.NewTimer(DAEMON_SHUTDOWN_GRACE)
+				select {
+				case <-done:
+				case sig = <-stop:
+					LogWarn("Received %v, abandoning the clusters not yet collected.", sig)
+				case <-grace.C:
+					LogWarn("The current collection didn't finish in %s, abandoning the clusters not yet collected.",
+						DAEMON_SHUTDOWN_GRACE)
+				}
+				grace.Stop()
+				cancel()
+				<-done
+			}
+			LogInfo("Received %v, shutting down.", sig)
+			return nil
+		}
+	}
+}
+
+// collect and write one report using the current config, abandoning the
+// clusters not yet collected if ctx is done
+func (d *Daemon) runOnce(ctx context.Context) {
+	// wait for any job collecting; if the daemon is shutting down meanwhile,
+	// there's nothing worth writing
+	d.collecting.Lock()
+	defer d.collecting.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+
+	d.mu.Lock()
+	clusters := d.clusters
+	d.status.Running = true
+	d.mu.Unlock()
+
+	clusterSummary := d.Collector.CollectContext(ctx, clusters)
+	err := d.Publisher.Publish(clusterSummary)
+	if err != nil {
+		LogError("%v", err)
+	}
+
+	d.mu.Lock()
+	d.latest = clusterSummary
+	d.status.Running = false
+	d.status.Runs = d.status.Runs + 1
+	now := time.Now()
+	d.status.LastRun = &now
+	if err != nil {
+		d.status.LastError = err.Error()
+	} else {
+		d.status.LastError = ""
+	}
+	d.mu.Unlock()
+}
+
+func (d *Daemon) loadConfig() (*ClusterList, error) {
+	clusters, err := LoadConfig(d.ConfigFile, d.Config)
+	if err != nil {
+		return nil, err
+	}
+	if d.Passwords != nil {
+		err = d.Passwords.Fill(clusters)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clusters, nil
+}
+
+// re-read the config file, keeping the current config if the new one is bad
+func (d *Daemon) reload() {
+	clusters, err := d.loadConfig()
+	if err != nil {
+		LogError("Not reloading configuration: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	d.clusters = clusters
+	d.mu.Unlock()
+	LogInfo("Reloaded config file %s, %d clusters.", d.ConfigFile, len(clusters.Clusters))
+}
+
+func (d *Daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", d.handleHealth)
+	mux.HandleFunc("GET /metrics", d.handleMetrics)
+	mux.HandleFunc("GET /report", d.handleReport)
+	if d.jobs != nil {
+		d.jobs.Register(mux, func() *ClusterList {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.clusters
+		})
+	}
+	return mux
+}
+
+func (d *Daemon) handleHealth(w http.ResponseWriter, req *http.Request) {
+	d.mu.Lock()
+	status := d.status
+	d.mu.Unlock()
+
+	body, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// the latest summary for Prometheus to scrape
+func (d *Daemon) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	latest, ok := d.latestReport(w, req)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", REPORT_CONTENT_TYPES[FORMAT_PROM])
+	w.Write(FormatPrometheus(latest))
+}
+
+// the latest report, as JSON or in the format given with ?format=
+func (d *Daemon) handleReport(w http.ResponseWriter, req *http.Request) {
+	latest, ok := d.latestReport(w, req)
+	if !ok {
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = FORMAT_JSON
+	}
+	if !ValidFormat(format) {
+		http.Error(w, fmt.Sprintf("unknown format '%s'", format), http.StatusBadRequest)
+		return
+	}
+	body, err := FormatReport(latest, ReportOptions{Format: format})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", REPORT_CONTENT_TYPES[format])
+	w.Write(body)
+}
+
+// the latest summary, after checking the request is allowed to see it; on
+// failure the response has been written
+func (d *Daemon) latestReport(w http.ResponseWriter, req *http.Request) (*SummaryInfo, bool) {
+	if !d.ReportAuth.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="cbsummary"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	d.mu.Lock()
+	latest := d.latest
+	d.mu.Unlock()
+
+	if latest == nil {
+		http.Error(w, "no collection has finished yet", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return latest, true
+}
+
+// the user and password required to fetch reports
+type BasicAuth struct {
+	User     string
+	Password string
+}
+
+// read the credentials for basic authentication from a file holding
+// "user:password"
+func LoadBasicAuth(file string) (*BasicAuth, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading credentials %s: %v", file, err)
+	}
+	user, password, ok := strings.Cut(strings.TrimSpace(string(body)), ":")
+	if !ok || len(user) == 0 {
+		return nil, fmt.Errorf("Credentials file %s must hold 'user:password'", file)
+	}
+	return &BasicAuth{User: user, Password: password}, nil
+}
+
+// whether the request carries the credentials; with none required, every
+// request is authorized
+func (a *BasicAuth) authorized(req *http.Request) bool {
+	if a == nil {
+		return true
+	}
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.User)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(a.Password)) == 1
+	return userOK && passwordOK
+}
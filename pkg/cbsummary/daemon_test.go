@@ -0,0 +1,68 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// a daemon handler with --report-auth, and the given --api-token, holding one
+// finished job
+func jobsHandler(token string) http.Handler {
+	d := &Daemon{ReportAuth: &BasicAuth{User: "reports", Password: "secret"}}
+	d.jobs = NewJobQueue(nil, token, d.ReportAuth, &d.collecting)
+	d.jobs.jobs["0123456789abcdef"] = &Job{ID: "0123456789abcdef", Status: JOB_DONE, report: &SummaryInfo{}}
+	return d.handler()
+}
+
+func TestJobReportNeedsReportAuth(t *testing.T) {
+	for _, token := range []string{"", "api-token"} {
+		handler := jobsHandler(token)
+		bearerStatus := http.StatusUnauthorized
+		if len(token) > 0 {
+			bearerStatus = http.StatusOK
+		}
+		for _, test := range []struct {
+			method, path string
+			auth         func(*http.Request)
+			status       int
+		}{
+			{"GET", "/api/v1/jobs/0123456789abcdef/report", nil, http.StatusUnauthorized},
+			{"GET", "/api/v1/jobs/0123456789abcdef", nil, http.StatusUnauthorized},
+			{"POST", "/api/v1/collect", nil, http.StatusUnauthorized},
+			{"GET", "/api/v1/jobs/0123456789abcdef/report", func(req *http.Request) {
+				req.SetBasicAuth("reports", "wrong")
+			}, http.StatusUnauthorized},
+			{"GET", "/api/v1/jobs/0123456789abcdef/report", func(req *http.Request) {
+				req.SetBasicAuth("reports", "secret")
+			}, http.StatusOK},
+			{"GET", "/api/v1/jobs/0123456789abcdef/report", func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer api-token")
+			}, bearerStatus},
+		} {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.auth != nil {
+				test.auth(req)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if resp.Code != test.status {
+				t.Errorf("token %q: %s %s with %s: got status %d, want %d", token, test.method, test.path,
+					req.Header.Get("Authorization"), resp.Code, test.status)
+			}
+			if resp.Code == http.StatusUnauthorized && len(resp.Header().Get("WWW-Authenticate")) == 0 {
+				t.Errorf("token %q: %s %s: no WWW-Authenticate header", token, test.method, test.path)
+			}
+		}
+	}
+}
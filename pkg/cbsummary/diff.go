@@ -0,0 +1,257 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// cbsummary diff - compare two JSON reports and print what changed between
+// them: clusters that appeared or disappeared, nodes added and removed,
+// version upgrades, changes in cores and RAM, and new or removed buckets
+// (buckets are only in full reports). Clusters are matched by their UUID.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// the parts of a cluster that are compared
+type diffCluster struct {
+	UUID    string
+	Name    string
+	Nodes   map[string]HistoryNode
+	Cores   float64
+	RAM     float64
+	Buckets map[string]bool // nil if the report doesn't list them
+}
+
+// read a JSON report, with its clusters as the types they were written from
+func LoadReport(file string) (*SummaryInfo, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading report %s: %v", file, err)
+	}
+
+	summary, err := ParseReport(body)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing report %s, it should be a JSON report: %v", file, err)
+	}
+	return summary, nil
+}
+
+// parse a JSON report, with its clusters as the types they were written from
+func ParseReport(body []byte) (*SummaryInfo, error) {
+	var summary SummaryInfo
+	err := json.Unmarshal(body, &summary)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Clusters []map[string]json.RawMessage `json:"clusters"`
+	}
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, fields := range raw.Clusters {
+		var cluster interface{}
+		switch {
+		case fields["error_with_cluster"] != nil:
+			cluster = &ClusterError{}
+		case fields["cluster_uuid"] != nil:
+			cluster = &BriefCluster{}
+		default:
+			cluster = &ClusterSummary{}
+		}
+		err = roundTrip(fields, cluster)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %d: %v", i, err)
+		}
+		summary.Clusters[i] = cluster
+	}
+	return &summary, nil
+}
+
+// the clusters of a report that were collected, and the ones in error
+func diffClusters(summary *SummaryInfo) ([]*diffCluster, []*ClusterError) {
+	clusters := make([]*diffCluster, 0)
+	errors := make([]*ClusterError, 0)
+
+	record := NewHistoryRecord(summary, time.Now())
+	next := 0
+	for _, icluster := range summary.Clusters {
+		var name string
+		var buckets map[string]bool
+		switch c := icluster.(type) {
+		case *ClusterError:
+			errors = append(errors, c)
+			continue
+		case *BriefCluster:
+			name = c.Label
+		case *ClusterSummary:
+			name = c.Label
+			if len(name) == 0 {
+				name = c.ClusterName
+			}
+			if c.Buckets != nil {
+				buckets = make(map[string]bool)
+				for _, bucket := range c.Buckets.Buckets {
+					buckets[bucket.Name] = true
+				}
+			}
+		default:
+			continue
+		}
+
+		// the history record has the collected clusters in the same order
+		history := record.Clusters[next]
+		next++
+		cluster := &diffCluster{UUID: history.UUID, Name: name, Nodes: make(map[string]HistoryNode),
+			Cores: history.Cores, RAM: history.RAM, Buckets: buckets}
+		for _, node := range history.Nodes {
+			cluster.Nodes[node.Name] = node
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, errors
+}
+
+func (c *diffCluster) String() string {
+	if len(c.Name) > 0 {
+		return fmt.Sprintf("%s (%s)", c.Name, c.UUID)
+	}
+	return c.UUID
+}
+
+// a change in a figure, e.g. "24 -> 32 (+8)"
+func formatDelta(from, to float64) string {
+	return fmt.Sprintf("%.0f -> %.0f (%+.0f)", from, to, to-from)
+}
+
+// the changes between two reports, as lines of text
+func DiffReports(old, new *SummaryInfo) []string {
+	lines := make([]string, 0)
+
+	oldClusters, _ := diffClusters(old)
+	newClusters, newErrors := diffClusters(new)
+	oldByUUID := make(map[string]*diffCluster)
+	for _, cluster := range oldClusters {
+		oldByUUID[cluster.UUID] = cluster
+	}
+
+	var oldNodes, newNodes int
+	var oldCores, newCores, oldRAM, newRAM float64
+	for _, cluster := range oldClusters {
+		oldNodes = oldNodes + len(cluster.Nodes)
+		oldCores = oldCores + cluster.Cores
+		oldRAM = oldRAM + cluster.RAM
+	}
+
+	seen := make(map[string]bool)
+	for _, cluster := range newClusters {
+		newNodes = newNodes + len(cluster.Nodes)
+		newCores = newCores + cluster.Cores
+		newRAM = newRAM + cluster.RAM
+
+		before, ok := oldByUUID[cluster.UUID]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("New cluster %s: %d nodes, %.0f cores, %.0f GB RAM",
+				cluster, len(cluster.Nodes), cluster.Cores, cluster.RAM))
+			continue
+		}
+		seen[cluster.UUID] = true
+
+		changes := clusterChanges(before, cluster)
+		if len(changes) > 0 {
+			lines = append(lines, fmt.Sprintf("Cluster %s:", cluster))
+			for _, change := range changes {
+				lines = append(lines, "  "+change)
+			}
+		}
+	}
+
+	for _, cluster := range oldClusters {
+		if !seen[cluster.UUID] {
+			lines = append(lines, fmt.Sprintf("Cluster %s is no longer in the report (%d nodes, %.0f cores, %.0f GB RAM)",
+				cluster, len(cluster.Nodes), cluster.Cores, cluster.RAM))
+		}
+	}
+	for _, e := range newErrors {
+		name := e.TheCluster.Label
+		if len(name) == 0 {
+			name = strings.Join(e.TheCluster.Nodes, ",")
+		}
+		lines = append(lines, fmt.Sprintf("Cluster %s could not be collected: %s", name, e.ErrMsg))
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "No changes.")
+	}
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("Totals: clusters %s, nodes %s, cores %s, RAM %s GB",
+		formatDelta(float64(len(oldClusters)), float64(len(newClusters))),
+		formatDelta(float64(oldNodes), float64(newNodes)),
+		formatDelta(oldCores, newCores), formatDelta(oldRAM, newRAM)))
+	return lines
+}
+
+// the changes to a cluster that is in both reports
+func clusterChanges(old, new *diffCluster) []string {
+	changes := make([]string, 0)
+
+	for _, name := range sortedKeys(new.Nodes) {
+		node := new.Nodes[name]
+		before, ok := old.Nodes[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ node %s (%s, %.0f cores, %.0f GB RAM)",
+				name, node.Version, node.Cores, node.RAM))
+			continue
+		}
+		if before.Version != node.Version {
+			changes = append(changes, fmt.Sprintf("~ node %s upgraded %s -> %s", name, before.Version, node.Version))
+		}
+		if before.Cores != node.Cores {
+			changes = append(changes, fmt.Sprintf("~ node %s cores %s", name, formatDelta(before.Cores, node.Cores)))
+		}
+		if fmt.Sprintf("%.0f", before.RAM) != fmt.Sprintf("%.0f", node.RAM) {
+			changes = append(changes, fmt.Sprintf("~ node %s RAM %s GB", name, formatDelta(before.RAM, node.RAM)))
+		}
+	}
+	for _, name := range sortedKeys(old.Nodes) {
+		if _, ok := new.Nodes[name]; !ok {
+			changes = append(changes, fmt.Sprintf("- node %s", name))
+		}
+	}
+
+	// only compare buckets when both reports list them
+	if old.Buckets != nil && new.Buckets != nil {
+		for _, name := range sortedKeys(new.Buckets) {
+			if !old.Buckets[name] {
+				changes = append(changes, fmt.Sprintf("+ bucket %s", name))
+			}
+		}
+		for _, name := range sortedKeys(old.Buckets) {
+			if !new.Buckets[name] {
+				changes = append(changes, fmt.Sprintf("- bucket %s", name))
+			}
+		}
+	}
+
+	if len(changes) > 0 && (old.Cores != new.Cores || old.RAM != new.RAM) {
+		changes = append(changes, fmt.Sprintf("cores %s, RAM %s GB", formatDelta(old.Cores, new.Cores),
+			formatDelta(old.RAM, new.RAM)))
+	}
+	return changes
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// node discovery - reaching a cluster when the nodes in the config are down
+//
+// Each time a cluster is collected, the nodes it reports in /pools/default are
+// remembered, keyed by the cluster's configured nodes. Later collections try
+// those nodes as well as the configured ones, so a cluster configured with a
+// single seed node can still be reached when that node is down. The cache is
+// kept in memory, which covers daemon mode, and in the --node-cache file if
+// one is given, which covers separate runs.
+//
+// All the candidate nodes are tried at once, and the first to answer is used
+// for the rest of the collection.
+//
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+type NodeCache struct {
+	path string
+
+	mu    sync.Mutex
+	nodes map[string][]string
+}
+
+func NewNodeCache() *NodeCache {
+	return &NodeCache{nodes: make(map[string][]string)}
+}
+
+// a node cache saved in a file, reading what's there already
+func LoadNodeCache(path string) (*NodeCache, error) {
+	cache := NewNodeCache()
+	cache.path = path
+
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading node cache %s: %v", path, err)
+	}
+	err = json.Unmarshal(body, &cache.nodes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing node cache %s: %v", path, err)
+	}
+	return cache, nil
+}
+
+func nodeCacheKey(cluster Cluster) string {
+	return strings.Join(cluster.Nodes, ",")
+}
+
+// the configured nodes of the cluster, followed by any others it's known to have
+func (cache *NodeCache) Candidates(cluster Cluster) []string {
+	candidates := append([]string{}, cluster.Nodes...)
+	if cache == nil {
+		return candidates
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, node := range cache.nodes[nodeCacheKey(cluster)] {
+		if !containsString(candidates, node) {
+			candidates = append(candidates, node)
+		}
+	}
+	return candidates
+}
+
+// remember the nodes of a cluster, reached at the given node
+func (cache *NodeCache) Update(cluster Cluster, reached string, nodes []NodeInfo) {
+	if cache == nil {
+		return
+	}
+	base, err := url.Parse(reached)
+	if err != nil {
+		return
+	}
+
+	// the other nodes are reached the same way as this one, through the
+	// same scheme and port
+	discovered := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		host := node.Hostname
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if len(host) == 0 {
+			continue
+		}
+		if port := base.Port(); len(port) > 0 {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		discovered = append(discovered, base.Scheme+"://"+host)
+	}
+
+	cache.mu.Lock()
+	cache.nodes[nodeCacheKey(cluster)] = discovered
+	cache.mu.Unlock()
+}
+
+// write the cache to its file, if it has one
+func (cache *NodeCache) Save() error {
+	if cache == nil || len(cache.path) == 0 {
+		return nil
+	}
+
+	cache.mu.Lock()
+	body, err := json.MarshalIndent(cache.nodes, "", "  ")
+	cache.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(cache.path, body, 0600)
+	if err != nil {
+		return fmt.Errorf("Error writing node cache %s: %v", cache.path, err)
+	}
+	return nil
+}
+
+// a node that answered, with what it said
+type nodeConn struct {
+	node         string
+	client       *RestClient
+	pools        *Pools
+	poolsDefault *PoolsDefault
+
+	// what we couldn't get from the node, and why
+	failed string
+	err    error
+}
+
+// try all the nodes at once, returning the first to give us /pools and
+// /pools/default, or the last error if none of them do
+func connectFirst(ctx context.Context, nodes []string, newClient func(node string) *RestClient) (*nodeConn, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes to connect to")
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	answers := make(chan *nodeConn, len(nodes))
+	for _, node := range nodes {
+		go func(node string) {
+			conn := &nodeConn{node: node, client: newClient(node).WithContext(raceCtx)}
+			conn.pools, conn.err = conn.client.GetPoolsData()
+			if conn.err != nil {
+				conn.failed = "bucket settings"
+			} else {
+				conn.poolsDefault, conn.err = conn.client.GetPoolsDefaultData()
+				conn.failed = "pools/default"
+			}
+			answers <- conn
+		}(node)
+	}
+
+	var err error
+	for range nodes {
+		conn := <-answers
+		if conn.err == nil {
+			// carry on without the race's context, which is cancelled on return
+			conn.client = conn.client.WithContext(ctx)
+			return conn, nil
+		}
+		err = conn.err
+		if ctx.Err() == nil {
+			LogError("Error getting %s from node %s: %v", conn.failed, conn.node, conn.err)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, err
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+// Package cbsummary collects a summary of a fleet of Couchbase clusters, and
+// writes it as a report, so other Go tools can summarize clusters without
+// running the cbsummary command. For example:
+//
+//	clusters, err := cbsummary.LoadConfig("clusters.json", cbsummary.ConfigOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	summary := cbsummary.NewCollector(cbsummary.CollectOptions{Full: true}).Collect(clusters)
+//	body, err := cbsummary.FormatReport(summary, cbsummary.ReportOptions{Format: cbsummary.FORMAT_JSON})
+//
+// A RestClient talks to a single cluster's REST API, the Collector collects
+// each cluster in a ClusterList with them, and the SummaryInfo it returns can
+// be formatted with FormatReport, or written, uploaded and sent on as
+// configured with a Publisher. Progress and errors are logged through
+// log/slog; SetLogger replaces the logger.
+package cbsummary
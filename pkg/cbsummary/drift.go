@@ -0,0 +1,229 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// configuration drift - compares key settings across the clusters that share
+// a label value (e.g. all clusters with env=prod) and reports the outliers
+//
+// For each group of clusters, the baseline for a setting is its most common
+// value, and every cluster with a different value is an outlier. When no
+// single value is most common there is no baseline, and the setting is listed
+// as inconsistent instead. Settings a cluster doesn't report (e.g. because
+// its server version predates them) aren't compared for that cluster.
+// Clusters without the label aren't compared at all, nor are those that were
+// busy (see busy.go), which are listed as skipped.
+//
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the settings compared, by the endpoint they come from; nested fields are
+// given as dotted paths
+var DRIFT_SETTINGS = []struct {
+	Path   string
+	Fields []string
+}{
+	{"/settings/autoFailover", []string{"enabled", "timeout", "maxCount"}},
+	{"/settings/autoCompaction", []string{
+		"autoCompactionSettings.databaseFragmentationThreshold.percentage",
+		"autoCompactionSettings.viewFragmentationThreshold.percentage",
+		"autoCompactionSettings.parallelDBAndViewCompaction",
+		"purgeInterval",
+	}},
+	{"/settings/security", []string{"disableUIOverHttp", "tlsMinVersion", "clusterEncryptionLevel"}},
+	{"/settings/passwordPolicy", []string{"minLength", "enforceUppercase", "enforceLowercase",
+		"enforceDigits", "enforceSpecialChars"}},
+}
+
+type DriftReport struct {
+	Label  string       `json:"label"`
+	Groups []DriftGroup `json:"groups"`
+
+	// clusters with the label whose settings weren't read, as they were busy
+	SkippedBusy []int `json:"skipped_busy,omitempty"`
+
+	clusters []driftCluster
+}
+
+type DriftGroup struct {
+	Value        string            `json:"label_value"`
+	Clusters     []int             `json:"clusters"`
+	Baseline     map[string]string `json:"baseline"`
+	Outliers     []DriftOutlier    `json:"outliers"`
+	Inconsistent []string          `json:"inconsistent,omitempty"`
+}
+
+type DriftOutlier struct {
+	ClusterNum int    `json:"cluster_num"`
+	UUID       string `json:"cluster_uuid"`
+	Setting    string `json:"setting"`
+	Value      string `json:"value"`
+	Expected   string `json:"expected"`
+}
+
+type driftCluster struct {
+	num      int
+	uuid     string
+	value    string
+	settings map[string]string
+}
+
+func NewDriftReport(label string) *DriftReport {
+	return &DriftReport{Label: label, Groups: make([]DriftGroup, 0)}
+}
+
+// read the compared settings of a cluster
+func CollectDriftSettings(client *RestClient, poolsDefault *PoolsDefault) map[string]string {
+	settings := map[string]string{
+		"memoryQuota":         fmt.Sprint(poolsDefault.MemoryQuota),
+		"indexMemoryQuota":    fmt.Sprint(poolsDefault.IndexMemoryQuota),
+		"ftsMemoryQuota":      fmt.Sprint(poolsDefault.FtsMemoryQuota),
+		"cbasMemoryQuota":     fmt.Sprint(poolsDefault.CbasMemoryQuota),
+		"eventingMemoryQuota": fmt.Sprint(poolsDefault.EventingMemoryQuota),
+	}
+
+	for _, endpoint := range DRIFT_SETTINGS {
+		var data map[string]interface{}
+		err := client.getJSON(endpoint.Path, &data)
+		if err != nil {
+			LogError("Error getting %s from %s: %v", endpoint.Path, client.host, err)
+			continue
+		}
+		for _, field := range endpoint.Fields {
+			if value, ok := lookupPath(data, field); ok {
+				settings[strings.TrimPrefix(endpoint.Path, "/settings/")+"."+field] = value
+			}
+		}
+	}
+	return settings
+}
+
+// the value at a dotted path in a decoded JSON object
+func lookupPath(data map[string]interface{}, path string) (string, bool) {
+	var value interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value, ok = object[key]
+		if !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprint(value), true
+}
+
+// add a cluster's settings to be compared, if it has the label
+func (r *DriftReport) AddCluster(clusterNum int, uuid string, labels map[string]string, settings map[string]string) {
+	value, ok := labels[r.Label]
+	if !ok {
+		return
+	}
+	r.clusters = append(r.clusters, driftCluster{clusterNum, uuid, value, settings})
+}
+
+// note a cluster with the label that wasn't compared, as it was busy
+func (r *DriftReport) AddSkippedBusy(clusterNum int) {
+	r.SkippedBusy = append(r.SkippedBusy, clusterNum)
+}
+
+// compare the settings of the clusters in each group
+func (r *DriftReport) Analyze() {
+	sort.Ints(r.SkippedBusy)
+
+	byValue := make(map[string][]driftCluster)
+	for _, cluster := range r.clusters {
+		byValue[cluster.value] = append(byValue[cluster.value], cluster)
+	}
+
+	values := make([]string, 0, len(byValue))
+	for value := range byValue {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	r.Groups = make([]DriftGroup, 0, len(values))
+	for _, value := range values {
+		r.Groups = append(r.Groups, analyzeDriftGroup(value, byValue[value]))
+	}
+}
+
+func analyzeDriftGroup(value string, clusters []driftCluster) DriftGroup {
+	group := DriftGroup{
+		Value:    value,
+		Clusters: make([]int, 0, len(clusters)),
+		Baseline: make(map[string]string),
+		Outliers: make([]DriftOutlier, 0),
+	}
+
+	// count the values of each setting
+	counts := make(map[string]map[string]int)
+	for _, cluster := range clusters {
+		group.Clusters = append(group.Clusters, cluster.num)
+		for setting, value := range cluster.settings {
+			if counts[setting] == nil {
+				counts[setting] = make(map[string]int)
+			}
+			counts[setting][value]++
+		}
+	}
+
+	settings := make([]string, 0, len(counts))
+	for setting := range counts {
+		settings = append(settings, setting)
+	}
+	sort.Strings(settings)
+
+	for _, setting := range settings {
+		baseline, best, tied := "", 0, false
+		for value, count := range counts[setting] {
+			if count > best {
+				baseline, best, tied = value, count, false
+			} else if count == best {
+				tied = true
+			}
+		}
+		if tied {
+			group.Inconsistent = append(group.Inconsistent, setting)
+			continue
+		}
+		group.Baseline[setting] = baseline
+
+		for _, cluster := range clusters {
+			if value, ok := cluster.settings[setting]; ok && value != baseline {
+				group.Outliers = append(group.Outliers, DriftOutlier{cluster.num, cluster.uuid, setting, value, baseline})
+			}
+		}
+	}
+
+	return group
+}
+
+func (r *DriftReport) String() string {
+	outliers := 0
+	clusters := make(map[int]bool)
+	for _, group := range r.Groups {
+		outliers = outliers + len(group.Outliers)
+		for _, outlier := range group.Outliers {
+			clusters[outlier.ClusterNum] = true
+		}
+	}
+	summary := fmt.Sprintf("%d settings differ from their %s group in %d clusters", outliers, r.Label, len(clusters))
+	if len(r.SkippedBusy) > 0 {
+		summary = summary + fmt.Sprintf(" (%d busy clusters not compared)", len(r.SkippedBusy))
+	}
+	return summary
+}
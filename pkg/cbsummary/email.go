@@ -0,0 +1,148 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// email delivery - after each run the report is mailed to the --email-to
+// recipients through the --smtp-server, with the HTML report as the body and
+// the JSON report attached, so that people who need the report don't need
+// access to the host collecting it
+//
+// The connection is upgraded with STARTTLS when the server offers it, which
+// it must if --smtp-auth credentials are to be sent.
+//
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+type EmailOptions struct {
+	Server string // host:port
+	From   string
+	To     []string
+	Auth   *BasicAuth // nil to send without authenticating
+}
+
+// the sender to use when none is given
+func DefaultEmailSender() string {
+	host, err := os.Hostname()
+	if err != nil || len(host) == 0 {
+		host = "localhost"
+	}
+	return "cbsummary@" + host
+}
+
+// the server address, on the standard SMTP port unless it gives one
+func SMTPAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(server, "25")
+	}
+	return server
+}
+
+// split a comma-separated list of recipients
+func ParseRecipients(list string) []string {
+	recipients := make([]string, 0)
+	for _, recipient := range strings.Split(list, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if len(recipient) > 0 {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// mail the report, as HTML with the JSON attached
+func EmailReport(options *EmailOptions, clusterSummary *SummaryInfo, history []HistoryRecord) error {
+	htmlBody, err := FormatReport(clusterSummary, ReportOptions{Format: FORMAT_HTML, History: history})
+	if err != nil {
+		return err
+	}
+	jsonBody, err := FormatReport(clusterSummary, ReportOptions{Format: FORMAT_JSON})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	subject := fmt.Sprintf("Couchbase summary report: %d clusters, %d nodes",
+		clusterSummary.NumClusters, clusterSummary.TotalNumNodes)
+	attachment := "cbsummary-" + now.Format("2006-01-02-150405") + ".json"
+
+	var msg bytes.Buffer
+	parts := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", options.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(options.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", parts.Boundary())
+
+	err = writeMailPart(parts, textproto.MIMEHeader{
+		"Content-Type": {"text/html; charset=utf-8"},
+	}, htmlBody)
+	if err != nil {
+		return err
+	}
+	err = writeMailPart(parts, textproto.MIMEHeader{
+		"Content-Type":        {"application/json"},
+		"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": attachment})},
+	}, jsonBody)
+	if err != nil {
+		return err
+	}
+	err = parts.Close()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if options.Auth != nil {
+		host, _, _ := net.SplitHostPort(options.Server)
+		auth = smtp.PlainAuth("", options.Auth.User, options.Auth.Password, host)
+	}
+	err = smtp.SendMail(options.Server, auth, options.From, options.To, msg.Bytes())
+	if err != nil {
+		return fmt.Errorf("Error sending report to %s via %s: %v", strings.Join(options.To, ", "), options.Server, err)
+	}
+	LogInfo("Emailed report to %s.", strings.Join(options.To, ", "))
+	return nil
+}
+
+// add a base64-encoded part to a message
+func writeMailPart(parts *multipart.Writer, header textproto.MIMEHeader, body []byte) error {
+	header.Set("Content-Transfer-Encoding", "base64")
+	part, err := parts.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	// with the lines no longer than 76 characters, as RFC 2045 requires
+	encoded := base64.StdEncoding.EncodeToString(body)
+	for len(encoded) > 76 {
+		_, err = part.Write([]byte(encoded[:76] + "\r\n"))
+		if err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = part.Write([]byte(encoded + "\r\n"))
+	return err
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// report encryption - reports hold topology details that should be protected
+// at rest, so --encrypt-key encrypts each report written or uploaded to the
+// public key in a file, which is either:
+//
+//   - one or more age recipients ("age1..."), one per line, as printed by
+//     age-keygen; the report is written in the age v1 format, readable with
+//     'age --decrypt -i <identity file>', and named *.age
+//   - a PGP public key, armored or binary, as exported by 'gpg --export'; the
+//     report is written as an OpenPGP message, readable with 'gpg --decrypt',
+//     and named *.gpg
+//
+// --compress gzips the report first (*.gz), so an encrypted, compressed JSON
+// report is named e.g. cbsummary.out.<timestamp>.gz.age.
+//
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+const (
+	SUFFIX_GZIP = ".gz"
+	SUFFIX_AGE  = ".age"
+	SUFFIX_PGP  = ".gpg"
+)
+
+// the suffixes a report's name may have after its timestamp
+var REPORT_SUFFIXES = []string{SUFFIX_GZIP, SUFFIX_AGE, SUFFIX_PGP}
+
+type ReportEncrypter interface {
+	Encrypt(body []byte) ([]byte, error)
+
+	// the suffix for the names of reports it encrypts
+	Suffix() string
+}
+
+// read the public key to encrypt reports to
+func LoadEncryptKey(file string) (ReportEncrypter, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading encryption key %s: %v", file, err)
+	}
+
+	if text := strings.TrimSpace(string(body)); strings.HasPrefix(text, "age1") || strings.HasPrefix(text, "#") {
+		encrypter, err := parseAgeRecipients(text)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing age recipients in %s: %v", file, err)
+		}
+		return encrypter, nil
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(body))
+	if err != nil {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading encryption key %s, it should hold age recipients or a PGP public key: %v",
+			file, err)
+	}
+
+	// find out now, not after collecting, if the key can't be encrypted to
+	encrypter := &pgpEncrypter{keys}
+	if _, err = encrypter.Encrypt(nil); err != nil {
+		return nil, fmt.Errorf("Error using encryption key %s: %v", file, err)
+	}
+	return encrypter, nil
+}
+
+// gzip, then encrypt, the report as asked, returning it with the suffix for its name
+func sealReport(body []byte, options ReportOptions) ([]byte, string, error) {
+	suffix := ""
+	if options.Compress {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		_, err := writer.Write(body)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("Error compressing report: %v", err)
+		}
+		body = buffer.Bytes()
+		suffix = SUFFIX_GZIP
+	}
+
+	if options.Encrypt != nil {
+		var err error
+		body, err = options.Encrypt.Encrypt(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error encrypting report: %v", err)
+		}
+		suffix = suffix + options.Encrypt.Suffix()
+	}
+	return body, suffix, nil
+}
+
+// the name with the suffix, unless it has it already
+func withSuffix(name, suffix string) string {
+	if strings.HasSuffix(name, suffix) {
+		return name
+	}
+	return name + suffix
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type pgpEncrypter struct {
+	keys openpgp.EntityList
+}
+
+func (e *pgpEncrypter) Encrypt(body []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := openpgp.Encrypt(&buffer, e.keys, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(body)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (e *pgpEncrypter) Suffix() string {
+	return SUFFIX_PGP
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// age (https://age-encryption.org) X25519 recipients
+
+type ageEncrypter struct {
+	recipients []age.Recipient
+}
+
+func parseAgeRecipients(text string) (*ageEncrypter, error) {
+	recipients, err := age.ParseRecipients(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	return &ageEncrypter{recipients}, nil
+}
+
+func (e *ageEncrypter) Suffix() string {
+	return SUFFIX_AGE
+}
+
+func (e *ageEncrypter) Encrypt(body []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := age.Encrypt(&buffer, e.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(body)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
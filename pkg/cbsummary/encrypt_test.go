@@ -0,0 +1,199 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const testReport = `{"#clusters": 1, "#nodes": 2}`
+
+func writeTestKey(t *testing.T, name string, body []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, body, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newAgeIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func ageDecrypt(t *testing.T, body []byte, identity age.Identity) string {
+	t.Helper()
+	r, err := age.Decrypt(bytes.NewReader(body), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(plain)
+}
+
+func TestAgeEncrypter(t *testing.T) {
+	alice, bob := newAgeIdentity(t), newAgeIdentity(t)
+	file := writeTestKey(t, "recipients.txt", []byte("# the report readers\n"+alice.Recipient().String()+"\n\n"+
+		bob.Recipient().String()+"\n"))
+
+	encrypter, err := LoadEncryptKey(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypter.Suffix() != SUFFIX_AGE {
+		t.Errorf("suffix %s, want %s", encrypter.Suffix(), SUFFIX_AGE)
+	}
+	body, err := encrypter.Encrypt([]byte(testReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(body, []byte("age-encryption.org/v1\n")) {
+		t.Errorf("doesn't start with the age v1 header: %q", body[:min(len(body), 40)])
+	}
+
+	// every recipient can read it, and no one else
+	for _, identity := range []*age.X25519Identity{alice, bob} {
+		if plain := ageDecrypt(t, body, identity); plain != testReport {
+			t.Errorf("decrypted %q, want %q", plain, testReport)
+		}
+	}
+	if _, err := age.Decrypt(bytes.NewReader(body), newAgeIdentity(t)); err == nil {
+		t.Errorf("decrypted with an identity that isn't a recipient")
+	}
+
+	// a report bigger than one 64 KiB chunk of the payload
+	large := strings.Repeat(testReport, 5000)
+	body, err = encrypter.Encrypt([]byte(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain := ageDecrypt(t, body, alice); plain != large {
+		t.Errorf("decrypted %d bytes, want %d", len(plain), len(large))
+	}
+}
+
+func TestAgeRecipientErrors(t *testing.T) {
+	for _, text := range []string{
+		"age1notarecipient",
+		"# no recipients at all",
+		newAgeIdentity(t).String(), // the identity rather than its recipient
+	} {
+		if _, err := parseAgeRecipients(text); err == nil {
+			t.Errorf("parsed %q", text)
+		}
+	}
+}
+
+func TestPGPEncrypter(t *testing.T) {
+	entity, err := openpgp.NewEntity("cbsummary test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var binary, armored bytes.Buffer
+	if err := entity.Serialize(&binary); err != nil {
+		t.Fatal(err)
+	}
+	w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	for name, key := range map[string][]byte{"key.gpg": binary.Bytes(), "key.asc": armored.Bytes()} {
+		encrypter, err := LoadEncryptKey(writeTestKey(t, name, key))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if encrypter.Suffix() != SUFFIX_PGP {
+			t.Errorf("%s: suffix %s, want %s", name, encrypter.Suffix(), SUFFIX_PGP)
+		}
+		body, err := encrypter.Encrypt([]byte(testReport))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		message, err := openpgp.ReadMessage(bytes.NewReader(body), openpgp.EntityList{entity}, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		plain, err := io.ReadAll(message.UnverifiedBody)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(plain) != testReport {
+			t.Errorf("%s: decrypted %q, want %q", name, plain, testReport)
+		}
+	}
+}
+
+func TestLoadEncryptKeyErrors(t *testing.T) {
+	if _, err := LoadEncryptKey(writeTestKey(t, "key.txt", []byte("not a key"))); err == nil {
+		t.Errorf("loaded a key from a file holding neither age recipients nor a PGP key")
+	}
+	if _, err := LoadEncryptKey(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("loaded a key from a missing file")
+	}
+}
+
+func TestSealReport(t *testing.T) {
+	identity := newAgeIdentity(t)
+	encrypter, err := parseAgeRecipients(identity.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, suffix, err := sealReport([]byte(testReport), ReportOptions{Compress: true, Encrypt: encrypter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suffix != SUFFIX_GZIP+SUFFIX_AGE {
+		t.Errorf("suffix %s, want %s", suffix, SUFFIX_GZIP+SUFFIX_AGE)
+	}
+	r, err := gzip.NewReader(strings.NewReader(ageDecrypt(t, body, identity)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != testReport {
+		t.Errorf("unsealed %q, want %q", plain, testReport)
+	}
+
+	if name := withSuffix("report.json", suffix); name != "report.json.gz.age" {
+		t.Errorf("named %s, want report.json.gz.age", name)
+	}
+	if name := withSuffix("report.json.gz.age", suffix); name != "report.json.gz.age" {
+		t.Errorf("named %s, want report.json.gz.age", name)
+	}
+}
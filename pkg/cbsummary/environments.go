@@ -0,0 +1,188 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// environment rollups - the fleet's totals broken down by the environment,
+// or other group, each cluster is assigned in the config, e.g.
+//
+//   {"login": "...", "pass": "...", "nodes": [...], "environment": "prod"}
+//
+// For each environment the report gives the clusters collected and those that
+// failed, and their nodes, cores and RAM, in total and for each edition, so
+// Enterprise and Community use can be told apart. Clusters with no
+// environment are counted under "unassigned", and the totals across all the
+// environments are given too.
+//
+// The rollups are only added when some cluster in the config has an
+// environment.
+//
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const ENVIRONMENT_UNASSIGNED = "unassigned"
+
+type EnvironmentRollup struct {
+	Environments map[string]*EnvironmentTotals `json:"environments"`
+	Total        *EnvironmentTotals            `json:"total"`
+}
+
+type EnvironmentTotals struct {
+	Clusters []int   `json:"clusters"`
+	Failed   []int   `json:"failed_clusters"`
+	Nodes    int     `json:"nodes"`
+	Cores    float64 `json:"cores"`
+	RAM      float64 `json:"ram_gb"`
+
+	// the totals for each edition, enterprise, community or unknown
+	Editions map[string]*EditionTotals `json:"editions"`
+}
+
+func NewEnvironmentRollup() *EnvironmentRollup {
+	return &EnvironmentRollup{
+		Environments: make(map[string]*EnvironmentTotals),
+		Total:        newEnvironmentTotals(),
+	}
+}
+
+func newEnvironmentTotals() *EnvironmentTotals {
+	return &EnvironmentTotals{
+		Clusters: make([]int, 0),
+		Failed:   make([]int, 0),
+		Editions: make(map[string]*EditionTotals),
+	}
+}
+
+// whether any of the clusters has an environment
+func hasEnvironments(clusters []Cluster) bool {
+	for _, cluster := range clusters {
+		if len(cluster.Environment) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *EnvironmentRollup) environment(name string) *EnvironmentTotals {
+	if len(name) == 0 {
+		name = ENVIRONMENT_UNASSIGNED
+	}
+	totals, ok := r.Environments[name]
+	if !ok {
+		totals = newEnvironmentTotals()
+		r.Environments[name] = totals
+	}
+	return totals
+}
+
+// add a collected cluster's nodes to its environment
+func (r *EnvironmentRollup) AddCluster(cnum int, environment string, nodes []NodeInfo) {
+	for _, totals := range []*EnvironmentTotals{r.environment(environment), r.Total} {
+		totals.Clusters = append(totals.Clusters, cnum)
+		for _, nodeInfo := range nodes {
+			cores := nodeInfo.SystemStats.CPU_cores_available
+			ram := nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+			totals.Nodes = totals.Nodes + 1
+			totals.Cores = totals.Cores + cores
+			totals.RAM = totals.RAM + ram
+
+			edition := totals.edition(nodeEdition(nodeInfo.Version))
+			edition.Nodes = edition.Nodes + 1
+			edition.Cores = edition.Cores + cores
+			edition.RAM = edition.RAM + ram
+		}
+		sort.Ints(totals.Clusters)
+	}
+}
+
+// count a cluster that couldn't be collected in its environment
+func (r *EnvironmentRollup) AddFailed(cnum int, environment string) {
+	for _, totals := range []*EnvironmentTotals{r.environment(environment), r.Total} {
+		totals.Failed = append(totals.Failed, cnum)
+		sort.Ints(totals.Failed)
+	}
+}
+
+func (t *EnvironmentTotals) edition(name string) *EditionTotals {
+	totals, ok := t.Editions[name]
+	if !ok {
+		totals = &EditionTotals{}
+		t.Editions[name] = totals
+	}
+	return totals
+}
+
+// add in another environment's totals, whose clusters follow offset clusters
+func (t *EnvironmentTotals) merge(other *EnvironmentTotals, offset int) {
+	for _, cnum := range other.Clusters {
+		t.Clusters = append(t.Clusters, cnum+offset)
+	}
+	for _, cnum := range other.Failed {
+		t.Failed = append(t.Failed, cnum+offset)
+	}
+	t.Nodes = t.Nodes + other.Nodes
+	t.Cores = t.Cores + other.Cores
+	t.RAM = t.RAM + other.RAM
+	for name, totals := range other.Editions {
+		edition := t.edition(name)
+		edition.Nodes = edition.Nodes + totals.Nodes
+		edition.Cores = edition.Cores + totals.Cores
+		edition.RAM = edition.RAM + totals.RAM
+	}
+}
+
+// add in another report's rollup, whose clusters follow offset clusters
+func (r *EnvironmentRollup) Merge(other *EnvironmentRollup, offset int) {
+	for name, totals := range other.Environments {
+		r.environment(name).merge(totals, offset)
+	}
+	if other.Total != nil {
+		r.Total.merge(other.Total, offset)
+	}
+}
+
+// the environments' names, in order
+func (r *EnvironmentRollup) Names() []string {
+	names := make([]string, 0, len(r.Environments))
+	for name := range r.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// e.g. "dev 1 clusters, 2 nodes, 8 cores; prod 3 clusters, 12 nodes, 96 cores"
+func (r *EnvironmentRollup) String() string {
+	parts := make([]string, 0, len(r.Environments))
+	for _, name := range r.Names() {
+		totals := r.Environments[name]
+		parts = append(parts, fmt.Sprintf("%s %d clusters, %d nodes, %.0f cores", name, len(totals.Clusters),
+			totals.Nodes, totals.Cores))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// the environment of a cluster in a report, as given in the config
+func clusterEnvironment(icluster interface{}) string {
+	switch c := icluster.(type) {
+	case *BriefCluster:
+		return c.Environment
+	case *ClusterSummary:
+		return c.Environment
+	case *ClusterError:
+		return c.TheCluster.Environment
+	}
+	return ""
+}
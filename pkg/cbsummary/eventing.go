@@ -0,0 +1,188 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// eventing function statistics, from /api/v1/stats on the eventing service
+//
+// The eventing service aggregates the stats of all its nodes, so they are
+// fetched from the first eventing node that answers. For each deployed
+// function we keep the DCP backlog, the timer counters and the failure
+// counters, which are what show a function falling behind.
+//
+// The full report also lists the functions from /api/v1/functions, with their
+// status from /api/v1/status.
+//
+
+import (
+	"strings"
+)
+
+type EventingStats struct {
+	Functions []EventingFunctionStats `json:"functions"`
+	Error     string                  `json:"error,omitempty"`
+}
+
+type EventingFunctionStats struct {
+	Name          string             `json:"name"`
+	DcpBacklog    float64            `json:"dcp_backlog"`
+	Timers        map[string]float64 `json:"timers,omitempty"`
+	Failures      map[string]float64 `json:"failures,omitempty"`
+	TotalFailures float64            `json:"total_failures"`
+}
+
+// the per-function entries returned by /api/v1/stats
+type eventingStatsEntry struct {
+	FunctionName    string                 `json:"function_name"`
+	EventsRemaining map[string]interface{} `json:"events_remaining"`
+	ExecutionStats  map[string]interface{} `json:"execution_stats"`
+	FailureStats    map[string]interface{} `json:"failure_stats"`
+}
+
+func CollectEventingStats(conn *ClusterConn) *EventingStats {
+	stats := &EventingStats{Functions: make([]EventingFunctionStats, 0)}
+
+	clients, err := conn.ServiceClients("eventing")
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+
+	var entries []eventingStatsEntry
+	for _, eventingClient := range clients {
+		err = eventingClient.getJSON("/api/v1/stats", &entries)
+		if err == nil {
+			break
+		}
+		LogError("Error getting eventing stats from %s: %v", eventingClient.host, err)
+	}
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+
+	for _, entry := range entries {
+		function := EventingFunctionStats{
+			Name:       entry.FunctionName,
+			DcpBacklog: statValue(entry.EventsRemaining, "dcp_backlog"),
+			Timers:     make(map[string]float64),
+			Failures:   make(map[string]float64),
+		}
+
+		for key := range entry.ExecutionStats {
+			if strings.Contains(key, "timer") {
+				function.Timers[key] = statValue(entry.ExecutionStats, key)
+			}
+		}
+		for key := range entry.FailureStats {
+			value := statValue(entry.FailureStats, key)
+			function.Failures[key] = value
+			function.TotalFailures = function.TotalFailures + value
+		}
+
+		stats.Functions = append(stats.Functions, function)
+	}
+
+	return stats
+}
+
+// the deployed eventing functions, for the full report: where each one reads
+// from and keeps its metadata, its status, and its DCP backlog
+type EventingSummary struct {
+	Functions []EventingFunction `json:"functions"`
+	Error     string             `json:"error,omitempty"`
+}
+
+type EventingFunction struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Source     string  `json:"source"`
+	Metadata   string  `json:"metadata"`
+	DcpBacklog float64 `json:"dcp_backlog"`
+}
+
+// a function definition from /api/v1/functions
+type eventingFunctionDef struct {
+	AppName string `json:"appname"`
+	DepCfg  struct {
+		SourceBucket       string `json:"source_bucket"`
+		SourceScope        string `json:"source_scope"`
+		SourceCollection   string `json:"source_collection"`
+		MetadataBucket     string `json:"metadata_bucket"`
+		MetadataScope      string `json:"metadata_scope"`
+		MetadataCollection string `json:"metadata_collection"`
+	} `json:"depcfg"`
+}
+
+// the response from /api/v1/status
+type eventingStatus struct {
+	Apps []struct {
+		Name            string `json:"name"`
+		CompositeStatus string `json:"composite_status"`
+	} `json:"apps"`
+}
+
+func CollectEventingFunctions(conn *ClusterConn) *EventingSummary {
+	summary := &EventingSummary{Functions: make([]EventingFunction, 0)}
+
+	clients, err := conn.ServiceClients("eventing")
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+
+	var defs []eventingFunctionDef
+	var status eventingStatus
+	for _, eventingClient := range clients {
+		err = eventingClient.getJSON("/api/v1/functions", &defs)
+		if err == nil {
+			err = eventingClient.getJSON("/api/v1/status", &status)
+		}
+		if err == nil {
+			break
+		}
+		LogError("Error getting eventing functions from %s: %v", eventingClient.host, err)
+	}
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+
+	statuses := make(map[string]string)
+	for _, app := range status.Apps {
+		statuses[app.Name] = app.CompositeStatus
+	}
+	backlogs := make(map[string]float64)
+	if stats := CollectEventingStats(conn); len(stats.Error) == 0 {
+		for _, function := range stats.Functions {
+			backlogs[function.Name] = function.DcpBacklog
+		}
+	}
+
+	for _, def := range defs {
+		summary.Functions = append(summary.Functions, EventingFunction{
+			Name:       def.AppName,
+			Status:     statuses[def.AppName],
+			Source:     keyspaceName(def.DepCfg.SourceBucket, def.DepCfg.SourceScope, def.DepCfg.SourceCollection),
+			Metadata:   keyspaceName(def.DepCfg.MetadataBucket, def.DepCfg.MetadataScope, def.DepCfg.MetadataCollection),
+			DcpBacklog: backlogs[def.AppName],
+		})
+	}
+	return summary
+}
+
+// bucket.scope.collection, or just the bucket for its default collection
+func keyspaceName(bucket, scope, collection string) string {
+	if (len(scope) == 0 || scope == "_default") && (len(collection) == 0 || collection == "_default") {
+		return bucket
+	}
+	return bucket + "." + scope + "." + collection
+}
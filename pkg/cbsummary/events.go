@@ -0,0 +1,126 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// recent cluster events for the full report, from the UI event log at /logs,
+// so the operational history of a cluster accompanies its inventory
+//
+// With --events=<n>, the n most recent log entries are read and those that
+// record failovers, auto-failovers and nodes joining or leaving the cluster
+// are kept, newest first. The log entries are free text, so they're told
+// apart by their module and wording:
+//
+//   auto-failover  the auto_failover module, or "automatically failed over"
+//   failover       "failed over", "failing over" or "failover"
+//   node-added     "joined cluster" or "added to cluster"
+//   node-removed   "left cluster", "ejected" or "removed from cluster"
+//
+// Entries about settings, such as the auto-failover settings changing, aren't
+// events.
+//
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EVENT_AUTO_FAILOVER = "auto-failover"
+	EVENT_FAILOVER      = "failover"
+	EVENT_NODE_ADDED    = "node-added"
+	EVENT_NODE_REMOVED  = "node-removed"
+)
+
+type ClusterEvents struct {
+	// how many of the most recent log entries were looked at
+	Scanned int            `json:"scanned"`
+	Events  []ClusterEvent `json:"events"`
+	Counts  map[string]int `json:"counts"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type ClusterEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Node    string    `json:"node,omitempty"`
+	Module  string    `json:"module,omitempty"`
+	Message string    `json:"message"`
+}
+
+// the response from /logs
+type logEntries struct {
+	List []struct {
+		Node      string  `json:"node"`
+		Module    string  `json:"module"`
+		Type      string  `json:"type"`
+		Tstamp    float64 `json:"tstamp"`
+		ShortText string  `json:"shortText"`
+		Text      string  `json:"text"`
+	} `json:"list"`
+}
+
+func CollectClusterEvents(conn *ClusterConn, limit int) *ClusterEvents {
+	events := &ClusterEvents{Events: make([]ClusterEvent, 0), Counts: make(map[string]int)}
+
+	var logs logEntries
+	err := conn.Client.getJSON("/logs", &logs)
+	if err != nil {
+		events.Error = err.Error()
+		return events
+	}
+
+	// newest first, then only the most recent
+	entries := logs.List
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Tstamp > entries[j].Tstamp })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	events.Scanned = len(entries)
+
+	for _, entry := range entries {
+		kind := eventKind(entry.Module, entry.Text)
+		if len(kind) == 0 {
+			continue
+		}
+		events.Events = append(events.Events, ClusterEvent{
+			Time:    time.UnixMilli(int64(entry.Tstamp)).UTC(),
+			Kind:    kind,
+			Node:    entry.Node,
+			Module:  entry.Module,
+			Message: strings.TrimSpace(entry.Text),
+		})
+		events.Counts[kind]++
+	}
+	return events
+}
+
+// the kind of event a log entry records, or "" if it isn't one we keep
+func eventKind(module, text string) string {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "settings"):
+		// e.g. "Updated auto-failover settings", which is configuration
+		return ""
+	case module == "auto_failover" || strings.Contains(text, "automatically failed over"):
+		return EVENT_AUTO_FAILOVER
+	case strings.Contains(text, "failed over") || strings.Contains(text, "failing over") ||
+		strings.Contains(text, "failover"):
+		return EVENT_FAILOVER
+	case strings.Contains(text, "joined cluster") || strings.Contains(text, "added to cluster"):
+		return EVENT_NODE_ADDED
+	case strings.Contains(text, "left cluster") || strings.Contains(text, "ejected") ||
+		strings.Contains(text, "removed from cluster"):
+		return EVENT_NODE_REMOVED
+	}
+	return ""
+}
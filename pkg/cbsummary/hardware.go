@@ -0,0 +1,107 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// hardware inventory - the CPUs and memory of each node, from /nodes/self
+//
+// ns_server reports the logical CPU count (threads), the cores available to
+// the server process (less than the threads when limited by a container) and
+// the total memory. It doesn't report the CPU model; the closest it has is the
+// build target in the "os" field, e.g. "x86_64-pc-linux-gnu", which is parsed
+// into the platform and CPU architecture.
+//
+
+import (
+	"strings"
+)
+
+type HardwareInventory struct {
+	Nodes          []NodeHardware `json:"nodes"`
+	Threads        float64        `json:"total_threads"`
+	CoresAvailable float64        `json:"total_cores_available"`
+	MemoryGB       float64        `json:"total_memory_gb"`
+	Error          string         `json:"error,omitempty"`
+}
+
+type NodeHardware struct {
+	Hostname       string  `json:"hostname"`
+	OS             string  `json:"os,omitempty"`
+	Platform       string  `json:"platform,omitempty"`
+	Arch           string  `json:"arch,omitempty"`
+	Threads        float64 `json:"threads"`
+	CoresAvailable float64 `json:"cores_available"`
+	MemoryGB       float64 `json:"memory_gb"`
+	Error          string  `json:"error,omitempty"`
+}
+
+func CollectHardwareInventory(conn *ClusterConn) *HardwareInventory {
+	inventory := &HardwareInventory{Nodes: make([]NodeHardware, 0)}
+
+	clients, err := conn.ServiceClients("mgmt")
+	if err != nil {
+		inventory.Error = err.Error()
+		return inventory
+	}
+
+	for _, nodeClient := range clients {
+		node := NodeHardware{Hostname: nodeClient.host}
+
+		self, err := nodeClient.GetNodeSelf()
+		if err != nil {
+			LogError("Error getting hardware details from %s: %v", nodeClient.host, err)
+			node.Error = err.Error()
+			inventory.Nodes = append(inventory.Nodes, node)
+			continue
+		}
+
+		node.Hostname = self.Hostname
+		node.OS = self.OS
+		node.Platform, node.Arch = ParseNodeOS(self.OS)
+		node.Threads = self.CpuCount
+		node.CoresAvailable = self.SystemStats.CPU_cores_available
+		node.MemoryGB = self.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+
+		inventory.Threads = inventory.Threads + node.Threads
+		inventory.CoresAvailable = inventory.CoresAvailable + node.CoresAvailable
+		inventory.MemoryGB = inventory.MemoryGB + node.MemoryGB
+		inventory.Nodes = append(inventory.Nodes, node)
+	}
+
+	return inventory
+}
+
+// the platform and CPU architecture from a node's "os" string, which is the
+// target the server was built for, e.g. "x86_64-pc-linux-gnu" or "win64"
+func ParseNodeOS(os string) (platform, arch string) {
+	lower := strings.ToLower(os)
+	switch {
+	case strings.Contains(lower, "linux"):
+		platform = "linux"
+	case strings.Contains(lower, "darwin") || strings.Contains(lower, "apple"):
+		platform = "macos"
+	case strings.HasPrefix(lower, "win") || strings.Contains(lower, "windows") || strings.Contains(lower, "mingw"):
+		platform = "windows"
+	}
+
+	switch {
+	case strings.HasPrefix(lower, "x86_64") || strings.HasPrefix(lower, "amd64") || lower == "win64":
+		arch = "x86_64"
+	case strings.HasPrefix(lower, "aarch64") || strings.HasPrefix(lower, "arm64"):
+		arch = "aarch64"
+	case len(platform) > 0:
+		// the first part of a target triple is the architecture
+		if idx := strings.Index(lower, "-"); idx > 0 {
+			arch = lower[:idx]
+		}
+	}
+	return platform, arch
+}
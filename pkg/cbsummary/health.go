@@ -0,0 +1,327 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// health checks - 'cbsummary check' evaluates the collected clusters against
+// a set of built-in rules and lists what it finds, each with a severity:
+//
+//   unreachable        critical  the cluster couldn't be collected
+//   node-unhealthy     critical  a node's status isn't "healthy", or it isn't
+//                                an active member of the cluster
+//   version-skew       critical  the nodes span more release lines (e.g. 7.1
+//                                and 7.2) than max_version_skew allows
+//   mixed-versions     warning   the cluster's nodes run different versions
+//   rebalance          warning   the rebalance status isn't "none"
+//   unbalanced         warning   the cluster needs a rebalance
+//   swap-in-use        warning   a node is using swap
+//   cpu-utilization    warning   a node's CPU is busier than max_cpu_percent
+//   disk-free          warning   less than min_free_disk_percent of the
+//                                cluster's disk is free
+//   bucket-quota       warning   a bucket is using more than
+//                                max_bucket_quota_percent of its RAM quota
+//   service-quota      warning   the index, search, analytics or eventing
+//                                service is using more than
+//                                max_service_quota_percent of its memory
+//                                quota on a node
+//
+// The thresholds can be overridden, and rules turned off, from a JSON or YAML
+// file given with --health-thresholds, e.g.
+//
+//   {"max_cpu_percent": 80, "max_version_skew": 0, "disabled_rules": ["swap-in-use"]}
+//
+// 'cbsummary check' exits with EXIT_CHECK_FAILED if there are any critical
+// findings; warnings alone don't fail the check.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	SEVERITY_CRITICAL = "critical"
+	SEVERITY_WARNING  = "warning"
+)
+
+type Finding struct {
+	Severity   string `json:"severity"`
+	Rule       string `json:"rule"`
+	ClusterNum int    `json:"cluster_num"`
+	Cluster    string `json:"cluster,omitempty"`
+	Node       string `json:"node,omitempty"`
+	Message    string `json:"message"`
+}
+
+// the limits the rules check against
+type HealthThresholds struct {
+	MaxBucketQuotaPercent  float64  `json:"max_bucket_quota_percent"`
+	MaxServiceQuotaPercent float64  `json:"max_service_quota_percent"`
+	MaxCPUPercent          float64  `json:"max_cpu_percent"`
+	MinFreeDiskPercent     float64  `json:"min_free_disk_percent"`
+	MaxVersionSkew         int      `json:"max_version_skew"`
+	DisabledRules          []string `json:"disabled_rules,omitempty"`
+}
+
+var DEFAULT_HEALTH_THRESHOLDS = HealthThresholds{
+	MaxBucketQuotaPercent:  90,
+	MaxServiceQuotaPercent: 90,
+	MaxCPUPercent:          90,
+	MinFreeDiskPercent:     10,
+	MaxVersionSkew:         1,
+}
+
+var HEALTH_RULES = []string{"unreachable", "node-unhealthy", "version-skew", "mixed-versions", "rebalance",
+	"unbalanced", "swap-in-use", "cpu-utilization", "disk-free", "bucket-quota", "service-quota"}
+
+// read the thresholds from a JSON or YAML file, keeping the defaults for any
+// it doesn't give
+func LoadHealthThresholds(file string) (HealthThresholds, error) {
+	thresholds := DEFAULT_HEALTH_THRESHOLDS
+	format, err := ConfigFormat(file, "")
+	if err != nil {
+		return thresholds, err
+	}
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return thresholds, fmt.Errorf("Error reading health thresholds %s: %v", file, err)
+	}
+	if format == CONFIG_FORMAT_YAML {
+		body, err = yamlToJSON(body)
+		if err != nil {
+			return thresholds, fmt.Errorf("Error parsing health thresholds %s: %v", file, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&thresholds)
+	if err != nil {
+		return thresholds, fmt.Errorf("Error parsing health thresholds %s: %v", file, err)
+	}
+	for _, rule := range thresholds.DisabledRules {
+		known := false
+		for _, r := range HEALTH_RULES {
+			known = known || r == rule
+		}
+		if !known {
+			return thresholds, fmt.Errorf("Unknown rule '%s' in health thresholds %s, expected one of %s", rule, file,
+				strings.Join(HEALTH_RULES, ", "))
+		}
+	}
+	if thresholds.MaxVersionSkew < 0 {
+		return thresholds, fmt.Errorf("max_version_skew in health thresholds %s must not be negative", file)
+	}
+	return thresholds, nil
+}
+
+func (t HealthThresholds) enabled(rule string) bool {
+	for _, r := range t.DisabledRules {
+		if r == rule {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate the rules against every cluster in the summary
+func CheckHealth(clusterSummary *SummaryInfo, thresholds HealthThresholds) []Finding {
+	findings := make([]Finding, 0)
+	for cnum, icluster := range clusterSummary.Clusters {
+		add := func(severity, rule, cluster, node, format string, args ...interface{}) {
+			if !thresholds.enabled(rule) {
+				return
+			}
+			findings = append(findings, Finding{severity, rule, cnum, cluster, node, fmt.Sprintf(format, args...)})
+		}
+
+		switch c := icluster.(type) {
+		case *ClusterError:
+			name := c.TheCluster.Label
+			if len(name) == 0 {
+				name = strings.Join(c.TheCluster.Nodes, ",")
+			}
+			add(SEVERITY_CRITICAL, "unreachable", name, "", "the cluster could not be collected: %s", c.ErrMsg)
+
+		case *BriefCluster:
+			name := c.Label
+			if len(name) == 0 {
+				name = c.UUID
+			}
+			versions := make([]string, 0, len(c.Nodes))
+			for _, node := range c.Nodes {
+				versions = append(versions, node.Version)
+			}
+			checkVersions(versions, thresholds, func(severity, rule, message string) {
+				add(severity, rule, name, "", "%s", message)
+			})
+
+		case *ClusterSummary:
+			name := c.Label
+			if len(name) == 0 {
+				name = c.ClusterName
+			}
+
+			versions := make([]string, 0, len(c.Nodes))
+			for _, node := range c.Nodes {
+				versions = append(versions, node.Version)
+				if node.Status != "healthy" {
+					add(SEVERITY_CRITICAL, "node-unhealthy", name, node.Hostname, "the node's status is %s", node.Status)
+				} else if node.ClusterMembership != MEMBERSHIP_ACTIVE {
+					add(SEVERITY_CRITICAL, "node-unhealthy", name, node.Hostname, "the node's membership is %s",
+						node.ClusterMembership)
+				}
+				if node.SystemStats.Swap_used > 0 {
+					add(SEVERITY_WARNING, "swap-in-use", name, node.Hostname, "%.0f MB of swap in use",
+						node.SystemStats.Swap_used/1024/1024)
+				}
+				if node.SystemStats.Cpu_utilization_rate > thresholds.MaxCPUPercent {
+					add(SEVERITY_WARNING, "cpu-utilization", name, node.Hostname, "CPU utilization is %.0f%%",
+						node.SystemStats.Cpu_utilization_rate)
+				}
+			}
+			checkVersions(versions, thresholds, func(severity, rule, message string) {
+				add(severity, rule, name, "", "%s", message)
+			})
+
+			if doing := c.Tasks.Describe(); len(doing) > 0 && len(c.Tasks.Rebalances) > 0 {
+				add(SEVERITY_WARNING, "rebalance", name, "", "the cluster is mid-operation: %s", doing)
+			} else if len(c.RebalanceStatus) > 0 && c.RebalanceStatus != "none" {
+				add(SEVERITY_WARNING, "rebalance", name, "", "the rebalance status is %s", c.RebalanceStatus)
+			}
+			if !c.Balanced {
+				add(SEVERITY_WARNING, "unbalanced", name, "", "the cluster needs a rebalance")
+			}
+
+			hdd := c.StorageTotals.HDD
+			if hdd.Total > 0 && 100*hdd.Free/hdd.Total < thresholds.MinFreeDiskPercent {
+				add(SEVERITY_WARNING, "disk-free", name, "", "only %.1f%% of the disk is free (%.0f of %.0f GB)",
+					100*hdd.Free/hdd.Total, hdd.Free/1024/1024/1024, hdd.Total/1024/1024/1024)
+			}
+
+			if c.Buckets != nil {
+				for _, bucket := range c.Buckets.Buckets {
+					if bucket.QuotaUsed > thresholds.MaxBucketQuotaPercent {
+						add(SEVERITY_WARNING, "bucket-quota", name, "", "bucket %s is using %.0f%% of its RAM quota",
+							bucket.Name, bucket.QuotaUsed)
+					}
+				}
+			}
+
+			if c.ServiceLayout != nil {
+				for _, allocation := range c.ServiceLayout.Services {
+					for _, node := range allocation.NodeUsage {
+						if node.MemoryUsedPct > thresholds.MaxServiceQuotaPercent {
+							add(SEVERITY_WARNING, "service-quota", name, node.Host,
+								"the %s service is using %.0f%% of its memory quota (%.0f of %.0f MB)",
+								allocation.Service, node.MemoryUsedPct, node.MemoryUsedMB, node.MemoryQuotaMB)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// the most severe first, otherwise in cluster order
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity == SEVERITY_CRITICAL && findings[j].Severity != SEVERITY_CRITICAL
+	})
+	return findings
+}
+
+// findings for the versions of a cluster's nodes: too many release lines, or
+// otherwise a mix of versions
+func checkVersions(versions []string, thresholds HealthThresholds, add func(severity, rule, message string)) {
+	lines := make(map[string]int)
+	for _, version := range versions {
+		parts := append(ParseVersion(version), 0, 0)
+		lines[fmt.Sprintf("%d.%d", parts[0], parts[1])]++
+	}
+	if skew := len(lines) - 1; skew > thresholds.MaxVersionSkew {
+		add(SEVERITY_CRITICAL, "version-skew", fmt.Sprintf("nodes span %d release lines (%s), more than the %d allowed",
+			len(lines), strings.Join(sortedVersions(lines), ", "), thresholds.MaxVersionSkew+1))
+	} else if mixed := describeMixedVersions(versions); len(mixed) > 0 {
+		add(SEVERITY_WARNING, "mixed-versions", "nodes run "+mixed)
+	}
+}
+
+func sortedVersions(versions map[string]int) []string {
+	sorted := sortedKeys(versions)
+	sort.Slice(sorted, func(i, j int) bool { return CompareVersions(sorted[i], sorted[j]) < 0 })
+	return sorted
+}
+
+// the versions of a cluster's nodes if there's more than one, e.g.
+// "2 versions: 7.1.4-3601-enterprise (1 node), 7.2.4-7070-enterprise (3 nodes)"
+func describeMixedVersions(versions []string) string {
+	counts := make(map[string]int)
+	for _, version := range versions {
+		counts[version]++
+	}
+	if len(counts) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(counts))
+	for _, version := range sortedVersions(counts) {
+		nodes := "nodes"
+		if counts[version] == 1 {
+			nodes = "node"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%d %s)", version, counts[version], nodes))
+	}
+	return fmt.Sprintf("%d versions: %s", len(counts), strings.Join(parts, ", "))
+}
+
+// print the findings as a list
+func PrintFindings(findings []Finding, numClusters int) {
+	critical := 0
+	for _, finding := range findings {
+		if finding.Severity == SEVERITY_CRITICAL {
+			critical++
+		}
+	}
+	fmt.Fprintf(console, "Health check of %d clusters: %d critical, %d warnings.\n", numClusters, critical,
+		len(findings)-critical)
+
+	for _, finding := range findings {
+		where := finding.Cluster
+		if len(finding.Node) > 0 {
+			where = where + " " + finding.Node
+		}
+		fmt.Fprintf(console, "  %-8s  %-15s  cluster %d (%s): %s\n", strings.ToUpper(finding.Severity), finding.Rule,
+			finding.ClusterNum, where, finding.Message)
+	}
+}
+
+// whether any of the findings are critical, failing the check
+func HasCriticalFindings(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == SEVERITY_CRITICAL {
+			return true
+		}
+	}
+	return false
+}
+
+// check the health of the clusters in a JSON report, printing the findings
+func CheckReportHealth(file string, thresholds HealthThresholds) ([]Finding, error) {
+	clusterSummary, err := LoadReport(file)
+	if err != nil {
+		return nil, err
+	}
+	findings := CheckHealth(clusterSummary, thresholds)
+	PrintFindings(findings, len(clusterSummary.Clusters))
+	return findings, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const TEST_VERSION = "7.2.4-7070-enterprise"
+
+// a full cluster that breaks none of the rules
+func healthyCluster() *ClusterSummary {
+	node := func(host string) NodeInfo {
+		return NodeInfo{Hostname: host, Version: TEST_VERSION, Status: "healthy", ClusterMembership: MEMBERSHIP_ACTIVE,
+			SystemStats: SysStats{Cpu_utilization_rate: 20}}
+	}
+	c := &ClusterSummary{
+		ClusterName:     "cluster-a",
+		Balanced:        true,
+		RebalanceStatus: "none",
+		Nodes:           []NodeInfo{node("node1:8091"), node("node2:8091")},
+		StorageTotals:   ClusterStorageInfo{HDD: HDDStorageInfo{Total: 1000, Free: 500}},
+		Buckets:         &BucketInventory{Buckets: []BucketDetail{{Name: "travel", QuotaUsed: 50}}},
+		ServiceLayout: &ServiceLayout{Services: []ServiceAllocation{{Service: "index",
+			NodeUsage: []ServiceNodeUsage{{Host: "node1:8091", MemoryUsedMB: 400, MemoryQuotaMB: 1000, MemoryUsedPct: 40}}}}},
+	}
+	c.Label = "prod-eu"
+	return c
+}
+
+// the rule, severity and node of each finding
+func findingKeys(findings []Finding) []string {
+	keys := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		keys = append(keys, finding.Severity+" "+finding.Rule+" "+finding.Node)
+	}
+	return keys
+}
+
+func TestCheckHealthRules(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(c *ClusterSummary)
+		want   []string
+	}{
+		{"healthy", func(c *ClusterSummary) {}, []string{}},
+		{"node status", func(c *ClusterSummary) { c.Nodes[1].Status = "unhealthy" },
+			[]string{"critical node-unhealthy node2:8091"}},
+		{"node membership", func(c *ClusterSummary) { c.Nodes[0].ClusterMembership = "inactiveFailed" },
+			[]string{"critical node-unhealthy node1:8091"}},
+		{"version skew", func(c *ClusterSummary) {
+			c.Nodes = append(c.Nodes, c.Nodes[0], c.Nodes[0])
+			c.Nodes[1].Version = "7.1.4-3601-enterprise"
+			c.Nodes[2].Version = "7.0.5-7658-enterprise"
+		}, []string{"critical version-skew "}},
+		{"mixed versions", func(c *ClusterSummary) { c.Nodes[1].Version = "7.1.4-3601-enterprise" },
+			[]string{"warning mixed-versions "}},
+		{"rebalance status", func(c *ClusterSummary) { c.RebalanceStatus = "running" },
+			[]string{"warning rebalance "}},
+		{"rebalance task", func(c *ClusterSummary) {
+			c.Tasks = &ClusterTasks{Rebalances: []RunningTask{{Type: "rebalance", Status: "running"}}}
+		}, []string{"warning rebalance "}},
+		{"unbalanced", func(c *ClusterSummary) { c.Balanced = false }, []string{"warning unbalanced "}},
+		{"swap", func(c *ClusterSummary) { c.Nodes[0].SystemStats.Swap_used = 64 * 1024 * 1024 },
+			[]string{"warning swap-in-use node1:8091"}},
+		{"cpu", func(c *ClusterSummary) { c.Nodes[1].SystemStats.Cpu_utilization_rate = 95 },
+			[]string{"warning cpu-utilization node2:8091"}},
+		{"disk", func(c *ClusterSummary) { c.StorageTotals.HDD.Free = 50 }, []string{"warning disk-free "}},
+		{"bucket quota", func(c *ClusterSummary) { c.Buckets.Buckets[0].QuotaUsed = 95 },
+			[]string{"warning bucket-quota "}},
+		{"service quota", func(c *ClusterSummary) { c.ServiceLayout.Services[0].NodeUsage[0].MemoryUsedPct = 95 },
+			[]string{"warning service-quota node1:8091"}},
+		{"critical first", func(c *ClusterSummary) {
+			c.Balanced = false
+			c.Nodes[1].Status = "unhealthy"
+		}, []string{"critical node-unhealthy node2:8091", "warning unbalanced "}},
+	} {
+		cluster := healthyCluster()
+		test.change(cluster)
+		summary := &SummaryInfo{Clusters: []interface{}{cluster}}
+		findings := CheckHealth(summary, DEFAULT_HEALTH_THRESHOLDS)
+		if got := findingKeys(findings); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: found %q, want %q", test.name, got, test.want)
+		}
+		for _, finding := range findings {
+			if finding.Cluster != "prod-eu" || finding.ClusterNum != 0 {
+				t.Errorf("%s: finding for cluster %d %q, want 0 \"prod-eu\"", test.name, finding.ClusterNum, finding.Cluster)
+			}
+		}
+	}
+}
+
+func TestCheckHealthBriefAndError(t *testing.T) {
+	brief := &BriefCluster{UUID: "uuid-1", Nodes: []BriefNode{{Version: TEST_VERSION}, {Version: "7.1.4-3601-enterprise"}}}
+	failed := &ClusterError{TheCluster: Cluster{Nodes: []string{"http://10.0.0.1:8091"}}, ErrMsg: "connection refused"}
+	summary := &SummaryInfo{Clusters: []interface{}{brief, failed}}
+
+	findings := CheckHealth(summary, DEFAULT_HEALTH_THRESHOLDS)
+	want := []Finding{
+		{SEVERITY_CRITICAL, "unreachable", 1, "http://10.0.0.1:8091", "",
+			"the cluster could not be collected: connection refused"},
+		{SEVERITY_WARNING, "mixed-versions", 0, "uuid-1", "",
+			"nodes run 2 versions: 7.1.4-3601-enterprise (1 node), 7.2.4-7070-enterprise (1 node)"},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("found %+v, want %+v", findings, want)
+	}
+}
+
+func TestLoadHealthThresholds(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	thresholds, err := LoadHealthThresholds(write("thresholds.yaml",
+		"max_cpu_percent: 99\nmax_version_skew: 0\ndisabled_rules: [swap-in-use, unbalanced]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DEFAULT_HEALTH_THRESHOLDS
+	want.MaxCPUPercent = 99
+	want.MaxVersionSkew = 0
+	want.DisabledRules = []string{"swap-in-use", "unbalanced"}
+	if !reflect.DeepEqual(thresholds, want) {
+		t.Errorf("loaded %+v, want %+v", thresholds, want)
+	}
+
+	// the overrides change what's found: a CPU of 95% is allowed, the
+	// disabled rules are skipped, and any mix of release lines is skew
+	cluster := healthyCluster()
+	cluster.Balanced = false
+	cluster.Nodes[0].SystemStats.Swap_used = 64 * 1024 * 1024
+	cluster.Nodes[0].SystemStats.Cpu_utilization_rate = 95
+	cluster.Nodes[1].Version = "7.1.4-3601-enterprise"
+	findings := CheckHealth(&SummaryInfo{Clusters: []interface{}{cluster}}, thresholds)
+	if got := findingKeys(findings); !reflect.DeepEqual(got, []string{"critical version-skew "}) {
+		t.Errorf("with the overrides, found %q, want only version-skew", got)
+	}
+
+	for _, test := range []struct {
+		name, body, want string
+	}{
+		{"unknown rule", `{"disabled_rules": ["no-such-rule"]}`, "Unknown rule 'no-such-rule'"},
+		{"negative skew", `{"max_version_skew": -1}`, "must not be negative"},
+		{"unknown threshold", `{"max_cpu": 80}`, "unknown field"},
+	} {
+		_, err := LoadHealthThresholds(write("bad.json", test.body))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one with %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// history store - a record of the key figures from every run, kept so that
+// growth can be tracked over time
+//
+// The history is appended to a JSON lines file, one record per run, or for a
+// file named *.sqlite, *.sqlite3 or *.db, kept in a SQLite database with
+// tables of runs, clusters and nodes that can be queried with other tools.
+// Scheduled deployments would grow it without bound, so a retention policy
+// (keep the last N runs and/or runs no older than a given age) is applied
+// after each append, and can be applied by hand with 'cbsummary prune'.
+// 'cbsummary trend' prints the growth recorded in a history.
+//
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the figures recorded for each run
+type HistoryRecord struct {
+	Time     time.Time        `json:"time"`
+	Clusters []HistoryCluster `json:"clusters"`
+}
+
+type HistoryCluster struct {
+	UUID  string        `json:"cluster_uuid"`
+	Name  string        `json:"cluster_name,omitempty"`
+	Nodes []HistoryNode `json:"nodes"`
+	Cores float64       `json:"cores"`
+	RAM   float64       `json:"ram_gb"`
+}
+
+type HistoryNode struct {
+	Name    string  `json:"hostname"`
+	Version string  `json:"version"`
+	Cores   float64 `json:"cores"`
+	RAM     float64 `json:"ram_gb"`
+}
+
+type HistoryStore interface {
+	Append(record HistoryRecord) error
+	Records() ([]HistoryRecord, error)
+	Prune(policy RetentionPolicy) (int, error)
+	Close() error
+}
+
+// which records to keep; zero values mean no limit
+type RetentionPolicy struct {
+	KeepRuns int
+	MaxAge   time.Duration
+}
+
+func (p RetentionPolicy) IsSet() bool {
+	return p.KeepRuns > 0 || p.MaxAge > 0
+}
+
+// records sorted oldest first, returning the ones the policy keeps
+func (p RetentionPolicy) Apply(records []HistoryRecord, now time.Time) []HistoryRecord {
+	kept := make([]HistoryRecord, 0, len(records))
+	for _, record := range records {
+		if p.MaxAge > 0 && now.Sub(record.Time) > p.MaxAge {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	if p.KeepRuns > 0 && len(kept) > p.KeepRuns {
+		kept = kept[len(kept)-p.KeepRuns:]
+	}
+	return kept
+}
+
+// parse an age such as "90d", "12h" or "30m"; Go durations don't have days
+func ParseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age '%s'", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(age)
+}
+
+func OpenHistoryStore(path string) (HistoryStore, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".sqlite", ".sqlite3", ".db":
+		return &sqliteHistory{path: path}, nil
+	}
+	return &jsonLinesHistory{path: path}, nil
+}
+
+// read all the records in a history store
+func LoadHistory(path string) ([]HistoryRecord, error) {
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	return store.Records()
+}
+
+// build the history record for a run from its summary
+func NewHistoryRecord(clusterSummary *SummaryInfo, at time.Time) HistoryRecord {
+	record := HistoryRecord{Time: at.UTC(), Clusters: make([]HistoryCluster, 0)}
+
+	for _, icluster := range clusterSummary.Clusters {
+		var cluster HistoryCluster
+		switch c := icluster.(type) {
+		case *BriefCluster:
+			cluster.UUID = c.UUID
+			for _, node := range c.Nodes {
+				cluster.Nodes = append(cluster.Nodes, HistoryNode{node.Name, node.Version, node.Cores, node.RAM})
+			}
+		case *ClusterSummary:
+			cluster.UUID = c.Uuid
+			cluster.Name = c.ClusterName
+			for _, node := range c.Nodes {
+				cluster.Nodes = append(cluster.Nodes, HistoryNode{node.Hostname, node.Version,
+					node.SystemStats.CPU_cores_available, node.MemoryTotal / 1024.0 / 1024.0 / 1024.0})
+			}
+		default:
+			continue // nothing to record for clusters in error
+		}
+
+		for _, node := range cluster.Nodes {
+			cluster.Cores = cluster.Cores + node.Cores
+			cluster.RAM = cluster.RAM + node.RAM
+		}
+		record.Clusters = append(record.Clusters, cluster)
+	}
+
+	return record
+}
+
+// add a run to the history, then apply the retention policy
+func RecordHistory(path string, clusterSummary *SummaryInfo, policy RetentionPolicy) error {
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	err = store.Append(NewHistoryRecord(clusterSummary, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	if policy.IsSet() {
+		pruned, err := store.Prune(policy)
+		if err != nil {
+			return err
+		}
+		if pruned > 0 {
+			LogInfo("Pruned %d old runs from history %s.", pruned, path)
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+// history kept as a JSON lines file
+type jsonLinesHistory struct {
+	path string
+}
+
+func (h *jsonLinesHistory) Append(record HistoryRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening history %s: %v", h.path, err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("Error writing history %s: %v", h.path, err)
+	}
+	return nil
+}
+
+func (h *jsonLinesHistory) Records() ([]HistoryRecord, error) {
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error opening history %s: %v", h.path, err)
+	}
+	defer f.Close()
+
+	records := make([]HistoryRecord, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record HistoryRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing history %s line %d: %v", h.path, lineNum, err)
+		}
+		records = append(records, record)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading history %s: %v", h.path, err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func (h *jsonLinesHistory) Prune(policy RetentionPolicy) (int, error) {
+	records, err := h.Records()
+	if err != nil {
+		return 0, err
+	}
+	kept := policy.Apply(records, time.Now())
+	if len(kept) == len(records) {
+		return 0, nil
+	}
+
+	// rewrite through a temp file so a crash can't lose the whole history
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), filepath.Base(h.path)+".tmp")
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning history %s: %v", h.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, record := range kept {
+		line, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err = writer.Flush(); err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning history %s: %v", h.path, err)
+	}
+
+	err = os.Rename(tmp.Name(), h.path)
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning history %s: %v", h.path, err)
+	}
+	return len(records) - len(kept), nil
+}
+
+func (h *jsonLinesHistory) Close() error {
+	return nil
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// HTML report - a self-contained page for human reviewers
+//
+// When a history store is available, each cluster also gets small line charts
+// of its nodes, cores and RAM over the recorded runs, drawn as inline SVG so
+// the page needs nothing beyond a browser.
+//
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+)
+
+const SPARKLINE_WIDTH = 240
+const SPARKLINE_HEIGHT = 40
+
+// a cluster as shown in the HTML report
+type htmlCluster struct {
+	Num     int
+	UUID    string
+	Name    string
+	Label   string
+	Tags    []string
+	Error   string
+	Nodes   []htmlNode
+	Cores   float64
+	RAM     float64
+	Version map[string]int
+	Trends  []htmlTrend
+
+	Replications []XDCRReplication
+}
+
+type htmlNode struct {
+	Name     string
+	Version  string
+	Cores    string
+	RAM      float64
+	Services string
+	Status   string
+}
+
+type htmlTrend struct {
+	Title  string
+	First  string
+	Last   string
+	Points int
+	Chart  template.HTML
+}
+
+type htmlReport struct {
+	Generated string
+	Summary   *SummaryInfo
+	Clusters  []htmlCluster
+	Runs      int
+}
+
+var HTML_TEMPLATE = template.Must(template.New("report").Funcs(template.FuncMap{"join": strings.Join}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cbsummary report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em 0; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.7em; text-align: left; }
+th { background: #f2f2f2; }
+td.num { text-align: right; }
+.error { color: #b00; }
+.meta { color: #666; font-size: 0.85em; }
+.advisory { background: #fff4ce; padding: 0.5em; border: 1px solid #e0c36a; }
+.trend { display: inline-block; margin-right: 1.5em; font-size: 0.85em; }
+.trend svg { display: block; background: #fafafa; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>Couchbase cluster summary</h1>
+<p>Generated {{.Generated}}: {{.Summary.NumClusters}} clusters, {{.Summary.TotalNumNodes}} nodes.</p>
+{{with .Summary.Metadata}}<p class="meta">cbsummary {{.ToolVersion}} ({{.ToolCommit}}), schema version {{.SchemaVersion}}{{if .CollectionDuration}}, collected in {{.CollectionDuration}}{{end}}{{if .ConfigHash}}, config {{.ConfigHash}}{{end}}</p>{{end}}
+{{with .Summary.Metadata}}{{range .Advisories}}<p class="advisory">{{.}}</p>{{end}}{{end}}
+
+<h2>Node versions</h2>
+<table>
+<tr><th>Version</th><th>Nodes</th></tr>
+{{range $version, $count := .Summary.NodeVersions}}<tr><td>{{$version}}</td><td class="num">{{$count}}</td></tr>
+{{end}}</table>
+
+{{with .Summary.License}}
+<h2>License summary ({{.Model}})</h2>
+<table>
+<tr><th>Cluster</th><th>UUID</th><th>Nodes</th><th>Cores</th><th>RAM (GB)</th></tr>
+{{range .Clusters}}<tr><td>{{.ClusterNum}}</td><td>{{.UUID}}</td><td class="num">{{.Nodes}}</td><td class="num">{{printf "%.1f" .Cores}}</td><td class="num">{{printf "%.1f" .RAM}}</td></tr>
+{{end}}<tr><th>Total</th><th></th><th>{{.TotalNodes}}</th><th>{{printf "%.1f" .TotalCores}}</th><th>{{printf "%.1f" .TotalRAM}}</th></tr>
+</table>
+<table>
+<tr><th>Edition</th><th>Nodes</th><th>Cores</th><th>RAM (GB)</th></tr>
+{{range $edition, $totals := .Editions}}<tr><td>{{$edition}}</td><td class="num">{{$totals.Nodes}}</td><td class="num">{{printf "%.1f" $totals.Cores}}</td><td class="num">{{printf "%.1f" $totals.RAM}}</td></tr>
+{{end}}</table>
+{{with .Overages}}
+<p class="advisory">License overage: the clusters exceed the entitlement.</p>
+<table>
+<tr><th>Limit</th><th>In use</th><th>Entitled</th><th>Over</th></tr>
+{{range .}}<tr><td>{{.Limit}}</td><td class="num">{{printf "%.1f" .InUse}}</td><td class="num">{{printf "%.1f" .Entitled}}</td><td class="num">{{printf "%.1f" .Over}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+
+{{with .Summary.ConsumptionUnits}}
+<h2>Consumption units</h2>
+<p>{{.Formula}}</p>
+<table>
+<tr><th>Cluster</th><th>UUID</th><th>CUs</th></tr>
+{{range .Clusters}}<tr><td>{{.ClusterNum}}</td><td>{{.UUID}}</td><td class="num">{{printf "%.1f" .Total}}</td></tr>
+{{end}}<tr><th>Total</th><th></th><th>{{printf "%.1f" .Total}}</th></tr>
+</table>
+{{end}}
+
+<h2>Clusters</h2>
+{{if .Runs}}<p>Trends are drawn from {{.Runs}} runs in the history store.</p>{{end}}
+{{range .Clusters}}
+<h3>Cluster {{.Num}}{{if .Label}} ({{.Label}}){{end}}{{if .Name}}: {{.Name}}{{end}}</h3>
+{{if .Tags}}<p>Tags: {{join .Tags ", "}}</p>{{end}}
+{{if .Error}}<p class="error">Error: {{.Error}}</p>{{else}}
+<p>UUID {{.UUID}}: {{len .Nodes}} nodes, {{printf "%.1f" .Cores}} cores, {{printf "%.1f" .RAM}} GB RAM.</p>
+{{range .Trends}}<div class="trend">{{.Title}}: {{.First}} &rarr; {{.Last}} over {{.Points}} runs{{.Chart}}</div>{{end}}
+<table>
+<tr><th>Hostname</th><th>Version</th><th>Cores</th><th>RAM (GB)</th><th>Services</th><th>Status</th></tr>
+{{range .Nodes}}<tr><td>{{.Name}}</td><td>{{.Version}}</td><td class="num">{{.Cores}}</td><td class="num">{{printf "%.1f" .RAM}}</td><td>{{.Services}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+{{if .Replications}}<h4>XDCR</h4>
+<table>
+<tr><th>Source bucket</th><th>Target</th><th>Filter</th><th>State</th></tr>
+{{range .Replications}}<tr><td>{{.SourceBucket}}</td><td>{{.TargetName}} / {{.TargetBucket}}</td><td>{{.Filter}}</td><td>{{.State}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+{{end}}
+
+{{with .Summary.Drift}}
+<h2>Configuration drift by {{.Label}}</h2>
+{{range .Groups}}<h3>{{$.Summary.Drift.Label}}={{.Value}} (clusters {{range $i, $c := .Clusters}}{{if $i}}, {{end}}{{$c}}{{end}})</h3>
+{{if .Outliers}}<table>
+<tr><th>Cluster</th><th>UUID</th><th>Setting</th><th>Value</th><th>Most common</th></tr>
+{{range .Outliers}}<tr><td>{{.ClusterNum}}</td><td>{{.UUID}}</td><td>{{.Setting}}</td><td>{{.Value}}</td><td>{{.Expected}}</td></tr>
+{{end}}</table>{{else}}<p>No settings differ.</p>{{end}}
+{{if .Inconsistent}}<p>No common value for: {{join .Inconsistent ", "}}.</p>{{end}}
+{{end}}
+{{if .SkippedBusy}}<p>Not compared, as they were busy: clusters {{range $i, $c := .SkippedBusy}}{{if $i}}, {{end}}{{$c}}{{end}}.</p>{{end}}
+{{end}}
+
+{{with .Summary.CapellaSizing}}
+<h2>Appendix: Capella migration sizing</h2>
+<p>{{.Note}}.</p>
+<table>
+<tr><th>Cluster</th><th>UUID</th><th>Service group</th><th>Observed nodes</th><th>Observed cores</th><th>Observed RAM (GB)</th><th>CPU %</th><th>Node size</th><th>Nodes</th></tr>
+{{range $c := .Clusters}}{{range .ServiceGroups}}<tr><td>{{$c.ClusterNum}}</td><td>{{$c.UUID}}</td><td>{{join .Services ", "}}</td><td class="num">{{.ObservedNodes}}</td><td class="num">{{printf "%.1f" .ObservedCores}}{{if .Estimated}}*{{end}}</td><td class="num">{{printf "%.1f" .ObservedRAM}}</td><td class="num">{{printf "%.0f" .CPUUtilization}}</td><td>{{.NodeSize}}</td><td class="num">{{.Nodes}}</td></tr>
+{{end}}{{end}}<tr><th>Total</th><th></th><th></th><th></th><th></th><th></th><th></th><th>{{.VCPUs}} vCPUs</th><th>{{.Nodes}}</th></tr>
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// render the summary as an HTML page, with trends drawn from the history records if there are any
+func FormatHTML(clusterSummary *SummaryInfo, history []HistoryRecord) ([]byte, error) {
+	generated := time.Now()
+	if clusterSummary.Metadata != nil && !clusterSummary.Metadata.GeneratedAt.IsZero() {
+		generated = clusterSummary.Metadata.GeneratedAt.Local()
+	}
+	report := htmlReport{
+		Generated: generated.Format(time.RFC1123),
+		Summary:   clusterSummary,
+		Clusters:  make([]htmlCluster, 0, len(clusterSummary.Clusters)),
+		Runs:      len(history),
+	}
+
+	for cnum, icluster := range clusterSummary.Clusters {
+		cluster := htmlCluster{Num: cnum}
+
+		switch c := icluster.(type) {
+		case *BriefCluster:
+			cluster.UUID = c.UUID
+			cluster.Label, cluster.Tags = c.Label, c.Tags
+			for _, node := range c.Nodes {
+				cluster.Nodes = append(cluster.Nodes, htmlNode{
+					Name:    node.Name,
+					Version: node.Version,
+					Cores:   formatCores(node.Cores),
+					RAM:     node.RAM,
+				})
+				cluster.Cores = cluster.Cores + node.Cores
+				cluster.RAM = cluster.RAM + node.RAM
+			}
+		case *ClusterSummary:
+			cluster.UUID = c.Uuid
+			cluster.Name = c.ClusterName
+			cluster.Label, cluster.Tags = c.Label, c.Tags
+			if c.XDCRTopology != nil {
+				cluster.Replications = c.XDCRTopology.Replications
+			}
+			for _, node := range c.Nodes {
+				ram := node.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+				cluster.Nodes = append(cluster.Nodes, htmlNode{
+					Name:     node.Hostname,
+					Version:  node.Version,
+					Cores:    formatCores(node.SystemStats.CPU_cores_available),
+					RAM:      ram,
+					Services: strings.Join(node.Services, ", "),
+					Status:   node.Status,
+				})
+				cluster.Cores = cluster.Cores + node.SystemStats.CPU_cores_available
+				cluster.RAM = cluster.RAM + ram
+			}
+		case *ClusterError:
+			cluster.Error = c.ErrMsg
+			cluster.Label, cluster.Tags = c.TheCluster.Label, c.TheCluster.Tags
+		default:
+			cluster.Error = "no information collected"
+		}
+
+		if len(cluster.UUID) > 0 {
+			cluster.Trends = clusterTrends(cluster.UUID, history)
+		}
+		report.Clusters = append(report.Clusters, cluster)
+	}
+
+	var buffer bytes.Buffer
+	err := HTML_TEMPLATE.Execute(&buffer, report)
+	if err != nil {
+		return nil, fmt.Errorf("Error rendering HTML report: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func formatCores(cores float64) string {
+	// no cores info for earlier than 6.5
+	if cores <= 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.1f", cores)
+}
+
+// the nodes, cores and RAM charts for one cluster, if it appears in at least two runs
+func clusterTrends(uuid string, history []HistoryRecord) []htmlTrend {
+	records := make([]HistoryRecord, len(history))
+	copy(records, history)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	var nodes, cores, ram []float64
+	for _, record := range records {
+		for _, cluster := range record.Clusters {
+			if cluster.UUID == uuid {
+				nodes = append(nodes, float64(len(cluster.Nodes)))
+				cores = append(cores, cluster.Cores)
+				ram = append(ram, cluster.RAM)
+				break
+			}
+		}
+	}
+	if len(nodes) < 2 {
+		return nil
+	}
+
+	return []htmlTrend{
+		newTrend("Nodes", "%.0f", nodes),
+		newTrend("Cores", "%.1f", cores),
+		newTrend("RAM (GB)", "%.1f", ram),
+	}
+}
+
+func newTrend(title, format string, values []float64) htmlTrend {
+	return htmlTrend{
+		Title:  title,
+		First:  fmt.Sprintf(format, values[0]),
+		Last:   fmt.Sprintf(format, values[len(values)-1]),
+		Points: len(values),
+		Chart:  Sparkline(values, SPARKLINE_WIDTH, SPARKLINE_HEIGHT),
+	}
+}
+
+// draw the values as an SVG line chart, scaled to fill the given size
+func Sparkline(values []float64, width, height int) template.HTML {
+	if len(values) == 0 {
+		return ""
+	}
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	const margin = 3.0
+	w := float64(width) - 2*margin
+	h := float64(height) - 2*margin
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := margin
+		if len(values) > 1 {
+			x = margin + w*float64(i)/float64(len(values)-1)
+		}
+		y := margin + h/2
+		if max > min {
+			y = margin + h - h*(v-min)/(max-min)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+
+	last := strings.Split(points[len(points)-1], ",")
+	return template.HTML(fmt.Sprintf(`<svg width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<polyline fill="none" stroke="#2a6fdb" stroke-width="1.5" points="%s"/>`+
+		`<circle cx="%s" cy="%s" r="2.5" fill="#2a6fdb"/></svg>`,
+		width, height, width, height, strings.Join(points, " "), last[0], last[1]))
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// cbsummary import - build a config file from inventories that app teams
+// already maintain, instead of keeping yet another list of clusters by hand
+//
+// Two sources are understood:
+//
+// - connection string lists: one SDK connection string per line, optionally
+//   followed by a login and password, e.g.
+//       couchbase://cb1.example.com,cb2.example.com  Administrator  secret
+//   Blank lines and lines starting with '#' are ignored.
+//
+// - connection profiles in the TOML format used by the Couchbase shell, with
+//   one [[cluster]] (or [[clusters]], or [[database]] in newer releases)
+//   table per cluster, e.g.
+//       [[cluster]]
+//       identifier = "prod"
+//       connstr = "couchbases://cb1.example.com"
+//       username = "Administrator"
+//       password = "secret"
+//   or, from older releases, a list of hostnames with tls-enabled. The
+//   identifier becomes the cluster's label; other settings are ignored.
+//   couchbase-cli keeps no connection profiles of its own, so the shell's
+//   are the nearest thing to read.
+//
+// Credentials missing from the source are taken from --username/--password.
+//
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func ImportConnectionStrings(file string) ([]Cluster, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening %s: %v", file, err)
+	}
+	defer f.Close()
+
+	clusters := make([]Cluster, 0)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		nodes, err := ConnectionStringNodes(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Error in %s line %d: %v", file, lineNum, err)
+		}
+
+		cluster := Cluster{Nodes: nodes}
+		if len(fields) > 1 {
+			cluster.Login = fields[1]
+		}
+		if len(fields) > 2 {
+			cluster.Pass = fields[2]
+		}
+		clusters = append(clusters, cluster)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", file, err)
+	}
+	return clusters, nil
+}
+
+func ImportProfiles(file string) ([]Cluster, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", file, err)
+	}
+	doc, err := decodeTOML(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("Error in %s %v", file, err)
+	}
+
+	profiles := make([]tomlTable, 0)
+	for _, name := range []string{"cluster", "clusters", "database"} {
+		switch tables := doc[name].(type) {
+		case nil:
+		case []tomlTable:
+			profiles = append(profiles, tables...)
+		default:
+			return nil, fmt.Errorf("Error in %s: '%s' must be an array of tables, [[%s]]", file, name, name)
+		}
+	}
+
+	clusters := make([]Cluster, 0, len(profiles))
+	for i, profile := range profiles {
+		cluster, err := profileCluster(profile)
+		if err != nil {
+			identifier, _ := profile["identifier"].(string)
+			return nil, fmt.Errorf("Error in %s profile %d (%s): %v", file, i+1, identifier, err)
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+func profileCluster(profile tomlTable) (Cluster, error) {
+	values, err := profileStrings(profile)
+	if err != nil {
+		return Cluster{}, err
+	}
+	nodes, err := ConnectionStringNodes(profileConnstr(profile, values))
+	if err != nil {
+		return Cluster{}, err
+	}
+
+	cluster := Cluster{Nodes: nodes, Label: values["identifier"], Login: values["username"], Pass: values["password"]}
+	if len(cluster.Login) == 0 {
+		cluster.Login = values["user"]
+	}
+	return cluster, nil
+}
+
+// the profile's string settings; those cbsummary uses must be strings
+func profileStrings(profile tomlTable) (map[string]string, error) {
+	values := make(map[string]string)
+	for key, value := range profile {
+		if s, ok := value.(string); ok {
+			values[key] = s
+		} else if key == "identifier" || key == "connstr" || key == "username" || key == "user" ||
+			key == "password" {
+			return values, fmt.Errorf("'%s' must be a string", key)
+		}
+	}
+	return values, nil
+}
+
+// the profile's connection string, from connstr, or from hostnames, which the
+// Couchbase shell gives as a list; without a scheme, the shell's TLS setting
+// says which to use
+func profileConnstr(profile tomlTable, values map[string]string) string {
+	connstr := values["connstr"]
+	if len(connstr) == 0 {
+		connstr = values["hostnames"]
+		if hosts, ok := profile["hostnames"].([]interface{}); ok {
+			names := make([]string, 0, len(hosts))
+			for _, host := range hosts {
+				names = append(names, fmt.Sprint(host))
+			}
+			connstr = strings.Join(names, ",")
+		}
+	}
+
+	tls, _ := profile["tls-enabled"].(bool)
+	if settings, ok := profile["tls"].(tomlTable); ok {
+		if enabled, ok := settings["enabled"].(bool); ok {
+			tls = enabled
+		}
+	}
+	if tls && len(connstr) > 0 && !strings.Contains(connstr, "://") {
+		connstr = "couchbases://" + connstr
+	}
+	return connstr
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const TEST_PROFILES = `# Couchbase shell config
+version = 1
+
+[[cluster]]
+identifier = "prod-eu"   # the EU cluster
+connstr = "couchbase://10.1.0.1,10.1.0.2"
+username = "Administrator"
+password = "se\"cret#1"
+
+[[cluster]]
+identifier = 'legacy'
+hostnames = [
+    "10.2.0.1",
+    "10.2.0.2",  # trailing comma
+]
+user = "reader"
+password = 'C:\pass'
+tls-enabled = true
+default-bucket = "travel-sample"
+kv-timeout = 2_500
+
+[[database]]
+identifier = "new"
+connstr = "couchbase://10.3.0.1"
+"display name" = "New DB"
+
+[database.tls]
+enabled = false
+`
+
+func writeProfiles(t *testing.T, body string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(file, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestImportProfiles(t *testing.T) {
+	clusters, err := ImportProfiles(writeProfiles(t, TEST_PROFILES))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cluster{
+		{Label: "prod-eu", Nodes: []string{"http://10.1.0.1:8091", "http://10.1.0.2:8091"},
+			Login: "Administrator", Pass: `se"cret#1`},
+		{Label: "legacy", Nodes: []string{"https://10.2.0.1:18091", "https://10.2.0.2:18091"},
+			Login: "reader", Pass: `C:\pass`},
+		{Label: "new", Nodes: []string{"http://10.3.0.1:8091"}},
+	}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("imported %+v, want %+v", clusters, want)
+	}
+}
+
+func TestImportProfilesErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, body, want string
+	}{
+		{"unterminated string", "[[cluster]]\nconnstr = \"couchbase://a\n", "line 2: unterminated string"},
+		{"inline table", "[[cluster]]\ntls = { enabled = true }\n", "line 2: inline tables"},
+		{"float", "[[cluster]]\ntimeout = 2.5\n", "line 2: unexpected '.'"},
+		{"duplicate key", "[[cluster]]\nuser = \"a\"\nuser = \"b\"\n", "line 3: 'user' is already defined"},
+		{"unclosed array", "[[cluster]]\nhostnames = [\"a\"\n", "expected ',' or ']'"},
+		{"not a string", "[[cluster]]\nconnstr = \"couchbase://a\"\nusername = 7\n", "'username' must be a string"},
+		{"not an array of tables", "[cluster]\nconnstr = \"couchbase://a\"\n", "must be an array of tables"},
+	} {
+		_, err := ImportProfiles(writeProfiles(t, test.body))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one with %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// GSI index summary for the full report, from /indexStatus, for auditing
+// index sprawl: how many indexes there are and where, their storage modes,
+// replicas and build status
+//
+// /indexStatus lists each replica of an index separately; they're counted
+// once, by keyspace and name.
+//
+
+type IndexSummary struct {
+	Count        int            `json:"index_count"`
+	ByKeyspace   map[string]int `json:"by_keyspace"`
+	StorageModes map[string]int `json:"storage_modes"`
+	ByStatus     map[string]int `json:"by_status"`
+	Indexes      []IndexDetail  `json:"indexes"`
+	Error        string         `json:"error,omitempty"`
+}
+
+type IndexDetail struct {
+	Name        string  `json:"name"`
+	Bucket      string  `json:"bucket"`
+	Scope       string  `json:"scope,omitempty"`
+	Collection  string  `json:"collection,omitempty"`
+	StorageMode string  `json:"storage_mode"`
+	Replicas    int     `json:"replicas"`
+	Partitions  int     `json:"partitions,omitempty"`
+	Status      string  `json:"status"`
+	Progress    float64 `json:"progress"`
+}
+
+// whether any of the cluster's nodes run the given service
+func runsService(nodes []NodeInfo, service string) bool {
+	for _, node := range nodes {
+		if containsString(node.Services, service) {
+			return true
+		}
+	}
+	return false
+}
+
+func CollectIndexSummary(conn *ClusterConn) *IndexSummary {
+	summary := &IndexSummary{
+		ByKeyspace:   make(map[string]int),
+		StorageModes: make(map[string]int),
+		ByStatus:     make(map[string]int),
+		Indexes:      make([]IndexDetail, 0),
+	}
+
+	status, err := conn.Client.GetIndexStatus()
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+
+	seen := make(map[string]bool)
+	for _, index := range status.Indexes {
+		scope := index.Scope
+		if len(scope) == 0 {
+			scope = "_default"
+		}
+		key := index.Bucket + "." + scope + "." + index.Collection + "." + index.Index
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		summary.Count++
+		summary.ByKeyspace[index.Bucket+"."+scope]++
+		summary.StorageModes[index.StorageMode]++
+		summary.ByStatus[index.Status]++
+		summary.Indexes = append(summary.Indexes, IndexDetail{
+			Name:        index.Index,
+			Bucket:      index.Bucket,
+			Scope:       index.Scope,
+			Collection:  index.Collection,
+			StorageMode: index.StorageMode,
+			Replicas:    index.NumReplica,
+			Partitions:  index.NumPartition,
+			Status:      index.Status,
+			Progress:    index.Progress,
+		})
+	}
+	return summary
+}
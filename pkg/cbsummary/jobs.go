@@ -0,0 +1,365 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// on-demand collection - lets other systems trigger a collection of some or
+// all of the daemon's clusters through its --listen server
+//
+//   POST /api/v1/collect              start a job; the body is a ClusterFilter,
+//                                     e.g. {"labels": {"env": "prod"}}, and the
+//                                     reply gives the job ID
+//   GET  /api/v1/jobs/{id}            the job's status and progress
+//   GET  /api/v1/jobs/{id}/report     the job's report, as JSON, or CSV/HTML
+//                                     with ?format=
+//
+// Jobs run one at a time, in the order they were submitted, and never while
+// the daemon's scheduled collection runs, so that a burst of requests can't
+// overload the clusters; at most JOB_QUEUE_SIZE can wait.
+// Their reports are only returned through the API, not written to the
+// output file, the history or the push receiver. The last MAX_JOBS jobs are
+// kept. If the daemon was given --api-token, requests must carry the token in
+// an "Authorization: Bearer <token>" header; if it was given --report-auth,
+// they may instead carry its basic authentication, as for /report, since the
+// jobs' reports are as full as the latest one.
+//
+// A job's report numbers its clusters from 0, in the order of the job's
+// "clusters", which are their positions in the config file: cluster n of the
+// report is clusters[n] of the config.
+//
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JOB_QUEUE_SIZE = 16
+	MAX_JOBS       = 100
+)
+
+var errQueueFull = errors.New("too many jobs waiting, try again later")
+
+const (
+	JOB_QUEUED  = "queued"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+)
+
+// which clusters to collect; clusters matching any of the given criteria
+// are chosen, and an empty filter chooses them all
+type ClusterFilter struct {
+	Clusters []int             `json:"clusters,omitempty"` // positions in the config file
+	Labels   map[string]string `json:"labels,omitempty"`   // all must match
+	Nodes    []string          `json:"nodes,omitempty"`    // substrings of a node address
+}
+
+type Job struct {
+	ID     string        `json:"id"`
+	Status string        `json:"status"`
+	Filter ClusterFilter `json:"filter"`
+	// the positions in the config file of the clusters chosen, in the order
+	// the job's report numbers them
+	Clusters []int      `json:"clusters"`
+	Done     int        `json:"clusters_done"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	clusterList *ClusterList
+	report      *SummaryInfo
+}
+
+type JobQueue struct {
+	collector *Collector
+	token     string
+	auth      *BasicAuth
+	// held while a job collects, shared with the daemon's scheduled runs
+	collecting *sync.Mutex
+
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	queue chan *Job
+}
+
+func NewJobQueue(collector *Collector, token string, auth *BasicAuth, collecting *sync.Mutex) *JobQueue {
+	return &JobQueue{
+		collector:  collector,
+		token:      token,
+		auth:       auth,
+		collecting: collecting,
+		jobs:       make(map[string]*Job),
+		queue:      make(chan *Job, JOB_QUEUE_SIZE),
+	}
+}
+
+// the positions in the list of the clusters the filter chooses
+func (f ClusterFilter) Select(clusters *ClusterList) []int {
+	selected := make([]int, 0)
+	for cnum, cluster := range clusters.Clusters {
+		if f.matches(cnum, cluster) {
+			selected = append(selected, cnum)
+		}
+	}
+	return selected
+}
+
+func (f ClusterFilter) matches(cnum int, cluster Cluster) bool {
+	if len(f.Clusters) == 0 && len(f.Labels) == 0 && len(f.Nodes) == 0 {
+		return true
+	}
+	for _, n := range f.Clusters {
+		if n == cnum {
+			return true
+		}
+	}
+	if len(f.Labels) > 0 {
+		matched := true
+		for key, value := range f.Labels {
+			if cluster.Labels[key] != value {
+				matched = false
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	for _, pattern := range f.Nodes {
+		for _, node := range cluster.Nodes {
+			if strings.Contains(node, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queue a job collecting the clusters the filter chooses, returning a copy of
+// it as queued
+func (q *JobQueue) Submit(filter ClusterFilter, clusters *ClusterList) (Job, error) {
+	selected := filter.Select(clusters)
+	if len(selected) == 0 {
+		return Job{}, fmt.Errorf("no clusters match the filter")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:          hex.EncodeToString(id),
+		Status:      JOB_QUEUED,
+		Filter:      filter,
+		Clusters:    selected,
+		Created:     time.Now(),
+		clusterList: &ClusterList{Transport: clusters.Transport},
+	}
+	for _, cnum := range selected {
+		job.clusterList.Clusters = append(job.clusterList.Clusters, clusters.Clusters[cnum])
+	}
+
+	// the job may start as soon as it's queued, so it's only read under the
+	// lock from here on
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- job:
+	default:
+		return Job{}, errQueueFull
+	}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	q.expire()
+	return *job, nil
+}
+
+// drop the oldest finished jobs beyond MAX_JOBS; called with the lock held
+func (q *JobQueue) expire() {
+	for i := 0; len(q.jobs) > MAX_JOBS && i < len(q.order); {
+		job := q.jobs[q.order[i]]
+		if job.Status == JOB_DONE {
+			delete(q.jobs, job.ID)
+			q.order = append(q.order[:i], q.order[i+1:]...)
+		} else {
+			i++
+		}
+	}
+}
+
+// run queued jobs until ctx is done, which abandons the clusters the job
+// running hasn't yet collected
+func (q *JobQueue) Run(ctx context.Context) {
+	for {
+		select {
+		case job := <-q.queue:
+			q.runJob(ctx, job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *JobQueue) runJob(ctx context.Context, job *Job) {
+	q.collecting.Lock()
+	defer q.collecting.Unlock()
+
+	q.mu.Lock()
+	started := time.Now()
+	job.Status = JOB_RUNNING
+	job.Started = &started
+	q.mu.Unlock()
+
+	collector := q.collector.WithProgress(func(progress CollectProgress) {
+		q.mu.Lock()
+		job.Done = progress.Done()
+		q.mu.Unlock()
+	})
+	report := collector.CollectContext(ctx, job.clusterList)
+
+	q.mu.Lock()
+	finished := time.Now()
+	job.Status = JOB_DONE
+	job.Finished = &finished
+	job.report = report
+	job.clusterList = nil
+	q.mu.Unlock()
+}
+
+// a copy of the job, safe to use without the lock
+func (q *JobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// whether the request carries the API token or the report credentials; with
+// neither required, every request is authorized
+func (q *JobQueue) authorized(req *http.Request) bool {
+	if len(q.token) == 0 && q.auth == nil {
+		return true
+	}
+	if len(q.token) > 0 {
+		given, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(given), []byte(q.token)) == 1 {
+			return true
+		}
+	}
+	return q.auth != nil && q.auth.authorized(req)
+}
+
+func (q *JobQueue) unauthorized(w http.ResponseWriter) {
+	if q.auth != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="cbsummary"`)
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// add the API endpoints to the mux, collecting from the clusters given by the function
+func (q *JobQueue) Register(mux *http.ServeMux, clusters func() *ClusterList) {
+	mux.HandleFunc("POST /api/v1/collect", func(w http.ResponseWriter, req *http.Request) {
+		if !q.authorized(req) {
+			q.unauthorized(w)
+			return
+		}
+
+		var filter ClusterFilter
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 1024*1024))
+		if err == nil && len(strings.TrimSpace(string(body))) > 0 {
+			err = json.Unmarshal(body, &filter)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid cluster filter: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		job, err := q.Submit(filter, clusters())
+		if err == errQueueFull {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	})
+
+	mux.HandleFunc("GET /api/v1/jobs/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if !q.authorized(req) {
+			q.unauthorized(w)
+			return
+		}
+		job, ok := q.get(req.PathValue("id"))
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	mux.HandleFunc("GET /api/v1/jobs/{id}/report", func(w http.ResponseWriter, req *http.Request) {
+		if !q.authorized(req) {
+			q.unauthorized(w)
+			return
+		}
+		job, ok := q.get(req.PathValue("id"))
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		if job.report == nil {
+			http.Error(w, fmt.Sprintf("job %s is %s", job.ID, job.Status), http.StatusConflict)
+			return
+		}
+
+		format := req.URL.Query().Get("format")
+		if len(format) == 0 {
+			format = FORMAT_JSON
+		}
+		if !ValidFormat(format) {
+			http.Error(w, fmt.Sprintf("unknown format '%s'", format), http.StatusBadRequest)
+			return
+		}
+		body, err := FormatReport(job.report, ReportOptions{Format: format})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", REPORT_CONTENT_TYPES[format])
+		w.Write(body)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	body, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
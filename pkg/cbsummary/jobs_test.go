@@ -0,0 +1,114 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func jobClusters() *ClusterList {
+	return &ClusterList{Clusters: []Cluster{
+		{Nodes: []string{"10.1.0.1:8091", "10.1.0.2:8091"}, Labels: map[string]string{"env": "prod", "region": "eu"}},
+		{Nodes: []string{"10.2.0.1:8091"}, Labels: map[string]string{"env": "prod", "region": "us"}},
+		{Nodes: []string{"db.test.example.com"}, Labels: map[string]string{"env": "test"}},
+	}}
+}
+
+func TestClusterFilterSelect(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		filter ClusterFilter
+		want   []int
+	}{
+		{"empty filter", ClusterFilter{}, []int{0, 1, 2}},
+		{"positions", ClusterFilter{Clusters: []int{2, 0, 7}}, []int{0, 2}},
+		{"one label", ClusterFilter{Labels: map[string]string{"env": "prod"}}, []int{0, 1}},
+		{"all labels must match", ClusterFilter{Labels: map[string]string{"env": "prod", "region": "us"}}, []int{1}},
+		{"no label matches", ClusterFilter{Labels: map[string]string{"env": "dev"}}, []int{}},
+		{"node substring", ClusterFilter{Nodes: []string{"10.1."}}, []int{0}},
+		{"any criterion", ClusterFilter{Clusters: []int{0}, Nodes: []string{"example.com"}}, []int{0, 2}},
+	} {
+		if got := test.filter.Select(jobClusters()); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: selected %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestJobQueueSubmit(t *testing.T) {
+	q := NewJobQueue(nil, "", nil, new(sync.Mutex))
+	clusters := jobClusters()
+
+	_, err := q.Submit(ClusterFilter{Labels: map[string]string{"env": "dev"}}, clusters)
+	if err == nil {
+		t.Errorf("a filter matching no clusters was queued")
+	}
+
+	job, err := q.Submit(ClusterFilter{Nodes: []string{"example.com"}}, clusters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JOB_QUEUED || !reflect.DeepEqual(job.Clusters, []int{2}) {
+		t.Errorf("job queued as %s of clusters %v, want %s of [2]", job.Status, job.Clusters, JOB_QUEUED)
+	}
+	if nodes := job.clusterList.Clusters[0].Nodes; len(job.clusterList.Clusters) != 1 || nodes[0] != "db.test.example.com" {
+		t.Errorf("job collects %+v, want only cluster 2", job.clusterList.Clusters)
+	}
+	if got, ok := q.get(job.ID); !ok || got.ID != job.ID {
+		t.Errorf("queued job %s can't be found", job.ID)
+	}
+
+	// nothing runs the queue, so it fills
+	for i := 1; i < JOB_QUEUE_SIZE; i++ {
+		if _, err := q.Submit(ClusterFilter{}, clusters); err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+	}
+	if _, err := q.Submit(ClusterFilter{}, clusters); err != errQueueFull {
+		t.Errorf("submitting to a full queue gave %v, want %v", err, errQueueFull)
+	}
+	if len(q.jobs) != JOB_QUEUE_SIZE || len(q.order) != JOB_QUEUE_SIZE {
+		t.Errorf("%d jobs kept, in an order of %d, want %d", len(q.jobs), len(q.order), JOB_QUEUE_SIZE)
+	}
+}
+
+func TestJobQueueExpire(t *testing.T) {
+	q := NewJobQueue(nil, "", nil, new(sync.Mutex))
+
+	// the oldest jobs are still running or queued, so the next oldest
+	// finished ones go
+	for i := 0; i < MAX_JOBS+5; i++ {
+		job := &Job{ID: fmt.Sprintf("job%03d", i), Status: JOB_DONE}
+		if i < 2 {
+			job.Status = JOB_RUNNING
+		} else if i == 2 {
+			job.Status = JOB_QUEUED
+		}
+		q.jobs[job.ID] = job
+		q.order = append(q.order, job.ID)
+	}
+	q.expire()
+
+	if len(q.jobs) != MAX_JOBS || len(q.order) != MAX_JOBS {
+		t.Fatalf("%d jobs kept, in an order of %d, want %d", len(q.jobs), len(q.order), MAX_JOBS)
+	}
+	want := []string{"job000", "job001", "job002", "job008", "job009"}
+	if !reflect.DeepEqual(q.order[:5], want) {
+		t.Errorf("jobs kept start %v, want %v", q.order[:5], want)
+	}
+	for _, id := range []string{"job003", "job007"} {
+		if _, ok := q.jobs[id]; ok {
+			t.Errorf("job %s wasn't expired", id)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// Kubernetes discovery - building the list of clusters from the
+// CouchbaseCluster resources managed by the Couchbase Autonomous Operator,
+// instead of from a config file
+//
+// The Kubernetes API is reached the way kubectl reaches it, through client-go:
+// with the file given with --kubeconfig, or the files in $KUBECONFIG, or,
+// when running in a pod, the pod's service account, or else ~/.kube/config.
+// Of the kubeconfig's contexts, --kube-context is used, or the current
+// context. Any of kubectl's ways of authenticating work, including exec
+// plugins such as those of EKS, GKE and AKS.
+//
+// The CouchbaseCluster resources (couchbase.com/v2) are listed in
+// --kube-namespace, or in all namespaces. For each one:
+//
+// - the admin endpoint is --kube-endpoint, if given, with {name} and
+//   {namespace} filled in; or else the external address of the <cluster>-ui
+//   service, if the admin console is exposed through a load balancer; or
+//   else, when running in a pod, the in-cluster DNS name of the operator's
+//   <cluster> service. The port is 8091, or 18091 if the cluster has TLS
+//   enabled. Run elsewhere, a cluster with none of these is left out.
+// - the login and password are the "username" and "password" of the
+//   cluster's spec.security.adminSecret
+// - the label is the resource's name, the "kube_namespace" label its
+//   namespace, and the resource's own labels are carried over
+//
+// Clusters whose endpoint or credentials can't be found are reported and left
+// out. The API is only read from; listing the resources and reading services
+// and secrets in the namespaces is all the access needed.
+//
+// Clusters in a config file can also take their credentials from Kubernetes
+// secrets: a "login" or "pass" given as "k8s:<namespace>/<secret>/<key>" is
+// the key of that secret, read when the config is loaded. The namespace may
+// be left out, "k8s:<secret>/<key>", for the "namespace" of the config's
+// "kubernetes" section, or "default". That section finds the API as above,
+// e.g. "kubernetes": {"kubeconfig": "/etc/cbsummary/kubeconfig", "context":
+// "prod"}.
+//
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const KUBE_SECRET_PREFIX = "k8s:"
+
+const (
+	KUBE_ADMIN_PORT     = 8091
+	KUBE_ADMIN_TLS_PORT = 18091
+
+	// the longest a request of the API may take, unless the transport
+	// options give a timeout
+	KUBE_REQUEST_TIMEOUT = time.Minute
+)
+
+// the operator's CouchbaseCluster resources
+var COUCHBASE_CLUSTER_RESOURCE = schema.GroupVersionResource{
+	Group:    "couchbase.com",
+	Version:  "v2",
+	Resource: "couchbaseclusters",
+}
+
+type KubeOptions struct {
+	// the kubeconfig file, or "" to find the API as kubectl does
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// the kubeconfig context, or "" for its current context
+	Context string `json:"context,omitempty"`
+	// the namespace to look in, or "" for all of them
+	Namespace string `json:"namespace,omitempty"`
+	// the admin URL of each cluster, with {name} and {namespace} filled in,
+	// e.g. "https://{name}.{namespace}.couchbase.example.com:18091", or ""
+	// to find it from the cluster's services
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// reads the secrets referred to by credentials, connecting on first use
+type KubeSecretReader struct {
+	options   KubeOptions
+	transport TransportOptions
+	api       *kubeAPI
+
+	// the secrets already read, by namespace/name
+	secrets map[string]*corev1.Secret
+}
+
+type CouchbaseClusterResource struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Security struct {
+			AdminSecret string `json:"adminSecret"`
+		} `json:"security"`
+		Networking struct {
+			ExposeAdminConsole bool `json:"exposeAdminConsole"`
+			// present when the cluster has TLS enabled
+			TLS interface{} `json:"tls"`
+		} `json:"networking"`
+	} `json:"spec"`
+}
+
+// a connection to the Kubernetes API
+type kubeAPI struct {
+	config *rest.Config
+	client kubernetes.Interface
+
+	// where the API was found, e.g. "kubeconfig /root/.kube/config"
+	where string
+	// whether cbsummary runs in a pod of the cluster, so that the cluster's
+	// DNS names resolve
+	inCluster bool
+}
+
+// the list of clusters managed by the operator, as a config file would give it
+func DiscoverKubeClusters(options KubeOptions, transport TransportOptions) (*ClusterList, error) {
+	api, err := connectKube(options, transport)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := dynamic.NewForConfig(api.config)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to Kubernetes through %s: %v", api.where, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), api.config.Timeout)
+	defer cancel()
+	list, err := resources.Resource(COUCHBASE_CLUSTER_RESOURCE).Namespace(options.Namespace).List(ctx,
+		metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing CouchbaseCluster resources from %s: %v", api.where, err)
+	}
+
+	clusters := &ClusterList{Clusters: make([]Cluster, 0, len(list.Items))}
+	for _, item := range list.Items {
+		var resource CouchbaseClusterResource
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &resource)
+		if err == nil {
+			var cluster Cluster
+			cluster, err = resource.cluster(api, options.Endpoint)
+			if err == nil {
+				clusters.Clusters = append(clusters.Clusters, cluster)
+				continue
+			}
+		}
+		LogError("Skipping CouchbaseCluster %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+	}
+	LogInfo("Found %d of %d CouchbaseCluster resources through %s.", len(clusters.Clusters), len(list.Items),
+		api.where)
+	return clusters, nil
+}
+
+// the cluster to collect for a resource
+func (resource CouchbaseClusterResource) cluster(api *kubeAPI, endpoint string) (Cluster, error) {
+	meta := resource.Metadata
+	node, err := resource.adminEndpoint(api, endpoint)
+	if err != nil {
+		return Cluster{}, err
+	}
+
+	if len(resource.Spec.Security.AdminSecret) == 0 {
+		return Cluster{}, fmt.Errorf("no spec.security.adminSecret")
+	}
+	secret, err := api.secret(meta.Namespace, resource.Spec.Security.AdminSecret)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("reading admin secret %s: %v", resource.Spec.Security.AdminSecret, err)
+	}
+	login, err := secretValue(secret, "username")
+	if err != nil {
+		return Cluster{}, fmt.Errorf("admin secret %s: %v", resource.Spec.Security.AdminSecret, err)
+	}
+	pass, err := secretValue(secret, "password")
+	if err != nil {
+		return Cluster{}, fmt.Errorf("admin secret %s: %v", resource.Spec.Security.AdminSecret, err)
+	}
+
+	labels := make(map[string]string)
+	for key, value := range meta.Labels {
+		labels[key] = value
+	}
+	labels["kube_namespace"] = meta.Namespace
+
+	return Cluster{
+		Login:  login,
+		Pass:   pass,
+		Nodes:  []string{node},
+		Label:  meta.Name,
+		Tags:   []string{"kubernetes"},
+		Labels: labels,
+	}, nil
+}
+
+// the URL of the cluster's admin REST API: the endpoint given, or the load
+// balancer in front of the admin console if there is one, or else, from
+// inside the cluster, the operator's service for the cluster's pods
+func (resource CouchbaseClusterResource) adminEndpoint(api *kubeAPI, endpoint string) (string, error) {
+	meta := resource.Metadata
+	if len(endpoint) > 0 {
+		return strings.NewReplacer("{name}", meta.Name, "{namespace}", meta.Namespace).Replace(endpoint), nil
+	}
+
+	scheme, port := "http", KUBE_ADMIN_PORT
+	if resource.Spec.Networking.TLS != nil {
+		scheme, port = "https", KUBE_ADMIN_TLS_PORT
+	}
+
+	if resource.Spec.Networking.ExposeAdminConsole {
+		service, err := api.service(meta.Namespace, meta.Name+"-ui")
+		if err != nil {
+			LogVerbose("Error getting the admin console service of %s/%s: %v", meta.Namespace, meta.Name, err)
+		} else if ingress := service.Status.LoadBalancer.Ingress; len(ingress) > 0 && hasPort(service, port) {
+			host := ingress[0].Hostname
+			if len(host) == 0 {
+				host = ingress[0].IP
+			}
+			return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)), nil
+		}
+	}
+
+	if !api.inCluster {
+		return "", fmt.Errorf("the admin console has no load balancer, and the cluster's service can only " +
+			"be reached from inside Kubernetes; give its address with --kube-endpoint")
+	}
+	service, err := api.service(meta.Namespace, meta.Name)
+	if err != nil {
+		return "", fmt.Errorf("getting service %s: %v", meta.Name, err)
+	}
+	if !hasPort(service, port) {
+		return "", fmt.Errorf("service %s has no port %d", meta.Name, port)
+	}
+	host := meta.Name + "." + meta.Namespace + ".svc"
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+func hasPort(service *corev1.Service, port int) bool {
+	for _, p := range service.Spec.Ports {
+		if int(p.Port) == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (api *kubeAPI) service(namespace, name string) (*corev1.Service, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), api.config.Timeout)
+	defer cancel()
+	return api.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (api *kubeAPI) secret(namespace, name string) (*corev1.Secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), api.config.Timeout)
+	defer cancel()
+	return api.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func secretValue(secret *corev1.Secret, key string) (string, error) {
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("no %s", key)
+	}
+	return string(value), nil
+}
+
+// connect to the Kubernetes API, found as kubectl finds it
+func connectKube(options KubeOptions, transport TransportOptions) (*kubeAPI, error) {
+	api := &kubeAPI{}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if len(options.Kubeconfig) > 0 {
+		rules.ExplicitPath = options.Kubeconfig
+	}
+	if len(options.Kubeconfig) == 0 && len(os.Getenv(clientcmd.RecommendedConfigPathEnvVar)) == 0 &&
+		len(os.Getenv("KUBERNETES_SERVICE_HOST")) > 0 {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Error loading the pod's service account: %v", err)
+		}
+		api.config, api.where, api.inCluster = config, "the pod's service account", true
+	} else {
+		loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+			&clientcmd.ConfigOverrides{CurrentContext: options.Context})
+		files := rules.ExplicitPath
+		if len(files) == 0 {
+			files = strings.Join(rules.Precedence, string(filepath.ListSeparator))
+		}
+		config, err := loader.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Error loading kubeconfig %s: %v", files, err)
+		}
+		api.config, api.where = config, "kubeconfig "+files
+	}
+
+	api.config.Timeout = KUBE_REQUEST_TIMEOUT
+	if transport.ConnectTimeout > 0 {
+		api.config.Timeout = time.Duration(transport.ConnectTimeout)
+	}
+	if transport.MaxRequestsPerSecond > 0 {
+		api.config.QPS = float32(transport.MaxRequestsPerSecond)
+	}
+
+	client, err := kubernetes.NewForConfig(api.config)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to Kubernetes through %s: %v", api.where, err)
+	}
+	api.client = client
+	return api, nil
+}
+
+func NewKubeSecretReader(options *KubeOptions, transport TransportOptions) *KubeSecretReader {
+	reader := &KubeSecretReader{transport: transport, secrets: make(map[string]*corev1.Secret)}
+	if options != nil {
+		reader.options = *options
+	}
+	return reader
+}
+
+func isKubeSecretReference(value string) bool {
+	return strings.HasPrefix(value, KUBE_SECRET_PREFIX)
+}
+
+// the value of a "k8s:[<namespace>/]<secret>/<key>" reference
+func (k *KubeSecretReader) Read(ref string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(ref, KUBE_SECRET_PREFIX), "/")
+	if len(parts) == 2 {
+		namespace := k.options.Namespace
+		if len(namespace) == 0 {
+			namespace = "default"
+		}
+		parts = append([]string{namespace}, parts...)
+	}
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return "", fmt.Errorf("Kubernetes secret reference '%s' should be k8s:<namespace>/<secret>/<key>", ref)
+	}
+	namespace, name, key := parts[0], parts[1], parts[2]
+
+	secret, ok := k.secrets[namespace+"/"+name]
+	if !ok {
+		if k.api == nil {
+			api, err := connectKube(k.options, k.transport)
+			if err != nil {
+				return "", err
+			}
+			k.api = api
+		}
+		var err error
+		secret, err = k.api.secret(namespace, name)
+		if err != nil {
+			return "", fmt.Errorf("Error reading Kubernetes secret %s/%s: %v", namespace, name, err)
+		}
+		k.secrets[namespace+"/"+name] = secret
+	}
+
+	value, err := secretValue(secret, key)
+	if err != nil {
+		return "", fmt.Errorf("Kubernetes secret %s/%s: %v", namespace, name, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// management round-trip times - how long each node's management endpoint
+// takes to answer a small request from the collection host
+//
+// Each node is sent RTT_SAMPLES requests for /pools. The connection is reused,
+// so the first sample includes connection (and TLS) setup and the minimum is
+// closest to the network round trip. Along with the time the whole cluster
+// took to collect, this helps explain why some clusters are slow to collect.
+//
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const RTT_SAMPLES = 3
+
+type ManagementLatency struct {
+	Nodes          []NodeLatency `json:"nodes"`
+	CollectSeconds float64       `json:"collect_seconds"`
+	Error          string        `json:"error,omitempty"`
+}
+
+type NodeLatency struct {
+	Hostname string  `json:"hostname"`
+	FirstMs  float64 `json:"first_ms"`
+	MinMs    float64 `json:"min_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func MeasureManagementLatency(conn *ClusterConn) *ManagementLatency {
+	latency := &ManagementLatency{Nodes: make([]NodeLatency, 0)}
+
+	clients, err := conn.ServiceClients("mgmt")
+	if err != nil {
+		latency.Error = err.Error()
+		return latency
+	}
+
+	for _, nodeClient := range clients {
+		node := NodeLatency{Hostname: nodeClient.host}
+
+		total := 0.0
+		for i := 0; i < RTT_SAMPLES; i++ {
+			ms, err := nodeClient.roundTrip("/pools")
+			if err != nil {
+				LogError("Error measuring round trip to %s: %v", nodeClient.host, err)
+				node.Error = err.Error()
+				break
+			}
+
+			if i == 0 {
+				node.FirstMs, node.MinMs, node.MaxMs = ms, ms, ms
+			}
+			if ms < node.MinMs {
+				node.MinMs = ms
+			}
+			if ms > node.MaxMs {
+				node.MaxMs = ms
+			}
+			total = total + ms
+			node.AvgMs = total / float64(i+1)
+		}
+
+		latency.Nodes = append(latency.Nodes, node)
+	}
+
+	return latency
+}
+
+// the time in milliseconds to fetch the given path, including reading the body
+func (r *RestClient) roundTrip(path string) (float64, error) {
+	start := time.Now()
+	resp, err := r.executeGetOnce(r.host + path)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	return float64(time.Since(start).Microseconds()) / 1000.0, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// service layout (multi-dimensional scaling) - how many nodes run each
+// service, and the memory quota of each service against what it uses
+//
+// The quotas are per node, so a service's total quota is its quota times the
+// nodes running it. The memory used by the data service comes from the
+// cluster's storage totals; that of the index, search, analytics and eventing
+// services from each of their nodes (see service_usage.go), which is also
+// given node by node against the quota. A service is near its quota when it,
+// or any one of its nodes, uses SERVICE_NEAR_QUOTA_PERCENT or more of it. A
+// cluster uses multi-dimensional scaling when its services are split across
+// nodes, i.e. some node doesn't run all of them.
+//
+
+var LAYOUT_SERVICES = []string{"kv", "index", "n1ql", "fts", "cbas", "eventing", "backup"}
+
+const SERVICE_NEAR_QUOTA_PERCENT = 90.0
+
+type ServiceLayout struct {
+	Services []ServiceAllocation `json:"services"`
+	MDS      bool                `json:"multi_dimensional_scaling"`
+}
+
+type ServiceAllocation struct {
+	Service      string  `json:"service"`
+	Nodes        int     `json:"nodes"`
+	QuotaPerNode float64 `json:"memory_quota_per_node_mb,omitempty"`
+	Quota        float64 `json:"memory_quota_mb,omitempty"`
+	Used         float64 `json:"memory_used_mb,omitempty"`
+	UsedPct      float64 `json:"memory_used_pct,omitempty"`
+	NearQuota    bool    `json:"near_quota,omitempty"`
+
+	// each node's memory against the quota, where the service reports it
+	NodeUsage []ServiceNodeUsage `json:"node_usage,omitempty"`
+}
+
+// the memory quota of a service on each node running it, in MB, or 0 if it
+// doesn't have one
+func serviceMemoryQuota(poolsDefault *PoolsDefault, service string) int {
+	switch service {
+	case "kv":
+		return poolsDefault.MemoryQuota
+	case "index":
+		return poolsDefault.IndexMemoryQuota
+	case "fts":
+		return poolsDefault.FtsMemoryQuota
+	case "cbas":
+		return poolsDefault.CbasMemoryQuota
+	case "eventing":
+		return poolsDefault.EventingMemoryQuota
+	}
+	return 0
+}
+
+// the layout of the cluster's services, given the memory used by each of
+// them, by service, where it was collected
+func SummarizeServiceLayout(poolsDefault *PoolsDefault, usage map[string]*ServiceUsage) *ServiceLayout {
+	layout := &ServiceLayout{Services: make([]ServiceAllocation, 0)}
+
+	nodes := make(map[string]int)
+	for _, nodeInfo := range poolsDefault.Nodes {
+		for _, service := range nodeInfo.Services {
+			nodes[service]++
+		}
+	}
+
+	for _, service := range LAYOUT_SERVICES {
+		if nodes[service] == 0 {
+			continue
+		}
+		allocation := ServiceAllocation{
+			Service:      service,
+			Nodes:        nodes[service],
+			QuotaPerNode: float64(serviceMemoryQuota(poolsDefault, service)),
+		}
+		if service == "kv" {
+			allocation.Used = poolsDefault.StorageTotals.RAM.UsedByData / 1024 / 1024
+		} else if serviceUsage := usage[service]; serviceUsage != nil {
+			allocation.Used = serviceUsage.MemoryUsedMB
+			if allocation.QuotaPerNode > 0 {
+				allocation.NodeUsage = serviceUsage.Nodes
+			}
+		}
+		allocation.Quota = allocation.QuotaPerNode * float64(allocation.Nodes)
+		if allocation.Quota > 0 {
+			allocation.UsedPct = 100 * allocation.Used / allocation.Quota
+		}
+		allocation.NearQuota = allocation.UsedPct >= SERVICE_NEAR_QUOTA_PERCENT
+		for _, node := range allocation.NodeUsage {
+			if node.MemoryUsedPct >= SERVICE_NEAR_QUOTA_PERCENT {
+				allocation.NearQuota = true
+			}
+		}
+		layout.Services = append(layout.Services, allocation)
+
+		if nodes[service] < len(poolsDefault.Nodes) {
+			layout.MDS = true
+		}
+	}
+
+	return layout
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// license summary - totals emphasized according to the license model of the
+// contract being reported against
+//
+// Older contracts count nodes, newer ones count cores. Both sets of totals are
+// always computed; the model decides which one is reported as the licensed
+// total and which columns the CSV summary table carries.
+//
+// The totals are also broken down by edition, and may be checked against an
+// entitlement file (JSON or YAML) such as {"max_cores": 512}, giving limits on
+// max_nodes, max_cores and max_ram_gb. Community Edition nodes don't count
+// against the entitlement; nodes whose edition can't be told do. Any limit
+// exceeded is reported as an overage, and cbsummary exits with EXIT_CHECK_FAILED.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	LICENSE_MODEL_NODES = "nodes"
+	LICENSE_MODEL_CORES = "cores"
+
+	EDITION_ENTERPRISE = "enterprise"
+	EDITION_COMMUNITY  = "community"
+	EDITION_UNKNOWN    = "unknown"
+)
+
+type LicenseSummary struct {
+	Model         string                  `json:"model"`
+	LicensedTotal float64                 `json:"licensed_total"`
+	TotalNodes    int                     `json:"total_nodes"`
+	TotalCores    float64                 `json:"total_cores"`
+	TotalRAM      float64                 `json:"total_ram_gb"`
+	Clusters      []ClusterLicenseSummary `json:"clusters"`
+
+	// the totals for each edition
+	Editions map[string]*EditionTotals `json:"editions"`
+
+	// the limits checked, and the ones exceeded
+	Entitlement *Entitlement     `json:"entitlement,omitempty"`
+	Overages    []LicenseOverage `json:"overages,omitempty"`
+}
+
+type EditionTotals struct {
+	Nodes int     `json:"nodes"`
+	Cores float64 `json:"cores"`
+	RAM   float64 `json:"ram_gb"`
+}
+
+// the capacity licensed; zero means no limit
+type Entitlement struct {
+	MaxNodes int     `json:"max_nodes,omitempty"`
+	MaxCores float64 `json:"max_cores,omitempty"`
+	MaxRAM   float64 `json:"max_ram_gb,omitempty"`
+}
+
+type LicenseOverage struct {
+	Limit    string  `json:"limit"`
+	Entitled float64 `json:"entitled"`
+	InUse    float64 `json:"in_use"`
+	Over     float64 `json:"over"`
+}
+
+type ClusterLicenseSummary struct {
+	ClusterNum int     `json:"cluster_num"`
+	UUID       string  `json:"cluster_uuid"`
+	Nodes      int     `json:"nodes"`
+	Cores      float64 `json:"cores"`
+	RAM        float64 `json:"ram_gb"`
+
+	// number of nodes with no core count, not even the host CPU count
+	NodesWithoutCores int `json:"nodes_without_cores,omitempty"`
+}
+
+func ValidLicenseModel(model string) bool {
+	return model == LICENSE_MODEL_NODES || model == LICENSE_MODEL_CORES
+}
+
+func NewLicenseSummary(model string) *LicenseSummary {
+	return &LicenseSummary{
+		Model:    model,
+		Clusters: make([]ClusterLicenseSummary, 0),
+		Editions: make(map[string]*EditionTotals),
+	}
+}
+
+// the edition a node runs, from its version, e.g. "7.2.4-7070-enterprise"
+func nodeEdition(version string) string {
+	switch {
+	case strings.HasSuffix(version, "-"+EDITION_ENTERPRISE):
+		return EDITION_ENTERPRISE
+	case strings.HasSuffix(version, "-"+EDITION_COMMUNITY):
+		return EDITION_COMMUNITY
+	}
+	return EDITION_UNKNOWN
+}
+
+func (s *LicenseSummary) edition(name string) *EditionTotals {
+	if s.Editions == nil {
+		s.Editions = make(map[string]*EditionTotals)
+	}
+	totals, ok := s.Editions[name]
+	if !ok {
+		totals = &EditionTotals{}
+		s.Editions[name] = totals
+	}
+	return totals
+}
+
+// add the totals for one cluster to the summary
+func (s *LicenseSummary) AddCluster(clusterNum int, uuid string, nodes []NodeInfo) {
+	cluster := ClusterLicenseSummary{
+		ClusterNum: clusterNum,
+		UUID:       uuid,
+		Nodes:      len(nodes),
+	}
+
+	for _, nodeInfo := range nodes {
+		cores := nodeInfo.SystemStats.CPU_cores_available
+		if cores <= 0 {
+			cluster.NodesWithoutCores = cluster.NodesWithoutCores + 1
+		}
+		ram := nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+		cluster.Cores = cluster.Cores + cores
+		cluster.RAM = cluster.RAM + ram
+
+		edition := s.edition(nodeEdition(nodeInfo.Version))
+		edition.Nodes = edition.Nodes + 1
+		edition.Cores = edition.Cores + cores
+		edition.RAM = edition.RAM + ram
+	}
+
+	s.Clusters = append(s.Clusters, cluster)
+	s.TotalNodes = s.TotalNodes + cluster.Nodes
+	s.TotalCores = s.TotalCores + cluster.Cores
+	s.TotalRAM = s.TotalRAM + cluster.RAM
+
+	if s.Model == LICENSE_MODEL_NODES {
+		s.LicensedTotal = float64(s.TotalNodes)
+	} else {
+		s.LicensedTotal = s.TotalCores
+	}
+
+	sort.Slice(s.Clusters, func(i, j int) bool {
+		return s.Clusters[i].ClusterNum < s.Clusters[j].ClusterNum
+	})
+}
+
+// add the clusters from another summary, renumbering them from offset
+func (s *LicenseSummary) Merge(other *LicenseSummary, offset int) {
+	for _, cluster := range other.Clusters {
+		cluster.ClusterNum = cluster.ClusterNum + offset
+		s.Clusters = append(s.Clusters, cluster)
+	}
+	s.TotalNodes = s.TotalNodes + other.TotalNodes
+	s.TotalCores = s.TotalCores + other.TotalCores
+	s.TotalRAM = s.TotalRAM + other.TotalRAM
+	s.LicensedTotal = s.LicensedTotal + other.LicensedTotal
+	for name, totals := range other.Editions {
+		edition := s.edition(name)
+		edition.Nodes = edition.Nodes + totals.Nodes
+		edition.Cores = edition.Cores + totals.Cores
+		edition.RAM = edition.RAM + totals.RAM
+	}
+}
+
+// read an entitlement file
+func LoadEntitlement(file string) (*Entitlement, error) {
+	format, err := ConfigFormat(file, "")
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading entitlement %s: %v", file, err)
+	}
+	if format == CONFIG_FORMAT_YAML {
+		body, err = yamlToJSON(body)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing entitlement %s: %v", file, err)
+		}
+	}
+
+	var entitlement Entitlement
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&entitlement)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing entitlement %s: %v", file, err)
+	}
+	if entitlement == (Entitlement{}) {
+		return nil, fmt.Errorf("Entitlement %s gives no limits, expected max_nodes, max_cores or max_ram_gb", file)
+	}
+	return &entitlement, nil
+}
+
+// compare the licensable totals, those of every edition but Community, with
+// the entitlement
+func (s *LicenseSummary) CheckEntitlement(entitlement *Entitlement) {
+	s.Entitlement = entitlement
+	s.Overages = nil
+
+	var inUse EditionTotals
+	for name, totals := range s.Editions {
+		if name == EDITION_COMMUNITY {
+			continue
+		}
+		inUse.Nodes = inUse.Nodes + totals.Nodes
+		inUse.Cores = inUse.Cores + totals.Cores
+		inUse.RAM = inUse.RAM + totals.RAM
+	}
+
+	check := func(limit string, entitled, used float64) {
+		if entitled > 0 && used > entitled {
+			s.Overages = append(s.Overages, LicenseOverage{limit, entitled, used, used - entitled})
+		}
+	}
+	check("nodes", float64(entitlement.MaxNodes), float64(inUse.Nodes))
+	check("cores", entitlement.MaxCores, inUse.Cores)
+	check("ram_gb", entitlement.MaxRAM, inUse.RAM)
+}
+
+// the overages as lines of text
+func (s *LicenseSummary) OverageLines() []string {
+	lines := make([]string, 0, len(s.Overages))
+	for _, overage := range s.Overages {
+		lines = append(lines, fmt.Sprintf("%s: %.1f in use, %.1f entitled, %.1f over", overage.Limit,
+			overage.InUse, overage.Entitled, overage.Over))
+	}
+	return lines
+}
+
+// write the summary table for the CSV report, with columns chosen by the model
+func (s *LicenseSummary) WriteCSV(buffer *strings.Builder) {
+	if s.Model == LICENSE_MODEL_NODES {
+		buffer.WriteString("cluster_num\tcluster_uuid\tnodes\n")
+		for _, cluster := range s.Clusters {
+			buffer.WriteString(fmt.Sprintf("%d\t%s\t%d\n", cluster.ClusterNum, cluster.UUID, cluster.Nodes))
+		}
+		buffer.WriteString(fmt.Sprintf("total\t\t%d\n", s.TotalNodes))
+		s.writeEditionsCSV(buffer)
+		return
+	}
+
+	buffer.WriteString("cluster_num\tcluster_uuid\tcpu_cores\tRAM\tnodes\tnodes_without_cores\n")
+	for _, cluster := range s.Clusters {
+		buffer.WriteString(fmt.Sprintf("%d\t%s\t%.1f\t%.1f\t%d\t%d\n", cluster.ClusterNum, cluster.UUID,
+			cluster.Cores, cluster.RAM, cluster.Nodes, cluster.NodesWithoutCores))
+	}
+	buffer.WriteString(fmt.Sprintf("total\t\t%.1f\t%.1f\t%d\t\n", s.TotalCores, s.TotalRAM, s.TotalNodes))
+	s.writeEditionsCSV(buffer)
+}
+
+// the edition totals and any overages, as further tables
+func (s *LicenseSummary) writeEditionsCSV(buffer *strings.Builder) {
+	buffer.WriteString("\nedition\tnodes\tcpu_cores\tRAM\n")
+	for _, name := range sortedKeys(s.Editions) {
+		totals := s.Editions[name]
+		buffer.WriteString(fmt.Sprintf("%s\t%d\t%.1f\t%.1f\n", name, totals.Nodes, totals.Cores, totals.RAM))
+	}
+
+	if len(s.Overages) > 0 {
+		buffer.WriteString("\noverage\tin_use\tentitled\tover\n")
+		for _, overage := range s.Overages {
+			buffer.WriteString(fmt.Sprintf("%s\t%.1f\t%.1f\t%.1f\n", overage.Limit, overage.InUse, overage.Entitled,
+				overage.Over))
+		}
+	}
+}
+
+func (s *LicenseSummary) String() string {
+	if s.Model == LICENSE_MODEL_NODES {
+		return fmt.Sprintf("%d licensable nodes across %d clusters", s.TotalNodes, len(s.Clusters))
+	}
+	return fmt.Sprintf("%.1f licensable cores (%.1f GB RAM) across %d clusters", s.TotalCores, s.TotalRAM,
+		len(s.Clusters))
+}
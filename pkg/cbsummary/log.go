@@ -0,0 +1,166 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// logging - progress, warnings and errors go through a leveled logger rather
+// than straight to the console:
+//
+//   --quiet    only warnings and errors
+//   (default)  progress messages too
+//   --verbose  each cluster as it is collected, and other detail
+//   --debug    every REST call, with all lines written as JSON objects
+//
+// Outside debug mode each line is the plain message, followed by any
+// attributes as key=value. --log-file writes the lines to a file, with
+// timestamps, instead of the console. The report summary, help and the
+// output of subcommands are not log lines and always go to the console.
+// With --output=- the report is written to standard output, and the console
+// is standard error instead.
+//
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const LEVEL_VERBOSE = slog.Level(-2)
+
+// where messages for the user go, standard error when the report itself is
+// written to standard output
+var console = os.Stdout
+
+var logger = slog.New(newConsoleHandler(console, slog.LevelInfo, false))
+
+// send messages for the user to another file, before SetupLogging is called
+func SetConsole(f *os.File) {
+	console = f
+}
+
+// log through the given logger instead, for programs embedding the collector
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+// set the level and destination of the log lines
+func SetupLogging(quiet, verbose, debug bool, file string) error {
+	level := slog.LevelInfo
+	switch {
+	case debug:
+		level = slog.LevelDebug
+	case verbose:
+		level = LEVEL_VERBOSE
+	case quiet:
+		level = slog.LevelWarn
+	}
+
+	var out io.Writer = console
+	if len(file) > 0 {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Error opening log file %s: %v", file, err)
+		}
+		out = f
+	}
+
+	if debug {
+		logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.LevelKey && a.Value.Any() == LEVEL_VERBOSE {
+					a.Value = slog.StringValue("VERBOSE")
+				}
+				return a
+			},
+		}))
+	} else {
+		logger = slog.New(newConsoleHandler(out, level, len(file) > 0))
+	}
+	return nil
+}
+
+func LogError(format string, args ...interface{}) {
+	logger.Error(fmt.Sprintf(format, args...))
+}
+
+func LogWarn(format string, args ...interface{}) {
+	logger.Warn(fmt.Sprintf(format, args...))
+}
+
+func LogInfo(format string, args ...interface{}) {
+	logger.Info(fmt.Sprintf(format, args...))
+}
+
+func LogVerbose(format string, args ...interface{}) {
+	logger.Log(context.Background(), LEVEL_VERBOSE, fmt.Sprintf(format, args...))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// writes each record as its message and attributes on a line of its own
+type consoleHandler struct {
+	out        io.Writer
+	level      slog.Level
+	timestamps bool
+	attrs      []slog.Attr
+}
+
+func newConsoleHandler(out io.Writer, level slog.Level, timestamps bool) *consoleHandler {
+	return &consoleHandler{out: out, level: level, timestamps: timestamps}
+}
+
+func (h *consoleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(ctx context.Context, record slog.Record) error {
+	var line strings.Builder
+	if h.timestamps {
+		line.WriteString(record.Time.Format(time.RFC3339) + " ")
+	}
+	line.WriteString(record.Message)
+	write := func(a slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	record.Attrs(write)
+	line.WriteString("\n")
+
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+
+	// keep the status line below the log lines
+	if statusLine != nil && h.out == console {
+		statusLine.clear()
+		defer statusLine.draw()
+	}
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &handler
+}
+
+// groups aren't used, so their attributes are simply flattened
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	return h
+}
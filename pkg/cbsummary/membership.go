@@ -0,0 +1,161 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// node membership anomalies - nodes that belong to a cluster but aren't
+// serving it, from each node's clusterMembership and recoveryType:
+//
+// - failed over: "inactiveFailed"
+// - added but not yet rebalanced in: "inactiveAdded"
+// - pending rebalance: the added nodes, and the failed-over nodes marked for
+//   delta or full recovery, which a rebalance will bring back
+//
+// When the full report has the event log (--events), each failed-over node is
+// given the time of its most recent failover or auto-failover.
+//
+// A cluster with any such nodes lists them, and the fleet rollup counts them
+// across all the clusters.
+//
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	MEMBERSHIP_ACTIVE          = "active"
+	MEMBERSHIP_INACTIVE_ADDED  = "inactiveAdded"
+	MEMBERSHIP_INACTIVE_FAILED = "inactiveFailed"
+)
+
+type MembershipAnomalies struct {
+	FailedOver       []MemberNode `json:"failed_over"`
+	InactiveAdded    []MemberNode `json:"inactive_added"`
+	PendingRebalance []MemberNode `json:"pending_rebalance"`
+}
+
+type MemberNode struct {
+	Hostname     string     `json:"hostname"`
+	Membership   string     `json:"cluster_membership"`
+	Status       string     `json:"status"`
+	RecoveryType string     `json:"recovery_type,omitempty"`
+	FailedOverAt *time.Time `json:"failed_over_at,omitempty"`
+}
+
+// the counts across the fleet, and the clusters with any anomalies
+type MembershipRollup struct {
+	FailedOver       int   `json:"failed_over"`
+	InactiveAdded    int   `json:"inactive_added"`
+	PendingRebalance int   `json:"pending_rebalance"`
+	Clusters         []int `json:"clusters"`
+}
+
+// the cluster's nodes that aren't active members, or nil if they all are
+func FindMembershipAnomalies(nodes []NodeInfo, events *ClusterEvents) *MembershipAnomalies {
+	anomalies := &MembershipAnomalies{
+		FailedOver:       make([]MemberNode, 0),
+		InactiveAdded:    make([]MemberNode, 0),
+		PendingRebalance: make([]MemberNode, 0),
+	}
+	for _, nodeInfo := range nodes {
+		node := MemberNode{
+			Hostname:   nodeInfo.Hostname,
+			Membership: nodeInfo.ClusterMembership,
+			Status:     nodeInfo.Status,
+		}
+		if len(nodeInfo.RecoveryType) > 0 && nodeInfo.RecoveryType != "none" {
+			node.RecoveryType = nodeInfo.RecoveryType
+		}
+
+		switch nodeInfo.ClusterMembership {
+		case MEMBERSHIP_INACTIVE_FAILED:
+			node.FailedOverAt = lastFailover(events, nodeInfo.Hostname)
+			anomalies.FailedOver = append(anomalies.FailedOver, node)
+			if len(node.RecoveryType) > 0 {
+				anomalies.PendingRebalance = append(anomalies.PendingRebalance, node)
+			}
+		case MEMBERSHIP_INACTIVE_ADDED:
+			anomalies.InactiveAdded = append(anomalies.InactiveAdded, node)
+			anomalies.PendingRebalance = append(anomalies.PendingRebalance, node)
+		}
+	}
+
+	if len(anomalies.FailedOver) == 0 && len(anomalies.InactiveAdded) == 0 {
+		return nil
+	}
+	return anomalies
+}
+
+// the time of the most recent failover of the node in the event log, if any;
+// the log names nodes by their Erlang name, e.g. ns_1@10.0.0.2
+func lastFailover(events *ClusterEvents, hostname string) *time.Time {
+	if events == nil {
+		return nil
+	}
+	host := hostname
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		host = h
+	}
+	for _, event := range events.Events {
+		if event.Kind != EVENT_FAILOVER && event.Kind != EVENT_AUTO_FAILOVER {
+			continue
+		}
+		if strings.Contains(event.Message, "@"+host+"'") || strings.Contains(event.Message, "@"+host+" ") ||
+			strings.HasSuffix(event.Message, "@"+host) {
+			at := event.Time
+			return &at
+		}
+	}
+	return nil
+}
+
+func NewMembershipRollup() *MembershipRollup {
+	return &MembershipRollup{Clusters: make([]int, 0)}
+}
+
+func (r *MembershipRollup) AddCluster(cnum int, anomalies *MembershipAnomalies) {
+	if anomalies == nil {
+		return
+	}
+	r.FailedOver = r.FailedOver + len(anomalies.FailedOver)
+	r.InactiveAdded = r.InactiveAdded + len(anomalies.InactiveAdded)
+	r.PendingRebalance = r.PendingRebalance + len(anomalies.PendingRebalance)
+	r.Clusters = append(r.Clusters, cnum)
+}
+
+func (r *MembershipRollup) String() string {
+	return fmt.Sprintf("%d nodes failed over, %d added but inactive, %d pending rebalance, in %d clusters",
+		r.FailedOver, r.InactiveAdded, r.PendingRebalance, len(r.Clusters))
+}
+
+// add in another report's rollup, whose clusters follow offset clusters
+func (r *MembershipRollup) Merge(other *MembershipRollup, offset int) {
+	r.FailedOver = r.FailedOver + other.FailedOver
+	r.InactiveAdded = r.InactiveAdded + other.InactiveAdded
+	r.PendingRebalance = r.PendingRebalance + other.PendingRebalance
+	for _, cnum := range other.Clusters {
+		r.Clusters = append(r.Clusters, cnum+offset)
+	}
+}
+
+// the anomalies of a cluster in a report, if it has any
+func clusterMembershipAnomalies(icluster interface{}) *MembershipAnomalies {
+	switch c := icluster.(type) {
+	case *BriefCluster:
+		return c.Membership
+	case *ClusterSummary:
+		return c.Membership
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// chat notifications - after each run a short digest of the report is posted
+// to a Slack or Microsoft Teams incoming webhook: the clusters collected, their
+// total nodes, cores and RAM, the clusters that couldn't be collected, and any
+// warnings (advisories, clusters without alerting, expiring certificates,
+// unhealthy nodes and configuration drift)
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	NOTIFY_SLACK = "slack"
+	NOTIFY_TEAMS = "teams"
+)
+
+type NotifyOptions struct {
+	URL  string
+	Kind string // NOTIFY_SLACK or NOTIFY_TEAMS
+}
+
+// the kind of webhook a URL is for, when it isn't given
+func NotifyKind(webhook string) string {
+	if strings.Contains(webhook, ".office.com") || strings.Contains(webhook, ".logic.azure.com") {
+		return NOTIFY_TEAMS
+	}
+	return NOTIFY_SLACK
+}
+
+// the headline figures of a report
+type Digest struct {
+	Collected int
+	Nodes     int
+	Cores     float64
+	RAM       float64 // GB
+	Errors    []string
+	Warnings  []string
+}
+
+func NewDigest(clusterSummary *SummaryInfo) *Digest {
+	digest := &Digest{Errors: make([]string, 0), Warnings: make([]string, 0)}
+
+	if clusterSummary.Metadata != nil {
+		digest.Warnings = append(digest.Warnings, clusterSummary.Metadata.Advisories...)
+	}
+	if len(clusterSummary.NoAlerting) > 0 {
+		digest.Warnings = append(digest.Warnings, fmt.Sprintf("%d clusters have no email alerting configured",
+			len(clusterSummary.NoAlerting)))
+	}
+
+	for _, icluster := range clusterSummary.Clusters {
+		switch c := icluster.(type) {
+		case *ClusterError:
+			name := c.TheCluster.Label
+			if len(name) == 0 && len(c.TheCluster.Nodes) > 0 {
+				name = c.TheCluster.Nodes[0]
+			}
+			msg := c.ErrMsg
+			if len(msg) > 100 {
+				msg = msg[:100] + "..."
+			}
+			digest.Errors = append(digest.Errors, fmt.Sprintf("%s: %s", name, msg))
+
+		case *ClusterSummary:
+			name := c.Label
+			if len(name) == 0 {
+				name = c.ClusterName
+			}
+			unhealthy := make([]string, 0)
+			for _, node := range c.Nodes {
+				if node.Status != "healthy" {
+					unhealthy = append(unhealthy, fmt.Sprintf("%s is %s", node.Hostname, node.Status))
+				}
+			}
+			if len(unhealthy) > 0 {
+				digest.Warnings = append(digest.Warnings, fmt.Sprintf("%s: %s", name, strings.Join(unhealthy, ", ")))
+			}
+			if c.Certificates != nil && c.Certificates.Expiring > 0 {
+				digest.Warnings = append(digest.Warnings, fmt.Sprintf("%s: %d certificates expiring soon",
+					name, c.Certificates.Expiring))
+			}
+		}
+	}
+
+	for _, cluster := range NewHistoryRecord(clusterSummary, time.Now()).Clusters {
+		digest.Collected++
+		digest.Nodes = digest.Nodes + len(cluster.Nodes)
+		digest.Cores = digest.Cores + cluster.Cores
+		digest.RAM = digest.RAM + cluster.RAM
+	}
+
+	if clusterSummary.Drift != nil {
+		digest.Warnings = append(digest.Warnings, "Configuration drift: "+clusterSummary.Drift.String())
+	}
+	return digest
+}
+
+func (d *Digest) Title() string {
+	return fmt.Sprintf("Couchbase summary: %d clusters, %d nodes, %.0f cores, %.0f GB RAM",
+		d.Collected, d.Nodes, d.Cores, d.RAM)
+}
+
+// the body of the digest, as lines of markdown
+func (d *Digest) Lines() []string {
+	lines := make([]string, 0)
+	if len(d.Errors) > 0 {
+		lines = append(lines, fmt.Sprintf("*%d clusters could not be collected:*", len(d.Errors)))
+		for _, e := range d.Errors {
+			lines = append(lines, "- "+e)
+		}
+	}
+	if len(d.Warnings) > 0 {
+		lines = append(lines, fmt.Sprintf("*%d warnings:*", len(d.Warnings)))
+		for _, w := range d.Warnings {
+			lines = append(lines, "- "+w)
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No errors or warnings.")
+	}
+	return lines
+}
+
+// post the digest of a report to the chat webhook
+func Notify(options *NotifyOptions, clusterSummary *SummaryInfo) error {
+	digest := NewDigest(clusterSummary)
+
+	var payload interface{}
+	switch options.Kind {
+	case NOTIFY_TEAMS:
+		// a legacy MessageCard, which Teams connectors and workflows both accept
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  digest.Title(),
+			"title":    digest.Title(),
+			"text":     strings.Join(digest.Lines(), "\n\n"),
+		}
+	default:
+		payload = map[string]string{
+			"text": "*" + digest.Title() + "*\n" + strings.Join(digest.Lines(), "\n"),
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Error marshalling notification: %v", err)
+	}
+
+	client := http.Client{
+		Transport: NewTransport(nil, TransportOptions{}),
+		Timeout:   time.Minute,
+	}
+	// the webhook URL is itself the secret, so only its host goes in errors
+	resource := options.URL
+	if u, err := url.Parse(options.URL); err == nil {
+		resource = u.Scheme + "://" + u.Host + "/..."
+	}
+	resp, err := client.Post(options.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return &RestClientError{"POST", resource, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		if len(bytes.TrimSpace(msg)) == 0 {
+			msg = []byte(resp.Status)
+		}
+		return HttpError{resp.StatusCode, "POST", resource, strings.TrimSpace(string(msg))}
+	}
+
+	LogInfo("Sent %s notification.", options.Kind)
+	return nil
+}
@@ -0,0 +1,613 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// writing the summary report, as JSON, CSV, HTML, XLSX, YAML, JSON Lines or
+// Prometheus metrics (see prometheus.go), or through a custom template (see
+// template.go)
+//
+// The YAML report has the same structure and field names as the JSON one,
+// with the keys of each mapping sorted so that reports can be diffed.
+//
+// Reports written under their default timestamped names can be pruned after
+// each run, keeping the most recent ones or those younger than an age, so a
+// daemon doesn't fill its disk.
+//
+// The JSON Lines report has one line for each cluster, with the cluster's
+// number added as "cluster_num", or with ReportOptions.JSONLNodes one line
+// for each node, with the number, UUID and label of its cluster added. The
+// sections across all the clusters, e.g. the license summary, are left out.
+//
+// The output file name may have placeholders, filled in for each report:
+//
+//   {date}     the date, e.g. 2024-05-31
+//   {time}     the time, e.g. 14-05-09
+//   {cluster}  the label or name of the cluster, if the report has just one,
+//              otherwise "clusters"
+//   {format}   the report's format, e.g. json
+//
+// so e.g. --output=reports/cbsummary-{date}.{format} writes a report a day
+// into the reports directory, which is created if need be.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FORMAT_JSON  = "json"
+	FORMAT_CSV   = "csv"
+	FORMAT_HTML  = "html"
+	FORMAT_XLSX  = "xlsx"
+	FORMAT_YAML  = "yaml"
+	FORMAT_JSONL = "jsonl"
+	FORMAT_PROM  = "prom"
+)
+
+const REPORT_PREFIX = "cbsummary.out."
+const OUTPUT_STDOUT = "-"
+const REPORT_TIME_FORMAT = "2006-01-02-15:04:05"
+
+// the layouts of the {date} and {time} placeholders in output file names
+const (
+	OUTPUT_DATE_FORMAT = "2006-01-02"
+	OUTPUT_TIME_FORMAT = "15-04-05"
+)
+
+var REPORT_FORMATS = []string{FORMAT_JSON, FORMAT_CSV, FORMAT_HTML, FORMAT_XLSX, FORMAT_YAML, FORMAT_JSONL,
+	FORMAT_PROM}
+
+// the content type of each format, for serving reports over HTTP
+var REPORT_CONTENT_TYPES = map[string]string{
+	FORMAT_JSON:  "application/json",
+	FORMAT_CSV:   "text/tab-separated-values; charset=utf-8",
+	FORMAT_HTML:  "text/html; charset=utf-8",
+	FORMAT_XLSX:  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FORMAT_YAML:  "application/yaml",
+	FORMAT_JSONL: "application/x-ndjson",
+	FORMAT_PROM:  "text/plain; version=0.0.4; charset=utf-8",
+}
+
+func ValidFormat(format string) bool {
+	for _, f := range REPORT_FORMATS {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// settings controlling the format of the report
+type ReportOptions struct {
+	Format string
+
+	// earlier runs, for the trend charts in HTML reports
+	History []HistoryRecord
+
+	// a custom layout, used instead of the format if set
+	Template *template.Template
+
+	// for JSON Lines, a line for each node rather than each cluster
+	JSONLNodes bool
+
+	// gzip the report, and encrypt it if set, when it is written or uploaded
+	Compress bool
+	Encrypt  ReportEncrypter
+}
+
+// what to do with each report once it has been collected
+type Publisher struct {
+	OutputFile string // empty for the default timestamped name
+	ReportDir  string // where timestamped reports are written; empty for the current directory
+	Report     ReportOptions
+	History    string
+	Retention  RetentionPolicy
+	Push       *PushOptions
+
+	// which timestamped reports to keep after each run
+	ReportRetention RetentionPolicy
+
+	// cloud storage to upload the report to instead of writing a file
+	Sink ReportSink
+
+	// also write a report for each cluster (see split.go), and with SplitOnly
+	// just those
+	SplitPerCluster bool
+	SplitOnly       bool
+
+	// who to mail each report to
+	Email *EmailOptions
+
+	// a webhook to POST each report to
+	Post *PostOptions
+
+	// a chat webhook to post a digest of each report to
+	Notify *NotifyOptions
+}
+
+// write the report, record it in the history and push it to the receiver, as configured
+func (p *Publisher) Publish(clusterSummary *SummaryInfo) error {
+	now := time.Now()
+	defaultName := DefaultOutputFile()
+	outputFile := p.OutputFile
+	if len(outputFile) == 0 {
+		outputFile = filepath.Join(p.ReportDir, defaultName)
+	}
+
+	// record the history first, so that this run appears in any trends in the report
+	options := p.Report
+	if len(p.History) > 0 {
+		err := RecordHistory(p.History, clusterSummary, p.Retention)
+		if err != nil {
+			return err
+		}
+
+		if options.Format == FORMAT_HTML {
+			options.History, err = LoadHistory(p.History)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	if !p.SplitOnly {
+		if p.Sink != nil {
+			err = UploadReport(clusterSummary, p.Sink, defaultName, options)
+		} else if outputFile == OUTPUT_STDOUT {
+			err = WriteReport(clusterSummary, outputFile, options)
+		} else {
+			err = WriteReport(clusterSummary, ExpandOutputFile(outputFile, outputClusterName(clusterSummary),
+				options.Format, now), options)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if p.SplitPerCluster {
+		err = p.publishSplit(clusterSummary, outputFile, defaultName, now, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Sink == nil && len(p.OutputFile) == 0 && p.ReportRetention.IsSet() {
+		removed, err := PruneReports(p.ReportDir, p.ReportRetention, time.Now())
+		if err != nil {
+			return err
+		}
+		if removed > 0 {
+			LogInfo("Removed %d old reports.", removed)
+		}
+	}
+
+	if p.Push != nil {
+		err = PushSummary(p.Push, clusterSummary)
+		if err != nil {
+			return fmt.Errorf("Error pushing summary: %v", err)
+		}
+	}
+
+	if p.Post != nil {
+		err = PostReport(p.Post, clusterSummary)
+		if err != nil {
+			return fmt.Errorf("Error posting report: %v", err)
+		}
+	}
+
+	if p.Notify != nil {
+		err = Notify(p.Notify, clusterSummary)
+		if err != nil {
+			return fmt.Errorf("Error sending notification: %v", err)
+		}
+	}
+
+	if p.Email != nil {
+		history := options.History
+		if history == nil && len(p.History) > 0 {
+			history, err = LoadHistory(p.History)
+			if err != nil {
+				return err
+			}
+		}
+		err = EmailReport(p.Email, clusterSummary, history)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the output file name to use when none is given on the command line
+func DefaultOutputFile() string {
+	return REPORT_PREFIX + time.Now().Format(REPORT_TIME_FORMAT)
+}
+
+// the output file name with its placeholders filled in
+func ExpandOutputFile(name, cluster, format string, now time.Time) string {
+	if !strings.Contains(name, "{") {
+		return name
+	}
+	return strings.NewReplacer(
+		"{date}", now.Format(OUTPUT_DATE_FORMAT),
+		"{time}", now.Format(OUTPUT_TIME_FORMAT),
+		"{cluster}", cluster,
+		"{format}", format,
+	).Replace(name)
+}
+
+// the name for {cluster} in the report's file name: its cluster's, if it has
+// just one, otherwise "clusters"
+func outputClusterName(clusterSummary *SummaryInfo) string {
+	if len(clusterSummary.Clusters) == 1 {
+		if name := clusterFileName(clusterSummary.Clusters[0]); len(name) > 0 {
+			return name
+		}
+	}
+	return "clusters"
+}
+
+// remove the timestamped reports in a directory that the policy doesn't keep,
+// returning how many were removed
+func PruneReports(dir string, policy RetentionPolicy, now time.Time) (int, error) {
+	if len(dir) == 0 {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("Error reading report directory %s: %v", dir, err)
+	}
+
+	// as history records, so the policy can be applied to them
+	reports := make([]HistoryRecord, 0)
+	names := make(map[time.Time][]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, REPORT_PREFIX) {
+			continue
+		}
+		// less any suffixes, the encryption's after the compression's
+		timestamp := strings.TrimPrefix(name, REPORT_PREFIX)
+		for i := len(REPORT_SUFFIXES) - 1; i >= 0; i-- {
+			timestamp = strings.TrimSuffix(timestamp, REPORT_SUFFIXES[i])
+		}
+		// and less the cluster's name, for a per-cluster report
+		if len(timestamp) > len(REPORT_TIME_FORMAT) && timestamp[len(REPORT_TIME_FORMAT)] == '.' {
+			timestamp = timestamp[:len(REPORT_TIME_FORMAT)]
+		}
+		stamp, err := time.ParseInLocation(REPORT_TIME_FORMAT, timestamp, time.Local)
+		if err != nil {
+			continue
+		}
+		// a run's reports are kept or removed together
+		if len(names[stamp]) == 0 {
+			reports = append(reports, HistoryRecord{Time: stamp})
+		}
+		names[stamp] = append(names[stamp], name)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Time.Before(reports[j].Time)
+	})
+
+	keep := make(map[time.Time]bool)
+	for _, report := range policy.Apply(reports, now) {
+		keep[report.Time] = true
+	}
+
+	removed := 0
+	for _, report := range reports {
+		if keep[report.Time] {
+			continue
+		}
+		for _, name := range names[report.Time] {
+			err := os.Remove(filepath.Join(dir, name))
+			if err != nil {
+				return removed, fmt.Errorf("Error removing old report: %v", err)
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// render the report in the requested format
+func FormatReport(clusterSummary *SummaryInfo, options ReportOptions) ([]byte, error) {
+	if options.Template != nil {
+		return FormatTemplate(clusterSummary, options.Template)
+	}
+	if options.Format == FORMAT_HTML {
+		return FormatHTML(clusterSummary, options.History)
+	}
+	if options.Format == FORMAT_XLSX {
+		return FormatXLSX(clusterSummary)
+	}
+	if options.Format == FORMAT_YAML {
+		return FormatYAML(clusterSummary)
+	}
+	if options.Format == FORMAT_JSONL {
+		return FormatJSONL(clusterSummary, options.JSONLNodes)
+	}
+	if options.Format == FORMAT_PROM {
+		return FormatPrometheus(clusterSummary), nil
+	}
+
+	if options.Format == FORMAT_CSV {
+		var buffer strings.Builder
+		if hasFullClusters(clusterSummary.Clusters) {
+			err := writeFullCSV(&buffer, clusterSummary.Clusters)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			writeBriefCSV(&buffer, clusterSummary.Clusters)
+		}
+
+		if clusterSummary.License != nil {
+			buffer.WriteString("\n")
+			clusterSummary.License.WriteCSV(&buffer)
+		}
+		if clusterSummary.Metadata != nil {
+			buffer.WriteString("\n")
+			clusterSummary.Metadata.WriteCSV(&buffer)
+		}
+		return []byte(buffer.String()), nil
+	}
+
+	// JSON output
+	body, err := json.MarshalIndent(clusterSummary, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling summary: %v", err)
+	}
+	return body, nil
+}
+
+// the metadata as name, value rows
+func (m *ReportMetadata) WriteCSV(buffer *strings.Builder) {
+	buffer.WriteString("metadata\tvalue\n")
+	buffer.WriteString(fmt.Sprintf("tool_version\t%s\n", m.ToolVersion))
+	buffer.WriteString(fmt.Sprintf("tool_commit\t%s\n", m.ToolCommit))
+	buffer.WriteString(fmt.Sprintf("schema_version\t%d\n", m.SchemaVersion))
+	buffer.WriteString(fmt.Sprintf("generated_at\t%s\n", m.GeneratedAt.Format(time.RFC3339)))
+	if m.CollectionDuration > 0 {
+		buffer.WriteString(fmt.Sprintf("collection_duration\t%v\n", m.CollectionDuration))
+	}
+	if len(m.ConfigHash) > 0 {
+		buffer.WriteString(fmt.Sprintf("config_hash\t%s\n", m.ConfigHash))
+	}
+}
+
+// render the summary as YAML, going through its JSON form for the field names
+func FormatYAML(clusterSummary *SummaryInfo) ([]byte, error) {
+	var doc interface{}
+	err := roundTrip(clusterSummary, &doc)
+	if err != nil {
+		return nil, err
+	}
+	body, err := yaml.Marshal(yamlNumbers(doc))
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling summary: %v", err)
+	}
+	return body, nil
+}
+
+// render the summary as JSON Lines, a line for each cluster or each node
+func FormatJSONL(clusterSummary *SummaryInfo, nodes bool) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+
+	for cnum, icluster := range clusterSummary.Clusters {
+		var fields map[string]interface{}
+		err := roundTrip(icluster, &fields)
+		if err != nil {
+			return nil, err
+		}
+
+		if !nodes {
+			fields["cluster_num"] = cnum
+			err = encoder.Encode(fields)
+			if err != nil {
+				return nil, fmt.Errorf("Error marshalling summary: %v", err)
+			}
+			continue
+		}
+
+		var uuid, label string
+		switch c := icluster.(type) {
+		case *BriefCluster:
+			uuid, label = c.UUID, c.Label
+		case *ClusterSummary:
+			uuid, label = c.Uuid, c.Label
+		}
+		clusterNodes, _ := fields["nodes"].([]interface{})
+		for _, inode := range clusterNodes {
+			node, ok := inode.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			node["cluster_num"] = cnum
+			node["cluster_uuid"] = uuid
+			node["label"] = label
+			err = encoder.Encode(node)
+			if err != nil {
+				return nil, fmt.Errorf("Error marshalling summary: %v", err)
+			}
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// replace the JSON numbers in a decoded document with integers where they
+// are whole, and floats otherwise, so they're written as YAML numbers
+func yamlNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = yamlNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlNumbers(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// write the brief clusters of the report as CSV rows, one per node
+func writeBriefCSV(buffer *strings.Builder, clusters []interface{}) {
+	buffer.WriteString("cluster_num\tcluster_uuid\tcluster_size\thostname\tcpu_cores\tRAM\tlabel\ttags\tcpu_limited\thost_cpu_count\n")
+
+	for cnum, icluster := range clusters {
+		cluster, ok := icluster.(*BriefCluster)
+		if ok {
+			tags := strings.Join(cluster.Tags, ",")
+			for _, node := range cluster.Nodes {
+				// no cores info from anywhere, e.g. for earlier than 6.5 without a cpuCount
+				cores := "N/A"
+				if node.Cores > 0 {
+					cores = fmt.Sprintf("%.1f", node.Cores)
+				}
+				hostCores := ""
+				if node.HostCores > 0 {
+					hostCores = fmt.Sprintf("%.0f", node.HostCores)
+				}
+				buffer.WriteString(fmt.Sprintf("%d\t%s\t%d\t%s\t%s\t%.1f\t%s\t%s\t%t\t%s\n", cnum, cluster.UUID,
+					cluster.Size, node.Name, cores, node.RAM, cluster.Label, tags, node.CPULimited, hostCores))
+			}
+		}
+	}
+}
+
+// whether the report has the full details of any cluster
+func hasFullClusters(clusters []interface{}) bool {
+	for _, icluster := range clusters {
+		if _, ok := icluster.(*ClusterSummary); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// write the report to the given file, and print a short summary of it
+func WriteReport(clusterSummary *SummaryInfo, outputFile string, options ReportOptions) error {
+	body, err := FormatReport(clusterSummary, options)
+	if err != nil {
+		return err
+	}
+
+	body, suffix, err := sealReport(body, options)
+	if err != nil {
+		return err
+	}
+
+	if outputFile == OUTPUT_STDOUT {
+		_, err = os.Stdout.Write(body)
+		if err != nil {
+			return fmt.Errorf("Error writing report to standard output: %v", err)
+		}
+		LogInfo("Wrote information on %d clusters to standard output.", clusterSummary.NumClusters)
+		printReportSummary(clusterSummary)
+		return nil
+	}
+
+	outputFile = withSuffix(outputFile, suffix)
+	if dir := filepath.Dir(outputFile); dir != "." {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("Error creating directory for output file %s: %v", outputFile, err)
+		}
+	}
+	err = ioutil.WriteFile(outputFile, body, 0644)
+	if err != nil {
+		return fmt.Errorf("Error writing output file %s: %v", outputFile, err)
+	}
+
+	LogInfo("Wrote information on %d clusters to file %s.", clusterSummary.NumClusters, outputFile)
+	printReportSummary(clusterSummary)
+	return nil
+}
+
+// upload the report to a sink, under the given name unless the sink gives its
+// own, and print a short summary of it
+func UploadReport(clusterSummary *SummaryInfo, sink ReportSink, name string, options ReportOptions) error {
+	body, err := FormatReport(clusterSummary, options)
+	if err != nil {
+		return err
+	}
+
+	contentType := REPORT_CONTENT_TYPES[options.Format]
+	if options.Template != nil {
+		contentType = "text/plain; charset=utf-8"
+	}
+	body, suffix, err := sealReport(body, options)
+	if err != nil {
+		return err
+	}
+	if options.Encrypt != nil {
+		contentType = "application/octet-stream"
+	} else if options.Compress {
+		contentType = "application/gzip"
+	}
+	location, err := sink.Write(name+suffix, body, contentType)
+	if err != nil {
+		return fmt.Errorf("Error uploading report: %v", err)
+	}
+
+	LogInfo("Uploaded information on %d clusters to %s.", clusterSummary.NumClusters, location)
+	printReportSummary(clusterSummary)
+	return nil
+}
+
+// print the headline figures of a report
+func printReportSummary(clusterSummary *SummaryInfo) {
+	if clusterSummary.License != nil {
+		fmt.Fprintf(console, "License summary: %s.\n", clusterSummary.License)
+		if len(clusterSummary.License.Overages) > 0 {
+			fmt.Fprintf(console, "\nLICENSE OVERAGE - the clusters exceed the entitlement:\n")
+			for _, line := range clusterSummary.License.OverageLines() {
+				fmt.Fprintf(console, "  %s\n", line)
+			}
+			fmt.Fprintf(console, "\n")
+		}
+	}
+	if clusterSummary.ConsumptionUnits != nil {
+		fmt.Fprintf(console, "Estimated %s.\n", clusterSummary.ConsumptionUnits)
+	}
+	if clusterSummary.CapellaSizing != nil {
+		fmt.Fprintf(console, "Capella sizing: %s.\n", clusterSummary.CapellaSizing)
+	}
+	if clusterSummary.Drift != nil {
+		fmt.Fprintf(console, "Configuration drift: %s.\n", clusterSummary.Drift)
+	}
+	if m := clusterSummary.NodeMembership; m != nil && len(m.Clusters) > 0 {
+		fmt.Fprintf(console, "Node membership: %s.\n", m)
+	}
+	if clusterSummary.Environments != nil {
+		fmt.Fprintf(console, "Environments: %s.\n", clusterSummary.Environments)
+	}
+}
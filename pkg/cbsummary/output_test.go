@@ -0,0 +1,247 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// report format tests - each format is produced from the clusters saved in
+// testdata/raw and read back, to check the figures survive the trip
+//
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+func formatRaw(t *testing.T, summary *SummaryInfo, options ReportOptions) []byte {
+	t.Helper()
+	body, err := FormatReport(summary, options)
+	if err != nil {
+		t.Fatalf("%s: %v", options.Format, err)
+	}
+	if len(body) == 0 {
+		t.Fatalf("%s: empty report", options.Format)
+	}
+	return body
+}
+
+func TestFormatHTML(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	body := string(formatRaw(t, summary, ReportOptions{Format: FORMAT_HTML}))
+
+	for _, want := range []string{"<html", "uuid-18091", "node2:18091", "</html>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML report doesn't have %s", want)
+		}
+	}
+}
+
+func TestFormatCSVRoundTrip(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	body := formatRaw(t, summary, ReportOptions{Format: FORMAT_CSV})
+
+	rows := make([]map[string]string, 0)
+	var header []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if len(line) == 0 {
+			break // the end of the node rows
+		}
+		fields := strings.Split(line, "\t")
+		if header == nil {
+			header = fields
+			continue
+		}
+		if len(fields) != len(header) {
+			t.Fatalf("%d fields in %q, want %d", len(fields), line, len(header))
+		}
+		row := make(map[string]string)
+		for i, name := range header {
+			row[name] = fields[i]
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("%d node rows, want 2", len(rows))
+	}
+	want := map[string]string{"cluster_uuid": "uuid-18091", "hostname": "node2:18091", "cpu_cores": "8.0",
+		"label": "prod-eu", "cpu_limited": "true", "host_cpu_count": "16"}
+	for name, value := range want {
+		if rows[1][name] != value {
+			t.Errorf("%s is %q, want %q", name, rows[1][name], value)
+		}
+	}
+
+	full := formatRaw(t, collectRaw(t, CollectOptions{Full: true}), ReportOptions{Format: FORMAT_CSV})
+	if !strings.Contains(string(full), "uuid-18091") {
+		t.Errorf("full CSV report doesn't have the cluster's UUID")
+	}
+}
+
+func TestFormatXLSXRoundTrip(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	body := formatRaw(t, summary, ReportOptions{Format: FORMAT_XLSX})
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(contents)
+	}
+
+	for _, want := range []string{`<sheet name="Fleet summary"`, `<sheet name="0 prod-eu"`} {
+		if !strings.Contains(parts["xl/workbook.xml"], want) {
+			t.Errorf("workbook doesn't have %s", want)
+		}
+	}
+	if !strings.Contains(parts["xl/worksheets/sheet2.xml"], "node2:18091") {
+		t.Errorf("cluster sheet doesn't list node2:18091")
+	}
+}
+
+func TestXLSXSheetName(t *testing.T) {
+	name := xlsxSheetName(12, "ümlaut-cluster/ü-prod-eu-west-1-primary")
+	if !utf8.ValidString(name) {
+		t.Errorf("%q isn't valid UTF-8", name)
+	}
+	if n := utf8.RuneCountInString(name); n != 31 {
+		t.Errorf("%q has %d characters, want 31", name, n)
+	}
+	if strings.ContainsRune(name, '/') {
+		t.Errorf("%q has a '/'", name)
+	}
+}
+
+func TestFormatYAMLRoundTrip(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	var doc struct {
+		NumClusters int `yaml:"#clusters"`
+		NumNodes    int `yaml:"#nodes"`
+		Clusters    []struct {
+			UUID  string `yaml:"cluster_uuid"`
+			Nodes []struct {
+				Cores float64 `yaml:"cpu_cores_available"`
+				Name  string  `yaml:"hostname"`
+			} `yaml:"nodes"`
+		} `yaml:"clusters"`
+	}
+	err := yaml.Unmarshal(formatRaw(t, summary, ReportOptions{Format: FORMAT_YAML}), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.NumClusters != 1 || doc.NumNodes != 2 || len(doc.Clusters) != 1 {
+		t.Fatalf("read back %+v", doc)
+	}
+	if doc.Clusters[0].UUID != "uuid-18091" || doc.Clusters[0].Nodes[1].Cores != 8 {
+		t.Errorf("read back cluster %+v", doc.Clusters[0])
+	}
+}
+
+func TestFormatJSONLRoundTrip(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	for _, nodes := range []bool{false, true} {
+		body := formatRaw(t, summary, ReportOptions{Format: FORMAT_JSONL, JSONLNodes: nodes})
+		lines := make([]map[string]interface{}, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var line map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("nodes %v: %v in %s", nodes, err, scanner.Text())
+			}
+			lines = append(lines, line)
+		}
+
+		want := 1
+		if nodes {
+			want = 2
+		}
+		if len(lines) != want {
+			t.Fatalf("nodes %v: %d lines, want %d", nodes, len(lines), want)
+		}
+		if lines[0]["cluster_uuid"] != "uuid-18091" || lines[0]["cluster_num"] != 0.0 {
+			t.Errorf("nodes %v: first line %v", nodes, lines[0])
+		}
+	}
+}
+
+func TestFormatPrometheusRoundTrip(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{})
+	body := formatRaw(t, summary, ReportOptions{Format: FORMAT_PROM})
+
+	samples := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		space := strings.LastIndex(line, " ")
+		if space < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		samples[line[:space]] = line[space+1:]
+	}
+
+	want := map[string]string{
+		`cbsummary_clusters`: "1",
+		`cbsummary_nodes`:    "2",
+		`cbsummary_cluster_cpu_cores{cluster_num="0",cluster_uuid="uuid-18091",label="prod-eu"}`: "12",
+		`cbsummary_environment_nodes{environment="prod"}`:                                        "2",
+	}
+	for sample, value := range want {
+		if samples[sample] != value {
+			t.Errorf("%s is %q, want %q", sample, samples[sample], value)
+		}
+	}
+}
+
+func TestFormatJSONRoundTrip(t *testing.T) {
+	for _, full := range []bool{false, true} {
+		summary := collectRaw(t, CollectOptions{Full: full})
+		parsed, err := ParseReport(formatRaw(t, summary, ReportOptions{Format: FORMAT_JSON}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.NumClusters != summary.NumClusters || parsed.TotalNumNodes != summary.TotalNumNodes {
+			t.Errorf("full %v: read back %d clusters and %d nodes, want %d and %d", full, parsed.NumClusters,
+				parsed.TotalNumNodes, summary.NumClusters, summary.TotalNumNodes)
+		}
+		switch c := parsed.Clusters[0].(type) {
+		case *BriefCluster:
+			if full || c.UUID != "uuid-18091" || len(c.Nodes) != 2 || !c.Nodes[0].CPULimited {
+				t.Errorf("full %v: read back %+v", full, c)
+			}
+		case *ClusterSummary:
+			if !full || c.Uuid != "uuid-18091" || len(c.Nodes) != 2 {
+				t.Errorf("full %v: read back %+v", full, c)
+			}
+		default:
+			t.Errorf("full %v: read back a %T", full, c)
+		}
+	}
+}
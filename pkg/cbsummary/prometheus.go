@@ -0,0 +1,177 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// Prometheus exposition format - the summary as gauges, for a node_exporter
+// textfile collector or a pushgateway
+//
+// Every metric is a gauge named cbsummary_*. The cluster metrics are labelled
+// with the cluster's number, UUID and label, and the node metrics also with
+// the node's hostname and version. Clusters which couldn't be collected have
+// cbsummary_cluster_up 0 and no other cluster or node metrics.
+//
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type promMetric struct {
+	help    string
+	samples []string
+}
+
+type promWriter struct {
+	names   []string
+	metrics map[string]*promMetric
+}
+
+func newPromWriter() *promWriter {
+	return &promWriter{metrics: make(map[string]*promMetric)}
+}
+
+// add a sample of a metric; labels are given as name, value pairs
+func (p *promWriter) add(name, help string, value float64, labels ...string) {
+	metric, ok := p.metrics[name]
+	if !ok {
+		metric = &promMetric{help: help}
+		p.metrics[name] = metric
+		p.names = append(p.names, name)
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], promEscape(labels[i+1])))
+	}
+	sample := name
+	if len(pairs) > 0 {
+		sample = sample + "{" + strings.Join(pairs, ",") + "}"
+	}
+	metric.samples = append(metric.samples, fmt.Sprintf("%s %g", sample, value))
+}
+
+func (p *promWriter) String() string {
+	var buffer strings.Builder
+	for _, name := range p.names {
+		metric := p.metrics[name]
+		fmt.Fprintf(&buffer, "# HELP %s %s\n# TYPE %s gauge\n", name, metric.help, name)
+		for _, sample := range metric.samples {
+			buffer.WriteString(sample + "\n")
+		}
+	}
+	return buffer.String()
+}
+
+func promEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// render the summary in the Prometheus exposition format
+func FormatPrometheus(clusterSummary *SummaryInfo) []byte {
+	p := newPromWriter()
+	if m := clusterSummary.Metadata; m != nil {
+		p.add("cbsummary_build_info", "The cbsummary build that produced the report, and the report's schema version.", 1,
+			"version", m.ToolVersion, "commit", m.ToolCommit, "schema_version", fmt.Sprint(m.SchemaVersion))
+		if !m.GeneratedAt.IsZero() {
+			p.add("cbsummary_generated_timestamp_seconds", "When the report was generated, in seconds since the epoch.",
+				float64(m.GeneratedAt.Unix()))
+		}
+		if m.CollectionDuration > 0 {
+			p.add("cbsummary_collection_duration_seconds", "How long collecting the clusters took.",
+				time.Duration(m.CollectionDuration).Seconds())
+		}
+	}
+	p.add("cbsummary_clusters", "Number of clusters in the report.", float64(clusterSummary.NumClusters))
+	p.add("cbsummary_nodes", "Number of nodes across all the clusters.", float64(clusterSummary.TotalNumNodes))
+
+	versions := make([]string, 0, len(clusterSummary.NodeVersions))
+	for version := range clusterSummary.NodeVersions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		p.add("cbsummary_version_nodes", "Number of nodes running each server version.",
+			float64(clusterSummary.NodeVersions[version]), "version", version)
+	}
+	if m := clusterSummary.NodeMembership; m != nil {
+		help := "Number of nodes across all the clusters that are failed over, added but inactive, or pending rebalance."
+		p.add("cbsummary_membership_nodes", help, float64(m.FailedOver), "state", "failed_over")
+		p.add("cbsummary_membership_nodes", help, float64(m.InactiveAdded), "state", "inactive_added")
+		p.add("cbsummary_membership_nodes", help, float64(m.PendingRebalance), "state", "pending_rebalance")
+	}
+	if e := clusterSummary.Environments; e != nil {
+		for _, name := range e.Names() {
+			totals := e.Environments[name]
+			p.add("cbsummary_environment_clusters", "Number of clusters collected in each environment.",
+				float64(len(totals.Clusters)), "environment", name)
+			p.add("cbsummary_environment_nodes", "Number of nodes in each environment.", float64(totals.Nodes),
+				"environment", name)
+			p.add("cbsummary_environment_cores", "Number of cores in each environment.", totals.Cores,
+				"environment", name)
+		}
+	}
+
+	for cnum, icluster := range clusterSummary.Clusters {
+		num := fmt.Sprint(cnum)
+		var uuid, label string
+		var nodes []BriefNode
+
+		switch c := icluster.(type) {
+		case *BriefCluster:
+			uuid, label, nodes = c.UUID, c.Label, c.Nodes
+		case *ClusterSummary:
+			uuid, label = c.Uuid, c.Label
+			for _, nodeInfo := range c.Nodes {
+				nodes = append(nodes, BriefNode{
+					Name:    nodeInfo.Hostname,
+					Version: nodeInfo.Version,
+					Cores:   nodeInfo.SystemStats.CPU_cores_available,
+					RAM:     nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0,
+				})
+			}
+		case *ClusterError:
+			p.add("cbsummary_cluster_up", "Whether the cluster was collected.", 0,
+				"cluster_num", num, "label", c.TheCluster.Label)
+			continue
+		default:
+			continue
+		}
+
+		p.add("cbsummary_cluster_up", "Whether the cluster was collected.", 1,
+			"cluster_num", num, "cluster_uuid", uuid, "label", label)
+		var cores, ram float64
+		for _, node := range nodes {
+			cores = cores + node.Cores
+			ram = ram + node.RAM
+		}
+		p.add("cbsummary_cluster_nodes", "Number of nodes in the cluster.", float64(len(nodes)),
+			"cluster_num", num, "cluster_uuid", uuid, "label", label)
+		p.add("cbsummary_cluster_cpu_cores", "CPU cores available to the cluster's nodes.", cores,
+			"cluster_num", num, "cluster_uuid", uuid, "label", label)
+		p.add("cbsummary_cluster_ram_gb", "Total RAM of the cluster's nodes in GB.", ram,
+			"cluster_num", num, "cluster_uuid", uuid, "label", label)
+
+		for _, node := range nodes {
+			// no cores info for earlier than 6.5
+			if node.Cores > 0 {
+				p.add("cbsummary_node_cpu_cores", "CPU cores available to the node.", node.Cores,
+					"cluster_uuid", uuid, "label", label, "hostname", node.Name, "version", node.Version)
+			}
+			p.add("cbsummary_node_ram_gb", "Total RAM of the node in GB.", node.RAM,
+				"cluster_uuid", uuid, "label", label, "hostname", node.Name, "version", node.Version)
+		}
+	}
+
+	return []byte(p.String())
+}
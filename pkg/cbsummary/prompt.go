@@ -0,0 +1,54 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// questions for the user at the terminal
+//
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// whether the file is a terminal rather than a pipe, a file or /dev/null
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// ask a yes/no question, returning the default if there's nobody at the terminal to answer
+func AskYesNo(question string, def bool) bool {
+	if !isTerminal(os.Stdin) {
+		return def
+	}
+
+	choices := "[y/N]"
+	if def {
+		choices = "[Y/n]"
+	}
+	fmt.Fprintf(console, "%s %s ", question, choices)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return def
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// agent push mode - cbsummary instances inside isolated network segments push
+// their summaries to a central receiver ('cbsummary receive'), which merges
+// the latest summary from every agent into one estate-wide report
+//
+// Pushes are only made over https. Each push is signed with an HMAC-SHA256 of
+// "<agent>\n<timestamp>\n<body>" using a key shared between the agents and
+// the receiver. The receiver rejects pushes whose timestamp is too far from
+// its own clock, or isn't later than the last push it accepted from the
+// agent, so a captured push can't be replayed, whether later, in the
+// meantime, or under another agent's name. It checks the headers before
+// reading the body, and the signature as the body streams in.
+//
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PUSH_PATH             = "/api/v1/push"
+	PUSH_AGENT_HEADER     = "X-Cbsummary-Agent"
+	PUSH_TIMESTAMP_HEADER = "X-Cbsummary-Timestamp"
+	PUSH_SIGNATURE_HEADER = "X-Cbsummary-Signature"
+
+	// how far a push's timestamp may be from the receiver's clock
+	PUSH_MAX_SKEW = 5 * time.Minute
+)
+
+type PushOptions struct {
+	URL     string
+	KeyFile string
+	Agent   string
+	CACert  string
+}
+
+// read the shared signing key, ignoring surrounding whitespace
+func LoadPushKey(keyFile string) ([]byte, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading push key %s: %v", keyFile, err)
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("Push key %s is too short, it should be at least 16 characters", keyFile)
+	}
+	return key, nil
+}
+
+// the HMAC of a push from the agent, for the body to be written to
+func pushMAC(key []byte, agent, timestamp string) hash.Hash {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(agent + "\n" + timestamp + "\n"))
+	return mac
+}
+
+// the signature header for a push's HMAC
+func pushSignature(mac hash.Hash) string {
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func signPush(key []byte, agent, timestamp string, body []byte) string {
+	mac := pushMAC(key, agent, timestamp)
+	mac.Write(body)
+	return pushSignature(mac)
+}
+
+// the time of a push, in seconds, checking it is close to the receiver's clock
+func checkPushTimestamp(timestamp string, now time.Time) (int64, error) {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(secs, 0))
+	if skew > PUSH_MAX_SKEW || skew < -PUSH_MAX_SKEW {
+		return 0, fmt.Errorf("timestamp is %s from the receiver's clock", skew)
+	}
+	return secs, nil
+}
+
+// check the signature and timestamp of a push from the agent
+func verifyPush(key []byte, agent, timestamp, signature string, body []byte, now time.Time) error {
+	_, err := checkPushTimestamp(timestamp, now)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(signPush(key, agent, timestamp, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// send a summary to the central receiver
+func PushSummary(options *PushOptions, clusterSummary *SummaryInfo) error {
+	if !strings.HasPrefix(options.URL, "https://") {
+		return fmt.Errorf("Push URL %s must use https", options.URL)
+	}
+
+	key, err := LoadPushKey(options.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(options.CACert) > 0 {
+		tlsConfig.RootCAs, err = LoadCACertPool(options.CACert)
+		if err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(clusterSummary)
+	if err != nil {
+		return fmt.Errorf("Error marshalling summary: %v", err)
+	}
+
+	url := strings.TrimSuffix(options.URL, "/")
+	if !strings.HasSuffix(url, PUSH_PATH) {
+		url = url + PUSH_PATH
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return &RestClientError{"POST", url, err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(PUSH_AGENT_HEADER, options.Agent)
+	req.Header.Set(PUSH_TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(PUSH_SIGNATURE_HEADER, signPush(key, options.Agent, timestamp, body))
+
+	client := http.Client{
+		Transport: NewTransport(tlsConfig, TransportOptions{}),
+		Timeout:   5 * time.Minute,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &RestClientError{"POST", url, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return HttpError{resp.StatusCode, "POST", url, strings.TrimSpace(string(msg))}
+	}
+
+	LogInfo("Pushed summary of %d clusters to %s as agent %s.", clusterSummary.NumClusters, options.URL,
+		options.Agent)
+	return nil
+}
+
+// the agent name to use when none is given
+func DefaultAgentName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPushSignatureCoversAgent(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	body := []byte(`{"#clusters": 0}`)
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := signPush(key, "agent-a", timestamp, body)
+
+	if err := verifyPush(key, "agent-a", timestamp, signature, body, now); err != nil {
+		t.Fatalf("verifying the push as signed: %v", err)
+	}
+	if err := verifyPush(key, "agent-b", timestamp, signature, body, now); err == nil {
+		t.Errorf("push verified under another agent's name")
+	}
+
+	// a replayed push under another agent's name is rejected, leaving that
+	// agent's state alone
+	dir := t.TempDir()
+	receiver := &Receiver{Key: key, StateDir: dir, OutputFile: filepath.Join(dir, "merged.json")}
+	if err := receiver.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		agent  string
+		status int
+	}{
+		{"agent-b", http.StatusUnauthorized},
+		{"agent-a", http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", PUSH_PATH, bytes.NewReader(body))
+		req.Header.Set(PUSH_AGENT_HEADER, test.agent)
+		req.Header.Set(PUSH_TIMESTAMP_HEADER, timestamp)
+		req.Header.Set(PUSH_SIGNATURE_HEADER, signature)
+		resp := httptest.NewRecorder()
+		receiver.HandlePush(resp, req)
+		if resp.Code != test.status {
+			t.Errorf("push as %s: got status %d, want %d", test.agent, resp.Code, test.status)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "agent-b.json")); !os.IsNotExist(err) {
+		t.Errorf("replayed push was saved as agent-b's state")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "agent-a.json")); err != nil {
+		t.Errorf("push as agent-a wasn't saved: %v", err)
+	}
+}
+
+func TestPushReplayRejected(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	body := []byte(`{"#clusters": 0}`)
+	now := time.Now()
+	dir := t.TempDir()
+
+	push := func(receiver *Receiver, at time.Time, signature string) int {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		if len(signature) == 0 {
+			signature = signPush(key, "agent-a", timestamp, body)
+		}
+		req := httptest.NewRequest("POST", PUSH_PATH, bytes.NewReader(body))
+		req.Header.Set(PUSH_AGENT_HEADER, "agent-a")
+		req.Header.Set(PUSH_TIMESTAMP_HEADER, timestamp)
+		req.Header.Set(PUSH_SIGNATURE_HEADER, signature)
+		resp := httptest.NewRecorder()
+		receiver.HandlePush(resp, req)
+		return resp.Code
+	}
+	receiver := func() *Receiver {
+		receiver := &Receiver{Key: key, StateDir: dir, OutputFile: filepath.Join(dir, "merged.json")}
+		if err := receiver.LoadState(); err != nil {
+			t.Fatal(err)
+		}
+		return receiver
+	}
+
+	first := receiver()
+	for _, test := range []struct {
+		name      string
+		at        time.Time
+		signature string
+		status    int
+	}{
+		{"bad signature", now, "sha256=00", http.StatusUnauthorized},
+		{"missing signature", now, "none", http.StatusUnauthorized},
+		{"outside the skew", now.Add(-2 * PUSH_MAX_SKEW), "", http.StatusUnauthorized},
+		{"first push", now, "", http.StatusOK},
+		{"exact replay", now, "", http.StatusUnauthorized},
+		{"older push", now.Add(-time.Minute), "", http.StatusUnauthorized},
+		{"newer push", now.Add(time.Second), "", http.StatusOK},
+	} {
+		if status := push(first, test.at, test.signature); status != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, status, test.status)
+		}
+	}
+
+	// the last accepted push survives a restart
+	if status := push(receiver(), now.Add(time.Second), ""); status != http.StatusUnauthorized {
+		t.Errorf("replay after a restart: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := push(receiver(), now.Add(2*time.Second), ""); status != http.StatusOK {
+		t.Errorf("newer push after a restart: got status %d, want %d", status, http.StatusOK)
+	}
+
+	// nothing is left behind from the rejected pushes
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(files) > 0 {
+		t.Errorf("rejected pushes left %v", files)
+	}
+}
+
+func TestMergeSummaries(t *testing.T) {
+	generated := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := &SummaryInfo{
+		NumClusters: 2,
+		Clusters:    []interface{}{"a0", "a1"},
+		Drift:       &DriftReport{Label: "env"},
+		Findings:    []Finding{{Severity: SEVERITY_WARNING, Rule: "mixed-versions", ClusterNum: 1}},
+		Metadata:    &ReportMetadata{ToolVersion: "1.0", GeneratedAt: generated},
+	}
+	second := &SummaryInfo{
+		NumClusters: 1,
+		Clusters:    []interface{}{"b0"},
+		Findings:    []Finding{{Severity: SEVERITY_CRITICAL, Rule: "cluster-unreachable", ClusterNum: 0}},
+		Metadata:    &ReportMetadata{ToolVersion: "1.1", TimedOut: true},
+	}
+
+	merged := MergeSummaries([]string{"agent-a", "agent-b"}, []*SummaryInfo{first, second})
+	if merged.NumClusters != 3 || len(merged.Clusters) != 3 {
+		t.Errorf("merged %d clusters, want 3", merged.NumClusters)
+	}
+	want := []Finding{
+		{Severity: SEVERITY_CRITICAL, Rule: "cluster-unreachable", ClusterNum: 2},
+		{Severity: SEVERITY_WARNING, Rule: "mixed-versions", ClusterNum: 1},
+	}
+	if !reflect.DeepEqual(merged.Findings, want) {
+		t.Errorf("merged findings %+v, want %+v", merged.Findings, want)
+	}
+	if merged.Drift != nil || !reflect.DeepEqual(merged.Metadata.OmittedSections, []string{"config_drift"}) {
+		t.Errorf("drift wasn't left out and noted: %+v, omitted %v", merged.Drift, merged.Metadata.OmittedSections)
+	}
+	if !merged.Metadata.TimedOut || merged.Metadata.Interrupted {
+		t.Errorf("merged timed out %v, interrupted %v, want true, false",
+			merged.Metadata.TimedOut, merged.Metadata.Interrupted)
+	}
+	agents := merged.Metadata.AgentReports
+	if len(agents) != 2 || agents[0].Agent != "agent-a" || agents[0].ToolVersion != "1.0" ||
+		!agents[0].GeneratedAt.Equal(generated) || agents[1].Agent != "agent-b" || !agents[1].TimedOut {
+		t.Errorf("agent metadata wasn't kept: %+v", agents)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// query service workload statistics, from /admin/stats on each query node,
+// with the memory used by each query service from /admin/vitals
+//
+// The counters are cumulative since each query service last started, so a
+// cluster which has served no requests in that time is reported as not
+// serving N1QL traffic, even though it runs the query service.
+//
+// These are collected with --query-stats, and always in the full report for
+// clusters running the query service.
+//
+
+type QueryServiceStats struct {
+	Nodes          []QueryNodeStats `json:"nodes"`
+	Requests       float64          `json:"requests"`
+	Errors         float64          `json:"errors"`
+	ActiveRequests float64          `json:"active_requests"`
+	QueuedRequests float64          `json:"queued_requests"`
+	Prepared       float64          `json:"prepared_statements"`
+	MemoryUsed     float64          `json:"memory_used_mb"`
+	ServesTraffic  bool             `json:"serves_traffic"`
+	Error          string           `json:"error,omitempty"`
+}
+
+type QueryNodeStats struct {
+	Host           string  `json:"host"`
+	Requests       float64 `json:"requests"`
+	Errors         float64 `json:"errors"`
+	ActiveRequests float64 `json:"active_requests"`
+	QueuedRequests float64 `json:"queued_requests"`
+	Prepared       float64 `json:"prepared_statements"`
+	MemoryUsed     float64 `json:"memory_used_mb"`
+	Error          string  `json:"error,omitempty"`
+}
+
+func CollectQueryStats(conn *ClusterConn) *QueryServiceStats {
+	stats := &QueryServiceStats{Nodes: make([]QueryNodeStats, 0)}
+
+	clients, err := conn.ServiceClients("n1ql")
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+
+	for _, queryClient := range clients {
+		node := QueryNodeStats{Host: queryClient.host}
+
+		var data map[string]interface{}
+		err := queryClient.getJSON("/admin/stats", &data)
+		if err != nil {
+			LogError("Error getting query stats from %s: %v", queryClient.host, err)
+			node.Error = err.Error()
+		} else {
+			node.Requests = statValue(data, "requests.count")
+			node.Errors = statValue(data, "errors.count")
+			node.ActiveRequests = statValue(data, "active_requests.count")
+			node.QueuedRequests = statValue(data, "queued_requests.count")
+			node.Prepared = statValue(data, "prepared.count")
+		}
+
+		// the vitals are informational, so don't fail the node without them
+		var vitals map[string]interface{}
+		err = queryClient.getJSON("/admin/vitals", &vitals)
+		if err != nil {
+			LogError("Error getting query vitals from %s: %v", queryClient.host, err)
+		} else {
+			node.MemoryUsed = statValue(vitals, "memory.usage") / 1024.0 / 1024.0
+		}
+
+		stats.Nodes = append(stats.Nodes, node)
+		stats.Requests = stats.Requests + node.Requests
+		stats.Errors = stats.Errors + node.Errors
+		stats.ActiveRequests = stats.ActiveRequests + node.ActiveRequests
+		stats.QueuedRequests = stats.QueuedRequests + node.QueuedRequests
+		stats.Prepared = stats.Prepared + node.Prepared
+		stats.MemoryUsed = stats.MemoryUsed + node.MemoryUsed
+	}
+
+	stats.ServesTraffic = stats.Requests > 0
+	return stats
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// rate limiting - --max-requests-per-second (or "max_requests_per_second" in
+// the transport section) spaces out the requests made of each cluster, so a
+// full collection of a large cluster doesn't load its admin ports during
+// business hours
+//
+// The limit is per cluster: the clients for a cluster's nodes and service
+// ports share one limiter, and clusters collected at the same time each have
+// their own. Retries count as requests. Requests are spaced evenly rather than
+// allowed in bursts, and replayed requests (see raw.go) aren't limited.
+//
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// a limiter allowing perSecond requests a second, or nil for no limit
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait for the next request's turn, returning the context's error if it's
+// done first
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// record and replay - 'cbsummary collect --raw-dir=<dir>' saves every REST
+// response the clusters give, and 'cbsummary report --from-raw=<dir>' later
+// generates a report from them without contacting the clusters, e.g. to try
+// another format or set of options on the same data, or to test against.
+//
+// The directory holds clusters.json, the clusters as configured with their
+// passwords, headers and client certificates left out, and a directory for
+// each host, with a file for each request made of it:
+//
+//   <dir>/clusters.json
+//   <dir>/10.1.2.3_8091/GET_pools_default_1a2b3c4d.json
+//
+// Each file has the request's method and URL, and the response's status,
+// content type and body, or the error if no response came. The body is
+// written as JSON when it is JSON, so the files can be read and edited by
+// hand. Requests are matched on their method, URL and body, so the report
+// can only use what was collected in the first place.
+//
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const RAW_CLUSTERS_FILE = "clusters.json"
+
+// a directory of raw REST responses, being recorded or replayed
+type RawStore struct {
+	dir    string
+	replay bool
+}
+
+// one request and its response, as saved
+type rawExchange struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Status      int             `json:"status,omitempty"`
+	ContentType string          `json:"content_type,omitempty"`
+	JSON        json.RawMessage `json:"json,omitempty"`
+	Body        string          `json:"body,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// a store saving responses in a directory, created if need be
+func NewRawRecorder(dir string) (*RawStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating raw response directory %s: %v", dir, err)
+	}
+	return &RawStore{dir: dir}, nil
+}
+
+// a store answering requests from the responses saved in a directory
+func OpenRawSnapshots(dir string) (*RawStore, error) {
+	_, err := os.Stat(filepath.Join(dir, RAW_CLUSTERS_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("%s doesn't hold raw responses saved with --raw-dir: %v", dir, err)
+	}
+	return &RawStore{dir: dir, replay: true}, nil
+}
+
+// save the clusters being collected, without their credentials, for replaying
+func (s *RawStore) SaveClusters(clusters *ClusterList) error {
+	saved := &ClusterList{Transport: clusters.Transport}
+	for _, cluster := range clusters.Clusters {
+		cluster = cluster.asConfigured()
+		cluster.Pass = ""
+		cluster.Prompt = false
+		saved.Clusters = append(saved.Clusters, cluster)
+	}
+	for _, org := range clusters.Capella {
+		org.APISecret = ""
+		saved.Capella = append(saved.Capella, org)
+	}
+
+	body, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(s.dir, RAW_CLUSTERS_FILE)
+	err = ioutil.WriteFile(file, body, 0644)
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %v", file, err)
+	}
+	return nil
+}
+
+// the clusters saved with the responses
+func (s *RawStore) LoadClusters() (*ClusterList, error) {
+	file := filepath.Join(s.dir, RAW_CLUSTERS_FILE)
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", file, err)
+	}
+	clusters := new(ClusterList)
+	err = json.Unmarshal(body, clusters)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", file, err)
+	}
+	return clusters, nil
+}
+
+// a round tripper recording the responses of next, or replaying those saved
+func (s *RawStore) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if s.replay {
+		return &rawReplayer{s}
+	}
+	return &rawRecorder{s, next}
+}
+
+// the file for a request, in the directory for its host
+func (s *RawStore) file(req *http.Request, body []byte) string {
+	safe := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '-' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+				return r
+			}
+			return '_'
+		}, s)
+	}
+	path := strings.Trim(req.URL.Path, "/")
+	if len(path) == 0 {
+		path = "root"
+	}
+	sum := sha256.Sum256(append([]byte(req.Method+" "+req.URL.String()+"\n"), body...))
+	return filepath.Join(s.dir, safe(req.URL.Host), fmt.Sprintf("%s_%s_%x.json", req.Method, safe(path), sum[:4]))
+}
+
+// the body of a request, leaving it to be read again
+func rawRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type rawRecorder struct {
+	store *RawStore
+	next  http.RoundTripper
+}
+
+func (r *rawRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := rawRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	file := r.store.file(req, reqBody)
+	exchange := rawExchange{Method: req.Method, URL: req.URL.String()}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		// requests given up on, e.g. for another node answering first, aren't errors
+		if req.Context().Err() == nil {
+			exchange.Error = err.Error()
+			r.save(file, exchange)
+		}
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	exchange.Status = resp.StatusCode
+	exchange.ContentType = resp.Header.Get("Content-Type")
+	if json.Valid(body) {
+		exchange.JSON = body
+	} else {
+		exchange.Body = string(body)
+	}
+	r.save(file, exchange)
+	return resp, nil
+}
+
+func (r *rawRecorder) save(file string, exchange rawExchange) {
+	body, err := json.MarshalIndent(exchange, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(file, body, 0644)
+	}
+	if err != nil {
+		LogError("Error saving raw response to %s: %v", file, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type rawReplayer struct {
+	store *RawStore
+}
+
+func (r *rawReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := rawRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	file := r.store.file(req, reqBody)
+	saved, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no response to %s %s was saved in %s", req.Method, req.URL, r.store.dir)
+	} else if err != nil {
+		return nil, err
+	}
+	var exchange rawExchange
+	err = json.Unmarshal(saved, &exchange)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", file, err)
+	}
+	if len(exchange.Error) > 0 {
+		return nil, fmt.Errorf("%s", exchange.Error)
+	}
+
+	body := []byte(exchange.Body)
+	if len(exchange.JSON) > 0 {
+		body = exchange.JSON
+	}
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	if len(exchange.ContentType) > 0 {
+		resp.Header.Set("Content-Type", exchange.ContentType)
+	}
+	return resp, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// RBAC users and roles, from /settings/rbac/users, for periodic access
+// reviews
+//
+// Users are counted by domain: "local" users are defined in the cluster
+// (internal), "external" ones are authenticated by LDAP, PAM or SAML. Each
+// role is counted once per user holding it, whatever the buckets it is
+// granted on, and the users holding the full "admin" role are listed by name.
+// Roles a user only gets through membership of a group are not expanded.
+//
+
+import (
+	"sort"
+)
+
+type RBACSummary struct {
+	Users      int            `json:"users"`
+	Internal   int            `json:"internal_users"`
+	External   int            `json:"external_users"`
+	Roles      map[string]int `json:"roles"`
+	FullAdmins []string       `json:"full_admins"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// types for parsing JSON from /settings/rbac/users
+
+type RBACUser struct {
+	ID     string     `json:"id"`
+	Domain string     `json:"domain"`
+	Name   string     `json:"name"`
+	Roles  []RBACRole `json:"roles"`
+	Groups []string   `json:"groups"`
+}
+
+type RBACRole struct {
+	Role       string `json:"role"`
+	BucketName string `json:"bucket_name"`
+}
+
+func CollectRBACSummary(conn *ClusterConn) *RBACSummary {
+	summary := &RBACSummary{
+		Roles:      make(map[string]int),
+		FullAdmins: make([]string, 0),
+	}
+
+	var users []RBACUser
+	err := conn.Client.getJSON("/settings/rbac/users", &users)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+
+	for _, user := range users {
+		summary.Users++
+		if user.Domain == "local" {
+			summary.Internal++
+		} else {
+			summary.External++
+		}
+
+		held := make(map[string]bool)
+		for _, role := range user.Roles {
+			held[role.Role] = true
+		}
+		for role := range held {
+			summary.Roles[role]++
+		}
+		if held["admin"] {
+			summary.FullAdmins = append(summary.FullAdmins, user.ID+" ("+user.Domain+")")
+		}
+	}
+
+	sort.Strings(summary.FullAdmins)
+	return summary
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// cbsummary receive - the central end of agent push mode (see push.go)
+//
+// The latest summary from each agent is kept in a state directory, so that a
+// restart of the receiver doesn't lose the agents that haven't pushed since,
+// and after every push the merged report is rewritten. Beside each summary is
+// the timestamp of the agent's last accepted push, so that a push captured in
+// flight can't be replayed, even across a restart.
+//
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// largest push accepted, to protect the receiver from runaway agents
+const MAX_PUSH_SIZE = 256 * 1024 * 1024
+
+var VALID_AGENT_NAME = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+type Receiver struct {
+	Key        []byte
+	StateDir   string
+	OutputFile string
+
+	mu     sync.Mutex
+	agents map[string]*SummaryInfo
+	// the timestamp of the last push accepted from each agent
+	lastPush map[string]int64
+}
+
+// load the summaries kept from earlier pushes
+func (r *Receiver) LoadState() error {
+	r.agents = make(map[string]*SummaryInfo)
+	r.lastPush = make(map[string]int64)
+
+	err := os.MkdirAll(r.StateDir, 0700)
+	if err != nil {
+		return fmt.Errorf("Error creating state directory %s: %v", r.StateDir, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(r.StateDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("Error reading agent state %s: %v", file, err)
+		}
+		var summary SummaryInfo
+		err = json.Unmarshal(body, &summary)
+		if err != nil {
+			return fmt.Errorf("Error parsing agent state %s: %v", file, err)
+		}
+		agent := strings.TrimSuffix(filepath.Base(file), ".json")
+		r.agents[agent] = &summary
+
+		// kept beside the summary; state from before timestamps were kept
+		// accepts any push in the allowed skew
+		timestamp, err := ioutil.ReadFile(r.timestampFile(agent))
+		if err == nil {
+			r.lastPush[agent], err = strconv.ParseInt(strings.TrimSpace(string(timestamp)), 10, 64)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error reading agent state %s: %v", r.timestampFile(agent), err)
+		}
+	}
+	return nil
+}
+
+func (r *Receiver) stateFile(agent string) string {
+	return filepath.Join(r.StateDir, agent+".json")
+}
+
+func (r *Receiver) timestampFile(agent string) string {
+	return filepath.Join(r.StateDir, agent+".timestamp")
+}
+
+// check a push is newer than the last accepted from the agent
+func (r *Receiver) checkNewer(agent string, timestamp int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.lastPush[agent]; ok && timestamp <= last {
+		return fmt.Errorf("timestamp %d is not later than the last push accepted, at %d", timestamp, last)
+	}
+	return nil
+}
+
+func (r *Receiver) HandlePush(w http.ResponseWriter, req *http.Request) {
+	agent := req.Header.Get(PUSH_AGENT_HEADER)
+	if !VALID_AGENT_NAME.MatchString(agent) {
+		http.Error(w, "missing or invalid agent name", http.StatusBadRequest)
+		return
+	}
+	reject := func(err error) {
+		LogError("Rejected push from agent %s (%s): %v", agent, req.RemoteAddr, err)
+		http.Error(w, "push rejected: "+err.Error(), http.StatusUnauthorized)
+	}
+
+	// everything but the body is checked before reading it
+	header := req.Header.Get(PUSH_TIMESTAMP_HEADER)
+	signature := req.Header.Get(PUSH_SIGNATURE_HEADER)
+	timestamp, err := checkPushTimestamp(header, time.Now())
+	if err == nil && !strings.HasPrefix(signature, "sha256=") {
+		err = fmt.Errorf("missing or invalid signature")
+	}
+	if err == nil {
+		err = r.checkNewer(agent, timestamp)
+	}
+	if err != nil {
+		reject(err)
+		return
+	}
+
+	// stream the body to a file beside the agent's state, signing it as it
+	// goes, and only parse it once the signature matches
+	tmp, err := ioutil.TempFile(r.StateDir, agent+".*.tmp")
+	if err != nil {
+		LogError("Error saving push from agent %s: %v", agent, err)
+		http.Error(w, "error saving summary", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	mac := pushMAC(r.Key, agent, header)
+	_, err = io.Copy(io.MultiWriter(tmp, mac), http.MaxBytesReader(w, req.Body, MAX_PUSH_SIZE))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(signature), []byte(pushSignature(mac))) {
+		reject(fmt.Errorf("signature mismatch"))
+		return
+	}
+
+	var summary SummaryInfo
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err == nil {
+		err = json.NewDecoder(tmp).Decode(&summary)
+	}
+	if err != nil {
+		http.Error(w, "invalid summary: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	tmp.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// another push from the agent may have been accepted meanwhile
+	if last, ok := r.lastPush[agent]; ok && timestamp <= last {
+		reject(fmt.Errorf("timestamp %d is not later than the last push accepted, at %d", timestamp, last))
+		return
+	}
+	err = os.Rename(tmp.Name(), r.stateFile(agent))
+	if err == nil {
+		err = ioutil.WriteFile(r.timestampFile(agent), []byte(strconv.FormatInt(timestamp, 10)+"\n"), 0600)
+	}
+	if err != nil {
+		LogError("Error saving push from agent %s: %v", agent, err)
+		http.Error(w, "error saving summary", http.StatusInternalServerError)
+		return
+	}
+	r.agents[agent] = &summary
+	r.lastPush[agent] = timestamp
+	LogInfo("Received summary of %d clusters from agent %s.", summary.NumClusters, agent)
+
+	err = r.writeMerged()
+	if err != nil {
+		LogError("%v", err)
+		http.Error(w, "error writing merged report", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// write the merged report; must be called with the lock held
+func (r *Receiver) writeMerged() error {
+	names := make([]string, 0, len(r.agents))
+	for name := range r.agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summaries := make([]*SummaryInfo, len(names))
+	for i, name := range names {
+		summaries[i] = r.agents[name]
+	}
+
+	merged := MergeSummaries(names, summaries)
+	body, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling merged summary: %v", err)
+	}
+	err = ioutil.WriteFile(r.OutputFile, body, 0644)
+	if err != nil {
+		return fmt.Errorf("Error writing output file %s: %v", r.OutputFile, err)
+	}
+	return nil
+}
+
+// merge the summaries from several agents into one, in the given order,
+// renumbering the clusters referred to in each. Config drift can't be merged,
+// so is left out, and listed in the metadata as omitted
+func MergeSummaries(agents []string, summaries []*SummaryInfo) *SummaryInfo {
+	merged := new(SummaryInfo)
+	merged.NodeVersions = make(map[string]int)
+	merged.Clusters = make([]interface{}, 0)
+	merged.Metadata = newReportMetadata()
+	merged.Metadata.Agents = agents
+	omitted := make(map[string]bool)
+
+	for i, summary := range summaries {
+		offset := len(merged.Clusters)
+
+		merged.NumClusters = merged.NumClusters + summary.NumClusters
+		merged.TotalNumNodes = merged.TotalNumNodes + summary.TotalNumNodes
+		for version, count := range summary.NodeVersions {
+			merged.NodeVersions[version] = merged.NodeVersions[version] + count
+		}
+		merged.Clusters = append(merged.Clusters, summary.Clusters...)
+
+		if summary.License != nil {
+			if merged.License == nil {
+				merged.License = NewLicenseSummary(summary.License.Model)
+			}
+			merged.License.Merge(summary.License, offset)
+		}
+		if summary.ConsumptionUnits != nil {
+			if merged.ConsumptionUnits == nil {
+				merged.ConsumptionUnits = NewConsumptionUnitReport()
+			}
+			merged.ConsumptionUnits.Merge(summary.ConsumptionUnits, offset)
+		}
+		if summary.CapellaSizing != nil {
+			if merged.CapellaSizing == nil {
+				merged.CapellaSizing = NewCapellaSizingReport()
+			}
+			merged.CapellaSizing.Merge(summary.CapellaSizing, offset)
+		}
+		if summary.NodeMembership != nil {
+			if merged.NodeMembership == nil {
+				merged.NodeMembership = NewMembershipRollup()
+			}
+			merged.NodeMembership.Merge(summary.NodeMembership, offset)
+		}
+		if summary.Environments != nil {
+			if merged.Environments == nil {
+				merged.Environments = NewEnvironmentRollup()
+			}
+			merged.Environments.Merge(summary.Environments, offset)
+		}
+		for _, cnum := range summary.NoAlerting {
+			merged.NoAlerting = append(merged.NoAlerting, cnum+offset)
+		}
+		for _, finding := range summary.Findings {
+			finding.ClusterNum = finding.ClusterNum + offset
+			merged.Findings = append(merged.Findings, finding)
+		}
+
+		// drift is found comparing the settings of the clusters, which
+		// aren't in the report, so can't be found across agents
+		if summary.Drift != nil && !omitted["config_drift"] {
+			omitted["config_drift"] = true
+			merged.Metadata.OmittedSections = append(merged.Metadata.OmittedSections, "config_drift")
+		}
+
+		if summary.Metadata != nil {
+			m := summary.Metadata
+			merged.Metadata.Advisories = append(merged.Metadata.Advisories, m.Advisories...)
+			merged.Metadata.Interrupted = merged.Metadata.Interrupted || m.Interrupted
+			merged.Metadata.TimedOut = merged.Metadata.TimedOut || m.TimedOut
+			merged.Metadata.AgentReports = append(merged.Metadata.AgentReports, AgentReportInfo{
+				Agent:              agents[i],
+				ToolVersion:        m.ToolVersion,
+				ToolCommit:         m.ToolCommit,
+				GeneratedAt:        m.GeneratedAt,
+				CollectionDuration: m.CollectionDuration,
+				ConfigHash:         m.ConfigHash,
+				Interrupted:        m.Interrupted,
+				TimedOut:           m.TimedOut,
+			})
+		}
+	}
+
+	// the most severe first, as from CheckHealth
+	sort.SliceStable(merged.Findings, func(i, j int) bool {
+		return merged.Findings[i].Severity == SEVERITY_CRITICAL && merged.Findings[j].Severity != SEVERITY_CRITICAL
+	})
+	merged.Metadata.HighestServerVersion = HighestVersion(merged.NodeVersions)
+	return merged
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// redaction - with --redact, the hostnames (and IP addresses), cluster names
+// and UUIDs in a report are replaced by tokens such as host-3, cluster-1 and
+// uuid-7, so the report can be shared with Couchbase support or other third
+// parties. A value gets the same token wherever it appears, in every section
+// and in error messages. The logins, passwords and headers that clusters in
+// error carry from the config file are replaced with REDACTED.
+//
+// The tokens are recorded in a mapping file, kept separately from the report
+// (cbsummary.redact-map.json by default), and reused from one run to the next,
+// so a host keeps its token across reports. Anyone holding the mapping file
+// can tell what the tokens stand for, so it should stay with the reports'
+// owner. Labels and tags from the config file are the user's own and are
+// left as they are.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_REDACT_MAP = "cbsummary.redact-map.json"
+
+// the values that have been given tokens, each mapped to its token
+type Redactor struct {
+	path string
+
+	mu       sync.Mutex
+	Hosts    map[string]string `json:"hosts"`
+	Clusters map[string]string `json:"clusters"`
+	UUIDs    map[string]string `json:"uuids"`
+}
+
+// the keys whose values are hosts, host:port pairs or URLs
+var REDACT_HOST_KEYS = map[string]bool{"hostname": true, "host": true, "hosts": true, "ldap_hosts": true,
+	"node": true, "nodes": true, "otpNode": true, "url": true}
+
+// the keys whose values are credentials, replaced outright
+var REDACT_CREDENTIAL_KEYS = map[string]bool{"login": true, "pass": true, "headers": true}
+
+const REDACTED = "REDACTED"
+
+// the keys whose values are cluster names
+var REDACT_CLUSTER_KEYS = map[string]bool{"clusterName": true, "cluster_name": true}
+
+var redactIPv4 = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+var redactUUID = regexp.MustCompile(`\b(?:[0-9a-f]{32}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\b`)
+
+// a redactor saving its tokens in a file, reading those there already
+func LoadRedactor(path string) (*Redactor, error) {
+	redactor := &Redactor{path: path, Hosts: make(map[string]string), Clusters: make(map[string]string),
+		UUIDs: make(map[string]string)}
+
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return redactor, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading redaction map %s: %v", path, err)
+	}
+	err = json.Unmarshal(body, redactor)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing redaction map %s: %v", path, err)
+	}
+	for _, tokens := range []*map[string]string{&redactor.Hosts, &redactor.Clusters, &redactor.UUIDs} {
+		if *tokens == nil {
+			*tokens = make(map[string]string)
+		}
+	}
+	return redactor, nil
+}
+
+func (r *Redactor) save() error {
+	body, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(r.path, body, 0600)
+	if err != nil {
+		return fmt.Errorf("Error writing redaction map %s: %v", r.path, err)
+	}
+	return nil
+}
+
+// a copy of the summary with the hostnames, cluster names and UUIDs replaced
+// by their tokens
+func (r *Redactor) Redact(clusterSummary *SummaryInfo) (*SummaryInfo, error) {
+	body, err := json.Marshal(clusterSummary)
+	if err != nil {
+		return nil, fmt.Errorf("Error redacting report: %v", err)
+	}
+	var tree interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	err = decoder.Decode(&tree)
+	if err != nil {
+		return nil, fmt.Errorf("Error redacting report: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collect(tree, "")
+	replace := r.replacer()
+	tree = redactTree(tree, "", replace)
+
+	body, err = json.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("Error redacting report: %v", err)
+	}
+	redacted, err := ParseReport(body)
+	if err != nil {
+		return nil, fmt.Errorf("Error redacting report: %v", err)
+	}
+	return redacted, r.save()
+}
+
+// give tokens to the values to be redacted, found by the keys they're under
+// or by their form
+func (r *Redactor) collect(value interface{}, key string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// an XDCR remote cluster reference has the remote cluster's name
+		_, hasHost := v["hostname"]
+		_, hasUUID := v["uuid"]
+		if name, ok := v["name"].(string); ok && hasHost && hasUUID {
+			r.add(r.Clusters, "cluster", name)
+		}
+		for k, item := range v {
+			r.collect(item, k)
+		}
+	case []interface{}:
+		for _, item := range v {
+			r.collect(item, key)
+		}
+	case string:
+		switch {
+		case REDACT_HOST_KEYS[key]:
+			r.add(r.Hosts, "host", redactHostOf(v))
+		case REDACT_CLUSTER_KEYS[key]:
+			r.add(r.Clusters, "cluster", v)
+		case strings.Contains(strings.ToLower(key), "uuid"):
+			r.add(r.UUIDs, "uuid", v)
+		}
+		for _, ip := range redactIPv4.FindAllString(v, -1) {
+			r.add(r.Hosts, "host", ip)
+		}
+		for _, uuid := range redactUUID.FindAllString(v, -1) {
+			r.add(r.UUIDs, "uuid", uuid)
+		}
+	}
+}
+
+func (r *Redactor) add(tokens map[string]string, kind, value string) {
+	if len(value) == 0 || len(tokens[value]) > 0 {
+		return
+	}
+	tokens[value] = fmt.Sprintf("%s-%d", kind, len(tokens)+1)
+}
+
+// the host in a hostname, host:port pair, otpNode (ns_1@host) or URL
+func redactHostOf(value string) string {
+	if strings.Contains(value, "://") {
+		if u, err := url.Parse(value); err == nil {
+			return u.Hostname()
+		}
+	}
+	if idx := strings.LastIndex(value, "@"); idx >= 0 {
+		value = value[idx+1:]
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	if strings.ContainsAny(value, " /") {
+		return ""
+	}
+	return strings.Trim(value, "[]")
+}
+
+// a function replacing every whole occurrence of a value with its token
+func (r *Redactor) replacer() func(string) string {
+	tokens := make(map[string]string)
+	for _, set := range []map[string]string{r.Hosts, r.Clusters, r.UUIDs} {
+		for value, token := range set {
+			tokens[value] = token
+		}
+	}
+	if len(tokens) == 0 {
+		return func(s string) string { return s }
+	}
+
+	// the longest first, so a host isn't replaced inside a longer one
+	values := sortedKeys(tokens)
+	sort.SliceStable(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = regexp.QuoteMeta(value)
+	}
+	re := regexp.MustCompile(strings.Join(quoted, "|"))
+
+	// a value only counts where it isn't part of a longer word or name
+	inWord := func(c byte) bool {
+		return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	return func(s string) string {
+		var out strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringIndex(s, -1) {
+			if m[0] > 0 && (inWord(s[m[0]-1]) || s[m[0]-1] == '.') {
+				continue
+			}
+			if m[1] < len(s) && inWord(s[m[1]]) {
+				continue
+			}
+			out.WriteString(s[last:m[0]])
+			out.WriteString(tokens[s[m[0]:m[1]]])
+			last = m[1]
+		}
+		out.WriteString(s[last:])
+		return out.String()
+	}
+}
+
+// the tree with every string, and every map key, passed through replace, and
+// the credentials removed
+func redactTree(value interface{}, key string, replace func(string) string) interface{} {
+	if REDACT_CREDENTIAL_KEYS[key] {
+		if headers, ok := value.(map[string]interface{}); ok {
+			for name := range headers {
+				headers[name] = REDACTED
+			}
+			return headers
+		}
+		if s, ok := value.(string); ok && len(s) > 0 {
+			return REDACTED
+		}
+		return value
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			redacted[replace(k)] = redactTree(item, k, replace)
+		}
+		return redacted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactTree(item, key, replace)
+		}
+		return v
+	case string:
+		return replace(v)
+	}
+	return value
+}
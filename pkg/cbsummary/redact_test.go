@@ -0,0 +1,120 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// redact the summary with the tokens kept in the map file, returning the
+// redacted report as JSON
+func redactWith(t *testing.T, mapFile string, summary *SummaryInfo) ([]byte, *Redactor) {
+	t.Helper()
+	redactor, err := LoadRedactor(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, err := redactor.Redact(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, redactor
+}
+
+// check that none of the values the redactor gave tokens to, nor any of the
+// others given, are left in the report, where they aren't part of a longer
+// word or name, as the redactor replaces them
+func checkRedacted(t *testing.T, body []byte, redactor *Redactor, originals ...string) {
+	t.Helper()
+	for _, tokens := range []map[string]string{redactor.Hosts, redactor.Clusters, redactor.UUIDs} {
+		for value := range tokens {
+			originals = append(originals, value)
+		}
+	}
+	for _, value := range originals {
+		whole := regexp.MustCompile(`(^|[^-\w.])` + regexp.QuoteMeta(value) + `($|[^-\w])`)
+		if whole.Match(body) {
+			t.Errorf("redacted report still has %q", value)
+		}
+	}
+}
+
+func TestRedactReplayedCluster(t *testing.T) {
+	summary := collectRaw(t, CollectOptions{Full: true})
+	mapFile := filepath.Join(t.TempDir(), "redact-map.json")
+
+	first, redactor := redactWith(t, mapFile, summary)
+	for _, value := range []string{"127.0.0.1", "node2"} {
+		if len(redactor.Hosts[value]) == 0 {
+			t.Errorf("host %s wasn't given a token", value)
+		}
+	}
+	if len(redactor.UUIDs["uuid-18091"]) == 0 {
+		t.Errorf("cluster UUID wasn't given a token")
+	}
+	checkRedacted(t, first, redactor, "127.0.0.1", "node2", "uuid-18091")
+	if !bytes.Contains(first, []byte("prod-eu")) {
+		t.Errorf("the cluster's label was redacted")
+	}
+
+	// a second run sharing the map file gives the same tokens
+	second, again := redactWith(t, mapFile, summary)
+	if !bytes.Equal(first, second) {
+		t.Errorf("redacting again with the same map gave a different report")
+	}
+	for value, token := range redactor.Hosts {
+		if again.Hosts[value] != token {
+			t.Errorf("host %s was %s, then %s", value, token, again.Hosts[value])
+		}
+	}
+}
+
+func TestRedactClusterError(t *testing.T) {
+	summary := &SummaryInfo{
+		NumClusters: 1,
+		Clusters: []interface{}{&ClusterError{
+			TheCluster: Cluster{
+				Login:   "ops-admin",
+				Pass:    "hunter2-secret",
+				Nodes:   []string{"https://10.20.30.40:18091", "db2.corp.example.com:8091"},
+				Headers: map[string]string{"X-Proxy-Token": "proxy-token-value"},
+				Label:   "payments",
+			},
+			ErrMsg: "Error getting /pools from https://10.20.30.40:18091 of cluster " +
+				"5f4dcc3b5aa765d61d8327deb882cf99: 401 Unauthorized",
+		}},
+	}
+	mapFile := filepath.Join(t.TempDir(), "redact-map.json")
+
+	body, redactor := redactWith(t, mapFile, summary)
+	checkRedacted(t, body, redactor, "ops-admin", "hunter2-secret", "proxy-token-value", "10.20.30.40",
+		"db2.corp.example.com", "5f4dcc3b5aa765d61d8327deb882cf99")
+	if !strings.Contains(string(body), REDACTED) || !strings.Contains(string(body), "X-Proxy-Token") {
+		t.Errorf("credentials weren't replaced with %s, keeping the header names: %s", REDACTED, body)
+	}
+	if !strings.Contains(string(body), "401 Unauthorized") {
+		t.Errorf("the error message was lost: %s", body)
+	}
+
+	again, _ := redactWith(t, mapFile, summary)
+	if !bytes.Equal(body, again) {
+		t.Errorf("redacting again with the same map gave %s, then %s", body, again)
+	}
+}
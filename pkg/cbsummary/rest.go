@@ -0,0 +1,924 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// cbsummary - a command-line utility for creating a summary report for a set of clusters
+//
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// types for communicating with the server
+
+type RestClientError struct {
+	method string
+	url    string
+	err    error
+}
+
+func (e RestClientError) Error() string {
+	return fmt.Sprintf("Rest client error (%s %s): %s", e.method, e.url, e.err)
+}
+
+type ServiceNotAvailableError struct {
+	service string
+}
+
+func (e ServiceNotAvailableError) Error() string {
+	return fmt.Sprintf("Service `%s` is not available on target cluster", e.service)
+}
+
+type SSLNotAvailableError struct {
+	service string
+}
+
+func (e SSLNotAvailableError) Error() string {
+	return fmt.Sprintf("SSL is not available for `%s` on target cluster", e.service)
+}
+
+type UnknownAuthorityError struct {
+	err error
+}
+
+func (e UnknownAuthorityError) Error() string {
+	return fmt.Sprintf("%s\n\nIf you are using self-signed certificates you can "+
+		"re-run this command with\nthe --no-ssl-verify flag. Note however that"+
+		" disabling ssl verification\nmeans that cbsummary will be vulnerable"+
+		" to man-in-the-middle attacks.\n\nFor the most secure access to Couchbase"+
+		" make sure that you have X.509\ncertificates set up in your cluster and"+
+		" use the --cacert flag to specify\nthe CA certificate that signed them.",
+		e.err.Error())
+}
+
+type HttpError struct {
+	code     int
+	method   string
+	resource string
+	body     string
+}
+
+func (e HttpError) Error() string {
+	switch e.code {
+	case http.StatusBadRequest:
+		return fmt.Sprintf("Bad request executing %s %s due to %s",
+			e.method, e.resource, e.body)
+	case http.StatusUnauthorized:
+		return fmt.Sprintf("Authentication error executing \"%s %s\" "+
+			"check username and password", e.method, e.resource)
+	case http.StatusForbidden:
+		return e.body
+	case http.StatusInternalServerError:
+		return fmt.Sprintf("Internal server error while executing \"%s %s\" "+
+			"check the server logs for more details", e.method, e.resource)
+	default:
+		return fmt.Sprintf("Recieved error %d while executing \"%s %s\"",
+			e.code, e.method, e.resource)
+	}
+}
+
+func (e HttpError) Code() int {
+	return e.code
+}
+
+type RestClient struct {
+	client   http.Client
+	secure   bool
+	host     string
+	username string
+	password string
+	headers  map[string]string
+
+	// authenticate with the TLS client certificate rather than basic auth
+	certAuth bool
+
+	// requests are abandoned when ctx is done, or after timeout if it's set
+	ctx     context.Context
+	timeout time.Duration
+	retry   RetryPolicy
+
+	// shared by the clients for a cluster's nodes and services
+	limiter *RateLimiter
+}
+
+func CreateRestClient(host, username, password string, tlsConfig *tls.Config, options TransportOptions) *RestClient {
+	var tr http.RoundTripper = SharedTransport(hostOf(host), tlsConfig, options)
+	limiter := NewRateLimiter(options.MaxRequestsPerSecond)
+	if options.Raw != nil {
+		tr = options.Raw.RoundTripper(tr)
+		if options.Raw.replay {
+			limiter = nil
+		}
+	}
+
+	// the options were checked when loaded, so this only falls back to the
+	// default jitter for a caller that didn't
+	retry, err := NewRetryPolicy(options)
+	if err != nil {
+		LogError("%v, using the default", err)
+	}
+	return &RestClient{
+		client:   http.Client{Transport: tr},
+		secure:   strings.HasPrefix(host, "https://"),
+		host:     host,
+		username: username,
+		password: password,
+		certAuth: tlsConfig != nil && len(tlsConfig.Certificates) > 0,
+		ctx:      context.Background(),
+		timeout:  time.Duration(options.ConnectTimeout),
+		retry:    retry,
+		limiter:  limiter,
+	}
+}
+
+// a client for another node or service port of the same cluster, sharing this
+// client's credentials, headers, connections and context
+func (r *RestClient) ForHost(host string) *RestClient {
+	return &RestClient{
+		client:   r.client,
+		secure:   strings.HasPrefix(host, "https://"),
+		host:     host,
+		username: r.username,
+		password: r.password,
+		headers:  r.headers,
+		certAuth: r.certAuth,
+		ctx:      r.ctx,
+		timeout:  r.timeout,
+		retry:    r.retry,
+		limiter:  r.limiter,
+	}
+}
+
+// a copy of the client whose requests are abandoned when ctx is done
+func (r *RestClient) WithContext(ctx context.Context) *RestClient {
+	copy := *r
+	copy.ctx = ctx
+	return &copy
+}
+
+func (r *RestClient) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// the context for one request, with the client's timeout applied
+func (r *RestClient) requestContext() (context.Context, context.CancelFunc) {
+	ctx := r.context()
+	if r.timeout > 0 {
+		return context.WithTimeout(ctx, r.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// the most of an unread response body read to keep its connection open
+const MAX_DRAIN_BYTES = 64 * 1024
+
+// the scheme, host and port of a URL
+func hostOf(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// a response body that releases the request's context once it has been read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	// read what's left, e.g. the newline after a JSON document, so the
+	// connection can be reused rather than closed
+	io.CopyN(io.Discard, b.ReadCloser, MAX_DRAIN_BYTES)
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// add headers to be sent with every request made by this client
+func (r *RestClient) SetHeaders(headers map[string]string) {
+	r.headers = headers
+}
+
+func (r *RestClient) setHeaders(req *http.Request) {
+	for key, val := range r.headers {
+		req.Header.Set(key, val)
+	}
+}
+
+// types for parsing the JSON in the config file
+
+type Cluster struct {
+	Login string   `json:"login"`
+	Pass  string   `json:"pass"`
+	Nodes []string `json:"nodes"`
+
+	// extra headers sent with every request to this cluster, e.g. for an auth proxy
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// a name for the cluster and tags for it, e.g. "prod-eu" and
+	// ["finance", "tier1"], carried into the report
+	Label string   `json:"label,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+
+	// the environment or group the cluster belongs to, e.g. "prod", which the
+	// report's totals are broken down by
+	Environment string `json:"environment,omitempty"`
+
+	// labels grouping the cluster with others, e.g. {"env": "prod"}
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// PEM files of an X.509 client certificate and key to authenticate with
+	// instead of the login and password
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+
+	// ask for the password at the terminal if the config doesn't give one
+	Prompt bool `json:"prompt,omitempty"`
+
+	// the login and password as written in the config, before they were resolved
+	configuredLogin string
+	configuredPass  string
+}
+
+func (c Cluster) identity() ClusterIdentity {
+	return ClusterIdentity{Label: c.Label, Environment: c.Environment, Tags: c.Tags, Labels: c.Labels}
+}
+
+// the cluster as given in the config, without any login or password resolved
+// from a secret store or the environment or asked for, and without the extra headers and client
+// certificate, which can carry credentials of their own. This is the form
+// written to error entries and raw snapshots.
+func (c Cluster) asConfigured() Cluster {
+	c.Login = c.configuredLogin
+	c.Pass = c.configuredPass
+	c.Headers = nil
+	c.ClientCert = ""
+	c.ClientKey = ""
+	return c
+}
+
+type ClusterList struct {
+	Clusters  []Cluster         `json:"clusters"`
+	Transport *TransportOptions `json:"transport,omitempty"`
+
+	// Capella organizations whose clusters are summarized too
+	Capella []CapellaOrg `json:"capella,omitempty"`
+
+	// where "vault:" credentials are read from
+	Vault *VaultOptions `json:"vault,omitempty"`
+
+	// where "k8s:" credentials are read from
+	Kubernetes *KubeOptions `json:"kubernetes,omitempty"`
+
+	// "sha256:" and the hex SHA-256 of the config file, as read
+	configHash string
+}
+
+//
+// types for parsing JSON from /pools REST API
+//
+
+type ComponentsVersion struct {
+	Ale        string `json:"ale"`
+	Asn1       string `json:"asn1"`
+	Crypto     string `json:"crypto"`
+	Inets      string `json:"inets"`
+	Kernel     string `json:"keynel"`
+	Lhttpc     string `json:"lhttpc"`
+	Ns_server  string `json:"ns_server"`
+	Os_mon     string `json:"os_mon"`
+	Public_key string `json:"public_key"`
+	Sasl       string `json:"sasl"`
+	Ssl        string `json:"ssl"`
+	Stdlib     string `json:"stdlib"`
+}
+
+type Pools struct {
+	Components            ComponentsVersion `json:"componentsVersion"`
+	ImplementationVersion string            `json:"implementationVersion"`
+	IsEnterprise          bool              `json:"isEnterprise"`
+	Uuid                  string            `json:"uuid"`
+}
+
+type PoolsDefault struct {
+	Alerts              []json.RawMessage  `json:"alerts"`
+	Balanced            bool               `json:"balanced"`
+	ClusterName         string             `json:"clusterName"`
+	FtsMemoryQuota      int                `json:"ftsMemoryQuota"`
+	IndexMemoryQuota    int                `json:"indexMemoryQuota"`
+	CbasMemoryQuota     int                `json:"cbasMemoryQuota"`
+	EventingMemoryQuota int                `json:"eventingMemoryQuota"`
+	MemoryQuota         int                `json:"memoryQuota"`
+	Name                string             `json:"name"`
+	Nodes               []NodeInfo         `json:"nodes"`
+	RebalanceStatus     string             `json:"rebalanceStatus"`
+	StorageTotals       ClusterStorageInfo `json:"storageTotals"`
+}
+
+type NodeInfo struct {
+	ClusterMembership  string    `json:"clusterMembership"`
+	CpuCount           float64   `json:"cpuCount"`
+	Hostname           string    `json:"hostname"`
+	InterestingStats   NodeStats `json:"interestingStats"`
+	McdMemoryAllocated float64   `json:"mcdMemoryAllocated"`
+	McdMemoryReserved  float64   `json:"mcdMemoryReserved"`
+	MemoryFree         float64   `json:"memoryFree"`
+	MemoryTotal        float64   `json:"memoryTotal"`
+	NodeEncryption     bool      `json:"nodeEncryption"`
+	OS                 string    `json:"os"`
+	RecoveryType       string    `json:"recoveryType,omitempty"`
+	Services           []string  `json:"services"`
+	Status             string    `json:"status"`
+	SystemStats        SysStats  `json:"systemStats"`
+	Uptime             string    `json:"uptime"`
+	Version            string    `json:"version"`
+}
+
+type NodeStats struct {
+	Cmd_get                      float64 `json:"cmd_get"`
+	Couch_docs_actual_disk_size  float64 `json:"couch_docs_actual_disk_size"`
+	Couch_docs_data_size         float64 `json:"couch_docs_data_size"`
+	Couch_spatial_data_size      float64 `json:"couch_spatial_data_size"`
+	Couch_spatial_disk_size      float64 `json:"couch_spatial_disk_size"`
+	Couch_views_actual_disk_size float64 `json:"couch_views_actual_disk_size"`
+	Couch_views_data_size        float64 `json:"couch_views_data_size"`
+	Curr_items                   float64 `json:"curr_items"`
+	Curr_items_tot               float64 `json:"curr_items_tot"`
+	Ep_bg_fetched                float64 `json:"ep_bg_fetched"`
+	Get_hits                     float64 `json:"get_hits"`
+	Mem_used                     float64 `json:"mem_used"`
+	Ops                          float64 `json:"ops"`
+	Vb_active_num_non_resident   float64 `json:"vb_active_num_non_resident"`
+	Vb_replica_curr_items        float64 `json:"vb_replica_curr_items"`
+}
+
+type SysStats struct {
+	Cpu_utilization_rate float64 `json:"cpu_utilization_rate"`
+	Mem_free             float64 `json:"mem_free"`
+	Mem_total            float64 `json:"mem_total"`
+	Swap_total           float64 `json:"swap_total"`
+	Swap_used            float64 `json:"swap_used"`
+	CPU_cores_available  float64 `json:"cpu_cores_available"`
+	Mem_limit            float64 `json:"mem_limit"`
+}
+
+type ClusterStorageInfo struct {
+	HDD HDDStorageInfo `json:"hdd"`
+	RAM RAMStorageInfo `json:"ram"`
+}
+
+type HDDStorageInfo struct {
+	Free       float64 `json:"free"`
+	QuotaTotal float64 `json:""`
+	Total      float64 `json:"total"`
+	Used       float64 `json:"used"`
+	UsedByData float64 `json:"usedByData"`
+}
+
+type RAMStorageInfo struct {
+	QuotaTotal        float64 `json:"quotaTotal"`
+	QuotaTotalPerNode float64 `json:"quotaTotalPerNode"`
+	QuotaUsed         float64 `json:"quotaUsed"`
+	QuotaUsedPerNode  float64 `json:"quataUsedPerNode"`
+	Total             float64 `json:"total"`
+	Used              float64 `json:"used"`
+	UsedByData        float64 `json:"usedByData"`
+}
+
+// types for parsing JSON from /pools/default/buckets
+
+type BucketInfo struct {
+	Name          string `json:"name"`
+	BucketType    string `json:"bucketType"`
+	ReplicaNumber int    `json:"replicaNumber"`
+	Quota         struct {
+		RAM    float64 `json:"ram"`
+		RawRAM float64 `json:"rawRAM"`
+	} `json:"quota"`
+	BasicStats BucketBasicStats `json:"basicStats"`
+
+	// false, or an object when the bucket overrides the cluster's settings
+	AutoCompactionSettings interface{} `json:"autoCompactionSettings"`
+	PurgeInterval          interface{} `json:"purgeInterval"`
+}
+
+type BucketBasicStats struct {
+	ItemCount        float64 `json:"itemCount"`
+	QuotaPercentUsed float64 `json:"quotaPercentUsed"`
+	OpsPerSec        float64 `json:"opsPerSec"`
+	DiskUsed         float64 `json:"diskUsed"`
+	DataUsed         float64 `json:"dataUsed"`
+	MemUsed          float64 `json:"memUsed"`
+}
+
+// types for parsing JSON from /indexStatus
+
+type IndexStatus struct {
+	Indexes []IndexStatusEntry `json:"indexes"`
+}
+
+type IndexStatusEntry struct {
+	Index        string  `json:"index"`
+	Bucket       string  `json:"bucket"`
+	Scope        string  `json:"scope"`
+	Collection   string  `json:"collection"`
+	StorageMode  string  `json:"storageMode"`
+	NumReplica   int     `json:"numReplica"`
+	NumPartition int     `json:"numPartition"`
+	Status       string  `json:"status"`
+	Progress     float64 `json:"progress"`
+}
+
+// types for parsing JSON from /pools/default/buckets/<bucket>/stats
+
+type BucketStatsSamples struct {
+	Op struct {
+		Samples map[string][]float64 `json:"samples"`
+	} `json:"op"`
+}
+
+// types for parsing JSON from /pools/default/tasks
+
+type TaskInfo struct {
+	Type        string        `json:"type"`
+	Subtype     string        `json:"subtype"`
+	ID          string        `json:"id"`
+	Status      string        `json:"status"`
+	Source      string        `json:"source"`
+	Target      string        `json:"target"`
+	ChangesLeft float64       `json:"changesLeft"`
+	Errors      []interface{} `json:"errors"`
+	Progress    float64       `json:"progress"`
+	Bucket      string        `json:"bucket"`
+}
+
+// types for parsing JSON from /pools/default/remoteClusters and
+// /pools/default/replications
+
+type RemoteClusterInfo struct {
+	Name               string `json:"name"`
+	UUID               string `json:"uuid"`
+	Hostname           string `json:"hostname"`
+	SecureType         string `json:"secureType"`
+	ConnectivityStatus string `json:"connectivityStatus"`
+	Deleted            bool   `json:"deleted"`
+}
+
+type ReplicationSpec struct {
+	ID                string `json:"id"`
+	SourceBucketName  string `json:"sourceBucketName"`
+	TargetClusterUUID string `json:"targetClusterUUID"`
+	TargetBucketName  string `json:"targetBucketName"`
+	FilterExpression  string `json:"filterExpression"`
+	PauseRequested    bool   `json:"pauseRequested"`
+	Settings          struct {
+		FilterExpression string `json:"filterExpression"`
+		PauseRequested   bool   `json:"pauseRequested"`
+	} `json:"settings"`
+}
+
+// types for parsing JSON from /pools/default/nodeServices
+
+type NodeServices struct {
+	NodesExt []NodeServicesExt `json:"nodesExt"`
+}
+
+type NodeServicesExt struct {
+	Hostname string         `json:"hostname"`
+	ThisNode bool           `json:"thisNode"`
+	Services map[string]int `json:"services"`
+}
+
+////////////////////////////////////////////////////////////////////////////
+
+// type for output
+
+type ClusterSummary struct {
+	ImplementationVersion string               `json:"implementationVersion"`
+	IsEnterprise          bool                 `json:"isEnterprise"`
+	Uuid                  string               `json:"uuid"`
+	Balanced              bool                 `json:"balanced"`
+	ClusterName           string               `json:"clusterName"`
+	FtsMemoryQuota        int                  `json:"ftsMemoryQuota"`
+	IndexMemoryQuota      int                  `json:"indexMemoryQuota"`
+	CbasMemoryQuota       int                  `json:"cbasMemoryQuota"`
+	EventingMemoryQuota   int                  `json:"eventingMemoryQuota"`
+	MemoryQuota           int                  `json:"memoryQuota"`
+	Name                  string               `json:"name"`
+	NodeCount             int                  `json:"nodeCount"`
+	NodeVersions          map[string]int       `json:"nodeVersions"`
+	Nodes                 []NodeInfo           `json:"nodes"`
+	RebalanceStatus       string               `json:"rebalanceStatus"`
+	StorageTotals         ClusterStorageInfo   `json:"storageTotals"`
+	Buckets               *BucketInventory     `json:"buckets,omitempty"`
+	Indexes               *IndexSummary        `json:"indexes,omitempty"`
+	SearchIndexes         *SearchSummary       `json:"fts_indexes,omitempty"`
+	Analytics             *AnalyticsSummary    `json:"analytics,omitempty"`
+	EventingFunctions     *EventingSummary     `json:"eventing_functions,omitempty"`
+	XDCRTopology          *XDCRTopology        `json:"xdcr_topology,omitempty"`
+	ServerGroups          *ServerGroupSummary  `json:"server_groups,omitempty"`
+	Alerts                *AlertSettings       `json:"alerts,omitempty"`
+	Certificates          *CertificateReport   `json:"certificates,omitempty"`
+	Events                *ClusterEvents       `json:"events,omitempty"`
+	Membership            *MembershipAnomalies `json:"membership_anomalies,omitempty"`
+	ServiceLayout         *ServiceLayout       `json:"service_layout,omitempty"`
+	Info                  *ClusterInfo         `json:"cluster_info,omitempty"`
+
+	ClusterIdentity
+	ClusterExtras
+}
+
+////////////////////////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////////////////////////
+////////////////////////////////////////////////////////////////////////////
+
+// GET the uri, retrying transient failures
+func (r *RestClient) executeGet(uri string) (*http.Response, error) {
+	for retry := 1; ; retry++ {
+		resp, err := r.executeGetOnce(uri)
+		if err == nil || retry > r.retry.Retries || !Retryable(err) {
+			return resp, err
+		}
+		if !r.retry.Wait(r.context(), retry) {
+			return nil, err
+		}
+	}
+}
+
+func (r *RestClient) setAuth(req *http.Request) {
+	if !r.certAuth {
+		req.SetBasicAuth(r.username, r.password)
+	}
+}
+
+func (r *RestClient) executeGetOnce(uri string) (*http.Response, error) {
+	method := "GET"
+	ctx, cancel := r.requestContext()
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		cancel()
+		return nil, &RestClientError{method, uri, err}
+	}
+	r.setAuth(req)
+	r.setHeaders(req)
+
+	resp, err := r.executeRequest(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{resp.Body, cancel}
+
+	return resp, nil
+}
+
+func (r *RestClient) executePost(uri string, params map[string]string) (*http.Response, error) {
+	// build the form parameters from the map
+	data := url.Values{}
+	for key, val := range params {
+		data.Set(key, val)
+	}
+
+	method := "POST"
+	ctx, cancel := r.requestContext()
+	req, err := http.NewRequestWithContext(ctx, method, uri, strings.NewReader(data.Encode()))
+	if err != nil {
+		cancel()
+		return nil, &RestClientError{method, uri, err}
+	}
+	r.setAuth(req)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.setHeaders(req)
+
+	resp, err := r.executeRequest(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{resp.Body, cancel}
+
+	return resp, nil
+}
+
+func (r *RestClient) executeRequest(req *http.Request) (*http.Response, error) {
+	if err := r.limiter.Wait(req.Context()); err != nil {
+		return nil, &RestClientError{req.Method, req.URL.String(), err}
+	}
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		logger.Debug("REST call failed", "method", req.Method, "url", req.URL.String(),
+			"duration_ms", time.Since(start).Milliseconds(), "error", err.Error())
+		switch err.(type) {
+		case *url.Error:
+			inner := err.(*url.Error).Err
+			var unknownAuthority x509.UnknownAuthorityError
+			if errors.As(inner, &unknownAuthority) {
+				return nil, UnknownAuthorityError{inner}
+			}
+			return nil, inner
+		case x509.UnknownAuthorityError:
+			return nil, UnknownAuthorityError{err}
+		case x509.CertificateInvalidError:
+			return nil, err
+		case x509.ConstraintViolationError:
+			return nil, err
+		case x509.HostnameError:
+			return nil, err
+		case x509.SystemRootsError:
+			return nil, err
+		case x509.UnhandledCriticalExtension:
+			return nil, err
+		default:
+			return nil, &RestClientError{req.Method, req.URL.String(), err}
+		}
+	}
+
+	logger.Debug("REST call", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode,
+		"duration_ms", time.Since(start).Milliseconds())
+	if resp.StatusCode == http.StatusBadRequest {
+		contents, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			contents = []byte("<no body>")
+		}
+		resp.Body.Close()
+		return nil, HttpError{resp.StatusCode, req.Method, req.URL.String(), string(contents)}
+	} else if resp.StatusCode == http.StatusForbidden {
+		type overlay struct {
+			Message     string
+			Permissions []string
+		}
+
+		var data overlay
+		decoder := json.NewDecoder(resp.Body)
+		decoder.UseNumber()
+		err = decoder.Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return nil, HttpError{resp.StatusCode, req.Method, req.URL.String(), ""}
+		}
+
+		msg := data.Message + ": " + strings.Join(data.Permissions, ", ")
+		return nil, HttpError{resp.StatusCode, req.Method, req.URL.String(), msg}
+	} else if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusCreated {
+		resp.Body.Close()
+		return nil, HttpError{resp.StatusCode, req.Method, req.URL.String(), ""}
+	}
+
+	return resp, nil
+}
+
+//
+// get the license summary report
+//
+
+func (r *RestClient) GetLicenseUsage() (report map[string]interface{}, err error) {
+	uri := r.host + "/settings/license/validate"
+
+	params := make(map[string]string)
+	params["generation_only"] = "true"
+
+	resp, err := r.executePost(uri, params)
+	if err != nil {
+		return nil, err
+	}
+
+	licenseBytes, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		json.Unmarshal(licenseBytes, &report)
+	}
+
+	return
+}
+
+//
+// for each cluster, we call the /pools REST API to get:
+// - componentsVersion
+// - implementationVersion as version
+// - isEnterprise as isEnterpriseEdition
+// - uuid
+
+func (r *RestClient) GetPoolsData() (*Pools, error) {
+	url := r.host + "/pools"
+	resp, err := r.executeGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data Pools
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&data)
+	if err != nil {
+		return nil, &RestClientError{"GET", url, err}
+	}
+
+	return &data, nil
+}
+
+// for each cluster, we call the /pools REST API to get:
+// - componentsVersion
+// - implementationVersion as version
+// - isEnterprise as isEnterpriseEdition
+// - uuid
+
+type ResultMap map[string]*json.RawMessage
+
+// func (r *RestClient) GetPoolsDefaultData() (*ResultMap, error) {
+func (r *RestClient) GetPoolsDefaultData() (*PoolsDefault, error) {
+	url := r.host + "/pools/default"
+	resp, err := r.executeGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var resultMap PoolsDefault
+	//var resultMap ResultMap
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&resultMap)
+	if err != nil {
+		return nil, &RestClientError{"GET", url, err}
+	}
+
+	return &resultMap, nil
+}
+
+// GET a path on this client's host and decode the JSON response into data
+func (r *RestClient) getJSON(path string, data interface{}) error {
+	url := r.host + path
+	resp, err := r.executeGet(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(data)
+	if err != nil {
+		return &RestClientError{"GET", url, err}
+	}
+	return nil
+}
+
+// POST form parameters to a path on this client's host and decode the JSON
+// response into data
+func (r *RestClient) postJSON(path string, params map[string]string, data interface{}) error {
+	url := r.host + path
+	resp, err := r.executePost(url, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(data)
+	if err != nil {
+		return &RestClientError{"POST", url, err}
+	}
+	return nil
+}
+
+// the ports of the services running on each node of the cluster
+func (r *RestClient) GetNodeServices() (*NodeServices, error) {
+	var data NodeServices
+	err := r.getJSON("/pools/default/nodeServices", &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// the status of the cluster's GSI indexes
+func (r *RestClient) GetIndexStatus() (*IndexStatus, error) {
+	var data IndexStatus
+	err := r.getJSON("/indexStatus", &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// the buckets defined on the cluster
+func (r *RestClient) GetBuckets() ([]BucketInfo, error) {
+	var data []BucketInfo
+	err := r.getJSON("/pools/default/buckets?skipMap=true", &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// the last minute of stats samples for a bucket
+func (r *RestClient) GetBucketStats(bucket string) (*BucketStatsSamples, error) {
+	var data BucketStatsSamples
+	err := r.getJSON("/pools/default/buckets/"+url.PathEscape(bucket)+"/stats?zoom=minute", &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// the details of the node the client is connected to
+func (r *RestClient) GetNodeSelf() (*NodeInfo, error) {
+	var data NodeInfo
+	err := r.getJSON("/nodes/self", &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// the nodes of the cluster from the per-node listing, which older servers
+// fill in where /pools/default doesn't
+func (r *RestClient) GetNodesList() ([]NodeInfo, error) {
+	var data struct {
+		Nodes []NodeInfo `json:"nodes"`
+	}
+	err := r.getJSON("/pools/nodes", &data)
+	if err != nil {
+		return nil, err
+	}
+	return data.Nodes, nil
+}
+
+// the tasks running on the cluster, including XDCR replications
+func (r *RestClient) GetTasks() ([]TaskInfo, error) {
+	var data []TaskInfo
+	err := r.getJSON("/pools/default/tasks", &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// the cluster's XDCR remote cluster references
+func (r *RestClient) GetRemoteClusters() ([]RemoteClusterInfo, error) {
+	var data []RemoteClusterInfo
+	err := r.getJSON("/pools/default/remoteClusters", &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// the cluster's XDCR replication definitions
+func (r *RestClient) GetReplications() ([]ReplicationSpec, error) {
+	var data []ReplicationSpec
+	err := r.getJSON("/pools/default/replications", &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// the latest value of a per-replication stat, summed over the nodes
+func (r *RestClient) GetReplicationStat(sourceBucket, replicationID, stat string) (float64, error) {
+	var data struct {
+		NodeStats map[string][]interface{} `json:"nodeStats"`
+	}
+	statName := "replications/" + replicationID + "/" + stat
+	path := "/pools/default/buckets/" + url.PathEscape("@xdcr-"+sourceBucket) + "/stats/" +
+		strings.ReplaceAll(url.PathEscape(statName), "/", "%2F")
+	err := r.getJSON(path, &data)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0.0
+	for _, samples := range data.NodeStats {
+		if len(samples) > 0 {
+			total = total + toFloat(samples[len(samples)-1])
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// retries - GET requests that fail for reasons likely to pass (a node
+// answering 503 while warming up, a connection reset by a load balancer) are
+// retried a few times with exponential backoff before the collector gives up
+// on the node
+//
+// Authentication and permission errors, bad requests, missing endpoints and
+// timeouts are not retried: trying again would give the same answer, or wait
+// just as long again. The delay before retry n is the backoff doubled n-1
+// times, up to RETRY_MAX_BACKOFF, less a random fraction of up to the jitter
+// so that many clients don't retry in step. The jitter is a fraction, from 0
+// to 1; anything else is an error in the config.
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_RETRIES       = 2
+	DEFAULT_RETRY_BACKOFF = 500 * time.Millisecond
+	DEFAULT_RETRY_JITTER  = 0.5
+	RETRY_MAX_BACKOFF     = 30 * time.Second
+)
+
+type RetryPolicy struct {
+	Retries int
+	Backoff time.Duration
+	Jitter  float64
+}
+
+// the retry policy from the transport options, with defaults for anything not set
+func NewRetryPolicy(options TransportOptions) (RetryPolicy, error) {
+	policy := RetryPolicy{
+		Retries: DEFAULT_RETRIES,
+		Backoff: DEFAULT_RETRY_BACKOFF,
+		Jitter:  DEFAULT_RETRY_JITTER,
+	}
+	if options.Retries != nil {
+		policy.Retries = *options.Retries
+	}
+	if options.RetryBackoff > 0 {
+		policy.Backoff = time.Duration(options.RetryBackoff)
+	}
+	if options.RetryJitter < 0 || options.RetryJitter > 1 {
+		return policy, fmt.Errorf("retry_jitter must be between 0 and 1, not %g", options.RetryJitter)
+	}
+	if options.RetryJitter > 0 {
+		policy.Jitter = options.RetryJitter
+	}
+	return policy, nil
+}
+
+// how long to wait before the given retry, counting from 1
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < retry && delay < RETRY_MAX_BACKOFF; i++ {
+		delay = delay * 2
+	}
+	if delay > RETRY_MAX_BACKOFF {
+		delay = RETRY_MAX_BACKOFF
+	}
+	return delay - time.Duration(rand.Float64()*p.Jitter*float64(delay))
+}
+
+// wait before the given retry, returning false if the context is done first
+func (p RetryPolicy) Wait(ctx context.Context, retry int) bool {
+	timer := time.NewTimer(p.Delay(retry))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// whether a request that failed with this error is worth trying again
+func Retryable(err error) bool {
+	var httpErr HttpError
+	if errors.As(err, &httpErr) {
+		switch httpErr.Code() {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicyJitter(t *testing.T) {
+	for _, test := range []struct {
+		jitter float64
+		want   float64
+		ok     bool
+	}{
+		{0, DEFAULT_RETRY_JITTER, true},
+		{0.25, 0.25, true},
+		{1, 1, true},
+		{-0.1, 0, false},
+		{1.5, 0, false},
+	} {
+		policy, err := NewRetryPolicy(TransportOptions{RetryJitter: test.jitter})
+		if (err == nil) != test.ok {
+			t.Errorf("jitter %g: got error %v, want ok %v", test.jitter, err, test.ok)
+		} else if test.ok && policy.Jitter != test.want {
+			t.Errorf("jitter %g: policy has %g, want %g", test.jitter, policy.Jitter, test.want)
+		}
+	}
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(config, []byte(`{"clusters": [{"login": "a", "pass": "b", "nodes": ["http://10.0.0.1:8091"]}],
+		"transport": {"retry_jitter": 2}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(config, ConfigOptions{}); err == nil || !strings.Contains(err.Error(), "retry_jitter") {
+		t.Errorf("loading a config with a jitter of 2 gave error %v", err)
+	}
+	if _, problems := ValidateConfig(config, ConfigOptions{}); !HasConfigErrors(problems) {
+		t.Errorf("validating a config with a jitter of 2 gave %v", problems)
+	}
+}
+
+// a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	for _, test := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"401", HttpError{code: 401}, false},
+		{"403", HttpError{code: 403}, false},
+		{"400", HttpError{code: 400}, false},
+		{"404", HttpError{code: 404}, false},
+		{"429", HttpError{code: 429}, true},
+		{"503", HttpError{code: 503}, true},
+		{"wrapped 503", fmt.Errorf("Error getting /pools: %w", HttpError{code: 503}), true},
+		{"ECONNRESET", &url.Error{Op: "Get", URL: "http://10.0.0.1:8091/pools", Err: reset}, true},
+		{"EOF", &url.Error{Op: "Get", URL: "http://10.0.0.1:8091/pools", Err: io.EOF}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, false},
+		{"timeout", &url.Error{Op: "Get", URL: "http://10.0.0.1:8091/pools", Err: timeoutError{}}, false},
+		{"deadline", context.DeadlineExceeded, false},
+	} {
+		if got := Retryable(test.err); got != test.want {
+			t.Errorf("%s: retryable %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	steady := RetryPolicy{Backoff: time.Second}
+	for _, test := range []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, RETRY_MAX_BACKOFF},
+		{1000, RETRY_MAX_BACKOFF},
+	} {
+		if got := steady.Delay(test.retry); got != test.want {
+			t.Errorf("retry %d: delay %v, want %v", test.retry, got, test.want)
+		}
+	}
+
+	// the jitter only ever shortens the delay, by up to its fraction
+	jittered := RetryPolicy{Backoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := jittered.Delay(1000); got > RETRY_MAX_BACKOFF || got < RETRY_MAX_BACKOFF/2 {
+			t.Fatalf("delay %v with jitter 0.5, want between %v and %v", got, RETRY_MAX_BACKOFF/2, RETRY_MAX_BACKOFF)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// the JSON Schema of the JSON report, for pipelines ingesting reports to
+// validate them against; 'cbsummary schema' prints it.
+//
+// report.schema.json is generated from the report's types, so regenerate it
+// with 'go generate ./pkg/cbsummary' after changing them, and bump
+// REPORT_SCHEMA_VERSION (see version.go) if a field is renamed or removed, or
+// changes meaning. Fields that are only added don't need a new version: the
+// schema allows properties it doesn't list.
+//
+
+import _ "embed"
+
+//go:generate go run ../../internal/genschema -o report.schema.json
+
+//go:embed report.schema.json
+var reportSchema []byte
+
+// the JSON Schema of the JSON report, at REPORT_SCHEMA_VERSION
+func ReportSchema() []byte {
+	return reportSchema
+}
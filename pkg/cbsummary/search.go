@@ -0,0 +1,103 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// Full-Text Search index summary for the full report, for clusters running
+// the fts service
+//
+// The index definitions are cluster-wide, so they're read from the search
+// service's /api/index on one node. The memory used is the total of each
+// search node's num_bytes_used_ram (see service_usage.go), compared with the
+// ftsMemoryQuota, which applies to each node.
+//
+
+import (
+	"sort"
+)
+
+type SearchSummary struct {
+	Count         int           `json:"index_count"`
+	Indexes       []SearchIndex `json:"indexes"`
+	MemoryUsedMB  float64       `json:"memory_used_mb"`
+	MemoryQuotaMB float64       `json:"memory_quota_mb"`
+	MemoryUsedPct float64       `json:"memory_used_pct"`
+	Error         string        `json:"error,omitempty"`
+
+	// each node's usage, for the service layout
+	usage *ServiceUsage
+}
+
+type SearchIndex struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Bucket     string `json:"bucket"`
+	Partitions int    `json:"partitions"`
+	Replicas   int    `json:"replicas"`
+}
+
+// the response from the search service's /api/index
+type searchIndexDefs struct {
+	IndexDefs struct {
+		IndexDefs map[string]struct {
+			Name       string `json:"name"`
+			Type       string `json:"type"`
+			SourceName string `json:"sourceName"`
+			PlanParams struct {
+				IndexPartitions int `json:"indexPartitions"`
+				NumReplicas     int `json:"numReplicas"`
+			} `json:"planParams"`
+		} `json:"indexDefs"`
+	} `json:"indexDefs"`
+}
+
+func CollectSearchSummary(conn *ClusterConn) *SearchSummary {
+	summary := &SearchSummary{Indexes: make([]SearchIndex, 0)}
+
+	clients, err := conn.ServiceClients("fts")
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	if len(clients) == 0 {
+		return summary
+	}
+
+	var defs searchIndexDefs
+	err = clients[0].getJSON("/api/index", &defs)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	for _, def := range defs.IndexDefs.IndexDefs {
+		summary.Indexes = append(summary.Indexes, SearchIndex{
+			Name:       def.Name,
+			Type:       def.Type,
+			Bucket:     def.SourceName,
+			Partitions: def.PlanParams.IndexPartitions,
+			Replicas:   def.PlanParams.NumReplicas,
+		})
+	}
+	sort.Slice(summary.Indexes, func(i, j int) bool { return summary.Indexes[i].Name < summary.Indexes[j].Name })
+	summary.Count = len(summary.Indexes)
+
+	usage := CollectSearchUsage(conn)
+	summary.usage = usage
+	summary.MemoryUsedMB = usage.MemoryUsedMB
+	summary.MemoryQuotaMB = float64(conn.PoolsDefault.FtsMemoryQuota * len(clients))
+	if summary.MemoryQuotaMB > 0 {
+		summary.MemoryUsedPct = 100 * summary.MemoryUsedMB / summary.MemoryQuotaMB
+	}
+	if len(usage.Error) > 0 {
+		summary.Error = usage.Error
+	}
+	return summary
+}
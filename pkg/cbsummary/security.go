@@ -0,0 +1,143 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// security posture - auditing, LDAP, encryption and password policy, for
+// review against security policy
+//
+// The settings come from /settings/audit, /settings/ldap, /settings/security
+// and /settings/passwordPolicy. Node-to-node encryption is reported per node
+// in /pools/default, and summarized as "all", "some" or "none" of the nodes.
+// Auditing and LDAP are Enterprise Edition features, so on Community Edition
+// those endpoints fail; the failures are listed in the section and the rest
+// of it is still filled in.
+//
+
+import (
+	"fmt"
+)
+
+type SecurityPosture struct {
+	AuditEnabled        bool   `json:"audit_enabled"`
+	AuditLogPath        string `json:"audit_log_path,omitempty"`
+	DisabledAuditEvents int    `json:"disabled_audit_events"`
+
+	LDAPAuthentication bool     `json:"ldap_authentication"`
+	LDAPAuthorization  bool     `json:"ldap_authorization"`
+	LDAPHosts          []string `json:"ldap_hosts,omitempty"`
+	LDAPEncryption     string   `json:"ldap_encryption,omitempty"`
+
+	ClusterEncryptionLevel string `json:"cluster_encryption_level,omitempty"`
+	NodeEncryption         string `json:"node_to_node_encryption"`
+	EncryptedNodes         int    `json:"encrypted_nodes"`
+	TLSMinVersion          string `json:"tls_min_version,omitempty"`
+	UIOverHTTPDisabled     bool   `json:"ui_over_http_disabled"`
+
+	PasswordPolicy *PasswordPolicy `json:"password_policy,omitempty"`
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+type PasswordPolicy struct {
+	MinLength           int  `json:"minLength"`
+	EnforceUppercase    bool `json:"enforceUppercase"`
+	EnforceLowercase    bool `json:"enforceLowercase"`
+	EnforceDigits       bool `json:"enforceDigits"`
+	EnforceSpecialChars bool `json:"enforceSpecialChars"`
+}
+
+// types for parsing JSON from the security settings endpoints
+
+type auditSettings struct {
+	AuditdEnabled bool          `json:"auditdEnabled"`
+	LogPath       string        `json:"logPath"`
+	Disabled      []interface{} `json:"disabled"`
+}
+
+type ldapSettings struct {
+	AuthenticationEnabled bool     `json:"authenticationEnabled"`
+	AuthorizationEnabled  bool     `json:"authorizationEnabled"`
+	Hosts                 []string `json:"hosts"`
+	Encryption            string   `json:"encryption"`
+}
+
+type securitySettings struct {
+	ClusterEncryptionLevel string `json:"clusterEncryptionLevel"`
+	TLSMinVersion          string `json:"tlsMinVersion"`
+	DisableUIOverHttp      bool   `json:"disableUIOverHttp"`
+}
+
+func CollectSecurityPosture(conn *ClusterConn) *SecurityPosture {
+	posture := new(SecurityPosture)
+	client := conn.Client
+
+	fail := func(path string, err error) {
+		LogError("Error getting %s from %s: %v", path, client.host, err)
+		posture.Errors = append(posture.Errors, fmt.Sprintf("%s: %v", path, err))
+	}
+
+	var audit auditSettings
+	if err := client.getJSON("/settings/audit", &audit); err != nil {
+		fail("/settings/audit", err)
+	} else {
+		posture.AuditEnabled = audit.AuditdEnabled
+		posture.AuditLogPath = audit.LogPath
+		posture.DisabledAuditEvents = len(audit.Disabled)
+	}
+
+	var ldap ldapSettings
+	if err := client.getJSON("/settings/ldap", &ldap); err != nil {
+		fail("/settings/ldap", err)
+	} else {
+		posture.LDAPAuthentication = ldap.AuthenticationEnabled
+		posture.LDAPAuthorization = ldap.AuthorizationEnabled
+		posture.LDAPHosts = ldap.Hosts
+		posture.LDAPEncryption = ldap.Encryption
+	}
+
+	var security securitySettings
+	if err := client.getJSON("/settings/security", &security); err != nil {
+		fail("/settings/security", err)
+	} else {
+		posture.ClusterEncryptionLevel = security.ClusterEncryptionLevel
+		posture.TLSMinVersion = security.TLSMinVersion
+		posture.UIOverHTTPDisabled = security.DisableUIOverHttp
+	}
+
+	var policy PasswordPolicy
+	if err := client.getJSON("/settings/passwordPolicy", &policy); err != nil {
+		fail("/settings/passwordPolicy", err)
+	} else {
+		posture.PasswordPolicy = &policy
+	}
+
+	for _, nodeInfo := range conn.PoolsDefault.Nodes {
+		if nodeInfo.NodeEncryption {
+			posture.EncryptedNodes++
+		}
+	}
+	switch {
+	case posture.EncryptedNodes == 0:
+		posture.NodeEncryption = "none"
+	case posture.EncryptedNodes == len(conn.PoolsDefault.Nodes):
+		posture.NodeEncryption = "all"
+	default:
+		posture.NodeEncryption = "some"
+	}
+
+	return posture
+}
+
+// whether LDAP is used for either authentication or authorization
+func (s *SecurityPosture) LDAPEnabled() bool {
+	return s.LDAPAuthentication || s.LDAPAuthorization
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// server groups (rack awareness), from /pools/default/serverGroups
+//
+// Rack awareness is configured when the nodes are split over more than one
+// server group. Replicas are only spread evenly across the groups when every
+// group has the same number of data nodes, so a configured cluster whose
+// groups differ in data nodes is flagged as unbalanced.
+//
+
+type ServerGroupSummary struct {
+	Groups     []ServerGroup `json:"groups"`
+	RackAware  bool          `json:"rack_awareness"`
+	Unbalanced bool          `json:"unbalanced"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type ServerGroup struct {
+	Name      string   `json:"name"`
+	Nodes     []string `json:"nodes"`
+	DataNodes int      `json:"data_nodes"`
+}
+
+// types for parsing JSON from /pools/default/serverGroups
+
+type serverGroupList struct {
+	Groups []struct {
+		Name  string     `json:"name"`
+		Nodes []NodeInfo `json:"nodes"`
+	} `json:"groups"`
+}
+
+func CollectServerGroups(conn *ClusterConn) *ServerGroupSummary {
+	summary := &ServerGroupSummary{Groups: make([]ServerGroup, 0)}
+
+	var data serverGroupList
+	err := conn.Client.getJSON("/pools/default/serverGroups", &data)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+
+	for _, group := range data.Groups {
+		serverGroup := ServerGroup{Name: group.Name, Nodes: make([]string, 0, len(group.Nodes))}
+		for _, nodeInfo := range group.Nodes {
+			serverGroup.Nodes = append(serverGroup.Nodes, nodeInfo.Hostname)
+			if containsString(nodeInfo.Services, "kv") {
+				serverGroup.DataNodes++
+			}
+		}
+		summary.Groups = append(summary.Groups, serverGroup)
+	}
+
+	summary.RackAware = len(summary.Groups) > 1
+	if summary.RackAware {
+		for _, group := range summary.Groups[1:] {
+			if group.DataNodes != summary.Groups[0].DataNodes {
+				summary.Unbalanced = true
+			}
+		}
+	}
+	return summary
+}
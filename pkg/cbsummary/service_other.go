@@ -0,0 +1,20 @@
+//go:build !windows
+
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+// outside of Windows there is no service control manager to hand over to, so
+// the daemon always runs in the foreground (systemd and friends manage it
+// through signals and the pid file)
+func RunService(d *Daemon) (bool, error) {
+	return false, nil
+}
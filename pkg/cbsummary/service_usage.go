@@ -0,0 +1,187 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// memory and disk used by the index, search (FTS), analytics and eventing
+// services, from the stats endpoints of each node running them, since a
+// node's systemStats don't say which service is using its resources
+//
+// - index:     the indexer's /stats, using memory_used for memory and the sum
+//              of the per-index <bucket>:<index>:disk_size for disk
+// - search:    /api/nsstats, using num_bytes_used_ram for memory and the sum of
+//              the per-index <bucket>:<index>:num_bytes_used_disk for disk
+// - analytics: /analytics/node/stats, using heap_used and disk_used
+// - eventing:  the service doesn't report its memory, so it's the resident
+//              memory of its producer and consumer processes, from the
+//              cluster's /pools/default/stats/range (7.0 and later); no disk
+//
+// Each node's memory is also given against the service's memory quota, which
+// applies to each node running it.
+//
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type ServiceUsage struct {
+	Nodes        []ServiceNodeUsage `json:"nodes"`
+	MemoryUsedMB float64            `json:"memory_used_mb"`
+	DiskUsedMB   float64            `json:"disk_used_mb"`
+	Error        string             `json:"error,omitempty"`
+}
+
+type ServiceNodeUsage struct {
+	Host          string  `json:"host"`
+	MemoryUsedMB  float64 `json:"memory_used_mb"`
+	DiskUsedMB    float64 `json:"disk_used_mb"`
+	MemoryQuotaMB float64 `json:"memory_quota_mb,omitempty"`
+	MemoryUsedPct float64 `json:"memory_used_pct,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// the eventing service's processes, as ns_server's process stats name them
+// (truncated to 15 characters, as the kernel does)
+var EVENTING_PROCESSES = []string{"eventing-produc", "eventing-consum"}
+
+// the response from /pools/default/stats/range/<metric>, with a series for
+// each node when nodesAggregation=none
+type statsRange struct {
+	Data []struct {
+		Metric struct {
+			Nodes []string `json:"nodes"`
+		} `json:"metric"`
+		Values [][]interface{} `json:"values"`
+	} `json:"data"`
+}
+
+func CollectIndexUsage(conn *ClusterConn) *ServiceUsage {
+	return collectServiceUsage(conn, "index", "/stats", func(stats map[string]interface{}) (float64, float64) {
+		disk := 0.0
+		for key := range stats {
+			if strings.HasSuffix(key, ":disk_size") {
+				disk = disk + statValue(stats, key)
+			}
+		}
+		return statValue(stats, "memory_used"), disk
+	})
+}
+
+func CollectSearchUsage(conn *ClusterConn) *ServiceUsage {
+	return collectServiceUsage(conn, "fts", "/api/nsstats", func(stats map[string]interface{}) (float64, float64) {
+		disk := 0.0
+		for key := range stats {
+			if strings.HasSuffix(key, ":num_bytes_used_disk") {
+				disk = disk + statValue(stats, key)
+			}
+		}
+		return statValue(stats, "num_bytes_used_ram"), disk
+	})
+}
+
+func CollectAnalyticsUsage(conn *ClusterConn) *ServiceUsage {
+	return collectServiceUsage(conn, "cbas", "/analytics/node/stats", func(stats map[string]interface{}) (float64, float64) {
+		return statValue(stats, "heap_used"), statValue(stats, "disk_used")
+	})
+}
+
+// fetch the stats from each node running the service, using extract to get
+// the memory and disk used (in bytes) from them
+func collectServiceUsage(conn *ClusterConn, service, path string,
+	extract func(map[string]interface{}) (float64, float64)) *ServiceUsage {
+	usage := &ServiceUsage{Nodes: make([]ServiceNodeUsage, 0)}
+
+	clients, err := conn.ServiceClients(service)
+	if err != nil {
+		usage.Error = err.Error()
+		return usage
+	}
+
+	for _, serviceClient := range clients {
+		node := ServiceNodeUsage{Host: serviceClient.host}
+
+		var stats map[string]interface{}
+		err := serviceClient.getJSON(path, &stats)
+		if err != nil {
+			LogError("Error getting %s stats from %s: %v", service, serviceClient.host, err)
+			node.Error = err.Error()
+		} else {
+			memory, disk := extract(stats)
+			node.MemoryUsedMB = memory / 1024.0 / 1024.0
+			node.DiskUsedMB = disk / 1024.0 / 1024.0
+			node.setQuota(conn.PoolsDefault, service)
+		}
+
+		usage.Nodes = append(usage.Nodes, node)
+		usage.MemoryUsedMB = usage.MemoryUsedMB + node.MemoryUsedMB
+		usage.DiskUsedMB = usage.DiskUsedMB + node.DiskUsedMB
+	}
+
+	return usage
+}
+
+// the resident memory of the eventing processes on each eventing node
+func CollectEventingUsage(conn *ClusterConn) *ServiceUsage {
+	usage := &ServiceUsage{Nodes: make([]ServiceNodeUsage, 0)}
+	if !runsService(conn.PoolsDefault.Nodes, "eventing") {
+		return usage
+	}
+
+	memory := make(map[string]float64)
+	for _, proc := range EVENTING_PROCESSES {
+		var stats statsRange
+		err := conn.Client.getJSON("/pools/default/stats/range/sysproc_mem_resident?nodesAggregation=none&start=-60&proc="+
+			url.QueryEscape(proc), &stats)
+		if err != nil {
+			LogError("Error getting eventing memory stats: %v", err)
+			usage.Error = err.Error()
+			return usage
+		}
+		for _, series := range stats.Data {
+			if len(series.Metric.Nodes) == 0 || len(series.Values) == 0 {
+				continue
+			}
+			// each value is [timestamp, "value"], the latest last
+			latest := series.Values[len(series.Values)-1]
+			if len(latest) < 2 {
+				continue
+			}
+			value, _ := latest[1].(string)
+			used, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			memory[series.Metric.Nodes[0]] += used
+		}
+	}
+
+	for _, nodeInfo := range conn.PoolsDefault.Nodes {
+		if !containsString(nodeInfo.Services, "eventing") {
+			continue
+		}
+		node := ServiceNodeUsage{Host: nodeInfo.Hostname, MemoryUsedMB: memory[nodeInfo.Hostname] / 1024.0 / 1024.0}
+		node.setQuota(conn.PoolsDefault, "eventing")
+		usage.Nodes = append(usage.Nodes, node)
+		usage.MemoryUsedMB = usage.MemoryUsedMB + node.MemoryUsedMB
+	}
+
+	return usage
+}
+
+// fill in the node's memory quota for the service, and how much of it is used
+func (n *ServiceNodeUsage) setQuota(poolsDefault *PoolsDefault, service string) {
+	n.MemoryQuotaMB = float64(serviceMemoryQuota(poolsDefault, service))
+	if n.MemoryQuotaMB > 0 {
+		n.MemoryUsedPct = 100 * n.MemoryUsedMB / n.MemoryQuotaMB
+	}
+}
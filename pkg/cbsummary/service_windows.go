@@ -0,0 +1,89 @@
+//go:build windows
+
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// running the daemon as a Windows service
+//
+// A stop or shutdown request from the service control manager is handled the
+// same way as SIGTERM elsewhere, and a parameter change request reloads the
+// config the same way as SIGHUP.
+//
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+const SERVICE_NAME = "cbsummary"
+
+// if we were started by the service control manager, run the daemon as a
+// service and return true once it has stopped
+func RunService(d *Daemon) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return false, fmt.Errorf("Error determining whether running as a service: %v", err)
+	}
+	if !isService {
+		return false, nil
+	}
+
+	return true, svc.Run(SERVICE_NAME, &serviceHandler{daemon: d})
+}
+
+type serviceHandler struct {
+	daemon *Daemon
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan os.Signal, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- h.daemon.RunUntil(stop)
+	}()
+
+	accepts := svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for {
+		select {
+		case err := <-errs:
+			if err != nil {
+				LogError("%v", err)
+				return false, 1
+			}
+			return false, 0
+
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.ParamChange:
+				h.daemon.reload()
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				stop <- os.Interrupt
+				if err := <-errs; err != nil {
+					LogError("%v", err)
+					return false, 1
+				}
+				return false, 0
+			}
+		}
+	}
+}
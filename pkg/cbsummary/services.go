@@ -0,0 +1,83 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// access to the REST APIs of the individual services (query, search, etc.),
+// which listen on their own ports on each node running the service
+//
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// the keys for each service's plain and SSL ports in /pools/default/nodeServices
+var SERVICE_PORTS = map[string][2]string{
+	"mgmt":     {"mgmt", "mgmtSSL"},
+	"n1ql":     {"n1ql", "n1qlSSL"},
+	"index":    {"indexHttp", "indexHttps"},
+	"fts":      {"fts", "ftsSSL"},
+	"cbas":     {"cbas", "cbasSSL"},
+	"eventing": {"eventingAdminPort", "eventingSSL"},
+}
+
+// a client for each node running the given service, talking to the service's
+// own port, using SSL if this client does
+func (r *RestClient) serviceClients(nodeServices *NodeServices, service string) []*RestClient {
+	// nodes only give a hostname when it differs from the one we asked
+	defaultHost := ""
+	if u, err := url.Parse(r.host); err == nil {
+		defaultHost = u.Hostname()
+	}
+
+	keys, ok := SERVICE_PORTS[service]
+	if !ok {
+		return nil
+	}
+	scheme, key := "http", keys[0]
+	if r.secure {
+		scheme, key = "https", keys[1]
+	}
+
+	clients := make([]*RestClient, 0)
+	for _, node := range nodeServices.NodesExt {
+		port, ok := node.Services[key]
+		if !ok {
+			continue
+		}
+		host := node.Hostname
+		if len(host) == 0 {
+			host = defaultHost
+		}
+		clients = append(clients, r.ForHost(scheme+"://"+net.JoinHostPort(host, strconv.Itoa(port))))
+	}
+	return clients
+}
+
+// read a numeric value from a decoded JSON object, or zero if it isn't there
+func statValue(stats map[string]interface{}, key string) float64 {
+	return toFloat(stats[key])
+}
+
+// a number decoded from JSON, or 0 if it isn't one
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case float64:
+		return v
+	}
+	return 0
+}
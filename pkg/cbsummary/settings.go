@@ -0,0 +1,84 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// cluster settings for the full report, from /settings/autoFailover,
+// /settings/autoReprovision, /settings/autoCompaction (see compaction.go)
+// and /settings/indexes
+//
+// Auto-reprovision applies to ephemeral buckets: it promotes replicas on
+// nodes that restart and lose their data, up to max_nodes at a time.
+//
+
+import (
+	"fmt"
+)
+
+// types for parsing JSON from the cluster settings endpoints
+
+type autoFailoverSettings struct {
+	Enabled  bool `json:"enabled"`
+	Timeout  int  `json:"timeout"`
+	MaxCount int  `json:"maxCount"`
+}
+
+type autoReprovisionSettings struct {
+	Enabled  bool `json:"enabled"`
+	MaxNodes int  `json:"max_nodes"`
+}
+
+type indexSettings struct {
+	StorageMode string `json:"storageMode"`
+}
+
+func CollectClusterSettings(conn *ClusterConn) ClusterSettings {
+	var settings ClusterSettings
+	client := conn.Client
+
+	fail := func(path string, err error) {
+		LogError("Error getting %s from %s: %v", path, client.host, err)
+		settings.Errors = append(settings.Errors, fmt.Sprintf("%s: %v", path, err))
+	}
+
+	var failover autoFailoverSettings
+	if err := client.getJSON("/settings/autoFailover", &failover); err != nil {
+		fail("/settings/autoFailover", err)
+	} else {
+		settings.EnableAutoFailover = failover.Enabled
+		settings.FailoverTimeout = failover.Timeout
+		settings.FailoverMaxCount = failover.MaxCount
+	}
+
+	var reprovision autoReprovisionSettings
+	if err := client.getJSON("/settings/autoReprovision", &reprovision); err != nil {
+		fail("/settings/autoReprovision", err)
+	} else {
+		settings.EnableAutoReprovision = reprovision.Enabled
+		settings.ReprovisionMaxNodes = reprovision.MaxNodes
+	}
+
+	compaction, err := client.GetCompactionSettings()
+	if err != nil {
+		fail("/settings/autoCompaction", err)
+	} else {
+		settings.Compaction = compaction
+	}
+
+	var indexes indexSettings
+	if err := client.getJSON("/settings/indexes", &indexes); err != nil {
+		fail("/settings/indexes", err)
+	} else {
+		settings.IndexStorageMode = indexes.StorageMode
+	}
+
+	return settings
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// report sinks - where the report is written: a local file, or an object in
+// cloud storage given as the --output URL
+//
+//   s3://bucket/prefix/      Amazon S3, or S3-compatible storage with
+//                            --sink-endpoint, in the region from
+//                            --sink-region or the AWS configuration
+//   gs://bucket/prefix/      Google Cloud Storage
+//   az://account/container/prefix/
+//                            Azure Blob Storage
+//
+// A URL ending in "/" is a prefix, and each report is written under it with
+// its default timestamped name; otherwise the URL names the object itself.
+//
+// Each store is written with its own SDK and that SDK's default credentials:
+// for S3 the environment, AWS_PROFILE and the shared config files, or an
+// instance or task role; for GCS the application default credentials
+// (GOOGLE_APPLICATION_CREDENTIALS, gcloud's login, or the workload's service
+// account); and for Azure a SAS token in AZURE_STORAGE_SAS_TOKEN if there is
+// one, or else the Azure SDK's default credential (the environment, a managed
+// or workload identity, or the Azure CLI's login).
+//
+// Server-side encryption: --sink-sse=AES256 or aws:kms asks S3 to encrypt
+// the report, with --sink-kms-key choosing the KMS key. For GCS the KMS key
+// is the Cloud KMS key name, and for Azure it is the encryption scope.
+//
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"google.golang.org/api/option"
+)
+
+// the longest an upload may take
+const SINK_UPLOAD_TIMEOUT = 5 * time.Minute
+
+// somewhere a report can be written
+type ReportSink interface {
+	// write the report under the given name, or the sink's own name if it
+	// has one
+	Write(name string, body []byte, contentType string) (string, error)
+}
+
+// settings for the cloud storage sinks
+type SinkOptions struct {
+	Region   string
+	Endpoint string
+	SSE      string
+	KMSKey   string
+}
+
+// whether the output names a cloud storage location rather than a file
+func IsSinkURL(output string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "az://"} {
+		if strings.HasPrefix(output, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// the sink for an output URL
+func NewReportSink(output string, options SinkOptions) (ReportSink, error) {
+	u, err := url.Parse(output)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid output URL %s", output)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Sink(u.Host, key, options)
+	case "gs":
+		return newGCSSink(u.Host, key, options)
+	case "az":
+		container, prefix, _ := strings.Cut(key, "/")
+		if len(container) == 0 {
+			return nil, fmt.Errorf("Output URL %s must give a container, az://<account>/<container>/", output)
+		}
+		return newAzureSink(u.Host, container, prefix, options)
+	}
+	return nil, fmt.Errorf("Unknown output URL scheme in %s", output)
+}
+
+// the object key to write to: the sink's own key, or the name under its prefix
+func objectKey(key, name string) string {
+	if len(key) == 0 || strings.HasSuffix(key, "/") {
+		return key + name
+	}
+	return key
+}
+
+// an S3 bucket, or one in S3-compatible storage
+type s3Sink struct {
+	uploader *manager.Uploader
+	bucket   string
+	key      string
+	sse      string
+	kmsKey   string
+}
+
+func newS3Sink(bucket, key string, options SinkOptions) (*s3Sink, error) {
+	var loadOptions []func(*config.LoadOptions) error
+	if len(options.Region) > 0 {
+		loadOptions = append(loadOptions, config.WithRegion(options.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading the AWS configuration: %v", err)
+	}
+	if len(cfg.Region) == 0 {
+		cfg.Region = AWS_DEFAULT_REGION
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if len(options.Endpoint) > 0 {
+			// S3-compatible stores are mostly addressed by path, not by host
+			o.BaseEndpoint = aws.String(options.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Sink{
+		uploader: manager.NewUploader(client),
+		bucket:   bucket,
+		key:      key,
+		sse:      options.SSE,
+		kmsKey:   options.KMSKey,
+	}, nil
+}
+
+func (s *s3Sink) Write(name string, body []byte, contentType string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(objectKey(s.key, name)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+	if len(s.sse) > 0 {
+		input.ServerSideEncryption = types.ServerSideEncryption(s.sse)
+		if len(s.kmsKey) > 0 {
+			input.SSEKMSKeyId = aws.String(s.kmsKey)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SINK_UPLOAD_TIMEOUT)
+	defer cancel()
+	output, err := s.uploader.Upload(ctx, input)
+	target := "s3://" + path.Join(s.bucket, *input.Key)
+	if err != nil {
+		return "", fmt.Errorf("writing %s: %v", target, awsErrorMessage(err))
+	}
+	if len(output.Location) > 0 {
+		target = output.Location
+	}
+	return target, nil
+}
+
+// a Google Cloud Storage bucket
+type gcsSink struct {
+	client *storage.Client
+	bucket string
+	key    string
+	kmsKey string
+}
+
+func newGCSSink(bucket, key string, options SinkOptions) (*gcsSink, error) {
+	var clientOptions []option.ClientOption
+	if len(options.Endpoint) > 0 {
+		clientOptions = append(clientOptions, option.WithEndpoint(options.Endpoint))
+	}
+	client, err := storage.NewClient(context.Background(), clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to Google Cloud Storage: %v", err)
+	}
+	return &gcsSink{client: client, bucket: bucket, key: key, kmsKey: options.KMSKey}, nil
+}
+
+func (s *gcsSink) Write(name string, body []byte, contentType string) (string, error) {
+	key := objectKey(s.key, name)
+	target := "gs://" + path.Join(s.bucket, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), SINK_UPLOAD_TIMEOUT)
+	defer cancel()
+	w := s.client.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+	w.KMSKeyName = s.kmsKey
+	_, err := w.Write(body)
+	if err != nil {
+		w.Close()
+		return "", fmt.Errorf("writing %s: %v", target, err)
+	}
+	err = w.Close()
+	if err != nil {
+		return "", fmt.Errorf("writing %s: %v", target, err)
+	}
+	return target, nil
+}
+
+// an Azure Blob Storage container
+type azureSink struct {
+	client    *azblob.Client
+	endpoint  string
+	container string
+	key       string
+	scope     string
+}
+
+func newAzureSink(account, container, key string, options SinkOptions) (*azureSink, error) {
+	endpoint := options.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/") + "/"
+
+	var client *azblob.Client
+	var err error
+	if sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"); len(sas) > 0 {
+		client, err = azblob.NewClientWithNoCredential(endpoint+"?"+sas, nil)
+	} else {
+		var credential *azidentity.DefaultAzureCredential
+		credential, err = azidentity.NewDefaultAzureCredential(nil)
+		if err == nil {
+			client, err = azblob.NewClient(endpoint, credential, nil)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to Azure Blob Storage account %s: %v", account, err)
+	}
+	return &azureSink{client: client, endpoint: endpoint, container: container, key: key, scope: options.KMSKey}, nil
+}
+
+func (s *azureSink) Write(name string, body []byte, contentType string) (string, error) {
+	key := objectKey(s.key, name)
+	target := s.endpoint + path.Join(s.container, key)
+
+	upload := &azblob.UploadBufferOptions{HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType}}
+	if len(s.scope) > 0 {
+		upload.CPKScopeInfo = &blob.CPKScopeInfo{EncryptionScope: &s.scope}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SINK_UPLOAD_TIMEOUT)
+	defer cancel()
+	_, err := s.client.UploadBuffer(ctx, s.container, key, body, upload)
+	if err != nil {
+		return "", fmt.Errorf("writing %s: %v", target, err)
+	}
+	return target, nil
+}
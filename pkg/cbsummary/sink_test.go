@@ -0,0 +1,155 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a store that accepts uploads, keeping the last one
+type fakeStore struct {
+	mu      sync.Mutex
+	status  int
+	request *http.Request
+	body    []byte
+}
+
+func newFakeStore(t *testing.T, status int) (*fakeStore, *httptest.Server) {
+	store := &fakeStore{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("reading upload: %v", err)
+		}
+		store.mu.Lock()
+		store.request = req
+		store.body = body
+		status := store.status
+		store.mu.Unlock()
+		w.Header().Set("ETag", `"0123456789abcdef"`)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return store, server
+}
+
+func (s *fakeStore) last() (*http.Request, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.request, s.body
+}
+
+func TestS3Sink(t *testing.T) {
+	// credentials from the environment only, whatever the machine running
+	// the test has set up
+	dir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	store, server := newFakeStore(t, http.StatusOK)
+	sink, err := NewReportSink("s3://reports/fleet/", SinkOptions{Region: "eu-west-1", Endpoint: server.URL,
+		SSE: "aws:kms", KMSKey: "alias/reports"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"#clusters": 1}`)
+	target, err := sink.Write("cbsummary.out.json", body, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(target, "reports/fleet/cbsummary.out.json") {
+		t.Errorf("uploaded to %s", target)
+	}
+
+	req, got := store.last()
+	if req == nil {
+		t.Fatal("nothing was uploaded")
+	}
+	if req.Method != "PUT" || req.URL.Path != "/reports/fleet/cbsummary.out.json" {
+		t.Errorf("upload was %s %s, want PUT /reports/fleet/cbsummary.out.json", req.Method, req.URL.Path)
+	}
+	if !bytes.Contains(got, body) {
+		t.Errorf("uploaded %q, want %q", got, body)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/") {
+		t.Errorf("upload wasn't signed with the credentials: %q", req.Header.Get("Authorization"))
+	}
+	for header, want := range map[string]string{
+		"Content-Type":                                "application/json",
+		"X-Amz-Server-Side-Encryption":                "aws:kms",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/reports",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s was %q, want %q", header, got, want)
+		}
+	}
+
+	store.mu.Lock()
+	store.status = http.StatusForbidden
+	store.mu.Unlock()
+	_, err = sink.Write("cbsummary.out.json", body, "application/json")
+	if err == nil {
+		t.Errorf("upload refused by the store didn't fail")
+	}
+}
+
+func TestAzureSink(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2022-11-02&sig=c2lnbmF0dXJl")
+
+	store, server := newFakeStore(t, http.StatusCreated)
+	sink, err := NewReportSink("az://account/reports/fleet/", SinkOptions{Endpoint: server.URL, KMSKey: "reports-scope"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"#clusters": 1}`)
+	target, err := sink.Write("cbsummary.out.json", body, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != server.URL+"/reports/fleet/cbsummary.out.json" {
+		t.Errorf("uploaded to %s", target)
+	}
+
+	req, got := store.last()
+	if req == nil {
+		t.Fatal("nothing was uploaded")
+	}
+	if req.Method != "PUT" || req.URL.Path != "/reports/fleet/cbsummary.out.json" {
+		t.Errorf("upload was %s %s, want PUT /reports/fleet/cbsummary.out.json", req.Method, req.URL.Path)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("uploaded %q, want %q", got, body)
+	}
+	if req.URL.Query().Get("sig") != "c2lnbmF0dXJl" {
+		t.Errorf("upload didn't carry the SAS token: %s", req.URL.RawQuery)
+	}
+	for header, want := range map[string]string{
+		"X-Ms-Blob-Type":         "BlockBlob",
+		"X-Ms-Blob-Content-Type": "application/json",
+		"X-Ms-Encryption-Scope":  "reports-scope",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s was %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// Capella migration sizing - maps each self-managed cluster onto a suggested
+// Capella cluster, as an appendix for migration planning
+//
+// The nodes of a cluster are grouped by the set of services they run, which
+// become the Capella service groups. For each group the vCPUs needed are the
+// observed cores scaled by how busy the CPUs are against a target utilization
+// (never below half nor above one and a half times what is there now), and
+// the RAM needed is the observed RAM. Nodes with no core count at all, not
+// even the host CPU count, are estimated from RAM, as for consumption units.
+//
+// Each group is then fitted to the Capella node size that needs the fewest
+// vCPUs in total, with at least SIZING_MIN_DATA_NODES nodes for groups with
+// the data service and SIZING_MIN_NODES for the others.
+//
+// Like the consumption units these are planning figures, not a quote.
+//
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// the CPU utilization Capella clusters are sized to run at
+const SIZING_TARGET_CPU_UTILIZATION = 70.0
+
+const SIZING_MIN_DATA_NODES = 3
+const SIZING_MIN_NODES = 2
+
+const SIZING_NOTE = "Suggested Capella service groups based on observed hardware and CPU utilization; " +
+	"for migration planning only, not a quote"
+
+// the Capella names of the services reported by ns_server
+var CAPELLA_SERVICE_NAMES = map[string]string{
+	"kv":       "data",
+	"index":    "index",
+	"n1ql":     "query",
+	"fts":      "search",
+	"cbas":     "analytics",
+	"eventing": "eventing",
+	"backup":   "backup",
+}
+
+type CapellaNodeSize struct {
+	VCPUs int `json:"vcpus"`
+	RAMGB int `json:"ram_gb"`
+}
+
+// the compute sizes offered for Capella nodes
+var CAPELLA_NODE_SIZES = []CapellaNodeSize{
+	{4, 16}, {4, 32},
+	{8, 16}, {8, 32}, {8, 64},
+	{16, 32}, {16, 64}, {16, 128},
+	{32, 128}, {32, 256},
+	{48, 192}, {64, 256}, {80, 320},
+}
+
+type CapellaSizingReport struct {
+	Note     string          `json:"note"`
+	Clusters []ClusterSizing `json:"clusters"`
+	Nodes    int             `json:"total_nodes"`
+	VCPUs    int             `json:"total_vcpus"`
+}
+
+type ClusterSizing struct {
+	ClusterNum    int                  `json:"cluster_num"`
+	UUID          string               `json:"cluster_uuid"`
+	ServiceGroups []ServiceGroupSizing `json:"service_groups"`
+	Nodes         int                  `json:"nodes"`
+	VCPUs         int                  `json:"vcpus"`
+}
+
+type ServiceGroupSizing struct {
+	Services       []string        `json:"services"`
+	ObservedNodes  int             `json:"observed_nodes"`
+	ObservedCores  float64         `json:"observed_cores"`
+	ObservedRAM    float64         `json:"observed_ram_gb"`
+	CPUUtilization float64         `json:"cpu_utilization"`
+	RequiredVCPUs  float64         `json:"required_vcpus"`
+	RequiredRAM    float64         `json:"required_ram_gb"`
+	NodeSize       CapellaNodeSize `json:"node_size"`
+	Nodes          int             `json:"nodes"`
+	Estimated      bool            `json:"estimated,omitempty"`
+}
+
+func NewCapellaSizingReport() *CapellaSizingReport {
+	return &CapellaSizingReport{
+		Note:     SIZING_NOTE,
+		Clusters: make([]ClusterSizing, 0),
+	}
+}
+
+// size one cluster and add it to the report
+func (r *CapellaSizingReport) AddCluster(clusterNum int, uuid string, nodes []NodeInfo) {
+	cluster := ClusterSizing{
+		ClusterNum:    clusterNum,
+		UUID:          uuid,
+		ServiceGroups: make([]ServiceGroupSizing, 0),
+	}
+
+	// group the nodes by the services they run
+	groups := make(map[string]*ServiceGroupSizing)
+	utilization := make(map[string]float64)
+	for _, nodeInfo := range nodes {
+		services := capellaServices(nodeInfo.Services)
+		key := strings.Join(services, ",")
+		group, ok := groups[key]
+		if !ok {
+			group = &ServiceGroupSizing{Services: services}
+			groups[key] = group
+		}
+
+		ram := nodeInfo.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+		cores := nodeInfo.SystemStats.CPU_cores_available
+		// no cores info for earlier than 6.5, so estimate from RAM
+		if cores <= 0 {
+			cores = ram / RAM_GB_PER_CU
+			group.Estimated = true
+		}
+
+		group.ObservedNodes++
+		group.ObservedCores = group.ObservedCores + cores
+		group.ObservedRAM = group.ObservedRAM + ram
+		utilization[key] = utilization[key] + cores*nodeInfo.SystemStats.Cpu_utilization_rate
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		group := groups[key]
+		if group.ObservedCores > 0 {
+			group.CPUUtilization = utilization[key] / group.ObservedCores
+		}
+		sizeServiceGroup(group)
+		cluster.ServiceGroups = append(cluster.ServiceGroups, *group)
+		cluster.Nodes = cluster.Nodes + group.Nodes
+		cluster.VCPUs = cluster.VCPUs + group.Nodes*group.NodeSize.VCPUs
+	}
+
+	r.Clusters = append(r.Clusters, cluster)
+	r.Nodes = r.Nodes + cluster.Nodes
+	r.VCPUs = r.VCPUs + cluster.VCPUs
+
+	// keep the clusters in config order regardless of the order they were added
+	sort.Slice(r.Clusters, func(i, j int) bool {
+		return r.Clusters[i].ClusterNum < r.Clusters[j].ClusterNum
+	})
+}
+
+// add the clusters from another report, renumbering them from offset
+func (r *CapellaSizingReport) Merge(other *CapellaSizingReport, offset int) {
+	for _, cluster := range other.Clusters {
+		cluster.ClusterNum = cluster.ClusterNum + offset
+		r.Clusters = append(r.Clusters, cluster)
+	}
+	r.Nodes = r.Nodes + other.Nodes
+	r.VCPUs = r.VCPUs + other.VCPUs
+}
+
+// work out the resources a service group needs and pick the node size for it
+func sizeServiceGroup(group *ServiceGroupSizing) {
+	scale := 1.0
+	if group.CPUUtilization > 0 {
+		scale = math.Min(math.Max(group.CPUUtilization/SIZING_TARGET_CPU_UTILIZATION, 0.5), 1.5)
+	}
+	group.RequiredVCPUs = group.ObservedCores * scale
+	group.RequiredRAM = group.ObservedRAM
+
+	minNodes := SIZING_MIN_NODES
+	for _, service := range group.Services {
+		if service == "data" {
+			minNodes = SIZING_MIN_DATA_NODES
+		}
+	}
+
+	best := -1
+	for _, size := range CAPELLA_NODE_SIZES {
+		nodes := minNodes
+		if n := int(math.Ceil(group.RequiredVCPUs / float64(size.VCPUs))); n > nodes {
+			nodes = n
+		}
+		if n := int(math.Ceil(group.RequiredRAM / float64(size.RAMGB))); n > nodes {
+			nodes = n
+		}
+
+		total := nodes * size.VCPUs
+		if best < 0 || total < best || (total == best && nodes < group.Nodes) {
+			best = total
+			group.NodeSize = size
+			group.Nodes = nodes
+		}
+	}
+}
+
+// the Capella names of a node's services, sorted
+func capellaServices(services []string) []string {
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		if name, ok := CAPELLA_SERVICE_NAMES[service]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, service)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s CapellaNodeSize) String() string {
+	return fmt.Sprintf("%d vCPU / %d GB", s.VCPUs, s.RAMGB)
+}
+
+func (r *CapellaSizingReport) String() string {
+	return fmt.Sprintf("%d Capella nodes with %d vCPUs across %d clusters", r.Nodes, r.VCPUs, len(r.Clusters))
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// per-cluster reports - with --split-per-cluster, a report is also written
+// for each cluster on its own, so each team can be sent just its clusters.
+// Each is named for its cluster's label, or its UUID if it has no label:
+//
+//   - if the output name has a {cluster} placeholder, it is filled in with
+//     the cluster's name
+//   - otherwise the cluster's name is added before the format's extension,
+//     e.g. fleet.json gives fleet.orders.json, or at the end of the name,
+//     e.g. cbsummary.out.<timestamp>.orders
+//
+// A cluster's report has its nodes, versions and health findings, but not
+// the sections that cover the whole fleet, such as the license summary.
+// --split-only writes just the per-cluster reports.
+//
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the report for each cluster on its own, with the name of its cluster
+func SplitSummary(clusterSummary *SummaryInfo) ([]string, []*SummaryInfo) {
+	names := make([]string, 0, len(clusterSummary.Clusters))
+	summaries := make([]*SummaryInfo, 0, len(clusterSummary.Clusters))
+	used := make(map[string]bool)
+
+	for cnum, icluster := range clusterSummary.Clusters {
+		summary := &SummaryInfo{
+			NumClusters:  1,
+			NodeVersions: make(map[string]int),
+			Clusters:     []interface{}{icluster},
+			Metadata:     clusterSummary.Metadata,
+		}
+
+		switch c := icluster.(type) {
+		case *BriefCluster:
+			for _, node := range c.Nodes {
+				summary.NodeVersions[node.Version]++
+			}
+			summary.TotalNumNodes = len(c.Nodes)
+		case *ClusterSummary:
+			for version, count := range c.NodeVersions {
+				summary.NodeVersions[version] = count
+			}
+			summary.TotalNumNodes = len(c.Nodes)
+		}
+		if clusterSummary.NodeMembership != nil {
+			summary.NodeMembership = NewMembershipRollup()
+			summary.NodeMembership.AddCluster(0, clusterMembershipAnomalies(icluster))
+		}
+
+		// the cluster is the first, and only, in its own report
+		for _, finding := range clusterSummary.Findings {
+			if finding.ClusterNum == cnum {
+				finding.ClusterNum = 0
+				summary.Findings = append(summary.Findings, finding)
+			}
+		}
+
+		// clusters without a label or UUID, or sharing a label, go by their number
+		name := clusterFileName(icluster)
+		if len(name) == 0 {
+			name = fmt.Sprintf("cluster-%d", cnum)
+		} else if used[name] {
+			name = fmt.Sprintf("%s-%d", name, cnum)
+		}
+		used[name] = true
+
+		names = append(names, name)
+		summaries = append(summaries, summary)
+	}
+	return names, summaries
+}
+
+// a cluster's label, or its UUID if it has no label, made safe for a file name
+func clusterFileName(icluster interface{}) string {
+	name := ""
+	switch c := icluster.(type) {
+	case *BriefCluster:
+		name = c.Label
+		if len(name) == 0 {
+			name = c.UUID
+		}
+	case *ClusterSummary:
+		name = c.Label
+		if len(name) == 0 {
+			name = c.Uuid
+		}
+	case *ClusterError:
+		name = c.TheCluster.Label
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// the output file name for a cluster's report, with a {cluster} placeholder
+// where its name is to go
+func splitOutputFile(name, format string) string {
+	if strings.Contains(name, "{cluster}") {
+		return name
+	}
+	if ext := "." + format; strings.HasSuffix(name, ext) {
+		return strings.TrimSuffix(name, ext) + ".{cluster}" + ext
+	}
+	return name + ".{cluster}"
+}
+
+// write, or upload, the report for each cluster
+func (p *Publisher) publishSplit(clusterSummary *SummaryInfo, outputFile, defaultName string, now time.Time,
+	options ReportOptions) error {
+	names, summaries := SplitSummary(clusterSummary)
+	for i, summary := range summaries {
+		var err error
+		if p.Sink != nil {
+			err = UploadReport(summary, p.Sink, defaultName+"."+names[i], options)
+		} else {
+			err = WriteReport(summary, ExpandOutputFile(splitOutputFile(outputFile, options.Format), names[i],
+				options.Format, now), options)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
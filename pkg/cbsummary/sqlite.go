@@ -0,0 +1,300 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// SQLite history - the history store kept in a SQLite database, through the
+// pure Go modernc.org/sqlite driver so no cgo is needed
+//
+// Each run appends a row to "runs", with a row in "clusters" for each of its
+// clusters and in "nodes" for each of their nodes. Retention deletes the runs
+// that aren't kept, and their clusters and nodes, leaving the rest of the
+// database (e.g. indexes or views added with the sqlite3 shell) alone.
+//
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var SQLITE_HISTORY_SCHEMA = []string{
+	"CREATE TABLE IF NOT EXISTS runs (id INTEGER PRIMARY KEY, time TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS clusters (id INTEGER PRIMARY KEY, run_id INTEGER NOT NULL, " +
+		"cluster_uuid TEXT, cluster_name TEXT, nodes INTEGER, cores REAL, ram_gb REAL)",
+	"CREATE TABLE IF NOT EXISTS nodes (id INTEGER PRIMARY KEY, cluster_id INTEGER NOT NULL, " +
+		"hostname TEXT, version TEXT, cores REAL, ram_gb REAL)",
+}
+
+// history kept in a SQLite database, opened on first use
+type sqliteHistory struct {
+	path string
+	db   *sql.DB
+}
+
+// open the database, creating it and its tables if create is set; without
+// create, a database that doesn't exist yet gives false
+func (h *sqliteHistory) open(create bool) (bool, error) {
+	if h.db != nil {
+		return true, nil
+	}
+	if !create {
+		if _, err := os.Stat(h.path); os.IsNotExist(err) {
+			return false, nil
+		}
+	}
+
+	db, err := sql.Open("sqlite", h.path)
+	if err != nil {
+		return false, err
+	}
+	// one connection, so the busy timeout applies to everything, waiting out
+	// another run or a reader holding the database
+	db.SetMaxOpenConns(1)
+	statements := append([]string{"PRAGMA busy_timeout = 5000"}, SQLITE_HISTORY_SCHEMA...)
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return false, err
+		}
+	}
+	h.db = db
+	return true, nil
+}
+
+func (h *sqliteHistory) Append(record HistoryRecord) error {
+	if _, err := h.open(true); err != nil {
+		return fmt.Errorf("Error opening history %s: %v", h.path, err)
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error writing history %s: %v", h.path, err)
+	}
+	err = insertHistoryRecord(tx, record)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Error writing history %s: %v", h.path, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Error writing history %s: %v", h.path, err)
+	}
+	return nil
+}
+
+func insertHistoryRecord(tx *sql.Tx, record HistoryRecord) error {
+	result, err := tx.Exec("INSERT INTO runs (time) VALUES (?)", record.Time.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	runID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, cluster := range record.Clusters {
+		result, err := tx.Exec("INSERT INTO clusters (run_id, cluster_uuid, cluster_name, nodes, cores, ram_gb) "+
+			"VALUES (?, ?, ?, ?, ?, ?)", runID, cluster.UUID, cluster.Name, len(cluster.Nodes), cluster.Cores, cluster.RAM)
+		if err != nil {
+			return err
+		}
+		clusterID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, node := range cluster.Nodes {
+			_, err := tx.Exec("INSERT INTO nodes (cluster_id, hostname, version, cores, ram_gb) VALUES (?, ?, ?, ?, ?)",
+				clusterID, node.Name, node.Version, node.Cores, node.RAM)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *sqliteHistory) Records() ([]HistoryRecord, error) {
+	exists, err := h.open(false)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening history %s: %v", h.path, err)
+	} else if !exists {
+		return nil, nil
+	}
+	records, err := h.records()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading history %s: %v", h.path, err)
+	}
+	return records, nil
+}
+
+// the runs, oldest first, with their clusters and nodes in the order recorded
+func (h *sqliteHistory) records() ([]HistoryRecord, error) {
+	records := make([]HistoryRecord, 0)
+	runs := make(map[int64]int)
+	err := sqliteQuery(h.db, "SELECT id, time FROM runs ORDER BY time, id", func(rows *sql.Rows) error {
+		var id int64
+		var at string
+		if err := rows.Scan(&id, &at); err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return fmt.Errorf("run %d: %v", id, err)
+		}
+		runs[id] = len(records)
+		records = append(records, HistoryRecord{Time: t, Clusters: make([]HistoryCluster, 0)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// where each cluster went, as its run and place in that run
+	type place struct{ run, cluster int }
+	clusters := make(map[int64]place)
+	err = sqliteQuery(h.db, "SELECT id, run_id, cluster_uuid, cluster_name, cores, ram_gb FROM clusters ORDER BY id",
+		func(rows *sql.Rows) error {
+			var id, runID int64
+			var uuid, name sql.NullString
+			var cores, ram sql.NullFloat64
+			if err := rows.Scan(&id, &runID, &uuid, &name, &cores, &ram); err != nil {
+				return err
+			}
+			run, ok := runs[runID]
+			if !ok {
+				return nil
+			}
+			clusters[id] = place{run, len(records[run].Clusters)}
+			records[run].Clusters = append(records[run].Clusters, HistoryCluster{
+				UUID:  uuid.String,
+				Name:  name.String,
+				Cores: cores.Float64,
+				RAM:   ram.Float64,
+			})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteQuery(h.db, "SELECT cluster_id, hostname, version, cores, ram_gb FROM nodes ORDER BY id",
+		func(rows *sql.Rows) error {
+			var clusterID int64
+			var hostname, version sql.NullString
+			var cores, ram sql.NullFloat64
+			if err := rows.Scan(&clusterID, &hostname, &version, &cores, &ram); err != nil {
+				return err
+			}
+			at, ok := clusters[clusterID]
+			if !ok {
+				return nil
+			}
+			cluster := &records[at.run].Clusters[at.cluster]
+			cluster.Nodes = append(cluster.Nodes, HistoryNode{hostname.String, version.String, cores.Float64, ram.Float64})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// call fn for each row of a query
+func sqliteQuery(db *sql.DB, query string, fn func(rows *sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// delete the runs the policy doesn't keep, with their clusters and nodes
+func (h *sqliteHistory) Prune(policy RetentionPolicy) (int, error) {
+	exists, err := h.open(false)
+	if err != nil {
+		return 0, fmt.Errorf("Error opening history %s: %v", h.path, err)
+	} else if !exists || !policy.IsSet() {
+		return 0, nil
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning history %s: %v", h.path, err)
+	}
+	pruned, err := pruneHistory(tx, policy, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("Error pruning history %s: %v", h.path, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("Error pruning history %s: %v", h.path, err)
+	}
+	return pruned, nil
+}
+
+// the same runs RetentionPolicy.Apply would drop; the times are all UTC in
+// the same format, so they compare as strings
+func pruneHistory(tx *sql.Tx, policy RetentionPolicy, now time.Time) (int, error) {
+	pruned := int64(0)
+	deleteRuns := func(query string, args ...interface{}) error {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		pruned = pruned + n
+		return err
+	}
+
+	if policy.MaxAge > 0 {
+		err := deleteRuns("DELETE FROM runs WHERE time < ?", now.Add(-policy.MaxAge).UTC().Format(time.RFC3339))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if policy.KeepRuns > 0 {
+		err := deleteRuns("DELETE FROM runs WHERE id NOT IN (SELECT id FROM runs ORDER BY time DESC, id DESC LIMIT ?)",
+			policy.KeepRuns)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+
+	for _, query := range []string{
+		"DELETE FROM clusters WHERE run_id NOT IN (SELECT id FROM runs)",
+		"DELETE FROM nodes WHERE cluster_id NOT IN (SELECT id FROM clusters)",
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return 0, err
+		}
+	}
+	return int(pruned), nil
+}
+
+func (h *sqliteHistory) Close() error {
+	if h.db == nil {
+		return nil
+	}
+	err := h.db.Close()
+	h.db = nil
+	return err
+}
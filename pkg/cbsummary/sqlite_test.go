@@ -0,0 +1,144 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a run of two clusters, the second of them with one node
+func testHistoryRecord(at time.Time, cores float64) HistoryRecord {
+	return HistoryRecord{
+		Time: at.UTC().Truncate(time.Second),
+		Clusters: []HistoryCluster{
+			{UUID: "uuid-1", Name: "prod", Cores: cores * 2, RAM: 64, Nodes: []HistoryNode{
+				{"10.0.0.1:8091", "7.2.4-7070-enterprise", cores, 32},
+				{"10.0.0.2:8091", "7.2.4-7070-enterprise", cores, 32},
+			}},
+			{UUID: "uuid-2", Cores: 4, RAM: 16, Nodes: []HistoryNode{
+				{"10.0.1.1:8091", "7.1.0-2556-community", 4, 16},
+			}},
+		},
+	}
+}
+
+func openTestHistory(t *testing.T, path string) HistoryStore {
+	t.Helper()
+	store, err := OpenHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteHistoryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.sqlite")
+	now := time.Now()
+	want := []HistoryRecord{
+		testHistoryRecord(now.Add(-2*time.Hour), 8),
+		testHistoryRecord(now.Add(-time.Hour), 16),
+	}
+
+	store := openTestHistory(t, path)
+	for _, record := range want {
+		if err := store.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// read back through a new store, as 'cbsummary trend' would
+	got, err := openTestHistory(t, path).Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %+v, want %+v", got, want)
+	}
+}
+
+func TestSQLiteHistoryMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.sqlite")
+	store := openTestHistory(t, path)
+
+	records, err := store.Records()
+	if err != nil || len(records) != 0 {
+		t.Errorf("got %v, %v reading a missing history, want no records", records, err)
+	}
+	pruned, err := store.Prune(RetentionPolicy{KeepRuns: 1})
+	if err != nil || pruned != 0 {
+		t.Errorf("got %d, %v pruning a missing history, want 0", pruned, err)
+	}
+}
+
+func TestSQLiteHistoryPrune(t *testing.T) {
+	now := time.Now()
+	records := []HistoryRecord{
+		testHistoryRecord(now.Add(-72*time.Hour), 2),
+		testHistoryRecord(now.Add(-48*time.Hour), 4),
+		testHistoryRecord(now.Add(-24*time.Hour), 8),
+		testHistoryRecord(now.Add(-time.Hour), 16),
+	}
+	policies := []RetentionPolicy{
+		{KeepRuns: 2},
+		{MaxAge: 36 * time.Hour},
+		{KeepRuns: 1, MaxAge: 36 * time.Hour},
+		{KeepRuns: 10},
+	}
+
+	for _, policy := range policies {
+		path := filepath.Join(t.TempDir(), "history.sqlite")
+		store := openTestHistory(t, path)
+		for _, record := range records {
+			if err := store.Append(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := policy.Apply(records, now)
+		pruned, err := store.Prune(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pruned != len(records)-len(want) {
+			t.Errorf("%+v: pruned %d runs, want %d", policy, pruned, len(records)-len(want))
+		}
+		got, err := store.Records()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: kept %+v, want %+v", policy, got, want)
+		}
+		store.Close()
+
+		// the pruned runs' clusters and nodes go with them
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var clusters, nodes int
+		err = db.QueryRow("SELECT (SELECT count(*) FROM clusters), (SELECT count(*) FROM nodes)").Scan(&clusters, &nodes)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clusters != 2*len(want) || nodes != 3*len(want) {
+			t.Errorf("%+v: %d clusters and %d nodes left, want %d and %d", policy, clusters, nodes, 2*len(want),
+				3*len(want))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// status line - while clusters are being collected at a terminal, the last
+// line of the console shows how far the collection has got, e.g.
+//
+//   Collecting clusters: 12 of 40 done (10 collected, 2 failed), 4 in progress
+//
+// Log lines written to the console meanwhile clear the status line, and it is
+// drawn again below them.
+//
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// held while writing to the console, by log lines and the status line
+var consoleMu sync.Mutex
+
+// the status line being shown, if any
+var statusLine *StatusLine
+
+type StatusLine struct {
+	text  string
+	width int
+}
+
+// start showing a status line, if the console is a terminal
+func StartStatusLine() *StatusLine {
+	fd := int(console.Fd())
+	if !term.IsTerminal(fd) {
+		return nil
+	}
+	width, _, err := term.GetSize(fd)
+	if err != nil {
+		width = 0
+	}
+
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	statusLine = &StatusLine{width: width}
+	return statusLine
+}
+
+// show the collection's progress
+func (s *StatusLine) Update(progress CollectProgress) {
+	if s == nil {
+		return
+	}
+	text := fmt.Sprintf("Collecting clusters: %d of %d done (%d collected, %d failed), %d in progress",
+		progress.Done(), progress.Total, progress.Collected, progress.Failed, progress.InFlight)
+	if s.width > 1 && len(text) >= s.width {
+		text = text[:s.width-1]
+	}
+
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	s.clear()
+	s.text = text
+	s.draw()
+}
+
+// remove the status line from the console
+func (s *StatusLine) Stop() {
+	if s == nil {
+		return
+	}
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	s.clear()
+	s.text = ""
+	statusLine = nil
+}
+
+// these are called with consoleMu held
+
+func (s *StatusLine) clear() {
+	if len(s.text) > 0 {
+		fmt.Fprint(console, "\r\033[K")
+	}
+}
+
+func (s *StatusLine) draw() {
+	if len(s.text) > 0 {
+		fmt.Fprint(console, s.text)
+	}
+}
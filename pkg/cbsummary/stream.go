@@ -0,0 +1,130 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// streaming the JSON report - with --stream, each cluster is written to the
+// report as soon as it and the clusters before it are collected, and then
+// dropped from memory, rather than the whole report being built up and
+// marshalled at the end. Peak memory then depends on the clusters in flight
+// rather than the size of the fleet, and if the run dies the file holds the
+// clusters collected so far.
+//
+// The report is the same JSON as otherwise, with the "clusters" array first
+// and the totals, which are only known at the end, after it. Anything that
+// needs the whole report at once - other formats, per-cluster reports,
+// compression, encryption, uploads, history and the like - can't be used
+// with it.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// a JSON report being written a cluster at a time
+type ReportStream struct {
+	w      io.Writer
+	closer io.Closer
+	name   string
+
+	count int
+	err   error
+}
+
+// the summary without its clusters, which have already been written: the
+// clusters field here hides the embedded one, and being nil is left out
+type summaryTail struct {
+	*SummaryInfo
+	Clusters []interface{} `json:"clusters,omitempty"`
+}
+
+// a report stream writing to a file, or standard output for "-"
+func CreateReportStream(outputFile string) (*ReportStream, error) {
+	if outputFile == OUTPUT_STDOUT {
+		return &ReportStream{w: os.Stdout, name: "standard output"}, nil
+	}
+	if dir := filepath.Dir(outputFile); dir != "." {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating directory for output file %s: %v", outputFile, err)
+		}
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating output file %s: %v", outputFile, err)
+	}
+	return &ReportStream{w: f, closer: f, name: "file " + outputFile}, nil
+}
+
+// append the next cluster to the report
+func (s *ReportStream) WriteCluster(cluster interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	body, err := json.MarshalIndent(cluster, "    ", "  ")
+	if err != nil {
+		s.err = fmt.Errorf("Error marshalling cluster %d: %v", s.count, err)
+		return s.err
+	}
+
+	prefix := ",\n    "
+	if s.count == 0 {
+		prefix = "{\n  \"clusters\": [\n    "
+	}
+	s.write(append([]byte(prefix), body...))
+	if s.err == nil {
+		s.count++
+	}
+	return s.err
+}
+
+// finish the report with the rest of the summary, once every cluster has been
+// written
+func (s *ReportStream) Close(clusterSummary *SummaryInfo) error {
+	if s.closer != nil {
+		defer s.closer.Close()
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	tail, err := json.MarshalIndent(summaryTail{SummaryInfo: clusterSummary}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling summary: %v", err)
+	}
+	if s.count == 0 {
+		s.write([]byte("{\n  \"clusters\": [],"))
+	} else {
+		s.write([]byte("\n  ],"))
+	}
+	s.write(append(tail[1:], '\n'))
+	if s.err != nil {
+		return s.err
+	}
+
+	LogInfo("Wrote information on %d clusters to %s.", s.count, s.name)
+	printReportSummary(clusterSummary)
+	return nil
+}
+
+func (s *ReportStream) write(body []byte) {
+	if s.err != nil {
+		return
+	}
+	_, err := s.w.Write(body)
+	if err != nil {
+		s.err = fmt.Errorf("Error writing report to %s: %v", s.name, err)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the report as JSON, without the times that differ from run to run
+func reportWithoutTimes(t *testing.T, summary *SummaryInfo) []byte {
+	t.Helper()
+	if summary.Metadata != nil {
+		summary.Metadata.GeneratedAt = time.Time{}
+		summary.Metadata.CollectionDuration = 0
+	}
+	body, err := FormatReport(summary, ReportOptions{Format: FORMAT_JSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestStreamMatchesReport(t *testing.T) {
+	options := CollectOptions{Full: true, LicenseModel: LICENSE_MODEL_CORES}
+	store, err := OpenRawSnapshots(filepath.FromSlash(TEST_RAW_DIR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := store.LoadClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Transport.Raw = store
+
+	file := filepath.Join(t.TempDir(), "stream.json")
+	stream, err := CreateReportStream(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := NewCollector(options).WithStream(stream).Collect(clusters)
+	if tail.Clusters[0] != nil {
+		t.Errorf("streamed summary kept the cluster")
+	}
+	if err := stream.Close(tail); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := ParseReport(body)
+	if err != nil {
+		t.Fatalf("streamed report doesn't parse: %v\n%s", err, body)
+	}
+	if streamed.NumClusters != 1 || len(streamed.Clusters) != 1 || streamed.License == nil {
+		t.Fatalf("streamed report has %d of %d clusters, license %v", len(streamed.Clusters), streamed.NumClusters,
+			streamed.License)
+	}
+
+	want := reportWithoutTimes(t, collectRaw(t, options))
+	if got := reportWithoutTimes(t, streamed); !bytes.Equal(got, want) {
+		t.Errorf("streamed report differs from the one built whole:\n%s\nwant:\n%s", got, want)
+	}
+}
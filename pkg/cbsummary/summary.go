@@ -0,0 +1,179 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// the summary report - the clusters collected, brief or full, and the
+// sections added across all of them
+//
+
+import "time"
+
+// data type for holding cluster info
+
+// count of buckets of different types
+type BucketSummary struct {
+	Emphemeral int `json:"ephemeral"`
+	Membase    int `json:"membase"`
+	Memcached  int `json:"memcached"`
+	Total      int `json:"total"`
+}
+
+// cluster settings
+type ClusterSettings struct {
+	Compaction            *CompactionSettings `json:"compaction,omitempty"`
+	EnableAutoFailover    bool                `json:"enable_auto_failover"`
+	FailoverTimeout       int                 `json:"failover_timeout"`
+	FailoverMaxCount      int                 `json:"failover_max_count"`
+	EnableAutoReprovision bool                `json:"enable_auto_reprovision"`
+	ReprovisionMaxNodes   int                 `json:"reprovision_max_nodes"`
+	IndexStorageMode      string              `json:"index_storage_mode"`
+	Errors                []string            `json:"errors,omitempty"`
+}
+
+// types for ODP reports
+type BriefCluster struct {
+	Nodes []BriefNode `json:"nodes"`
+	Size  int         `json:"cluster_size"`
+	UUID  string      `json:"cluster_uuid"`
+
+	// the nodes that are failed over or waiting to be rebalanced in, if any
+	Membership *MembershipAnomalies `json:"membership_anomalies,omitempty"`
+
+	ClusterIdentity
+	ClusterExtras
+}
+
+// how the config identifies the cluster, for matching the report up with
+// other inventories
+type ClusterIdentity struct {
+	Label       string            `json:"label,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// optional sections, collected on request for both brief and full reports
+type ClusterExtras struct {
+	QueryStats     *QueryServiceStats `json:"query_stats,omitempty"`
+	IndexUsage     *ServiceUsage      `json:"index_usage,omitempty"`
+	SearchUsage    *ServiceUsage      `json:"fts_usage,omitempty"`
+	AnalyticsUsage *ServiceUsage      `json:"analytics_usage,omitempty"`
+	EventingUsage  *ServiceUsage      `json:"eventing_usage,omitempty"`
+	EventingStats  *EventingStats     `json:"eventing_stats,omitempty"`
+	BucketStats    *BucketStats       `json:"bucket_stats,omitempty"`
+	XDCRStats      *XDCRStats         `json:"xdcr_stats,omitempty"`
+	Tasks          *ClusterTasks      `json:"tasks,omitempty"`
+	Hardware       *HardwareInventory `json:"hardware_inventory,omitempty"`
+	Security       *SecurityPosture   `json:"security,omitempty"`
+	RBACUsers      *RBACSummary       `json:"rbac_users,omitempty"`
+
+	ManagementLatency *ManagementLatency `json:"management_latency,omitempty"`
+
+	// why the optional sections were skipped, e.g. "rebalance"
+	SkippedBusy string `json:"skipped_busy,omitempty"`
+}
+
+type BriefNode struct {
+	Cores   float64 `json:"cpu_cores_available"`
+	RAM     float64 `json:"mem_total"`
+	Name    string  `json:"hostname"`
+	Version string  `json:"version"`
+
+	// for nodes in containers, the host's CPU count and memory where they
+	// differ from the limits imposed on the container (cgroup quotas)
+	HostCores  float64 `json:"host_cpu_count,omitempty"`
+	CPULimited bool    `json:"cpu_limited,omitempty"`
+	RAMLimit   float64 `json:"mem_limit,omitempty"`
+}
+
+type ClusterInfo struct {
+	AdminAuditEnabled bool            `json:"adminAuditEnabled"`
+	AdminLDAPEnabled  bool            `json:"adminLDAPEnabled"`
+	Buckets           BucketSummary   `json:"buckets"`
+	Cluster_Settings  ClusterSettings `json:"cluester_settings"`
+}
+
+type SummaryInfo struct {
+	NumClusters   int            `json:"#clusters"`
+	TotalNumNodes int            `json:"#nodes"`
+	NodeVersions  map[string]int `json:"#nodeVersions"`
+	Clusters      []interface{}  `json:"clusters"`
+
+	License          *LicenseSummary        `json:"license_summary,omitempty"`
+	ConsumptionUnits *ConsumptionUnitReport `json:"consumption_units,omitempty"`
+	CapellaSizing    *CapellaSizingReport   `json:"capella_sizing,omitempty"`
+	Drift            *DriftReport           `json:"config_drift,omitempty"`
+
+	// the failed-over and inactive nodes across all the clusters
+	NodeMembership *MembershipRollup `json:"node_membership,omitempty"`
+
+	// the totals for each environment given in the config
+	Environments *EnvironmentRollup `json:"environment_rollups,omitempty"`
+
+	// for 'cbsummary check', what the health rules found
+	Findings []Finding `json:"health_findings,omitempty"`
+
+	// for full reports, the clusters with no email alerting configured
+	NoAlerting []int `json:"clusters_without_alerting,omitempty"`
+
+	Metadata *ReportMetadata `json:"metadata,omitempty"`
+}
+
+// information about how the report was produced
+type ReportMetadata struct {
+	ToolVersion   string    `json:"tool_version,omitempty"`
+	ToolCommit    string    `json:"tool_commit,omitempty"`
+	SchemaVersion int       `json:"schema_version,omitempty"`
+	GeneratedAt   time.Time `json:"generated_at"`
+
+	// how long collecting took, and the SHA-256 of the config file collected from
+	CollectionDuration Duration `json:"collection_duration,omitempty"`
+	ConfigHash         string   `json:"config_hash,omitempty"`
+
+	MaxKnownServerVersion string   `json:"max_known_server_version"`
+	HighestServerVersion  string   `json:"highest_server_version,omitempty"`
+	Advisories            []string `json:"advisories,omitempty"`
+
+	// set if the run was interrupted, or its deadline passed, leaving some
+	// clusters uncollected
+	Interrupted bool `json:"interrupted,omitempty"`
+	TimedOut    bool `json:"timed_out,omitempty"`
+
+	// for reports merged from agent pushes, the agents included, the
+	// metadata of each agent's own report, and the sections that can't be
+	// merged and so are left out
+	Agents          []string          `json:"agents,omitempty"`
+	AgentReports    []AgentReportInfo `json:"agent_reports,omitempty"`
+	OmittedSections []string          `json:"omitted_sections,omitempty"`
+}
+
+// the metadata of an agent's report, kept in a report merged from it
+type AgentReportInfo struct {
+	Agent              string    `json:"agent"`
+	ToolVersion        string    `json:"tool_version,omitempty"`
+	ToolCommit         string    `json:"tool_commit,omitempty"`
+	GeneratedAt        time.Time `json:"generated_at"`
+	CollectionDuration Duration  `json:"collection_duration,omitempty"`
+	ConfigHash         string    `json:"config_hash,omitempty"`
+	Interrupted        bool      `json:"interrupted,omitempty"`
+	TimedOut           bool      `json:"timed_out,omitempty"`
+}
+
+type ClusterError struct {
+	TheCluster Cluster `json:"error_with_cluster"`
+	ErrMsg     string  `json:"error_message"`
+
+	// set if the run was interrupted, or its deadline passed, before the
+	// cluster could be collected
+	Interrupted bool `json:"interrupted,omitempty"`
+	TimedOut    bool `json:"timed_out,omitempty"`
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// running tasks - what a cluster is in the middle of, from
+// /pools/default/tasks, rather than just the rebalanceStatus string of
+// /pools/default:
+//
+// - rebalances and failovers that are running, with their progress (see
+//   BUSY_TASK_TYPES)
+// - bucket and view compactions that are running, with their progress
+// - XDCR replications, with their status and changes left
+//
+// A cluster is mid-operation while a rebalance, failover or compaction is
+// running; XDCR replications run all the time, so they don't count.
+//
+
+import (
+	"fmt"
+)
+
+const (
+	TASK_BUCKET_COMPACTION = "bucket_compaction"
+	TASK_VIEW_COMPACTION   = "view_compaction"
+	TASK_XDCR              = "xdcr"
+	TASK_STATUS_RUNNING    = "running"
+)
+
+type ClusterTasks struct {
+	Rebalances   []RunningTask `json:"rebalances"`
+	Compactions  []RunningTask `json:"compactions"`
+	XDCR         []RunningTask `json:"xdcr"`
+	MidOperation bool          `json:"mid_operation"`
+	Error        string        `json:"error,omitempty"`
+}
+
+type RunningTask struct {
+	Type        string  `json:"type"`
+	Subtype     string  `json:"subtype,omitempty"`
+	ID          string  `json:"id,omitempty"`
+	Bucket      string  `json:"bucket,omitempty"`
+	Target      string  `json:"target,omitempty"`
+	Status      string  `json:"status"`
+	Progress    float64 `json:"progress_pct"`
+	ChangesLeft float64 `json:"changes_left,omitempty"`
+}
+
+func CollectClusterTasks(conn *ClusterConn) *ClusterTasks {
+	summary := &ClusterTasks{
+		Rebalances:  make([]RunningTask, 0),
+		Compactions: make([]RunningTask, 0),
+		XDCR:        make([]RunningTask, 0),
+	}
+
+	tasks, err := conn.Client.GetTasks()
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+
+	for _, task := range tasks {
+		running := RunningTask{
+			Type:     task.Type,
+			Subtype:  task.Subtype,
+			Status:   task.Status,
+			Progress: task.Progress,
+		}
+		switch {
+		case task.Type == TASK_XDCR:
+			running.ID = task.ID
+			running.Bucket = task.Source
+			running.Target = task.Target
+			running.ChangesLeft = task.ChangesLeft
+			summary.XDCR = append(summary.XDCR, running)
+		case task.Status != TASK_STATUS_RUNNING:
+			// the rebalance task is always there, as "notRunning" when idle
+		case BUSY_TASK_TYPES[task.Type]:
+			summary.Rebalances = append(summary.Rebalances, running)
+			summary.MidOperation = true
+		case task.Type == TASK_BUCKET_COMPACTION || task.Type == TASK_VIEW_COMPACTION:
+			running.Bucket = task.Bucket
+			summary.Compactions = append(summary.Compactions, running)
+			summary.MidOperation = true
+		}
+	}
+	return summary
+}
+
+// what the cluster is in the middle of, e.g. "rebalance 42% done", or "" if
+// nothing
+func (t *ClusterTasks) Describe() string {
+	if t == nil {
+		return ""
+	}
+	if len(t.Rebalances) > 0 {
+		rebalance := t.Rebalances[0]
+		name := rebalance.Type
+		if len(rebalance.Subtype) > 0 {
+			name = rebalance.Subtype
+		}
+		return fmt.Sprintf("%s %.0f%% done", name, rebalance.Progress)
+	}
+	if len(t.Compactions) > 0 {
+		return fmt.Sprintf("%d compactions running", len(t.Compactions))
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// custom report layouts - the summary rendered through a Go text/template
+// given with --template
+//
+// The template is executed with the SummaryInfo as its data, so it sees the
+// same fields as the JSON report under their Go names, e.g. {{.NumClusters}}.
+// Each of the Clusters is a *BriefCluster, a *ClusterSummary or a
+// *ClusterError depending on the report and whether it could be collected;
+// the "kind" function tells which, as "brief", "full" or "error". "join"
+// joins a list of strings and "json" renders any value as indented JSON.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var TEMPLATE_FUNCS = template.FuncMap{
+	"join": strings.Join,
+	"json": func(value interface{}) (string, error) {
+		body, err := json.MarshalIndent(value, "", "  ")
+		return string(body), err
+	},
+	"kind": func(cluster interface{}) string {
+		switch cluster.(type) {
+		case *BriefCluster:
+			return "brief"
+		case *ClusterSummary:
+			return "full"
+		case *ClusterError:
+			return "error"
+		}
+		return ""
+	},
+}
+
+// read and parse a report template, so mistakes show up before collecting
+func LoadReportTemplate(file string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(file)).Funcs(TEMPLATE_FUNCS).ParseFiles(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading template %s: %v", file, err)
+	}
+	return tmpl, nil
+}
+
+// render the summary through a report template
+func FormatTemplate(clusterSummary *SummaryInfo, tmpl *template.Template) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, clusterSummary)
+	if err != nil {
+		return nil, fmt.Errorf("Error rendering template %s: %v", tmpl.Name(), err)
+	}
+	return buffer.Bytes(), nil
+}
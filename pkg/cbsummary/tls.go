@@ -0,0 +1,106 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// TLS settings for https:// cluster endpoints
+//
+// By default the cluster certificates are verified against the system's
+// trusted CAs. --cacert adds the CA certificates from a PEM file instead, for
+// clusters with certificates signed by a private CA, and --no-ssl-verify turns
+// verification off altogether, which leaves the connections open to
+// man-in-the-middle attacks.
+//
+// Clusters that require X.509 client certificate authentication for the admin
+// REST API are given a certificate and key with --client-cert/--client-key, or
+// per cluster with "client_cert"/"client_key" in the config file. Requests
+// presenting a client certificate don't send the login and password.
+//
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+type TLSOptions struct {
+	CACert     string
+	NoVerify   bool
+	ClientCert string
+	ClientKey  string
+}
+
+// read the CA certificates in a PEM file
+func LoadCACertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CA certificate %s: %v", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// the TLS config for connecting to the clusters, or nil for the defaults
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	if len(options.CACert) == 0 && !options.NoVerify && len(options.ClientCert) == 0 && len(options.ClientKey) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: options.NoVerify}
+	if len(options.CACert) > 0 {
+		pool, err := LoadCACertPool(options.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(options.ClientCert) > 0 || len(options.ClientKey) > 0 {
+		cert, err := loadClientCert(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// the TLS config for a cluster, which may have a client certificate of its own
+func ClusterTLSConfig(base *tls.Config, cluster Cluster) (*tls.Config, error) {
+	if len(cluster.ClientCert) == 0 && len(cluster.ClientKey) == 0 {
+		return base, nil
+	}
+
+	cert, err := loadClientCert(cluster.ClientCert, cluster.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{}
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	return tlsConfig, nil
+}
+
+func loadClientCert(certFile, keyFile string) (tls.Certificate, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return tls.Certificate{}, fmt.Errorf("A client certificate needs both a certificate and a key file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Error loading client certificate %s: %v", certFile, err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// a TOML decoder for connection profiles (see import.go)
+//
+// It handles the parts of TOML that profile files use: [tables] and
+// [[arrays of tables]] with dotted and quoted keys, basic and literal strings,
+// integers, booleans and arrays of these, across as many lines as they like,
+// and comments. Anything else (floats, dates, inline tables, multi-line
+// strings) is reported as an error, with its line, rather than misread.
+//
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tomlTable map[string]interface{}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// decode a TOML document into tables, []tomlTable for arrays of tables,
+// string, int64, bool and []interface{}
+func decodeTOML(src string) (tomlTable, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := tomlTable{}
+	current := root
+
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+
+		var err error
+		if p.peek() == '[' {
+			current, err = p.parseHeader(root)
+		} else {
+			err = p.parseKeyValue(current)
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", p.line, err)
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skip spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skip whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// anything after a header or value must be a comment
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if !p.eof() && p.peek() != '\n' {
+		return fmt.Errorf("unexpected '%c'", p.peek())
+	}
+	return nil
+}
+
+func (p *tomlParser) expect(s string) error {
+	if !strings.HasPrefix(p.src[p.pos:], s) {
+		return fmt.Errorf("expected '%s'", s)
+	}
+	p.pos = p.pos + len(s)
+	return nil
+}
+
+// a [table] or [[array of tables]] header, returning the table it starts
+func (p *tomlParser) parseHeader(root tomlTable) (tomlTable, error) {
+	array := strings.HasPrefix(p.src[p.pos:], "[[")
+	if array {
+		p.pos = p.pos + 2
+	} else {
+		p.pos++
+	}
+	p.skipSpace()
+	key, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	if !array {
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+		return tomlDescend(root, key)
+	}
+	if err = p.expect("]]"); err != nil {
+		return nil, err
+	}
+
+	parent, err := tomlDescend(root, key[:len(key)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := key[len(key)-1]
+	table := tomlTable{}
+	switch existing := parent[last].(type) {
+	case nil:
+		parent[last] = []tomlTable{table}
+	case []tomlTable:
+		parent[last] = append(existing, table)
+	default:
+		return nil, fmt.Errorf("'%s' is already defined", strings.Join(key, "."))
+	}
+	return table, nil
+}
+
+// the table at the dotted key, creating any missing; a key naming an array
+// of tables goes to its last table
+func tomlDescend(table tomlTable, key []string) (tomlTable, error) {
+	for i, part := range key {
+		switch next := table[part].(type) {
+		case nil:
+			created := tomlTable{}
+			table[part] = created
+			table = created
+		case tomlTable:
+			table = next
+		case []tomlTable:
+			table = next[len(next)-1]
+		default:
+			return nil, fmt.Errorf("'%s' is not a table", strings.Join(key[:i+1], "."))
+		}
+	}
+	return table, nil
+}
+
+func (p *tomlParser) parseKeyValue(table tomlTable) error {
+	key, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if err = p.expect("="); err != nil {
+		return err
+	}
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	table, err = tomlDescend(table, key[:len(key)-1])
+	if err != nil {
+		return err
+	}
+	last := key[len(key)-1]
+	if _, ok := table[last]; ok {
+		return fmt.Errorf("'%s' is already defined", strings.Join(key, "."))
+	}
+	table[last] = value
+	return nil
+}
+
+// a dotted key of bare or quoted parts, and the spaces after it
+func (p *tomlParser) parseKey() ([]string, error) {
+	key := make([]string, 0, 1)
+	for {
+		var part string
+		switch p.peek() {
+		case '"', '\'':
+			s, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("expected a key")
+			}
+			part = p.src[start:p.pos]
+		}
+		key = append(key, part)
+
+		p.skipSpace()
+		if p.peek() != '.' {
+			return key, nil
+		}
+		p.pos++
+		p.skipSpace()
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.parseString()
+	case c == '[':
+		return p.parseArray()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos = p.pos + 4
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos = p.pos + 5
+		return false, nil
+	case c == '+' || c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for !p.eof() && (p.peek() >= '0' && p.peek() <= '9' || p.peek() == '_') {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(p.src[start:p.pos], "_", ""), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported number '%s'", p.src[start:p.pos])
+		}
+		return n, nil
+	case c == '{':
+		return nil, fmt.Errorf("inline tables aren't supported")
+	default:
+		return nil, fmt.Errorf("expected a value")
+	}
+}
+
+// an array, which may run over several lines
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++
+	values := make([]interface{}, 0)
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// a "basic" string, with escapes, or a 'literal' one, without
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.src[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", fmt.Errorf("multi-line strings aren't supported")
+	}
+	p.pos++
+
+	var s strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		if c == quote {
+			return s.String(), nil
+		}
+		if c != '\\' || quote == '\'' {
+			s.WriteByte(c)
+			continue
+		}
+
+		if p.eof() {
+			return "", fmt.Errorf("unterminated string")
+		}
+		escape := p.peek()
+		p.pos++
+		switch escape {
+		case 'b':
+			s.WriteByte('\b')
+		case 't':
+			s.WriteByte('\t')
+		case 'n':
+			s.WriteByte('\n')
+		case 'f':
+			s.WriteByte('\f')
+		case 'r':
+			s.WriteByte('\r')
+		case '"', '\\':
+			s.WriteByte(escape)
+		case 'u', 'U':
+			size := 4
+			if escape == 'U' {
+				size = 8
+			}
+			if p.pos+size > len(p.src) {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			s.WriteRune(rune(code))
+			p.pos = p.pos + size
+		default:
+			return "", fmt.Errorf("invalid escape '\\%c'", escape)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// tuning of the HTTP transport used to talk to the clusters
+//
+// The defaults of net/http are fine on a LAN but stall over slow WAN links, so
+// the timeouts and connection limits can be set in the "transport" section of
+// the config file, or with command-line flags which take precedence. Zero
+// values leave the net/http defaults in place.
+//
+// The REST clients for a host share one transport for as long as the process
+// runs, so the many requests made of each node, and the runs of a daemon,
+// reuse its kept-alive connections rather than each opening a new TCP
+// connection and TLS session.
+//
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the idle connections kept open to each host, enough for the requests made
+// of a node at once
+const IDLE_CONNS_PER_HOST = 8
+
+type TransportOptions struct {
+	// the longest any one request may take, from connecting to reading the response
+	ConnectTimeout        Duration `json:"connect_timeout,omitempty"`
+	DialTimeout           Duration `json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout,omitempty"`
+	MaxConnsPerHost       int      `json:"max_conns_per_host,omitempty"`
+	DisableHTTP2          bool     `json:"disable_http2,omitempty"`
+
+	// retries of GET requests that fail transiently (see retry.go)
+	Retries      *int     `json:"retries,omitempty"`
+	RetryBackoff Duration `json:"retry_backoff,omitempty"`
+	RetryJitter  float64  `json:"retry_jitter,omitempty"`
+
+	// the most requests a second to make of any one cluster (see ratelimit.go)
+	MaxRequestsPerSecond float64 `json:"max_requests_per_second,omitempty"`
+
+	// where to record the responses to, or replay them from (see raw.go)
+	Raw *RawStore `json:"-"`
+}
+
+// a time.Duration that reads and writes JSON as a string such as "30s"
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %s", string(data))
+	}
+	val, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(val)
+	return nil
+}
+
+// combine two sets of options, with any values set in over taking precedence
+func (o TransportOptions) Merge(over TransportOptions) TransportOptions {
+	if over.ConnectTimeout > 0 {
+		o.ConnectTimeout = over.ConnectTimeout
+	}
+	if over.DialTimeout > 0 {
+		o.DialTimeout = over.DialTimeout
+	}
+	if over.TLSHandshakeTimeout > 0 {
+		o.TLSHandshakeTimeout = over.TLSHandshakeTimeout
+	}
+	if over.ResponseHeaderTimeout > 0 {
+		o.ResponseHeaderTimeout = over.ResponseHeaderTimeout
+	}
+	if over.MaxConnsPerHost > 0 {
+		o.MaxConnsPerHost = over.MaxConnsPerHost
+	}
+	if over.DisableHTTP2 {
+		o.DisableHTTP2 = true
+	}
+	if over.Retries != nil {
+		o.Retries = over.Retries
+	}
+	if over.RetryBackoff > 0 {
+		o.RetryBackoff = over.RetryBackoff
+	}
+	if over.RetryJitter > 0 {
+		o.RetryJitter = over.RetryJitter
+	}
+	if over.MaxRequestsPerSecond > 0 {
+		o.MaxRequestsPerSecond = over.MaxRequestsPerSecond
+	}
+	if over.Raw != nil {
+		o.Raw = over.Raw
+	}
+	return o
+}
+
+func NewTransport(tlsConfig *tls.Config, options TransportOptions) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+
+	// a custom TLS config turns off HTTP/2 unless we ask for it explicitly
+	tr.ForceAttemptHTTP2 = !options.DisableHTTP2
+	if options.DisableHTTP2 {
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if options.DialTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   time.Duration(options.DialTimeout),
+			KeepAlive: 30 * time.Second,
+		}
+		tr.DialContext = dialer.DialContext
+	}
+	if options.TLSHandshakeTimeout > 0 {
+		tr.TLSHandshakeTimeout = time.Duration(options.TLSHandshakeTimeout)
+	}
+	if options.ResponseHeaderTimeout > 0 {
+		tr.ResponseHeaderTimeout = time.Duration(options.ResponseHeaderTimeout)
+	}
+	tr.MaxIdleConnsPerHost = IDLE_CONNS_PER_HOST
+	if options.MaxConnsPerHost > 0 {
+		tr.MaxConnsPerHost = options.MaxConnsPerHost
+		if options.MaxConnsPerHost < IDLE_CONNS_PER_HOST {
+			tr.MaxIdleConnsPerHost = options.MaxConnsPerHost
+		}
+	}
+
+	return tr
+}
+
+// the transports shared by the REST clients, by host and settings
+var sharedTransports = struct {
+	sync.Mutex
+	byKey map[string]*http.Transport
+}{byKey: make(map[string]*http.Transport)}
+
+// the transport for requests to a host with these settings, shared with every
+// other client for the same host and settings so connections are reused
+func SharedTransport(host string, tlsConfig *tls.Config, options TransportOptions) *http.Transport {
+	key := fmt.Sprintf("%s|%s|%v/%v/%v/%d/%t", strings.ToLower(host), tlsConfigKey(tlsConfig),
+		options.DialTimeout, options.TLSHandshakeTimeout, options.ResponseHeaderTimeout,
+		options.MaxConnsPerHost, options.DisableHTTP2)
+
+	sharedTransports.Lock()
+	defer sharedTransports.Unlock()
+	tr, ok := sharedTransports.byKey[key]
+	if !ok {
+		tr = NewTransport(tlsConfig, options)
+		sharedTransports.byKey[key] = tr
+	}
+	return tr
+}
+
+// what distinguishes one TLS config from another, given that per-cluster
+// configs are rebuilt for each run with the same CAs and client certificate
+func tlsConfigKey(tlsConfig *tls.Config) string {
+	if tlsConfig == nil {
+		return ""
+	}
+	hash := sha256.New()
+	for _, cert := range tlsConfig.Certificates {
+		for _, der := range cert.Certificate {
+			hash.Write(der)
+		}
+	}
+	return fmt.Sprintf("%p/%t/%x", tlsConfig.RootCAs, tlsConfig.InsecureSkipVerify, hash.Sum(nil)[:8])
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// checking a config file - 'cbsummary validate --config=<file>' reports what
+// is wrong with a config without collecting anything: syntax errors, keys
+// cbsummary doesn't know, clusters without nodes or credentials, nodes that
+// aren't URLs or connection strings, and nodes or labels given twice.
+//
+// With --probe it then makes one authenticated request of each node,
+// GET /pools/default, to show the nodes can be reached and the credentials
+// are accepted.
+//
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// something wrong with a config file
+type ConfigProblem struct {
+	// the cluster it concerns, counting from 1, or 0 for the file as a whole
+	Cluster int
+
+	// set if cbsummary can still work with the config
+	Warning bool
+
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Cluster > 0 {
+		return fmt.Sprintf("%s: cluster %d: %s", level, p.Cluster, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", level, p.Message)
+}
+
+// whether any of the problems make the config unusable
+func HasConfigErrors(problems []ConfigProblem) bool {
+	for _, p := range problems {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// check a config file, returning what's wrong with it and the clusters as
+// they would be loaded, or nil if the config can't be used
+func ValidateConfig(configFile string, options ConfigOptions) (*ClusterList, []ConfigProblem) {
+	problems := make([]ConfigProblem, 0)
+	fail := func(format string, args ...interface{}) (*ClusterList, []ConfigProblem) {
+		return nil, append(problems, ConfigProblem{Message: fmt.Sprintf(format, args...)})
+	}
+
+	format, err := ConfigFormat(configFile, options.Format)
+	if err != nil {
+		return fail("%v", err)
+	}
+	config, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return fail("Error reading configuration file %s: %v", configFile, err)
+	}
+	if IsEncryptedConfig(config) {
+		config, err = DecryptConfig(config, options.Key)
+		if err != nil {
+			return fail("Error decrypting configuration file %s: %v", configFile, err)
+		}
+	}
+
+	if format == CONFIG_FORMAT_YAML {
+		config, err = yamlToJSON(config)
+		if err != nil {
+			return fail("YAML syntax error: %v", err)
+		}
+	}
+	clusters := new(ClusterList)
+	err = json.Unmarshal(config, clusters)
+	if err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			line, col := offsetPosition(config, syntax.Offset)
+			return fail("JSON syntax error at line %d, column %d: %v", line, col, err)
+		}
+		return fail("%v", err)
+	}
+
+	// anything the loose parse above ignored is most likely a misspelling
+	strict := json.NewDecoder(bytes.NewReader(config))
+	strict.DisallowUnknownFields()
+	if err := strict.Decode(new(ClusterList)); err != nil {
+		problems = append(problems, ConfigProblem{Warning: true, Message: fmt.Sprintf("%v, which is ignored", err)})
+	}
+
+	if len(clusters.Clusters) == 0 && len(clusters.Capella) == 0 {
+		problems = append(problems, ConfigProblem{Message: "no clusters are configured"})
+	}
+	if clusters.Transport != nil {
+		if _, err := NewRetryPolicy(*clusters.Transport); err != nil {
+			problems = append(problems, ConfigProblem{Message: fmt.Sprintf("transport: %v", err)})
+		}
+	}
+
+	nodeCluster := make(map[string]int)
+	labelCluster := make(map[string]int)
+	for i := range clusters.Clusters {
+		cnum := i + 1
+		cluster := &clusters.Clusters[i]
+		problem := func(warning bool, format string, args ...interface{}) {
+			problems = append(problems, ConfigProblem{Cluster: cnum, Warning: warning, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if len(cluster.Nodes) == 0 {
+			problem(false, "no nodes")
+		}
+		nodes := make([]string, 0, len(cluster.Nodes))
+		for _, node := range cluster.Nodes {
+			if isConnectionString(node) {
+				resolved, err := ConnectionStringNodes(node)
+				if err != nil {
+					problem(false, "%v", err)
+					continue
+				}
+				nodes = append(nodes, resolved...)
+				continue
+			}
+			msg, warning := checkNodeURL(node)
+			if len(msg) > 0 {
+				problem(warning, "%s", msg)
+				if !warning {
+					continue
+				}
+			}
+			nodes = append(nodes, node)
+		}
+		for _, node := range nodes {
+			key := strings.ToLower(strings.TrimSuffix(node, "/"))
+			if other, ok := nodeCluster[key]; ok && other == cnum {
+				problem(true, "node %s is listed more than once", node)
+			} else if ok {
+				problem(false, "node %s is also in cluster %d", node, other)
+			} else {
+				nodeCluster[key] = cnum
+			}
+		}
+		cluster.Nodes = nodes
+
+		if len(cluster.Label) > 0 {
+			if other, ok := labelCluster[cluster.Label]; ok {
+				problem(true, "label '%s' is also used by cluster %d", cluster.Label, other)
+			} else {
+				labelCluster[cluster.Label] = cnum
+			}
+		}
+
+		certAuth := len(cluster.ClientCert) > 0 || len(cluster.ClientKey) > 0
+		if certAuth {
+			if _, err := loadClientCert(cluster.ClientCert, cluster.ClientKey); err != nil {
+				problem(false, "%v", err)
+			}
+		} else {
+			if len(cluster.Login) == 0 {
+				problem(false, "no login")
+			}
+			if len(cluster.Pass) == 0 && !cluster.Prompt {
+				problem(true, "no password, and no \"prompt\": true; it must come from --password-from-stdin")
+			}
+		}
+	}
+
+	for i, org := range clusters.Capella {
+		if len(org.OrganizationID) == 0 {
+			problems = append(problems, ConfigProblem{Message: fmt.Sprintf("Capella organization %d has no organization_id", i+1)})
+		}
+		if len(org.APISecret) == 0 {
+			problems = append(problems, ConfigProblem{Message: fmt.Sprintf("Capella organization %d has no api_secret", i+1)})
+		}
+	}
+
+	if err := expandCredentials(clusters); err != nil {
+		problems = append(problems, ConfigProblem{Message: err.Error()})
+	}
+
+	if HasConfigErrors(problems) {
+		return nil, problems
+	}
+	return clusters, problems
+}
+
+// what's wrong with a node's URL, if anything, and whether it's only a warning
+func checkNodeURL(node string) (string, bool) {
+	u, err := url.Parse(node)
+	if err != nil {
+		return fmt.Sprintf("node '%s' isn't a valid URL: %v", node, err), false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Sprintf("node '%s' isn't an http:// or https:// URL or a couchbase:// connection string", node), false
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Sprintf("node '%s' has no host", node), false
+	}
+	if len(u.Path) > 0 && u.Path != "/" || len(u.RawQuery) > 0 {
+		return fmt.Sprintf("node '%s' should be just the scheme, host and port", node), false
+	}
+	if len(u.Port()) == 0 {
+		port := MGMT_PORT
+		if u.Scheme == "https" {
+			port = MGMT_SSL_PORT
+		}
+		return fmt.Sprintf("node '%s' has no port; the admin port is usually %s", node, port), true
+	}
+	return "", false
+}
+
+// the line and column of a byte offset in a file, counting from 1
+func offsetPosition(body []byte, offset int64) (int, int) {
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	before := body[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// the outcome of probing one node
+type NodeProbe struct {
+	Cluster int
+	Node    string
+	Elapsed time.Duration
+	Err     error
+}
+
+// make an authenticated request of every node of every cluster, at once,
+// without collecting anything from them
+func ProbeClusters(ctx context.Context, clusters *ClusterList, tlsConfig *tls.Config, transport TransportOptions) []NodeProbe {
+	probes := make([]NodeProbe, 0)
+	clients := make([]*RestClient, 0)
+	for i, cluster := range clusters.Clusters {
+		clusterTLS, err := ClusterTLSConfig(tlsConfig, cluster)
+		for _, node := range cluster.Nodes {
+			probes = append(probes, NodeProbe{Cluster: i + 1, Node: node, Err: err})
+			var client *RestClient
+			if err == nil {
+				client = CreateRestClient(node, cluster.Login, cluster.Pass, clusterTLS, transport).WithContext(ctx)
+				client.SetHeaders(cluster.Headers)
+			}
+			clients = append(clients, client)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range probes {
+		if clients[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(probe *NodeProbe, client *RestClient) {
+			defer wg.Done()
+			start := time.Now()
+			probe.Err = client.checkAuth()
+			probe.Elapsed = time.Since(start)
+		}(&probes[i], clients[i])
+	}
+	wg.Wait()
+	return probes
+}
+
+// GET /pools/default, which needs the credentials to be accepted, discarding
+// the answer
+func (r *RestClient) checkAuth() error {
+	resp, err := r.executeGet(r.host + "/pools/default")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return err
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// HashiCorp Vault - credentials read from Vault when the config is loaded, so
+// they never live in the config file
+//
+// A "login", "pass" or Capella "api_secret" given as "vault:<path>#<field>",
+// e.g. "vault:secret/couchbase/prod#password", is the field of the secret at
+// that path. KV version 1 and 2 mounts are both read; for version 2 the path
+// is given as the CLI takes it, without the "data/".
+//
+// Vault is reached as given in the "vault" section of the config file, e.g.
+//
+//   "vault": {"address": "https://vault.example.com:8200",
+//             "role_id": "...", "secret_id": "$VAULT_SECRET_ID"}
+//
+// with anything left out taken from the environment as the Vault CLI does:
+//
+//   address     VAULT_ADDR
+//   token       VAULT_TOKEN
+//   namespace   VAULT_NAMESPACE (Vault Enterprise)
+//   cacert      VAULT_CACERT
+//   role_id     VAULT_ROLE_ID
+//   secret_id   VAULT_SECRET_ID
+//
+// A token is used as it is; otherwise a role_id and secret_id log in with
+// AppRole, at auth/approle or the "approle_mount" given. Each secret is read
+// once per config load however many credentials refer to it.
+//
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const VAULT_PREFIX = "vault:"
+
+const VAULT_DEFAULT_APPROLE_MOUNT = "approle"
+
+type VaultOptions struct {
+	Address      string `json:"address,omitempty"`
+	Token        string `json:"token,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	CACert       string `json:"cacert,omitempty"`
+	RoleID       string `json:"role_id,omitempty"`
+	SecretID     string `json:"secret_id,omitempty"`
+	AppRoleMount string `json:"approle_mount,omitempty"`
+}
+
+// reads secrets from Vault, logging in on first use
+type VaultReader struct {
+	options VaultOptions
+	client  *http.Client
+	token   string
+
+	// the secrets already read, by path
+	secrets map[string]map[string]interface{}
+}
+
+// the response to every Vault API request
+type vaultResponse struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// a reader for the Vault given in the config, if any, and the environment
+func NewVaultReader(config *VaultOptions) *VaultReader {
+	options := VaultOptions{}
+	if config != nil {
+		options = *config
+	}
+	fromEnv := func(value *string, name string) {
+		if len(*value) == 0 {
+			*value = os.Getenv(name)
+		}
+	}
+	fromEnv(&options.Address, "VAULT_ADDR")
+	fromEnv(&options.Token, "VAULT_TOKEN")
+	fromEnv(&options.Namespace, "VAULT_NAMESPACE")
+	fromEnv(&options.CACert, "VAULT_CACERT")
+	fromEnv(&options.RoleID, "VAULT_ROLE_ID")
+	fromEnv(&options.SecretID, "VAULT_SECRET_ID")
+	if len(options.AppRoleMount) == 0 {
+		options.AppRoleMount = VAULT_DEFAULT_APPROLE_MOUNT
+	}
+	return &VaultReader{options: options, secrets: make(map[string]map[string]interface{})}
+}
+
+func isVaultReference(value string) bool {
+	return strings.HasPrefix(value, VAULT_PREFIX)
+}
+
+// the value of a "vault:<path>#<field>" reference
+func (v *VaultReader) Read(ref string) (string, error) {
+	path, field, ok := strings.Cut(strings.TrimPrefix(ref, VAULT_PREFIX), "#")
+	path = strings.Trim(path, "/")
+	if !ok || len(path) == 0 || len(field) == 0 {
+		return "", fmt.Errorf("Vault reference '%s' should be vault:<path>#<field>", ref)
+	}
+
+	secret, ok := v.secrets[path]
+	if !ok {
+		var err error
+		secret, err = v.readSecret(path)
+		if err != nil {
+			return "", fmt.Errorf("Error reading %s from Vault: %v", path, err)
+		}
+		v.secrets[path] = secret
+	}
+
+	value, ok := secret[field]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no field %s", path, field)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s field %s isn't a string", path, field)
+	}
+	return s, nil
+}
+
+// the fields of the secret at a path, from a KV version 1 or 2 mount
+func (v *VaultReader) readSecret(path string) (map[string]interface{}, error) {
+	err := v.login()
+	if err != nil {
+		return nil, err
+	}
+
+	// which mount the path is in, and its KV version; if the token can't see
+	// that, take it to be version 1
+	var mount struct {
+		Path    string `json:"path"`
+		Options struct {
+			Version string `json:"version"`
+		} `json:"options"`
+	}
+	apiPath := path
+	resp, err := v.request("GET", "sys/internal/ui/mounts/"+path, nil)
+	kv2 := err == nil && json.Unmarshal(resp.Data, &mount) == nil && mount.Options.Version == "2"
+	if kv2 {
+		mountPath := strings.Trim(mount.Path, "/")
+		rest := strings.TrimPrefix(strings.TrimPrefix(path, mountPath), "/")
+		if !strings.HasPrefix(rest, "data/") {
+			apiPath = mountPath + "/data/" + rest
+		}
+	}
+
+	resp, err = v.request("GET", apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]interface{}
+	if kv2 {
+		var versioned struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		err = json.Unmarshal(resp.Data, &versioned)
+		data = versioned.Data
+	} else {
+		err = json.Unmarshal(resp.Data, &data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected response: %v", err)
+	}
+	return data, nil
+}
+
+// get a token, from the options or by logging in with AppRole
+func (v *VaultReader) login() error {
+	if len(v.options.Address) == 0 {
+		return fmt.Errorf("no Vault address; set VAULT_ADDR or give the \"vault\" address in the config")
+	}
+	if v.client == nil {
+		tlsConfig := &tls.Config{}
+		if len(v.options.CACert) > 0 {
+			pool, err := LoadCACertPool(v.options.CACert)
+			if err != nil {
+				return err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		v.client = &http.Client{Transport: NewTransport(tlsConfig, TransportOptions{}), Timeout: time.Minute}
+	}
+	if len(v.token) > 0 {
+		return nil
+	}
+	if len(v.options.Token) > 0 {
+		v.token = v.options.Token
+		return nil
+	}
+	if len(v.options.RoleID) == 0 || len(v.options.SecretID) == 0 {
+		return fmt.Errorf("no Vault token, or role_id and secret_id for AppRole")
+	}
+
+	body, err := json.Marshal(map[string]string{"role_id": v.options.RoleID, "secret_id": v.options.SecretID})
+	if err != nil {
+		return err
+	}
+	resp, err := v.request("POST", "auth/"+strings.Trim(v.options.AppRoleMount, "/")+"/login", body)
+	if err != nil {
+		return fmt.Errorf("AppRole login failed: %v", err)
+	}
+	if resp.Auth == nil || len(resp.Auth.ClientToken) == 0 {
+		return fmt.Errorf("AppRole login gave no token")
+	}
+	v.token = resp.Auth.ClientToken
+	return nil
+}
+
+func (v *VaultReader) request(method, path string, body []byte) (*vaultResponse, error) {
+	url := strings.TrimSuffix(v.options.Address, "/") + "/v1/" + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &RestClientError{method, url, err}
+	}
+	if len(v.token) > 0 {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if len(v.options.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", v.options.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, &RestClientError{method, url, err}
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &RestClientError{method, url, err}
+	}
+
+	var data vaultResponse
+	jsonErr := json.Unmarshal(contents, &data)
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(contents))
+		if jsonErr == nil && len(data.Errors) > 0 {
+			msg = strings.Join(data.Errors, "; ")
+		}
+		if len(msg) == 0 {
+			msg = resp.Status
+		}
+		return nil, HttpError{resp.StatusCode, method, url, msg}
+	}
+	if jsonErr != nil {
+		return nil, &RestClientError{method, url, jsonErr}
+	}
+	return &data, nil
+}
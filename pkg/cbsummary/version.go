@@ -0,0 +1,134 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// helpers for comparing Couchbase Server versions, and the advisory issued when
+// a cluster is newer than this tool knows about. Also the version of cbsummary
+// itself, recorded in the metadata of every report.
+//
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the version of the layout of the report, bumped whenever a field is renamed
+// or removed, or changes meaning, so consumers can tell what they're reading
+const REPORT_SCHEMA_VERSION = 1
+
+// the build of the tool producing reports, as set with SetToolVersion
+var toolVersion = "dev"
+var toolCommit = "unknown"
+
+// record the version and commit of the tool producing reports, for their metadata
+func SetToolVersion(version, commit string) {
+	if len(version) > 0 {
+		toolVersion = version
+	}
+	if len(commit) > 0 {
+		toolCommit = commit
+	}
+}
+
+// the tool version, e.g. "7.6.0 (abc1234)"
+func ToolVersion() string {
+	return fmt.Sprintf("%s (%s)", toolVersion, toolCommit)
+}
+
+// the metadata every report starts with, generated now
+func newReportMetadata() *ReportMetadata {
+	return &ReportMetadata{
+		ToolVersion:           toolVersion,
+		ToolCommit:            toolCommit,
+		SchemaVersion:         REPORT_SCHEMA_VERSION,
+		GeneratedAt:           time.Now().UTC().Truncate(time.Second),
+		MaxKnownServerVersion: MAX_KNOWN_SERVER_VERSION,
+	}
+}
+
+// the newest Couchbase Server release whose REST API this tool has been checked
+// against. Update this whenever the parsed types are brought up to date.
+const MAX_KNOWN_SERVER_VERSION = "7.6"
+
+// parse the numeric part of a version string such as "7.2.4-7070-enterprise"
+// into its components, e.g. [7 2 4]. Non-numeric components end the parse.
+func ParseVersion(version string) []int {
+	if idx := strings.IndexAny(version, "-_ "); idx >= 0 {
+		version = version[:idx]
+	}
+
+	parts := make([]int, 0, 3)
+	for _, field := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// compare two version strings, returning -1, 0 or 1. Missing components count
+// as zero, so "7.2" == "7.2.0".
+func CompareVersions(a, b string) int {
+	va := ParseVersion(a)
+	vb := ParseVersion(b)
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// the highest version in a set of versions, or "" if there are none
+func HighestVersion(versions map[string]int) string {
+	highest := ""
+	for version := range versions {
+		if highest == "" || CompareVersions(version, highest) > 0 {
+			highest = version
+		}
+	}
+	return highest
+}
+
+// return an advisory message if the given version is newer than this tool
+// knows about. Only the major and minor versions are considered, since
+// maintenance releases don't change the REST API.
+func VersionAdvisory(version string) string {
+	parts := ParseVersion(version)
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	majorMinor := make([]string, len(parts))
+	for i, n := range parts {
+		majorMinor[i] = strconv.Itoa(n)
+	}
+
+	if CompareVersions(strings.Join(majorMinor, "."), MAX_KNOWN_SERVER_VERSION) <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("Cluster version %s is newer than the latest version known to this tool (%s); "+
+		"some fields may be missing or misparsed, consider upgrading cbsummary.", version, MAX_KNOWN_SERVER_VERSION)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// webhook delivery - after each run the JSON report is POSTed to --post-url,
+// for inventory and CMDB services to pick up
+//
+// Headers such as auth tokens are given with --post-header='Name: value',
+// which can be repeated, or kept out of the command line in a
+// --post-headers=<file> with one 'Name: value' per line.
+//
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type PostOptions struct {
+	URL     string
+	Headers http.Header
+	CACert  string
+}
+
+// the headers to send, from 'Name: value' flags and lines of the headers file
+func ParsePostHeaders(headers []string, headersFile string) (http.Header, error) {
+	parsed := make(http.Header)
+	add := func(header string) error {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || len(name) == 0 {
+			return fmt.Errorf("Invalid header '%s', it should be 'Name: value'", header)
+		}
+		parsed.Add(name, strings.TrimSpace(value))
+		return nil
+	}
+
+	if len(headersFile) > 0 {
+		file, err := os.Open(headersFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading headers file %s: %v", headersFile, err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			// don't echo the line, it may well hold a secret
+			if err := add(line); err != nil {
+				return nil, fmt.Errorf("Invalid line in headers file %s, it should be 'Name: value'", headersFile)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("Error reading headers file %s: %v", headersFile, err)
+		}
+	}
+
+	for _, header := range headers {
+		if err := add(header); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// POST the JSON report to the webhook
+func PostReport(options *PostOptions, clusterSummary *SummaryInfo) error {
+	body, err := FormatReport(clusterSummary, ReportOptions{Format: FORMAT_JSON})
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(options.CACert) > 0 {
+		tlsConfig.RootCAs, err = LoadCACertPool(options.CACert)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("POST", options.URL, bytes.NewReader(body))
+	if err != nil {
+		return &RestClientError{"POST", options.URL, err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range options.Headers {
+		req.Header[name] = values
+	}
+
+	client := http.Client{
+		Transport: NewTransport(tlsConfig, TransportOptions{}),
+		Timeout:   5 * time.Minute,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &RestClientError{"POST", options.URL, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		if len(bytes.TrimSpace(msg)) == 0 {
+			msg = []byte(resp.Status)
+		}
+		return HttpError{resp.StatusCode, "POST", options.URL, strings.TrimSpace(string(msg))}
+	}
+
+	LogInfo("Posted report of %d clusters to %s.", clusterSummary.NumClusters, options.URL)
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// XDCR replication statistics
+//
+// The replications and their status, backlog (changes left) and errors come
+// from /pools/default/tasks. The bandwidth in use is a per-replication stat
+// of the source bucket, summed over the nodes. Replications with errors or
+// that aren't running are flagged as broken, and those with more changes left
+// than the lag threshold as lagging.
+//
+// The full report also has the XDCR topology: the remote cluster references
+// from /pools/default/remoteClusters, and the replication definitions from
+// /pools/default/replications with their filter expressions and whether
+// they're paused.
+//
+
+import (
+	"fmt"
+)
+
+// the default backlog above which a replication is reported as lagging
+const DEFAULT_XDCR_LAG_THRESHOLD = 100000
+
+type XDCRStats struct {
+	Replications []XDCRReplicationStats `json:"replications"`
+	Lagging      int                    `json:"lagging"`
+	Broken       int                    `json:"broken"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+type XDCRReplicationStats struct {
+	ID             string  `json:"id"`
+	Source         string  `json:"source_bucket"`
+	Target         string  `json:"target"`
+	Status         string  `json:"status"`
+	ChangesLeft    float64 `json:"changes_left"`
+	BandwidthUsage float64 `json:"bandwidth_usage"`
+	ErrorCount     int     `json:"error_count"`
+	LastError      string  `json:"last_error,omitempty"`
+	Lagging        bool    `json:"lagging,omitempty"`
+	Broken         bool    `json:"broken,omitempty"`
+}
+
+func CollectXDCRStats(conn *ClusterConn, lagThreshold float64) *XDCRStats {
+	stats := &XDCRStats{Replications: make([]XDCRReplicationStats, 0)}
+
+	tasks, err := conn.Client.GetTasks()
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+
+	for _, task := range tasks {
+		if task.Type != "xdcr" {
+			continue
+		}
+
+		replication := XDCRReplicationStats{
+			ID:          task.ID,
+			Source:      task.Source,
+			Target:      task.Target,
+			Status:      task.Status,
+			ChangesLeft: task.ChangesLeft,
+			ErrorCount:  len(task.Errors),
+		}
+		if len(task.Errors) > 0 {
+			replication.LastError = fmt.Sprint(task.Errors[len(task.Errors)-1])
+		}
+
+		bandwidth, err := conn.Client.GetReplicationStat(task.Source, task.ID, "bandwidth_usage")
+		if err != nil {
+			LogError("Error getting bandwidth for replication %s: %v", task.ID, err)
+		} else {
+			replication.BandwidthUsage = bandwidth
+		}
+
+		replication.Broken = replication.ErrorCount > 0 || (task.Status != "running" && task.Status != "paused")
+		replication.Lagging = task.Status == "running" && replication.ChangesLeft > lagThreshold
+		if replication.Broken {
+			stats.Broken++
+		}
+		if replication.Lagging {
+			stats.Lagging++
+		}
+
+		stats.Replications = append(stats.Replications, replication)
+	}
+
+	return stats
+}
+
+// the XDCR topology for the full report: the remote clusters the cluster
+// replicates to, and each replication's buckets, filter and state
+type XDCRTopology struct {
+	RemoteClusters []XDCRRemoteCluster `json:"remote_clusters"`
+	Replications   []XDCRReplication   `json:"replications"`
+	Error          string              `json:"error,omitempty"`
+}
+
+type XDCRRemoteCluster struct {
+	Name         string `json:"name"`
+	UUID         string `json:"uuid"`
+	Hostname     string `json:"hostname"`
+	Encryption   string `json:"encryption,omitempty"`
+	Connectivity string `json:"connectivity,omitempty"`
+}
+
+type XDCRReplication struct {
+	ID           string `json:"id"`
+	SourceBucket string `json:"source_bucket"`
+	TargetName   string `json:"target_cluster"`
+	TargetBucket string `json:"target_bucket"`
+	Filter       string `json:"filter_expression,omitempty"`
+	State        string `json:"state"`
+}
+
+func CollectXDCRTopology(conn *ClusterConn) *XDCRTopology {
+	topology := &XDCRTopology{
+		RemoteClusters: make([]XDCRRemoteCluster, 0),
+		Replications:   make([]XDCRReplication, 0),
+	}
+
+	remotes, err := conn.Client.GetRemoteClusters()
+	if err != nil {
+		topology.Error = err.Error()
+		return topology
+	}
+	names := make(map[string]string)
+	for _, remote := range remotes {
+		if remote.Deleted {
+			continue
+		}
+		names[remote.UUID] = remote.Name
+		topology.RemoteClusters = append(topology.RemoteClusters, XDCRRemoteCluster{
+			Name:         remote.Name,
+			UUID:         remote.UUID,
+			Hostname:     remote.Hostname,
+			Encryption:   remote.SecureType,
+			Connectivity: remote.ConnectivityStatus,
+		})
+	}
+
+	replications, err := conn.Client.GetReplications()
+	if err != nil {
+		topology.Error = err.Error()
+		return topology
+	}
+	for _, spec := range replications {
+		state := "running"
+		if spec.Settings.PauseRequested || spec.PauseRequested {
+			state = "paused"
+		}
+		filter := spec.FilterExpression
+		if len(filter) == 0 {
+			filter = spec.Settings.FilterExpression
+		}
+		target := names[spec.TargetClusterUUID]
+		if len(target) == 0 {
+			target = spec.TargetClusterUUID
+		}
+		topology.Replications = append(topology.Replications, XDCRReplication{
+			ID:           spec.ID,
+			SourceBucket: spec.SourceBucketName,
+			TargetName:   target,
+			TargetBucket: spec.TargetBucketName,
+			Filter:       filter,
+			State:        state,
+		})
+	}
+	return topology
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package cbsummary
+
+//
+// XLSX report - a spreadsheet for license and capacity reviews, with a fleet
+// summary sheet and a sheet for each cluster listing its nodes
+//
+// The workbook is written directly as the zipped Office Open XML parts, with
+// the strings inline in the cells, so there's nothing to install. Numbers are
+// written as numbers, so the sheets can be summed and charted as they are.
+//
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// a sheet of the workbook; each cell is a string or a float64
+type xlsxSheet struct {
+	Name string
+	Rows [][]interface{}
+}
+
+var XLSX_FLEET_HEADER = []interface{}{"cluster_num", "label", "cluster_name", "cluster_uuid", "nodes", "cpu_cores",
+	"RAM", "error"}
+var XLSX_NODE_HEADER = []interface{}{"hostname", "version", "cpu_cores", "RAM", "services", "status"}
+
+// render the summary as an XLSX workbook
+func FormatXLSX(clusterSummary *SummaryInfo) ([]byte, error) {
+	fleet := xlsxSheet{Name: "Fleet summary", Rows: [][]interface{}{XLSX_FLEET_HEADER}}
+	sheets := make([]xlsxSheet, 0, len(clusterSummary.Clusters)+1)
+	var totalNodes, totalCores, totalRAM float64
+
+	for cnum, icluster := range clusterSummary.Clusters {
+		var label, name, uuid, errMsg string
+		nodes := [][]interface{}{XLSX_NODE_HEADER}
+		var cores, ram float64
+
+		switch c := icluster.(type) {
+		case *BriefCluster:
+			label, uuid = c.Label, c.UUID
+			for _, node := range c.Nodes {
+				nodes = append(nodes, []interface{}{node.Name, node.Version, xlsxCores(node.Cores), node.RAM, "", ""})
+				cores = cores + node.Cores
+				ram = ram + node.RAM
+			}
+		case *ClusterSummary:
+			label, name, uuid = c.Label, c.ClusterName, c.Uuid
+			for _, node := range c.Nodes {
+				nodeRAM := node.MemoryTotal / 1024.0 / 1024.0 / 1024.0
+				nodes = append(nodes, []interface{}{node.Hostname, node.Version,
+					xlsxCores(node.SystemStats.CPU_cores_available), nodeRAM, strings.Join(node.Services, ", "),
+					node.Status})
+				cores = cores + node.SystemStats.CPU_cores_available
+				ram = ram + nodeRAM
+			}
+		case *ClusterError:
+			label, errMsg = c.TheCluster.Label, c.ErrMsg
+		default:
+			errMsg = "no information collected"
+		}
+
+		count := float64(len(nodes) - 1)
+		fleet.Rows = append(fleet.Rows, []interface{}{float64(cnum), label, name, uuid, count, cores, ram, errMsg})
+		totalNodes = totalNodes + count
+		totalCores = totalCores + cores
+		totalRAM = totalRAM + ram
+
+		if len(errMsg) == 0 {
+			sheets = append(sheets, xlsxSheet{Name: xlsxSheetName(cnum, label), Rows: nodes})
+		}
+	}
+	fleet.Rows = append(fleet.Rows, []interface{}{"total", "", "", "", totalNodes, totalCores, totalRAM, ""})
+
+	return writeXLSX(append([]xlsxSheet{fleet}, sheets...))
+}
+
+// no cores info for earlier than 6.5, so leave the cell empty
+func xlsxCores(cores float64) interface{} {
+	if cores <= 0 {
+		return ""
+	}
+	return cores
+}
+
+// a sheet name for a cluster: at most 31 characters, none of them []:*?/\
+func xlsxSheetName(cnum int, label string) string {
+	name := fmt.Sprintf("Cluster %d", cnum)
+	if len(label) > 0 {
+		name = fmt.Sprintf("%d %s", cnum, label)
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// Excel limits names to 31 characters, not bytes
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	return name
+}
+
+func writeXLSX(sheets []xlsxSheet) ([]byte, error) {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	var contentTypes, workbook, workbookRels strings.Builder
+	worksheets := make([]string, 0, len(sheets))
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	workbookRels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.Name), n, n)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`, n, n)
+		worksheets = append(worksheets, xlsxWorksheet(sheet))
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	workbookRels.WriteString(`</Relationships>`)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" ` +
+			`Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", workbookRels.String()},
+	}
+	for i, worksheet := range worksheets {
+		parts = append(parts, struct{ name, body string }{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), worksheet})
+	}
+	for _, part := range parts {
+		err := writeZipPart(archive, part.name, part.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Error writing XLSX report: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func xlsxWorksheet(sheet xlsxSheet) string {
+	var body strings.Builder
+	body.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for _, row := range sheet.Rows {
+		body.WriteString(`<row>`)
+		for _, cell := range row {
+			switch v := cell.(type) {
+			case string:
+				if len(v) == 0 {
+					body.WriteString(`<c/>`)
+				} else {
+					fmt.Fprintf(&body, `<c t="inlineStr"><is><t>%s</t></is></c>`, xmlEscape(v))
+				}
+			case float64:
+				fmt.Fprintf(&body, `<c><v>%g</v></c>`, v)
+			default:
+				fmt.Fprintf(&body, `<c t="inlineStr"><is><t>%s</t></is></c>`, xmlEscape(fmt.Sprint(v)))
+			}
+		}
+		body.WriteString(`</row>`)
+	}
+	body.WriteString(`</sheetData></worksheet>`)
+	return body.String()
+}
+
+func writeZipPart(archive *zip.Writer, name, body string) error {
+	part, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("Error writing XLSX report: %v", err)
+	}
+	_, err = part.Write([]byte(body))
+	if err != nil {
+		return fmt.Errorf("Error writing XLSX report: %v", err)
+	}
+	return nil
+}
+
+func xmlEscape(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
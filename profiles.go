@@ -0,0 +1,93 @@
+/*
+Copyright 2017-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package main
+
+//
+// collection profiles - named bundles of the collector, analysis and output
+// options for common uses, e.g. --profile=license
+//
+// A profile is just a set of flag values. They are applied after the command
+// line is parsed, and only to flags which weren't given on the command line,
+// so any of them can still be overridden.
+//
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/couchbase/cbsummary/pkg/cbsummary"
+)
+
+var PROFILES = map[string]map[string]string{
+	// what's needed to true up a license or subscription
+	"license": {
+		"license-model":     cbsummary.LICENSE_MODEL_CORES,
+		"consumption-units": "true",
+		"hardware":          "true",
+	},
+	// whether the clusters are keeping up with their workload
+	"health": {
+		"query-stats":    "true",
+		"eventing-stats": "true",
+		"bucket-stats":   "true",
+		"xdcr-stats":     "true",
+		"tasks":          "true",
+		"node-rtt":       "true",
+		"skip-busy":      "true",
+	},
+	// how much the clusters use and what they would need elsewhere
+	"capacity": {
+		"service-usage":     "true",
+		"bucket-stats":      "true",
+		"hardware":          "true",
+		"consumption-units": "true",
+		"capella-sizing":    "true",
+	},
+	// the cluster settings, for review against security policy
+	"security": {
+		"full":     "true",
+		"security": "true",
+	},
+}
+
+func ProfileNames() []string {
+	names := make([]string, 0, len(PROFILES))
+	for name := range PROFILES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// set the flags of the named profile that weren't given on the command line
+func ApplyProfile(name string) error {
+	profile, ok := PROFILES[name]
+	if !ok {
+		return fmt.Errorf("Unknown profile '%s'", name)
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, value := range profile {
+		if given[name] {
+			continue
+		}
+		err := flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("Error applying profile setting %s=%s: %v", name, value, err)
+		}
+	}
+	return nil
+}